@@ -0,0 +1,153 @@
+// Command raal-import loads licenses exported from another system into a
+// running raalisence server through POST /api/v1/licenses/import.
+//
+//	raal-import [-url http://localhost:8080] [-resign] [-batch 1000] licenses.csv|licenses.json
+//
+// The admin key is read from RAAL_SERVER_ADMIN_API_KEY (or -key). CSV files
+// need a header row (license_key, customer, machine_id, expires_at,
+// features.<key>, ...); JSON files hold an array of records or
+// {"licenses": [...]}. Files larger than -batch records are sent in several
+// requests, each its own transaction; the response bodies are printed as
+// JSON lines so re-signed license files can be collected.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "raalisence server URL")
+	key := flag.String("key", os.Getenv("RAAL_SERVER_ADMIN_API_KEY"), "admin API key")
+	resign := flag.Bool("resign", false, "return raalisence-signed license files for the imported licenses")
+	batch := flag.Int("batch", 1000, "licenses per request (server maximum 1000)")
+	flag.Parse()
+	if flag.NArg() != 1 || *key == "" || *batch < 1 {
+		log.Fatalf("usage: %s [-url URL] [-key KEY] [-resign] [-batch N] <file.csv|file.json>", flag.CommandLine.Name())
+	}
+
+	path := flag.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("read %s: %v", path, err)
+	}
+
+	var bodies []map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		bodies, err = csvBatches(data, *batch)
+	case ".json":
+		bodies, err = jsonBatches(data, *batch)
+	default:
+		err = fmt.Errorf("unsupported file type %q (want .csv or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	total := 0
+	for i, body := range bodies {
+		body["resign"] = *resign
+		n, err := post(client, strings.TrimRight(*baseURL, "/")+"/api/v1/licenses/import", *key, body)
+		if err != nil {
+			log.Fatalf("batch %d/%d: %v (%d licenses imported before it)", i+1, len(bodies), err, total)
+		}
+		total += n
+	}
+	log.Printf("imported %d licenses", total)
+}
+
+// csvBatches splits CSV rows into request bodies, repeating the header.
+func csvBatches(data []byte, size int) ([]map[string]any, error) {
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("no licenses")
+	}
+	header, rows := rows[0], rows[1:]
+	var out []map[string]any
+	for start := 0; start < len(rows); start += size {
+		end := min(start+size, len(rows))
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		_ = cw.Write(header)
+		_ = cw.WriteAll(rows[start:end]) // flushes
+		if err := cw.Error(); err != nil {
+			return nil, err
+		}
+		out = append(out, map[string]any{"csv": buf.String()})
+	}
+	return out, nil
+}
+
+// jsonBatches splits a JSON array (or {"licenses": [...]}) into request bodies.
+func jsonBatches(data []byte, size int) ([]map[string]any, error) {
+	var recs []json.RawMessage
+	if err := json.Unmarshal(data, &recs); err != nil {
+		var wrapped struct {
+			Licenses []json.RawMessage `json:"licenses"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("expected an array of licenses or {\"licenses\": [...]}")
+		}
+		recs = wrapped.Licenses
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("no licenses")
+	}
+	var out []map[string]any
+	for start := 0; start < len(recs); start += size {
+		end := min(start+size, len(recs))
+		out = append(out, map[string]any{"licenses": recs[start:end]})
+	}
+	return out, nil
+}
+
+// post sends one import request, prints the response and returns the
+// number of licenses imported.
+func post(client *http.Client, url, key string, body map[string]any) (int, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	var out struct {
+		Imported int `json:"imported"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return 0, err
+	}
+	os.Stdout.Write(bytes.TrimSpace(respBody))
+	fmt.Println()
+	return out.Imported, nil
+}
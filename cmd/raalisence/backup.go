@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/db/sqlite"
+)
+
+const backupUsage = `usage: raalisence backup -out FILE
+
+Copies the SQLite database (db.path) to FILE with SQLite's online backup,
+so it can run while the server is serving. FILE must not exist yet.
+
+`
+
+// backup implements "raalisence backup".
+func backup(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("out", "", "file to write the backup to")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, backupUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *out == "" {
+		fs.Usage()
+		return fmt.Errorf("-out is required")
+	}
+
+	cfg, err := sqliteConfig()
+	if err != nil {
+		return err
+	}
+	db, _, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := sqlite.Backup(ctx, db, *out); err != nil {
+		return err
+	}
+	fi, err := os.Stat(*out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "backed up %s to %s (%d bytes)\n", cfg.DB.Path, *out, fi.Size())
+	return nil
+}
+
+const restoreUsage = `usage: raalisence restore -in FILE
+
+Replaces the SQLite database (db.path) with the backup in FILE, after
+checking FILE is an intact raalisence database. The current database is
+kept beside it as <db.path>.before-restore-<time>. Stop the server first;
+it brings the restored schema up to date when it starts.
+
+`
+
+// restore implements "raalisence restore".
+func restore(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	in := fs.String("in", "", "backup file to restore")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, restoreUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("-in is required")
+	}
+	if _, err := os.Stat(*in); err != nil {
+		return err
+	}
+
+	cfg, err := sqliteConfig()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	saved, err := sqlite.Restore(ctx, *in, cfg.DB.Path)
+	if saved != "" {
+		fmt.Fprintf(stdout, "previous database saved as %s\n", saved)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "restored %s from %s\n", cfg.DB.Path, *in)
+	return nil
+}
+
+// sqliteConfig loads the config of a command that only works on a SQLite
+// database file.
+func sqliteConfig() (*config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	if cfg.DB.Driver != "sqlite3" {
+		return nil, errors.New("db.driver must be sqlite3; back up other databases with their own tools")
+	}
+	return cfg, nil
+}
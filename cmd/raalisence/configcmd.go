@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+const configUsage = `usage: raalisence config validate
+
+Loads the configuration the server would (config.yaml and RAAL_*
+variables) and runs the checks serve runs before it accepts traffic: the
+signing key pairs load and match, server.addr is a host:port, the route
+auth policy holds and the database answers. It also reports settings in
+the config file that raalisence doesn't know, which serve only warns
+about. Every problem is listed; the exit status is non-zero if there is
+any.
+
+`
+
+// configCmd implements "raalisence config".
+func configCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, configUsage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() == 0:
+		fs.Usage()
+		return fmt.Errorf("missing subcommand")
+	case fs.Arg(0) != "validate":
+		fs.Usage()
+		return fmt.Errorf("unknown subcommand %q", fs.Arg(0))
+	case fs.NArg() > 1:
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(1))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	file := cfg.File()
+	if file == "" {
+		file = "(no config file; RAAL_* variables only)"
+	}
+	fmt.Fprintf(stdout, "config: %s\n", file)
+
+	problems := preflight(cfg)
+	for _, k := range cfg.UnknownKeys() {
+		problems = append(problems, fmt.Errorf("unknown setting %s; check its spelling against config.example.yaml", k))
+	}
+	if err := checkDB(cfg); err != nil {
+		problems = append(problems, err)
+	}
+	for _, p := range problems {
+		fmt.Fprintf(stdout, "  - %v\n", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	fmt.Fprintln(stdout, "config OK")
+	return nil
+}
+
+// checkDB makes sure the configured database can be reached. A SQLite file
+// isn't opened, which would create it, only its directory checked.
+func checkDB(cfg *config.Config) error {
+	if cfg.DB.Driver == "sqlite3" {
+		if cfg.DB.Path == "" {
+			return fmt.Errorf("db.path is empty; set it to the SQLite database file")
+		}
+		dir := filepath.Dir(cfg.DB.Path)
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("db.path %s: directory %s doesn't exist; create it first", cfg.DB.Path, dir)
+		}
+		return nil
+	}
+	db, _, err := connectDB(cfg)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const hashKeyUsage = `usage: raalisence hash-key [flags] [KEY]
+
+Prints the bcrypt hash of an admin API key, for
+server.admin_api_key_hashes (RAAL_SERVER_ADMIN_API_KEY_HASHES). Without
+KEY, the key is read from the first line of stdin, which keeps it out of
+the shell history.
+
+`
+
+// hashKey implements "raalisence hash-key".
+func hashKey(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("hash-key", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cost := fs.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, hashKeyUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(1))
+	}
+
+	key := fs.Arg(0)
+	if fs.NArg() == 0 {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read key: %w", err)
+		}
+		key = strings.TrimRight(line, "\r\n")
+	}
+	if key == "" {
+		return errors.New("key is empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(key), *cost)
+	if err != nil {
+		return fmt.Errorf("hash key: %w", err)
+	}
+	fmt.Fprintln(stdout, string(hash))
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+const keygenUsage = `usage: raalisence keygen [flags]
+
+Generates a signing key pair and prints it as a config.yaml signing: block
+(or, with -format env, as RAAL_SIGNING_* variables). With -out the keys are
+also written to priv.pem (0600) and pub.pem (0644) in that directory.
+
+`
+
+// keygen implements "raalisence keygen".
+func keygen(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	alg := fs.String("alg", crypto.AlgES256, "key algorithm: ES256 (ECDSA P-256), EdDSA (Ed25519) or PS256 (RSA 3072)")
+	format := fs.String("format", "yaml", "output: yaml or env")
+	out := fs.String("out", "", "directory to write priv.pem and pub.pem to")
+	force := fs.Bool("force", false, "overwrite existing key files")
+	passFile := fs.String("passphrase-file", "", "encrypt the private key with the passphrase in this file")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, keygenUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *format != "yaml" && *format != "env" {
+		return fmt.Errorf("-format must be yaml or env, not %q", *format)
+	}
+	for _, a := range []string{crypto.AlgES256, crypto.AlgEdDSA, crypto.AlgPS256} {
+		if strings.EqualFold(*alg, a) {
+			*alg = a
+		}
+	}
+
+	privPEM, pubPEM, err := crypto.GenerateKeyPEM(*alg)
+	if err != nil {
+		return err
+	}
+	if *passFile != "" {
+		pass, err := os.ReadFile(*passFile)
+		if err != nil {
+			return fmt.Errorf("passphrase: %w", err)
+		}
+		pass = []byte(strings.TrimRight(string(pass), "\r\n"))
+		if len(pass) == 0 {
+			return errors.New("passphrase: file is empty")
+		}
+		key, err := crypto.ParsePrivateKey(privPEM)
+		if err != nil {
+			return err
+		}
+		if privPEM, err = crypto.EncryptPrivateKeyPEM(key, pass); err != nil {
+			return err
+		}
+	}
+
+	if *out != "" {
+		privPath, pubPath := filepath.Join(*out, "priv.pem"), filepath.Join(*out, "pub.pem")
+		if err := os.MkdirAll(*out, 0o700); err != nil {
+			return err
+		}
+		if err := writeKeyFile(privPath, privPEM, 0o600, *force); err != nil {
+			return err
+		}
+		if err := writeKeyFile(pubPath, pubPEM, 0o644, *force); err != nil {
+			return err
+		}
+		fmt.Fprintf(stderr, "wrote %s and %s\n", privPath, pubPath)
+	}
+
+	if *format == "env" {
+		fmt.Fprintf(stdout, "export RAAL_SIGNING_ALGORITHM='%s'\n", *alg)
+		fmt.Fprintf(stdout, "export RAAL_SIGNING_PRIVATE_KEY_PEM='%s'\n", privPEM)
+		fmt.Fprintf(stdout, "export RAAL_SIGNING_PUBLIC_KEY_PEM='%s'\n", pubPEM)
+		if *passFile != "" {
+			fmt.Fprintf(stdout, "export RAAL_SIGNING_PRIVATE_KEY_PASSPHRASE_FILE='%s'\n", *passFile)
+		}
+		return nil
+	}
+	fmt.Fprintln(stdout, "signing:")
+	fmt.Fprintf(stdout, "  algorithm: %q\n", *alg)
+	fmt.Fprintf(stdout, "  private_key_pem: |\n%s", indent(privPEM, "    "))
+	fmt.Fprintf(stdout, "  public_key_pem: |\n%s", indent(pubPEM, "    "))
+	if *passFile != "" {
+		fmt.Fprintf(stdout, "  private_key_passphrase_file: %q\n", *passFile)
+	}
+	return nil
+}
+
+// writeKeyFile creates path with perm, refusing to replace an existing file
+// unless force is set.
+func writeKeyFile(path, data string, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s exists (use -force to overwrite)", path)
+	}
+	if err != nil {
+		return err
+	}
+	// OpenFile leaves the mode of an existing file alone
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func indent(s, prefix string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line != "" {
+			b.WriteString(prefix + line)
+		}
+	}
+	return b.String()
+}
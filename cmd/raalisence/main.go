@@ -3,87 +3,181 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/rpattn/raalisence/internal/config"
+	mysqlmigrate "github.com/rpattn/raalisence/internal/db/migrations_mysql"
 	"github.com/rpattn/raalisence/internal/db/migrations_sqlite"
-	"github.com/rpattn/raalisence/internal/server"
+	"github.com/rpattn/raalisence/internal/db/mysql"
+	"github.com/rpattn/raalisence/internal/logging"
 )
 
+// command is one raalisence subcommand.
+type command struct {
+	name, summary string
+	run           func(args []string, stdout, stderr io.Writer) error
+}
+
+// commands lists the subcommands in the order usage shows them.
+var commands = []command{
+	{"serve", "run the license server (the default)", serve},
+	{"migrate", "bring the database schema up to date", migrateCmd},
+	{"keygen", "generate a signing key pair", keygen},
+	{"hash-key", "bcrypt an admin API key for server.admin_api_key_hashes", hashKey},
+	{"config", "check the configuration (config validate)", configCmd},
+	{"resign", "re-sign licenses with the current signing key", resign},
+	{"backup", "copy the SQLite database while it serves", backup},
+	{"restore", "replace the SQLite database with a backup", restore},
+}
+
 func main() {
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("load config: %v", err)
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return
 	}
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(args, os.Stdout, os.Stderr); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatalf("%s: %v", name, err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "raalisence: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
 
-	// Preflight: ensure signing keys are valid early, with clear error.
-	if _, err := cfg.PrivateKey(); err != nil {
-		log.Fatalf("signing private key: %v", err)
+func usage(w io.Writer) {
+	fmt.Fprint(w, "usage: raalisence <command> [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
 	}
-	if _, err := cfg.PublicKey(); err != nil {
-		log.Fatalf("signing public key: %v", err)
+	fmt.Fprint(w, "\nWith no command, raalisence serves. Run raalisence <command> -h for its flags.\n")
+}
+
+// openDB connects to the configured database, bringing a SQLite or MySQL
+// schema up to date, and returns it with the driver name.
+func openDB(cfg *config.Config) (*sql.DB, string, error) {
+	db, driver, err := connectDB(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	// In-app migration for SQLite and MySQL (idempotent)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	switch driver {
+	case "sqlite3":
+		if err := migrate.EnsureSQLiteSchema(ctx, db); err != nil {
+			db.Close()
+			return nil, "", fmt.Errorf("sqlite migrate: %w", err)
+		}
+	case "mysql":
+		if err := mysqlmigrate.EnsureMySQLSchema(ctx, db); err != nil {
+			db.Close()
+			return nil, "", fmt.Errorf("mysql migrate: %w", err)
+		}
 	}
+	return db, driver, nil
+}
 
-	// choose db driver
+// connectDB opens and pings the configured database, leaving its schema
+// alone, and returns it with the driver name.
+func connectDB(cfg *config.Config) (*sql.DB, string, error) {
 	driver := "pgx"
 	dsn := cfg.DB.DSN
-	if cfg.DB.Driver == "sqlite3" {
+	switch cfg.DB.Driver {
+	case "sqlite3":
 		driver = "sqlite3"
-		dsn = cfg.DB.Path
+		dsn = cfg.SQLiteDSN(cfg.DB.Path)
+	case "mysql":
+		driver = "mysql"
+	case "cockroach":
+		// Postgres's wire protocol and SQL, but transactions that lose a
+		// conflict have to be rerun (store.RetryTx)
+		driver = "cockroach"
 	}
 
-	db, err := sql.Open(driver, dsn)
+	var db *sql.DB
+	var err error
+	switch driver {
+	case "mysql":
+		db, err = mysql.Open(dsn)
+	case "cockroach":
+		db, err = sql.Open("pgx", dsn)
+	default:
+		db, err = sql.Open(driver, dsn)
+	}
 	if err != nil {
-		log.Fatalf("open db: %v", err)
+		return nil, "", fmt.Errorf("open db: %w", err)
 	}
-	defer db.Close()
-	if err := db.Ping(); err != nil {
-		log.Fatalf("ping db: %v", err)
+	setPool(db, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, "", fmt.Errorf("ping db: %w; check db.%s and that the database is up and reachable from here", err, dsnSetting(cfg))
 	}
+	return db, driver, nil
+}
 
-	// In-app migration for SQLite (idempotent)
-	if driver == "sqlite3" {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := migrate.EnsureSQLiteSchema(ctx, db); err != nil {
-			log.Fatalf("sqlite migrate: %v", err)
-		}
+// dsnSetting names the setting that says where the database is.
+func dsnSetting(cfg *config.Config) string {
+	if cfg.DB.Driver == "sqlite3" {
+		return "path"
 	}
+	return "dsn"
+}
 
-	srv := server.New(db, cfg)
-
-	httpSrv := &http.Server{
-		Addr:              cfg.Server.Addr,
-		Handler:           srv.Handler(),
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       15 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		IdleTimeout:       90 * time.Second,
+// setPool applies the db pool settings to db.
+func setPool(db *sql.DB, cfg *config.Config) {
+	if n := cfg.DB.MaxOpenConns; n > 0 {
+		db.SetMaxOpenConns(n)
 	}
+	if n := cfg.DB.MaxIdleConns; n > 0 {
+		db.SetMaxIdleConns(n)
+	}
+	if d := cfg.DB.ConnMaxLifetime; d > 0 {
+		db.SetConnMaxLifetime(d)
+	}
+}
 
-	go func() {
-		log.Printf("raalisence listening on %s (driver=%s)", cfg.Server.Addr, driver)
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("http server: %v", err)
-		}
-	}()
-
-	// graceful shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := httpSrv.Shutdown(ctx); err != nil {
-		log.Printf("shutdown error: %v", err)
+// openReplica connects to db.replica_dsn, if set, for the primary's driver.
+// A replica that doesn't answer yet is kept: reads fall back to the primary
+// until it does.
+func openReplica(cfg *config.Config, driver string) (*sql.DB, error) {
+	if cfg.DB.ReplicaDSN == "" {
+		return nil, nil
+	}
+	var db *sql.DB
+	var err error
+	switch driver {
+	case "mysql":
+		db, err = mysql.Open(cfg.DB.ReplicaDSN)
+	case "pgx", "cockroach":
+		db, err = sql.Open("pgx", cfg.DB.ReplicaDSN)
+	default:
+		return nil, fmt.Errorf("db.replica_dsn needs postgres or mysql, not %s", driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open replica: %w", err)
+	}
+	setPool(db, cfg)
+	if err := db.Ping(); err != nil {
+		logging.Warnf("replica ping: %v; reading from the primary until it answers", err)
 	}
-	log.Println("bye")
+	return db, nil
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/rpattn/raalisence/internal/config"
+	pgmigrate "github.com/rpattn/raalisence/internal/db/migrations"
+	mysqlmigrate "github.com/rpattn/raalisence/internal/db/migrations_mysql"
+	"github.com/rpattn/raalisence/internal/db/migrations_sqlite"
+)
+
+const migrateUsage = `usage: raalisence migrate [flags]
+
+Brings the configured database's schema up to date with the migrations
+built into this binary. The server does this itself for SQLite and MySQL
+when it starts; for Postgres and CockroachDB, run it before the first
+start and after each upgrade. Each migration is applied once and
+recorded in schema_migrations.
+
+`
+
+// migrateCmd implements "raalisence migrate".
+func migrateCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	status := fs.Bool("status", false, "list pending migrations instead of applying them")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, migrateUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	db, driver, err := connectDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var pending, unknown []string
+	switch driver {
+	case "sqlite3":
+		pending, unknown, err = migrate.StatusSQLite(ctx, db)
+	case "mysql":
+		pending, unknown, err = mysqlmigrate.StatusMySQL(ctx, db)
+	default:
+		pending, unknown, err = pgmigrate.StatusPostgres(ctx, db)
+	}
+	if err != nil {
+		return fmt.Errorf("migration status: %w", err)
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(stderr, "warning: applied by a newer raalisence: %s\n", strings.Join(unknown, ", "))
+	}
+	if *status {
+		if len(pending) == 0 {
+			fmt.Fprintln(stdout, "schema is up to date")
+		}
+		for _, v := range pending {
+			fmt.Fprintf(stdout, "pending %s\n", v)
+		}
+		return nil
+	}
+
+	switch driver {
+	case "sqlite3":
+		err = migrate.EnsureSQLiteSchema(ctx, db)
+	case "mysql":
+		err = mysqlmigrate.EnsureMySQLSchema(ctx, db)
+	default:
+		_, err = pgmigrate.ApplyPostgres(ctx, db)
+	}
+	if err != nil {
+		return fmt.Errorf("%s migrate: %w", driver, err)
+	}
+	for _, v := range pending {
+		fmt.Fprintf(stdout, "applied %s\n", v)
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(stdout, "schema is up to date")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/handlers"
+)
+
+const resignUsage = `usage: raalisence resign -out DIR [flags]
+
+Re-signs every active license (or those matching the filters) with the key
+the current config selects for it, e.g. after moving signing.private_key_pem
+to a new key, and writes each new license file to DIR/<license_key>.lic.
+The new key_id is recorded per license and license.reissued is queued for
+webhooks, which the running server delivers.
+
+`
+
+// resign implements "raalisence resign".
+func resign(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("resign", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("out", "", "directory to write the re-signed license files to")
+	var f handlers.ResignFilter
+	fs.StringVar(&f.KeyID, "key-id", "", "only licenses signed by this key_id, e.g. the key being retired")
+	fs.StringVar(&f.ProductID, "product-id", "", "only licenses for this product")
+	fs.StringVar(&f.Tenant, "tenant", "", "only licenses for this tenant")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, resignUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *out == "" {
+		fs.Usage()
+		return fmt.Errorf("-out is required")
+	}
+	if err := os.MkdirAll(*out, 0o700); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	db, _, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	n, err := handlers.ResignAll(ctx, db, cfg, f, "cli:resign", "", func(lf handlers.LicenseFile) error {
+		b, err := json.MarshalIndent(lf, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(*out, lf.LicenseKey+".lic"), append(b, '\n'), 0o600)
+	})
+	fmt.Fprintf(stdout, "re-signed %d licenses into %s\n", n, *out)
+	return err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/errreport"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/server"
+	"github.com/rpattn/raalisence/internal/systemd"
+)
+
+const serveUsage = `usage: raalisence [serve]
+
+Runs the license server with the configuration from config.yaml and RAAL_*
+variables until SIGINT or SIGTERM, then drains as server.shutdown says.
+SIGHUP reloads the signing keys, admin API keys and rate limits without a
+restart. Under systemd socket activation it serves on the socket passed in
+instead of server.addr. A SQLite or MySQL schema is brought up to date
+first; run raalisence migrate for Postgres.
+
+`
+
+// serve implements "raalisence serve", also run with no command.
+func serve(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, serveUsage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	logs, err := logging.Setup(logging.Options{
+		Level: cfg.Log.Level, Output: cfg.Log.Output, File: cfg.Log.File,
+		Syslog: cfg.Log.Syslog, SyslogTag: cfg.Log.SyslogTag, ValidateSample: cfg.Log.ValidateSample,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	if errs := preflight(cfg); len(errs) > 0 {
+		return fmt.Errorf("invalid config (raalisence config validate checks it without starting):\n%w", errors.Join(errs...))
+	}
+	for _, k := range cfg.UnknownKeys() {
+		logging.Warnf("%s: unknown setting %s is ignored", cfg.File(), k)
+	}
+
+	db, driver, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	reporter, err := errreport.New(errreport.Options{
+		DSN: cfg.ErrorReporting.DSN, URL: cfg.ErrorReporting.URL,
+		Environment: cfg.ErrorReporting.Environment, Release: cfg.ErrorReporting.Release,
+	})
+	if err != nil {
+		return err
+	}
+	srv := server.New(db, cfg).WithErrorReporter(reporter)
+	replica, err := openReplica(cfg, driver)
+	if err != nil {
+		return err
+	}
+	if replica != nil {
+		defer replica.Close()
+		srv.WithReplica(replica)
+	}
+
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+	go srv.ReapSessions(bgCtx)
+	go srv.RunWebhooks(bgCtx)
+	go srv.RunRetention(bgCtx)
+	go srv.RunMetrics(bgCtx)
+	go srv.RunStaleScan(bgCtx)
+	go srv.RunCheckpoints(bgCtx)
+	go srv.RunSnapshots(bgCtx)
+
+	tlsCfg, err := server.TLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	httpSrv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       90 * time.Second,
+		TLSConfig:         tlsCfg,
+	}
+
+	ln, from, err := listen(cfg)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var err error
+		if tlsCfg != nil {
+			logging.Infof("raalisence listening on %s%s with TLS (driver=%s)", ln.Addr(), from, driver)
+			// the certificate is already loaded into tlsCfg
+			err = httpSrv.ServeTLS(ln, "", "")
+		} else {
+			logging.Infof("raalisence listening on %s%s (driver=%s)", ln.Addr(), from, driver)
+			err = httpSrv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("http server: %v", err)
+		}
+	}()
+	notify("READY=1")
+
+	// SIGHUP reloads what can change while serving; SIGINT and SIGTERM
+	// shut down gracefully
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := <-sigs; sig == syscall.SIGHUP; sig = <-sigs {
+		notify("RELOADING=1")
+		reload(srv)
+		notify("READY=1")
+	}
+
+	// answer new requests 503 at once, give load balancers
+	// server.shutdown.drain_delay to notice (another signal cuts it short),
+	// then close the listener and let running requests finish
+	notify("STOPPING=1")
+	srv.Drain()
+	httpSrv.SetKeepAlivesEnabled(false)
+	if d := cfg.Server.Shutdown.DrainDelay; d > 0 {
+		logging.Infof("draining for %s", d)
+		select {
+		case <-time.After(d):
+		case <-sigs:
+		}
+	}
+	bgCancel()
+	timeout := cfg.ShutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		logging.Errorf("requests still running after server.shutdown.timeout (%s) were cut off: %v", timeout, err)
+		httpSrv.Close()
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := reporter.Close(ctx); err != nil {
+		logging.Warnf("error reports not sent: %v", err)
+	}
+	logging.Infof("bye")
+	return nil
+}
+
+// listen returns the socket systemd passed in, when socket activated, or
+// else listens on server.addr. from says which, for the log.
+func listen(cfg *config.Config) (net.Listener, string, error) {
+	lns, err := systemd.Listeners()
+	if err != nil {
+		return nil, "", err
+	}
+	switch len(lns) {
+	case 0:
+		ln, err := net.Listen("tcp", cfg.Server.Addr)
+		return ln, "", err
+	case 1:
+		return lns[0], " (socket from systemd; server.addr unused)", nil
+	}
+	for _, ln := range lns {
+		ln.Close()
+	}
+	return nil, "", fmt.Errorf("systemd passed %d sockets; raalisence serves on one, so give the socket unit a single ListenStream=", len(lns))
+}
+
+// notify tells systemd about state, logging failures.
+func notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		logging.Warnf("%v", err)
+	}
+}
+
+// reload loads the config again and hands it to srv, logging the outcome;
+// on any error the running config stays.
+func reload(srv *server.Server) {
+	next, err := config.Load()
+	if err != nil {
+		logging.Errorf("config reload failed, keeping the running config: %v", err)
+		return
+	}
+	changed, err := srv.Reload(next)
+	if err != nil {
+		logging.Errorf("config reload failed, keeping the running config: %v", err)
+		return
+	}
+	if len(changed) == 0 {
+		logging.Infof("config reloaded: nothing changed")
+		return
+	}
+	logging.Infof("config reloaded: new %s", strings.Join(changed, ", "))
+}
+
+// preflight checks what would stop the server from working before it
+// starts (config.Lint and the route auth policy) and returns every problem
+// found.
+func preflight(cfg *config.Config) []error {
+	errs := cfg.Lint()
+	if err := server.CheckAuthPolicy(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	gocrypto "crypto"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// Lint checks what Load lets through but the server can't work with: a
+// missing or mismatched signing key pair and a malformed server.addr. Each
+// error names the setting and how to fix it.
+func (c *Config) Lint() []error {
+	var errs []error
+	if err := c.checkAddr("server.addr", c.Server.Addr); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.checkKeyPair(); err != nil {
+		errs = append(errs, err)
+	}
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+	for _, section := range []struct {
+		path  string
+		pairs map[string]KeyPair
+	}{{"signing.products", c.Signing.Products}, {"signing.tenants", c.Signing.Tenants}} {
+		ids := make([]string, 0, len(section.pairs))
+		for id := range section.pairs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			path := section.path + "." + id
+			key, pubPEM, err := c.pairSigningKey(path, section.pairs[id])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			pub, _ := parsePublicKeyPEM(pubPEM, "")
+			if !sameKey(key.Public(), pub) {
+				errs = append(errs, fmt.Errorf("%s.public_key_pem is not the public half of its private_key_pem; export it again from the private key", path))
+			}
+		}
+	}
+	return errs
+}
+
+// UnknownKeys lists the settings in the config file that raalisence
+// doesn't know, e.g. misspelt ones, which are otherwise ignored.
+func (c *Config) UnknownKeys() []string { return c.unknownKeys }
+
+// File returns the config file Load read, or "" if there was none.
+func (c *Config) File() string { return c.file }
+
+func (c *Config) checkAddr(key, addr string) error {
+	if addr == "" {
+		return fmt.Errorf("%s is empty; set it to host:port, e.g. :8080", key)
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s %q: %v; want host:port, e.g. :8080 or 127.0.0.1:8080", key, addr, err)
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		if n < 0 || n > 65535 {
+			return fmt.Errorf("%s %q: port %d is out of range (0-65535)", key, addr, n)
+		}
+		return nil
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("%s %q: unknown port %q; use a port number", key, addr, port)
+	}
+	return nil
+}
+
+// checkKeyPair makes sure the default private and public keys load and
+// belong together, so licenses signed with one verify with the other.
+func (c *Config) checkKeyPair() error {
+	key, err := c.PrivateKey()
+	if err != nil {
+		return fmt.Errorf("%w; generate a key pair with raalisence keygen", err)
+	}
+	pub, err := c.PublicKey()
+	if err != nil {
+		return fmt.Errorf("signing.public_key_pem: %w; generate a key pair with raalisence keygen", err)
+	}
+	if !sameKey(key.Public(), pub) {
+		return fmt.Errorf("signing.public_key_pem is not the public half of signing.private_key_pem, so clients would reject every license; export it again from the private key")
+	}
+	return nil
+}
+
+func sameKey(a, b gocrypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(gocrypto.PublicKey) bool })
+	return ok && b != nil && k.Equal(b)
+}
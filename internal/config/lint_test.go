@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+func TestLint(t *testing.T) {
+	priv, pub, err := crypto.GeneratePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPub, err := crypto.GeneratePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lint := func(addr, pubPEM string) []error {
+		cfg := &Config{}
+		cfg.Server.Addr = addr
+		cfg.Signing.PrivateKeyPEM, cfg.Signing.PublicKeyPEM = priv, pubPEM
+		return cfg.Lint()
+	}
+	if errs := lint(":8080", pub); len(errs) != 0 {
+		t.Fatalf("valid config: %v", errs)
+	}
+	for _, addr := range []string{"", "8080", ":80800", "localhost:nope"} {
+		if errs := lint(addr, pub); len(errs) != 1 || !strings.Contains(errs[0].Error(), "server.addr") {
+			t.Errorf("addr %q: %v", addr, errs)
+		}
+	}
+	errs := lint("127.0.0.1:8080", otherPub)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "not the public half") {
+		t.Fatalf("mismatched pair: %v", errs)
+	}
+
+	cfg := &Config{}
+	cfg.Server.Addr = ":0"
+	cfg.Signing.Products = map[string]KeyPair{"pro": {PrivateKeyPEM: priv, PublicKeyPEM: otherPub}}
+	cfg.Signing.PrivateKeyPEM, cfg.Signing.PublicKeyPEM = priv, pub
+	if errs := cfg.Lint(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "signing.products.pro") {
+		t.Fatalf("mismatched product pair: %v", errs)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	yaml := "server:\n  addr: \":9000\"\n  adress: \":9001\"\ndb:\n  driver: sqlite3\nloggging:\n  level: debug\n"
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.UnknownKeys(), ","); got != "loggging,server.adress" {
+		t.Fatalf("unknown keys: %s", got)
+	}
+	if cfg.Server.Addr != ":9000" || !strings.HasSuffix(cfg.File(), "config.yaml") {
+		t.Fatalf("addr %q file %q", cfg.Server.Addr, cfg.File())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("server: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "config.yaml") {
+		t.Fatalf("broken yaml: %v", err)
+	}
+}
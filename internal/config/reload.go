@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// default rate limit groups, for settings left at zero
+var defaultRateLimits = map[string]RateLimitGroup{
+	"fast":    {RPS: 5, Burst: 10},
+	"admin":   {RPS: 1, Burst: 3},
+	"default": {RPS: 2, Burst: 5},
+}
+
+// Reload takes over the settings that can change while the server runs
+// from next, a config fresh from Load: the signing keys (signing.*
+// key pairs and cert chains), the admin API keys and the rate limits.
+// Everything else keeps its value until a restart. It returns what
+// changed.
+func (c *Config) Reload(next *Config) ([]string, error) {
+	if next.Signing.Backend != c.Signing.Backend {
+		return nil, fmt.Errorf("signing.backend changed from %q to %q; restart to switch backends", c.Signing.Backend, next.Signing.Backend)
+	}
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+
+	var changed []string
+	cur, nxt := &c.Signing, &next.Signing
+	if cur.PrivateKeyPEM != nxt.PrivateKeyPEM || cur.PublicKeyPEM != nxt.PublicKeyPEM ||
+		cur.Algorithm != nxt.Algorithm || cur.CertChainPEM != nxt.CertChainPEM ||
+		!maps.Equal(cur.Products, nxt.Products) || !maps.Equal(cur.Tenants, nxt.Tenants) {
+		cur.PrivateKeyPEM, cur.PublicKeyPEM = nxt.PrivateKeyPEM, nxt.PublicKeyPEM
+		cur.Algorithm, cur.CertChainPEM = nxt.Algorithm, nxt.CertChainPEM
+		cur.Products, cur.Tenants = nxt.Products, nxt.Tenants
+		// keep the keys Load decrypted; the passphrases are gone
+		c.privateKey, c.publicKey, c.pairKeys = next.privateKey, next.publicKey, next.pairKeys
+		changed = append(changed, "signing keys")
+	} else if closer, ok := next.privateKey.(interface{ Close() }); ok && next.privateKey != c.privateKey {
+		// the same backend key: keep the signer in use, drop the new one
+		closer.Close()
+	}
+
+	if !slices.Equal(c.Server.AdminAPIKeyHashes, next.Server.AdminAPIKeyHashes) || c.Server.AdminAPIKey != next.Server.AdminAPIKey {
+		c.Server.AdminAPIKeyHashes, c.Server.AdminAPIKey = next.Server.AdminAPIKeyHashes, next.Server.AdminAPIKey
+		changed = append(changed, "admin API keys")
+	}
+
+	if c.RateLimit != next.RateLimit {
+		c.RateLimit = next.RateLimit
+		changed = append(changed, "rate limits")
+	}
+	return changed, nil
+}
+
+// KeyPairs returns the default signing pair and the per-product and
+// per-tenant pairs, as of the last Reload.
+func (c *Config) KeyPairs() (def KeyPair, products, tenants map[string]KeyPair) {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+	def = KeyPair{
+		PrivateKeyPEM: c.Signing.PrivateKeyPEM, PublicKeyPEM: c.Signing.PublicKeyPEM,
+		Algorithm: c.Signing.Algorithm, CertChainPEM: c.Signing.CertChainPEM,
+	}
+	return def, maps.Clone(c.Signing.Products), maps.Clone(c.Signing.Tenants)
+}
+
+// RateLimits returns the rate limit groups (fast, admin and default), with
+// defaults for those not set.
+func (c *Config) RateLimits() map[string]RateLimitGroup {
+	c.keysMu.Lock()
+	set := map[string]RateLimitGroup{"fast": c.RateLimit.Fast, "admin": c.RateLimit.Admin, "default": c.RateLimit.Default}
+	c.keysMu.Unlock()
+	for name, g := range set {
+		def := defaultRateLimits[name]
+		if g.RPS <= 0 {
+			g.RPS = def.RPS
+		}
+		if g.Burst <= 0 {
+			g.Burst = def.Burst
+		}
+		set[name] = g
+	}
+	return set
+}
@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ParseCertChain parses a PEM bundle of certificates, leaf first, then any
+// intermediates. The root may be included but verifiers should not rely on
+// it: they pin their own copy.
+func ParseCertChain(pemStr string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(pemStr)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q in certificate chain", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates in chain")
+	}
+	return certs, nil
+}
+
+// EncodeCertChain returns certs as standard base64 DER, the x5c form of
+// RFC 7517 section 4.7.
+func EncodeCertChain(certs []*x509.Certificate) []string {
+	out := make([]string, len(certs))
+	for i, c := range certs {
+		out[i] = base64.StdEncoding.EncodeToString(c.Raw)
+	}
+	return out
+}
+
+// VerifyCertChain checks that the x5c chain leads from its leaf, through
+// the intermediates that follow it, to one of roots, with every certificate
+// valid at the given time, and returns the leaf's public key. Pass the time
+// the signature was made (a license's issued_at) so that licenses outlive
+// the leaf certificate that signed them.
+func VerifyCertChain(x5c []string, roots *x509.CertPool, at time.Time) (gocrypto.PublicKey, error) {
+	if len(x5c) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, s := range x5c {
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", i, err)
+		}
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", i, err)
+		}
+	}
+	leaf := certs[0]
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, errors.New("leaf certificate is not valid for digital signatures")
+	}
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := Algorithm(leaf.PublicKey); err != nil {
+		return nil, err
+	}
+	return leaf.PublicKey, nil
+}
@@ -0,0 +1,88 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for pub, signed by parent/parentKey or
+// self-signed when parent is nil.
+func testCert(t *testing.T, cn string, ca bool, pub gocrypto.PublicKey, parent *x509.Certificate, parentKey gocrypto.Signer, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent = tmpl
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestVerifyCertChain(t *testing.T) {
+	year := time.Now().AddDate(1, 0, 0)
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := testCert(t, "root", true, rootKey.Public(), nil, rootKey, year)
+	interKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	inter := testCert(t, "intermediate", true, interKey.Public(), root, rootKey, year)
+
+	_, leafPEM, _ := GenerateKeyPEM(AlgEdDSA)
+	leafPub, _ := ParsePublicKey(leafPEM)
+	leaf := testCert(t, "leaf", false, leafPub, inter, interKey, time.Now().Add(time.Hour))
+
+	bundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: inter.Raw}))
+	certs, err := ParseCertChain(bundle)
+	if err != nil || len(certs) != 2 {
+		t.Fatalf("parse: %d certs, %v", len(certs), err)
+	}
+	x5c := EncodeCertChain(certs)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	pub, err := VerifyCertChain(x5c, roots, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leafPub.(interface{ Equal(gocrypto.PublicKey) bool }).Equal(pub) {
+		t.Fatal("returned key is not the leaf's")
+	}
+
+	// validity is judged at the time given, which callers set to issued_at
+	if _, err := VerifyCertChain(x5c, roots, time.Now().Add(2*time.Hour)); err == nil {
+		t.Fatal("verified after the leaf expired")
+	}
+	if _, err := VerifyCertChain(x5c[:1], roots, time.Now()); err == nil {
+		t.Fatal("verified without the intermediate")
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other := x509.NewCertPool()
+	other.AddCert(testCert(t, "other root", true, otherKey.Public(), nil, otherKey, year))
+	if _, err := VerifyCertChain(x5c, other, time.Now()); err == nil {
+		t.Fatal("verified against the wrong root")
+	}
+	if _, err := ParseCertChain(leafPEM); err == nil {
+		t.Fatal("public key accepted as a certificate chain")
+	}
+}
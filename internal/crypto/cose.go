@@ -0,0 +1,196 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithm identifiers (RFC 9053) for the JOSE algorithms above.
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgPS256 = -37
+)
+
+// coseSign1Tag is the CBOR tag of a COSE_Sign1 message (RFC 9052).
+const coseSign1Tag = 18
+
+// COSE header labels.
+const (
+	coseHeaderAlg = 1
+	coseHeaderKID = 4
+)
+
+// cborEnc encodes deterministically (RFC 8949 core deterministic encoding),
+// so equal payloads produce equal bytes.
+var cborEnc, _ = cbor.CoreDetEncOptions().EncMode()
+
+// COSESign1 is a parsed COSE_Sign1 message.
+type COSESign1 struct {
+	Alg     int    // from the protected header
+	KeyID   string // kid from the unprotected header, if any
+	Payload []byte
+
+	protected []byte
+	signature []byte
+}
+
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[int]any
+	Payload     []byte
+	Signature   []byte
+}
+
+// MarshalCBOR encodes v with the deterministic encoding used for COSE
+// payloads.
+func MarshalCBOR(v any) ([]byte, error) { return cborEnc.Marshal(v) }
+
+// SignCOSE wraps payload in a tagged COSE_Sign1 message signed with priv:
+// the algorithm goes in the protected header and kid, when set, in the
+// unprotected one. ECDSA signatures are the fixed-size r||s COSE expects,
+// not ASN.1 DER.
+func SignCOSE(priv gocrypto.Signer, kid string, payload []byte) ([]byte, error) {
+	alg, err := coseAlg(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	protected, err := cborEnc.Marshal(map[int]int{coseHeaderAlg: alg})
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := coseSigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	var sig []byte
+	switch pub := priv.Public().(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(tbs)
+		der, err := priv.Sign(rand.Reader, h[:], gocrypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		var es ecdsaSig
+		if _, err := asn1.Unmarshal(der, &es); err != nil {
+			return nil, err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		es.R.FillBytes(sig[:size])
+		es.S.FillBytes(sig[size:])
+	case ed25519.PublicKey:
+		if sig, err = priv.Sign(rand.Reader, tbs, gocrypto.Hash(0)); err != nil {
+			return nil, err
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(tbs)
+		if sig, err = priv.Sign(rand.Reader, h[:], pssOptions); err != nil {
+			return nil, err
+		}
+	}
+	unprotected := map[int]any{}
+	if kid != "" {
+		unprotected[coseHeaderKID] = []byte(kid)
+	}
+	return cborEnc.Marshal(cbor.Tag{Number: coseSign1Tag, Content: coseSign1{
+		Protected:   protected,
+		Unprotected: unprotected,
+		Payload:     payload,
+		Signature:   sig,
+	}})
+}
+
+// ParseCOSESign1 decodes a tagged or untagged COSE_Sign1 message without
+// checking its signature.
+func ParseCOSESign1(msg []byte) (*COSESign1, error) {
+	var raw cbor.RawTag
+	if err := cbor.Unmarshal(msg, &raw); err == nil {
+		if raw.Number != coseSign1Tag {
+			return nil, fmt.Errorf("cose: unexpected tag %d", raw.Number)
+		}
+		msg = raw.Content
+	}
+	var m coseSign1
+	if err := cbor.Unmarshal(msg, &m); err != nil {
+		return nil, fmt.Errorf("cose: %w", err)
+	}
+	var hdr map[int]any
+	if err := cbor.Unmarshal(m.Protected, &hdr); err != nil {
+		return nil, fmt.Errorf("cose: protected header: %w", err)
+	}
+	alg, ok := hdr[coseHeaderAlg].(int64)
+	if !ok {
+		return nil, errors.New("cose: protected header has no alg")
+	}
+	out := &COSESign1{Alg: int(alg), Payload: m.Payload, protected: m.Protected, signature: m.Signature}
+	if kid, ok := m.Unprotected[coseHeaderKID].([]byte); ok {
+		out.KeyID = string(kid)
+	}
+	return out, nil
+}
+
+// Verify checks m's signature with pub, whose algorithm must match m.Alg.
+func (m *COSESign1) Verify(pub gocrypto.PublicKey) error {
+	alg, err := coseAlg(pub)
+	if err != nil {
+		return err
+	}
+	if alg != m.Alg {
+		return fmt.Errorf("cose: signed with alg %d, key is %d", m.Alg, alg)
+	}
+	tbs, err := coseSigStructure(m.protected, m.Payload)
+	if err != nil {
+		return err
+	}
+	ok := false
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(m.signature) == 2*size {
+			h := sha256.Sum256(tbs)
+			r, s := new(big.Int).SetBytes(m.signature[:size]), new(big.Int).SetBytes(m.signature[size:])
+			ok = ecdsa.Verify(k, h[:], r, s)
+		}
+	default:
+		ok, err = verifyBytes(pub, tbs, m.signature)
+		if err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return errors.New("cose: bad signature")
+	}
+	return nil
+}
+
+// coseSigStructure is the Sig_structure a COSE_Sign1 signature covers, with
+// no external AAD.
+func coseSigStructure(protected, payload []byte) ([]byte, error) {
+	return cborEnc.Marshal([]any{"Signature1", protected, []byte{}, payload})
+}
+
+func coseAlg(pub gocrypto.PublicKey) (int, error) {
+	alg, err := Algorithm(pub)
+	if err != nil {
+		return 0, err
+	}
+	switch alg {
+	case AlgES256:
+		return COSEAlgES256, nil
+	case AlgEdDSA:
+		return COSEAlgEdDSA, nil
+	}
+	return COSEAlgPS256, nil
+}
@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSignCOSE(t *testing.T) {
+	payload, err := MarshalCBOR(map[string]any{"license_key": "k", "seats": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []string{AlgES256, AlgEdDSA, AlgPS256} {
+		privPEM, pubPEM, err := GenerateKeyPEM(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, _ := ParsePrivateKey(privPEM)
+		pub, _ := ParsePublicKey(pubPEM)
+
+		msg, err := SignCOSE(priv, "kid-1", payload)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if msg[0] != 0xd2 { // tag 18
+			t.Fatalf("%s: not a tagged COSE_Sign1: %x", alg, msg[:4])
+		}
+		m, err := ParseCOSESign1(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if m.KeyID != "kid-1" || !bytes.Equal(m.Payload, payload) {
+			t.Fatalf("%s: parsed %+v", alg, m)
+		}
+		if err := m.Verify(pub); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		m.Payload = append([]byte{}, payload...)
+		m.Payload[len(m.Payload)-1]++
+		if err := m.Verify(pub); err == nil {
+			t.Fatalf("%s: tampered payload verified", alg)
+		}
+		_, otherPEM, _ := GenerateKeyPEM(AlgEdDSA)
+		other, _ := ParsePublicKey(otherPEM)
+		if alg != AlgEdDSA {
+			if err := m.Verify(other); err == nil {
+				t.Fatalf("%s: verified with an Ed25519 key", alg)
+			}
+		}
+	}
+	if _, err := ParseCOSESign1([]byte("not cbor")); err == nil {
+		t.Fatal("garbage parsed")
+	}
+}
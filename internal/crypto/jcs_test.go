@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		name string
+		in   any
+		want string
+	}{
+		// RFC 8785 section 3.2.2
+		{"rfc example", json.RawMessage(`{"numbers":[333333333.33333329,1E30,4.50,2e-3,0.000000000000000000000000001],
+			"string":"\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/","literals":[null,true,false]}`),
+			"{\"literals\":[null,true,false],\"numbers\":[333333333.3333333,1e+30,4.5,0.002,1e-27],\"string\":\"\u20ac$\\u000f\\nA'B\\\"\\\\\\\\\\\"/\"}"},
+		// RFC 8785 section 3.2.3: UTF-16 order puts U+1F600 before U+FB33
+		{"key order", json.RawMessage(`{"\u20ac":1,"\r":2,"\ufb33":3,"1":4,"\ud83d\ude00":5,"\u0080":6,"\u00f6":7}`),
+			"{\"\\r\":2,\"1\":4,\"\u0080\":6,\"\u00f6\":7,\"\u20ac\":1,\"\U0001F600\":5,\"\ufb33\":3}"},
+		{"no html escaping", map[string]any{"customer": "A&B <x>", "sep": "\u2028"}, "{\"customer\":\"A&B <x>\",\"sep\":\"\u2028\"}"},
+		{"nested structs", map[string]any{"b": struct {
+			Z int `json:"z"`
+			A int `json:"a"`
+		}{1, 2}, "a": []any{}}, `{"a":[],"b":{"a":2,"z":1}}`},
+	}
+	for _, c := range cases {
+		got, err := Canonicalize(c.in)
+		if err != nil || string(got) != c.want {
+			t.Errorf("%s: got %s (%v)\nwant %s", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestCanonicalNumbers(t *testing.T) {
+	// RFC 8785 appendix B
+	for bits, want := range map[uint64]string{
+		0x0000000000000000: "0",
+		0x8000000000000000: "0",
+		0x0000000000000001: "5e-324",
+		0x8000000000000001: "-5e-324",
+		0x7fefffffffffffff: "1.7976931348623157e+308",
+		0x4340000000000000: "9007199254740992",
+		0xc340000000000000: "-9007199254740992",
+		0x4430000000000000: "295147905179352830000",
+		0x44b52d02c7e14af5: "9.999999999999997e+22",
+		0x44b52d02c7e14af6: "1e+23",
+		0x3eb0c6f7a0b5ed8d: "0.000001",
+		0x3eb0c6f7a0b5ed8c: "9.999999999999997e-7",
+		0x41b3de4355555553: "333333333.3333332",
+	} {
+		got, err := Canonicalize(math.Float64frombits(bits))
+		if err != nil || string(got) != want {
+			t.Errorf("%#016x: got %s (%v), want %s", bits, got, err, want)
+		}
+	}
+}
+
+func TestVerifyJSONLegacyEncoding(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	payload := map[string]any{"customer": "A&B", "seats": 3}
+
+	// a signature made before canonicalization, over json.Marshal's bytes
+	legacy, _ := json.Marshal(payload)
+	h := sha256.Sum256(legacy)
+	r, s, _ := ecdsa.Sign(rand.Reader, priv, h[:])
+	der, _ := asn1.Marshal(ecdsaSig{R: r, S: s})
+	if ok, err := VerifyJSON(&priv.PublicKey, payload, base64.RawURLEncoding.EncodeToString(der)); err != nil || !ok {
+		t.Fatalf("legacy signature: ok=%v err=%v", ok, err)
+	}
+
+	sig, err := SignJSON(priv, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyJSON(&priv.PublicKey, payload, sig); err != nil || !ok {
+		t.Fatalf("canonical signature: ok=%v err=%v", ok, err)
+	}
+	payload["seats"] = 4
+	if ok, _ := VerifyJSON(&priv.PublicKey, payload, sig); ok {
+		t.Fatal("tampered payload verified")
+	}
+}
@@ -0,0 +1,224 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted PKCS#8 (RFC 5958) with PBES2 (RFC 8018), which is what
+// `openssl pkcs8 -topk8` and most KMS exports produce. PBKDF2 (HMAC-SHA1/
+// SHA-2) or scrypt key derivation and AES-CBC encryption are supported; the
+// legacy PBES1 schemes (DES, RC2) are not.
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// ErrPassphraseRequired is returned for an encrypted private key when no
+// passphrase was given.
+var ErrPassphraseRequired = errors.New("private key is encrypted and no passphrase was given")
+
+// ErrBadPassphrase is returned when an encrypted private key does not
+// decrypt with the passphrase given.
+var ErrBadPassphrase = errors.New("private key does not decrypt with this passphrase (wrong passphrase?)")
+
+// pbkdf2Iterations is used when encrypting; decryption takes the count from
+// the key.
+const pbkdf2Iterations = 600_000
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt            []byte
+	CostParameter   int
+	BlockSize       int
+	Parallelization int
+	KeyLength       int `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts the DER of an "ENCRYPTED PRIVATE KEY" block to the
+// plain PKCS#8 DER.
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("malformed encrypted private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %s (only PBES2 is supported; re-export with openssl pkcs8 -topk8 -v2 aes-256-cbc)", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("malformed PBES2 parameters")
+	}
+
+	var keyLen int
+	switch enc := params.EncryptionScheme.Algorithm; {
+	case enc.Equal(oidAES128CBC):
+		keyLen = 16
+	case enc.Equal(oidAES192CBC):
+		keyLen = 24
+	case enc.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported private key cipher %s (want AES-CBC)", enc)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("malformed AES-CBC parameters")
+	}
+
+	key, err := deriveKey(params.KeyDerivationFunc, passphrase, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("malformed encrypted private key")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// strip PKCS#7 padding; bad padding almost always means a wrong passphrase
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, ErrBadPassphrase
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, ErrBadPassphrase
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+func deriveKey(kdf pkix.AlgorithmIdentifier, passphrase []byte, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var p pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &p); err != nil {
+			return nil, fmt.Errorf("malformed PBKDF2 parameters")
+		}
+		if p.KeyLength != 0 && p.KeyLength != keyLen {
+			return nil, fmt.Errorf("PBKDF2 key length %d does not match the cipher", p.KeyLength)
+		}
+		var h func() hash.Hash
+		switch prf := p.PRF.Algorithm; {
+		case len(prf) == 0, prf.Equal(oidHMACSHA1):
+			h = sha1.New
+		case prf.Equal(oidHMACSHA256):
+			h = sha256.New
+		case prf.Equal(oidHMACSHA384):
+			h = sha512.New384
+		case prf.Equal(oidHMACSHA512):
+			h = sha512.New
+		default:
+			return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", prf)
+		}
+		return pbkdf2.Key(passphrase, p.Salt, p.Iterations, keyLen, h), nil
+	case kdf.Algorithm.Equal(oidScrypt):
+		var p scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &p); err != nil {
+			return nil, fmt.Errorf("malformed scrypt parameters")
+		}
+		return scrypt.Key(passphrase, p.Salt, p.CostParameter, p.BlockSize, p.Parallelization, keyLen)
+	}
+	return nil, fmt.Errorf("unsupported key derivation %s (want PBKDF2 or scrypt)", kdf.Algorithm)
+}
+
+// EncryptPrivateKeyPEM encrypts key as an "ENCRYPTED PRIVATE KEY" PEM using
+// PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, which openssl and other
+// PKCS#8 readers accept.
+func EncryptPrivateKeyPEM(key any, passphrase []byte) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	salt, iv := make([]byte, 16), make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt: salt, Iterations: pbkdf2Iterations, KeyLength: 32,
+		PRF: pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return "", err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return "", err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return "", err
+	}
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, make([]byte, pad)...)
+	for i := len(der); i < len(plain); i++ {
+		plain[i] = byte(pad)
+	}
+	enc := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(enc, plain)
+
+	out, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: enc,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: out})), nil
+}
@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// AlgECDHESA256GCM is the only Sealed algorithm: ephemeral-static ECDH on
+// the recipient's curve, HKDF-SHA256 and AES-256-GCM.
+const AlgECDHESA256GCM = "ECDH-ES+A256GCM"
+
+// sealInfo prefixes the HKDF info; the ephemeral and recipient public keys
+// follow it, binding the key to both.
+const sealInfo = "raalisence sealed v1"
+
+// Sealed is JSON encrypted to a client's X25519 or P-256 key, so only the
+// holder of the private key can read it. All byte fields are unpadded
+// base64url. To open it, compute the ECDH secret of the recipient private
+// key and EPK, derive 32 bytes with HKDF-SHA256 (no salt, info = "raalisence
+// sealed v1" || EPK || recipient public key, keys in their raw encodings:
+// 32 bytes for X25519, the 65-byte uncompressed point for P-256) and decrypt
+// Ciphertext (which ends with the GCM tag) with AES-256-GCM under Nonce.
+type Sealed struct {
+	Alg        string `json:"alg"`
+	Crv        string `json:"crv"` // X25519 or P-256
+	EPK        string `json:"epk"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// ParseRecipientKey parses a client encryption key: a PKIX "PUBLIC KEY" PEM
+// holding an X25519 or P-256 key, or a bare base64url X25519 key.
+func ParseRecipientKey(s string) (*ecdh.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.New("recipient key must be a PEM public key or base64url X25519 key")
+		}
+		return ecdh.X25519().NewPublicKey(raw)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *ecdh.PublicKey:
+		if k.Curve() == ecdh.X25519() || k.Curve() == ecdh.P256() {
+			return k, nil
+		}
+	case *ecdsa.PublicKey:
+		if pub, err := k.ECDH(); err == nil && pub.Curve() == ecdh.P256() {
+			return pub, nil
+		}
+	}
+	return nil, errors.New("recipient key must be X25519 or P-256")
+}
+
+// SealJSON encrypts the JSON encoding of v to recipient.
+func SealJSON(recipient *ecdh.PublicKey, v any) (*Sealed, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	eph, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sealAEAD(shared, eph.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Sealed{
+		Alg:        AlgECDHESA256GCM,
+		Crv:        curveName(recipient.Curve()),
+		EPK:        base64.RawURLEncoding.EncodeToString(eph.PublicKey().Bytes()),
+		Nonce:      base64.RawURLEncoding.EncodeToString(nonce),
+		Ciphertext: base64.RawURLEncoding.EncodeToString(aead.Seal(nil, nonce, plain, nil)),
+	}, nil
+}
+
+// OpenJSON decrypts s with the recipient's private key into v.
+func (s *Sealed) OpenJSON(priv *ecdh.PrivateKey, v any) error {
+	if s.Alg != AlgECDHESA256GCM {
+		return fmt.Errorf("unsupported sealed alg %q", s.Alg)
+	}
+	if s.Crv != curveName(priv.Curve()) {
+		return fmt.Errorf("sealed to a %s key, not %s", s.Crv, curveName(priv.Curve()))
+	}
+	epkRaw, err := base64.RawURLEncoding.DecodeString(s.EPK)
+	if err != nil {
+		return fmt.Errorf("epk: %w", err)
+	}
+	epk, err := priv.Curve().NewPublicKey(epkRaw)
+	if err != nil {
+		return fmt.Errorf("epk: %w", err)
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(s.Nonce)
+	if err != nil {
+		return fmt.Errorf("nonce: %w", err)
+	}
+	ct, err := base64.RawURLEncoding.DecodeString(s.Ciphertext)
+	if err != nil {
+		return fmt.Errorf("ciphertext: %w", err)
+	}
+	shared, err := priv.ECDH(epk)
+	if err != nil {
+		return err
+	}
+	aead, err := sealAEAD(shared, epkRaw, priv.PublicKey().Bytes())
+	if err != nil {
+		return err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return errors.New("nonce: wrong size")
+	}
+	plain, err := aead.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return errors.New("cannot decrypt (wrong key?)")
+	}
+	return json.Unmarshal(plain, v)
+}
+
+func sealAEAD(shared, epk, recipient []byte) (cipher.AEAD, error) {
+	info := append(append([]byte(sealInfo), epk...), recipient...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, info), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func curveName(c ecdh.Curve) string {
+	if c == ecdh.P256() {
+		return "P-256"
+	}
+	return "X25519"
+}
@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"reflect"
+	"testing"
+)
+
+func TestSealJSON(t *testing.T) {
+	features := map[string]any{"tier": "enterprise", "modules": []any{"cad", "cam"}}
+	for _, curve := range []ecdh.Curve{ecdh.X25519(), ecdh.P256()} {
+		priv, _ := curve.GenerateKey(rand.Reader)
+		der, _ := x509.MarshalPKIXPublicKey(priv.PublicKey())
+		recipient, err := ParseRecipientKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := SealJSON(recipient, features)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := sealed.OpenJSON(priv, &got); err != nil {
+			t.Fatalf("%s: %v", sealed.Crv, err)
+		}
+		if !reflect.DeepEqual(got, features) {
+			t.Fatalf("%s: got %v", sealed.Crv, got)
+		}
+
+		other, _ := curve.GenerateKey(rand.Reader)
+		if err := sealed.OpenJSON(other, &got); err == nil {
+			t.Fatalf("%s: opened with the wrong key", sealed.Crv)
+		}
+	}
+}
+
+func TestParseRecipientKey(t *testing.T) {
+	priv, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	pub, err := ParseRecipientKey(base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()))
+	if err != nil || !pub.Equal(priv.PublicKey()) {
+		t.Fatalf("bare X25519 key: %v", err)
+	}
+	if _, err := ParseRecipientKey(opensslEd25519Public); err == nil {
+		t.Fatal("Ed25519 signing key accepted as a recipient")
+	}
+	if _, err := ParseRecipientKey("not a key!"); err == nil {
+		t.Fatal("garbage accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// EncryptSecret encrypts a secret for storage with AES-256-GCM under key,
+// bound to context (e.g. the row it belongs to) so it can't be moved to
+// another. The result is unpadded base64url of nonce || ciphertext.
+func EncryptSecret(key, plain []byte, context string) (string, error) {
+	aead, err := secretAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(context))), nil
+}
+
+// DecryptSecret reverses EncryptSecret.
+func DecryptSecret(key []byte, enc, context string) ([]byte, error) {
+	aead, err := secretAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted secret")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return nil, errors.New("cannot decrypt secret (wrong key?)")
+	}
+	return plain, nil
+}
+
+func secretAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("secret encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
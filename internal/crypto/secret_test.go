@@ -0,0 +1,27 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	enc, err := EncryptSecret(key, []byte("hunter2"), "key:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := DecryptSecret(key, enc, "key:abc")
+	if err != nil || string(plain) != "hunter2" {
+		t.Fatalf("round trip: %q %v", plain, err)
+	}
+	if _, err := DecryptSecret(key, enc, "key:other"); err == nil {
+		t.Fatal("decrypted under another context")
+	}
+	if _, err := DecryptSecret(bytes.Repeat([]byte{8}, 32), enc, "key:abc"); err == nil {
+		t.Fatal("decrypted under another key")
+	}
+	if _, err := EncryptSecret([]byte("short"), []byte("x"), ""); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
@@ -1,79 +1,261 @@
 package crypto
 
 import (
+	"bytes"
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 )
 
+// Signing algorithms, named as in JOSE (RFC 7518, RFC 8037). The algorithm
+// follows from the key type.
+const (
+	AlgES256 = "ES256" // ECDSA P-256/SHA-256, ASN.1 DER signature
+	AlgEdDSA = "EdDSA" // Ed25519, 64-byte signature
+	AlgPS256 = "PS256" // RSASSA-PSS/SHA-256, salt length = hash length
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing.
+const minRSABits = 2048
+
+// pssOptions matches JOSE PS256, which most RSA-only verifiers default to.
+var pssOptions = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: gocrypto.SHA256}
+
 type ecdsaSig struct{ R, S *big.Int }
 
-// SignJSON signs the canonical JSON encoding of payload using ECDSA P-256/SHA-256.
-func SignJSON(priv *ecdsa.PrivateKey, payload map[string]any) (string, error) {
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
+// Algorithm returns the signing algorithm for a private or public key.
+func Algorithm(key any) (string, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+		return AlgES256, nil
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return AlgEdDSA, nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return AlgPS256, nil
+	case gocrypto.Signer:
+		return Algorithm(k.Public())
 	}
-	h := sha256.Sum256(b)
-	r, s, err := ecdsa.Sign(rand.Reader, priv, h[:])
+	return "", fmt.Errorf("unsupported key type %T", key)
+}
+
+// SignJSON signs the canonical (RFC 8785) JSON encoding of payload with an
+// ECDSA P-256 (SHA-256), Ed25519 or RSA (PSS, SHA-256) key. priv may be any
+// crypto.Signer for such a key, e.g. one backed by Vault, as long as it
+// signs like the standard library's keys: ASN.1 DER for ECDSA, the message
+// itself for Ed25519.
+func SignJSON(priv gocrypto.Signer, payload map[string]any) (string, error) {
+	b, err := Canonicalize(payload)
 	if err != nil {
 		return "", err
 	}
-	sig, err := asn1.Marshal(ecdsaSig{R: r, S: s})
+	var sig []byte
+	switch priv.Public().(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(b)
+		sig, err = priv.Sign(rand.Reader, h[:], gocrypto.SHA256)
+	case ed25519.PublicKey:
+		sig, err = priv.Sign(rand.Reader, b, gocrypto.Hash(0))
+	case *rsa.PublicKey:
+		h := sha256.Sum256(b)
+		sig, err = priv.Sign(rand.Reader, h[:], pssOptions)
+	default:
+		return "", fmt.Errorf("unsupported key type %T", priv.Public())
+	}
 	if err != nil {
 		return "", err
 	}
 	return base64.RawURLEncoding.EncodeToString(sig), nil
 }
 
-// VerifyJSON verifies a signature over payload with a public key.
-func VerifyJSON(pub *ecdsa.PublicKey, payload map[string]any, sigB64 string) (bool, error) {
-	b, err := json.Marshal(payload)
+// VerifyJSON verifies a signature over payload with a public key. Payloads
+// signed before canonicalization (Go's json.Marshal encoding, which differs
+// only in escaping <, > and & and a few number forms) still verify.
+func VerifyJSON(pub gocrypto.PublicKey, payload map[string]any, sigB64 string) (bool, error) {
+	sig, err := base64.RawURLEncoding.DecodeString(sigB64)
 	if err != nil {
 		return false, err
 	}
-	h := sha256.Sum256(b)
-	sig, err := base64.RawURLEncoding.DecodeString(sigB64)
+	canonical, err := Canonicalize(payload)
 	if err != nil {
 		return false, err
 	}
-	var es ecdsaSig
-	if _, err := asn1.Unmarshal(sig, &es); err != nil {
+	ok, err := verifyBytes(pub, canonical, sig)
+	if ok || err != nil {
+		return ok, err
+	}
+	legacy, err := json.Marshal(payload)
+	if err != nil || bytes.Equal(legacy, canonical) {
 		return false, err
 	}
-	ok := ecdsa.Verify(pub, h[:], es.R, es.S)
-	return ok, nil
+	return verifyBytes(pub, legacy, sig)
+}
+
+func verifyBytes(pub gocrypto.PublicKey, b, sig []byte) (bool, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(b)
+		var es ecdsaSig
+		if _, err := asn1.Unmarshal(sig, &es); err != nil {
+			return false, err
+		}
+		return ecdsa.Verify(k, h[:], es.R, es.S), nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, b, sig), nil
+	case *rsa.PublicKey:
+		h := sha256.Sum256(b)
+		return rsa.VerifyPSS(k, gocrypto.SHA256, h[:], sig, pssOptions) == nil, nil
+	}
+	return false, fmt.Errorf("unsupported key type %T", pub)
 }
 
 // Helpers to generate PEM keys (useful in tests/dev)
 func GeneratePEM() (privPEM, pubPEM string, err error) {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return GenerateKeyPEM(AlgES256)
+}
+
+// GenerateKeyPEM generates a key pair for alg: SEC1 "EC PRIVATE KEY" for
+// ES256, PKCS#8 "PRIVATE KEY" for EdDSA, PKCS#1 "RSA PRIVATE KEY" (3072-bit)
+// for PS256, and a PKIX "PUBLIC KEY" for all of them.
+func GenerateKeyPEM(alg string) (privPEM, pubPEM string, err error) {
+	var block *pem.Block
+	var pub any
+	switch alg {
+	case AlgES256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		b, err := x509.MarshalECPrivateKey(priv)
+		if err != nil {
+			return "", "", err
+		}
+		block, pub = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, &priv.PublicKey
+	case AlgEdDSA:
+		edPub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		b, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return "", "", err
+		}
+		block, pub = &pem.Block{Type: "PRIVATE KEY", Bytes: b}, edPub
+	case AlgPS256:
+		priv, err := rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			return "", "", err
+		}
+		block, pub = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}, &priv.PublicKey
+	default:
+		return "", "", fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	privPEM = string(pem.EncodeToMemory(block))
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
 		return "", "", err
 	}
-	b, err := x509.MarshalECPrivateKey(priv)
+	pubPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	return
+}
+
+// ParsePrivateKey parses a SEC1 "EC PRIVATE KEY", PKCS#1 "RSA PRIVATE KEY"
+// or PKCS#8 "PRIVATE KEY" PEM holding an ECDSA, Ed25519 or RSA key.
+func ParsePrivateKey(pemStr string) (gocrypto.Signer, error) {
+	return ParsePrivateKeyWithPassphrase(pemStr, nil)
+}
+
+// ParsePrivateKeyWithPassphrase is ParsePrivateKey that also accepts keys
+// encrypted with passphrase: PKCS#8 "ENCRYPTED PRIVATE KEY", or the
+// traditional OpenSSL "Proc-Type: 4,ENCRYPTED" SEC1/PKCS#1 form written by
+// `openssl ec -aes256`.
+func ParsePrivateKeyWithPassphrase(pemStr string, passphrase []byte) (gocrypto.Signer, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM private key")
+	}
+	der := block.Bytes
+	//lint:ignore SA1019 the legacy format is weak but still what openssl ec/rsa write
+	encrypted := x509.IsEncryptedPEMBlock(block)
+	if encrypted {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		//lint:ignore SA1019 see above
+		plain, err := x509.DecryptPEMBlock(block, passphrase)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, ErrBadPassphrase
+		}
+		if err != nil {
+			return nil, err
+		}
+		der = plain
+	}
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		plain, err := decryptPKCS8(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		der, encrypted = plain, true
+	case "EC PRIVATE KEY", "RSA PRIVATE KEY", "PRIVATE KEY":
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		return nil, fmt.Errorf("PEM block %q is a public key, not a private key", block.Type)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q (want EC PRIVATE KEY, RSA PRIVATE KEY, PRIVATE KEY or ENCRYPTED PRIVATE KEY)", block.Type)
+	}
+	// the label doesn't always match the encoding, so try each in turn
+	key, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
-		return "", "", err
+		if ec, ecErr := x509.ParseECPrivateKey(der); ecErr == nil {
+			key, err = ec, nil
+		} else if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(der); rsaErr == nil {
+			key, err = rsaKey, nil
+		}
 	}
-	privPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}))
-	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
 	if err != nil {
-		return "", "", err
+		if encrypted {
+			return nil, ErrBadPassphrase
+		}
+		return nil, fmt.Errorf("PEM block %q is not a PKCS#8, SEC1 or PKCS#1 private key", block.Type)
 	}
-	pubPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
-	return
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		if err := checkRSASize(&k.PublicKey); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+func checkRSASize(pub *rsa.PublicKey) error {
+	if bits := pub.N.BitLen(); bits < minRSABits {
+		return fmt.Errorf("RSA key is %d bits, want at least %d", bits, minRSABits)
+	}
+	return nil
 }
 
-// Parse public key from PEM
-func ParsePublicKey(pemStr string) (*ecdsa.PublicKey, error) {
+// Parse public key from PEM: an ECDSA, Ed25519 or RSA PKIX "PUBLIC KEY".
+func ParsePublicKey(pemStr string) (gocrypto.PublicKey, error) {
 	block, _ := pem.Decode([]byte(pemStr))
 	if block == nil {
 		return nil, fmt.Errorf("invalid PEM")
@@ -82,9 +264,16 @@ func ParsePublicKey(pemStr string) (*ecdsa.PublicKey, error) {
 	if err != nil {
 		return nil, err
 	}
-	pub, ok := any.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("not ECDSA key")
+	switch pub := any.(type) {
+	case *ecdsa.PublicKey:
+		return pub, nil
+	case ed25519.PublicKey:
+		return pub, nil
+	case *rsa.PublicKey:
+		if err := checkRSASize(pub); err != nil {
+			return nil, err
+		}
+		return pub, nil
 	}
-	return pub, nil
+	return nil, fmt.Errorf("not an ECDSA, Ed25519 or RSA key")
 }
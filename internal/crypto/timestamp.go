@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	gocrypto "crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/digitorus/timestamp"
+)
+
+// maxTimestampResponse bounds how much of a TSA response is read.
+const maxTimestampResponse = 1 << 20
+
+// RequestTimestamp asks the RFC 3161 time-stamping authority at url to
+// timestamp msg (its SHA-256 hash, with a fresh nonce and the TSA's
+// certificate included) and returns the DER TimeStampToken.
+func RequestTimestamp(ctx context.Context, client *http.Client, url string, msg []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	tsq, err := timestamp.CreateRequest(bytes.NewReader(msg), &timestamp.RequestOptions{
+		Hash:         gocrypto.SHA256,
+		Certificates: true,
+		Nonce:        nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(tsq))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTimestampResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tsa: HTTP %d", resp.StatusCode)
+	}
+	ts, err := timestamp.ParseResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("tsa: %w", err)
+	}
+	if ts.Nonce == nil || ts.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("tsa: response nonce does not match the request")
+	}
+	if err := checkImprint(ts, msg); err != nil {
+		return nil, err
+	}
+	return ts.RawToken, nil
+}
+
+// VerifyTimestamp checks that token is a validly signed timestamp of msg
+// and returns the time it attests. With roots, the TSA certificate in the
+// token must chain to one of them and be valid for time stamping at that
+// time; without, only the token's own signature is checked.
+func VerifyTimestamp(token, msg []byte, roots *x509.CertPool) (time.Time, error) {
+	ts, err := timestamp.Parse(token)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp: %w", err)
+	}
+	if !ts.AddTSACertificate || len(ts.Certificates) == 0 {
+		return time.Time{}, errors.New("timestamp: token does not include the TSA certificate")
+	}
+	if err := checkImprint(ts, msg); err != nil {
+		return time.Time{}, err
+	}
+	if roots != nil {
+		p7, err := pkcs7.Parse(token)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("timestamp: %w", err)
+		}
+		inter := x509.NewCertPool()
+		for _, c := range p7.Certificates {
+			inter.AddCert(c)
+		}
+		if err := p7.VerifyWithOpts(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: inter,
+			CurrentTime:   ts.Time,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		}); err != nil {
+			return time.Time{}, fmt.Errorf("timestamp: %w", err)
+		}
+	}
+	return ts.Time, nil
+}
+
+func checkImprint(ts *timestamp.Timestamp, msg []byte) error {
+	if ts.HashAlgorithm != gocrypto.SHA256 {
+		return fmt.Errorf("timestamp: unexpected hash %v", ts.HashAlgorithm)
+	}
+	h := sha256.Sum256(msg)
+	if !bytes.Equal(ts.HashedMessage, h[:]) {
+		return errors.New("timestamp: token is for a different message")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digitorus/timestamp"
+)
+
+// testTSA serves RFC 3161 requests, signing with a certificate issued by the
+// returned root.
+func testTSA(t *testing.T) (*httptest.Server, *x509.Certificate) {
+	t.Helper()
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := testCert(t, "tsa root", true, rootKey.Public(), nil, rootKey, time.Now().AddDate(1, 0, 0))
+	tsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "tsa"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, root, tsaKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsaCert, _ := x509.ParseCertificate(der)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := timestamp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts := timestamp.Timestamp{
+			HashAlgorithm:     req.HashAlgorithm,
+			HashedMessage:     req.HashedMessage,
+			Time:              time.Now().UTC().Truncate(time.Second),
+			Nonce:             req.Nonce,
+			Policy:            []int{1, 2, 3},
+			AddTSACertificate: req.Certificates,
+		}
+		resp, err := ts.CreateResponseWithOpts(tsaCert, tsaKey, gocrypto.SHA256)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, root
+}
+
+func TestTimestamp(t *testing.T) {
+	srv, root := testTSA(t)
+	msg := []byte("signature bytes")
+	token, err := RequestTimestamp(context.Background(), srv.Client(), srv.URL, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	at, err := VerifyTimestamp(token, msg, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(at) > time.Minute {
+		t.Fatalf("timestamp %v is not recent", at)
+	}
+	if _, err := VerifyTimestamp(token, []byte("other bytes"), nil); err == nil {
+		t.Fatal("token verified for another message")
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other := x509.NewCertPool()
+	other.AddCert(testCert(t, "other root", true, otherKey.Public(), nil, otherKey, time.Now().AddDate(1, 0, 0)))
+	if _, err := VerifyTimestamp(token, msg, other); err == nil {
+		t.Fatal("token verified against the wrong root")
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	if _, err := RequestTimestamp(context.Background(), http.DefaultClient, down.URL, msg); err == nil {
+		t.Fatal("expected an error from an unreachable TSA")
+	}
+}
@@ -0,0 +1,10 @@
+-- internal/db/migrations/0002_activations.sql
+alter table licenses add column if not exists max_activations integer not null default 1;
+
+create table if not exists activations (
+    id uuid primary key,
+    license_key text not null references licenses(license_key) on delete cascade,
+    machine_id text not null,
+    activated_at timestamptz not null default now(),
+    unique (license_key, machine_id)
+);
@@ -0,0 +1,12 @@
+-- internal/db/migrations/0003_sessions.sql
+alter table licenses add column if not exists floating_seats integer not null default 0;
+
+create table if not exists sessions (
+    id uuid primary key,
+    license_key text not null references licenses(license_key) on delete cascade,
+    machine_id text not null,
+    checked_out_at timestamptz not null,
+    last_heartbeat_at timestamptz not null
+);
+create index if not exists idx_sessions_license_key on sessions(license_key);
+create index if not exists idx_sessions_last_heartbeat_at on sessions(last_heartbeat_at);
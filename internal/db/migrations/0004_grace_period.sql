@@ -0,0 +1,3 @@
+-- internal/db/migrations/0004_grace_period.sql
+-- null means "use the server default (licensing.grace_days)"
+alter table licenses add column if not exists grace_days integer null;
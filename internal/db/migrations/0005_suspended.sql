@@ -0,0 +1,2 @@
+-- internal/db/migrations/0005_suspended.sql
+alter table licenses add column if not exists suspended boolean not null default false;
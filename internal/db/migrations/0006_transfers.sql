@@ -0,0 +1,9 @@
+-- internal/db/migrations/0006_transfers.sql
+create table if not exists license_transfers (
+    id uuid primary key,
+    license_key text not null references licenses(license_key) on delete cascade,
+    from_machine_id text not null,
+    to_machine_id text not null,
+    reason text not null default '',
+    transferred_at timestamptz not null default now()
+);
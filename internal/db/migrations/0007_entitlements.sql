@@ -0,0 +1,2 @@
+-- internal/db/migrations/0007_entitlements.sql
+alter table licenses add column if not exists entitlements jsonb not null default '{}';
@@ -0,0 +1,9 @@
+-- internal/db/migrations/0008_usage.sql
+create table if not exists usage_records (
+    license_key text not null references licenses(license_key) on delete cascade,
+    metric text not null,
+    period text not null,          -- e.g. 2025-01 (monthly) or 2025-01-31 (daily)
+    amount bigint not null default 0,
+    updated_at timestamptz not null default now(),
+    primary key (license_key, metric, period)
+);
@@ -0,0 +1,2 @@
+-- internal/db/migrations/0009_product_id.sql
+alter table licenses add column if not exists product_id text not null default '';
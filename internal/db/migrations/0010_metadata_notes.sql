@@ -0,0 +1,3 @@
+-- internal/db/migrations/0010_metadata_notes.sql
+alter table licenses add column if not exists metadata jsonb not null default '{}';
+alter table licenses add column if not exists notes text not null default '';
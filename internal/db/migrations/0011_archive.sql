@@ -0,0 +1,3 @@
+-- internal/db/migrations/0011_archive.sql
+alter table licenses add column if not exists archived_at timestamptz null;
+create index if not exists idx_licenses_archived_at on licenses(archived_at);
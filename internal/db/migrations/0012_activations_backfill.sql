@@ -0,0 +1,6 @@
+-- internal/db/migrations/0012_activations_backfill.sql
+-- validation now checks activations membership, so every license needs its
+-- issuing machine recorded there.
+insert into activations (id, license_key, machine_id, activated_at)
+select gen_random_uuid(), license_key, machine_id, created_at from licenses
+on conflict (license_key, machine_id) do nothing;
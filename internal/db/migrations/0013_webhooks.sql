@@ -0,0 +1,29 @@
+-- internal/db/migrations/0013_webhooks.sql
+create table if not exists webhooks (
+    id uuid primary key,
+    url text not null,
+    secret text not null,
+    events jsonb not null default '[]'::jsonb,   -- empty = every event
+    active boolean not null default true,
+    created_at timestamptz not null default now()
+);
+
+create table if not exists webhook_deliveries (
+    id uuid primary key,
+    webhook_id uuid not null references webhooks(id) on delete cascade,
+    event text not null,
+    payload jsonb not null,
+    status text not null default 'pending',     -- pending | delivered | failed
+    attempts integer not null default 0,
+    next_attempt_at timestamptz not null,
+    last_error text not null default '',
+    response_code integer null,
+    created_at timestamptz not null default now(),
+    delivered_at timestamptz null
+);
+create index if not exists idx_webhook_deliveries_due on webhook_deliveries(status, next_attempt_at);
+create index if not exists idx_webhook_deliveries_webhook on webhook_deliveries(webhook_id, created_at);
+
+-- time-based events fire once per transition
+alter table licenses add column if not exists expiry_notified_at timestamptz null;
+alter table licenses add column if not exists stale_notified_at timestamptz null;
@@ -0,0 +1,10 @@
+-- internal/db/migrations/0014_idempotency_keys.sql
+-- Idempotency-Key header on issuance: a retried request replays response.
+create table if not exists idempotency_keys (
+    key text primary key,
+    request_hash text not null,
+    license_key text not null,
+    response jsonb null,              -- null until the license file is signed
+    created_at timestamptz not null
+);
+create index if not exists idx_idempotency_keys_license_key on idempotency_keys(license_key);
@@ -0,0 +1,11 @@
+-- internal/db/migrations/0015_audit_log.sql
+create table if not exists audit_log (
+    id uuid primary key,
+    actor text not null,                 -- admin key id, e.g. key:1a2b3c4d5e6f
+    action text not null,                -- license.issue, license.revoke, ...
+    license_key text not null default '',
+    details jsonb not null default '{}'::jsonb,
+    request_id text not null default '',
+    created_at timestamptz not null
+);
+create index if not exists idx_audit_log_created_at on audit_log(created_at, id);
@@ -0,0 +1,14 @@
+-- internal/db/migrations/0016_machines.sql
+-- hardware reported by clients on activate and heartbeat; a machine may hold
+-- several licenses (see activations)
+create table if not exists machines (
+    machine_id text primary key,
+    hostname text not null default '',
+    os text not null default '',
+    fingerprint jsonb not null default '{}'::jsonb,   -- component name -> value
+    first_seen_at timestamptz not null,
+    last_seen_at timestamptz not null,
+    last_license_key text not null default ''
+);
+create index if not exists idx_machines_first_seen on machines(first_seen_at, machine_id);
+create index if not exists idx_machines_hostname on machines(hostname);
@@ -0,0 +1,12 @@
+-- internal/db/migrations/0017_api_keys.sql
+-- admin keys managed through the API; the config hashes remain as bootstrap keys
+create table if not exists api_keys (
+    id uuid primary key,
+    label text not null default '',
+    key_prefix text not null,            -- first characters of the key, for display
+    key_hash text not null unique,       -- sha256 hex of the full key
+    created_at timestamptz not null,
+    rotated_at timestamptz null,
+    last_used_at timestamptz null,
+    revoked_at timestamptz null
+);
@@ -0,0 +1,11 @@
+-- internal/db/migrations/0018_validation_events.sql
+-- one row per validation of a known license, for the activity endpoint
+create table if not exists validation_events (
+    id uuid primary key,
+    license_key text not null,
+    machine_id text not null,
+    valid boolean not null,
+    reason text not null default '',
+    ip text not null default '',
+    created_at timestamptz not null
+);
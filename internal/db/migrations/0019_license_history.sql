@@ -0,0 +1,12 @@
+-- internal/db/migrations/0019_license_history.sql
+-- field-level before/after of every change to a license row
+create table if not exists license_history (
+    id uuid primary key,
+    license_key text not null,
+    action text not null,                -- license.issue, license.update, ...
+    actor text not null,
+    changes jsonb not null default '{}'::jsonb, -- {"field":{"before":..,"after":..}}
+    request_id text not null default '',
+    created_at timestamptz not null
+);
+create index if not exists idx_license_history_license_key on license_history(license_key, created_at, id);
@@ -0,0 +1,3 @@
+-- internal/db/migrations/0020_features_recipient.sql
+-- client public key license file features are encrypted to; empty = plaintext
+alter table licenses add column if not exists features_recipient_key text not null default '';
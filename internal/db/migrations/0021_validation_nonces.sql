@@ -0,0 +1,12 @@
+-- internal/db/migrations/0021_validation_nonces.sql
+-- server-issued validation challenges; licenses with require_nonce must
+-- validate with one, and each is deleted when used
+alter table licenses add column if not exists require_nonce boolean not null default false;
+create table if not exists validation_nonces (
+    nonce text primary key,
+    license_key text not null,
+    machine_id text not null,
+    expires_at timestamptz not null,
+    created_at timestamptz not null
+);
+create index if not exists idx_validation_nonces_expires_at on validation_nonces(expires_at);
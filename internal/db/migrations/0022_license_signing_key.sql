@@ -0,0 +1,6 @@
+-- internal/db/migrations/0022_license_signing_key.sql
+-- tenant selects a signing.tenants key; signing_key_id records the key id
+-- (JWKS kid) of the key the license was last signed with
+alter table licenses add column if not exists tenant text not null default '';
+alter table licenses add column if not exists signing_key_id text not null default '';
+create index if not exists idx_licenses_signing_key_id on licenses(signing_key_id);
@@ -0,0 +1,4 @@
+-- internal/db/migrations/0023_api_key_roles.sql
+-- role limits what a managed key may do (admin, issuer, support, viewer);
+-- keys created before roles existed keep full access
+alter table api_keys add column if not exists role text not null default 'admin';
@@ -0,0 +1,6 @@
+-- internal/db/migrations/0024_api_key_scopes.sql
+-- scopes limit a key to some routes; product_ids and customers to some
+-- licenses. Empty arrays limit nothing.
+alter table api_keys add column if not exists scopes jsonb not null default '[]'::jsonb;
+alter table api_keys add column if not exists product_ids jsonb not null default '[]'::jsonb;
+alter table api_keys add column if not exists customers jsonb not null default '[]'::jsonb;
@@ -0,0 +1,12 @@
+-- internal/db/migrations/0025_admin_sessions.sql
+-- admin panel sign-ins through OIDC; the cookie holds the token, we keep its hash
+create table if not exists admin_sessions (
+    id uuid primary key,
+    token_hash text not null unique,     -- sha256 hex of the session token
+    subject text not null,               -- the provider's sub claim
+    email text not null default '',
+    role text not null,
+    created_at timestamptz not null,
+    expires_at timestamptz not null
+);
+create index if not exists admin_sessions_expires_at_idx on admin_sessions (expires_at);
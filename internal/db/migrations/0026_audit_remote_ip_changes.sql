@@ -0,0 +1,4 @@
+-- internal/db/migrations/0026_audit_remote_ip_changes.sql
+-- where an admin change came from, and what it did to the license
+alter table audit_log add column if not exists remote_ip text not null default '';
+alter table audit_log add column if not exists changes jsonb not null default '{}'::jsonb; -- {"field":{"before":..,"after":..}}
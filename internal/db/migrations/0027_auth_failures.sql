@@ -0,0 +1,9 @@
+-- internal/db/migrations/0027_auth_failures.sql
+-- failed admin logins per client IP, shared by every replica
+create table if not exists auth_failures (
+    client text primary key,             -- client IP
+    failures integer not null,
+    last_failure_at timestamptz not null,
+    locked_until timestamptz null
+);
+create index if not exists idx_auth_failures_last_failure_at on auth_failures(last_failure_at);
@@ -0,0 +1,3 @@
+-- internal/db/migrations/0028_request_secret.sql
+-- per-license HMAC secret; when set, validate and heartbeat calls must be signed with it
+alter table licenses add column if not exists request_secret text not null default '';
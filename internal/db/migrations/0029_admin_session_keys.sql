@@ -0,0 +1,4 @@
+-- internal/db/migrations/0029_admin_session_keys.sql
+-- sessions started by exchanging an API key (POST /api/v1/auth/login)
+alter table admin_sessions add column if not exists actor text not null default '';      -- '' = OIDC, actor from subject/email
+alter table admin_sessions add column if not exists api_key_id text not null default ''; -- managed key the session stands in for
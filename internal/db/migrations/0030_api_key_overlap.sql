@@ -0,0 +1,5 @@
+-- internal/db/migrations/0030_api_key_overlap.sql
+-- the secret a key had before its last rotation, still accepted until previous_expires_at
+alter table api_keys add column if not exists previous_key_hash text not null default '';
+alter table api_keys add column if not exists previous_expires_at timestamptz null;
+create index if not exists idx_api_keys_previous_key_hash on api_keys(previous_key_hash) where previous_key_hash <> '';
@@ -0,0 +1,9 @@
+-- internal/db/migrations/0031_admin_totp.sql
+-- TOTP second factors of admin identities (API keys, bootstrap keys, SSO users)
+create table if not exists admin_totp (
+    actor text primary key,              -- audit actor the factor belongs to
+    secret_enc text not null,            -- secret encrypted under server.totp.encryption_key
+    confirmed_at timestamptz,            -- null until a first code is confirmed
+    last_step bigint not null default 0, -- time step of the last accepted code, against replays
+    created_at timestamptz not null
+);
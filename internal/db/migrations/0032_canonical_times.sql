@@ -0,0 +1,4 @@
+-- internal/db/migrations/0032_canonical_times.sql
+-- SQLite's 0032 rewrites timestamps stored as TEXT into one layout. Postgres
+-- columns are timestamptz already, so there is nothing to do here; the file
+-- keeps the two sets of migrations numbered alike.
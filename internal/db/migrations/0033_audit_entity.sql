@@ -0,0 +1,9 @@
+-- internal/db/migrations/0033_audit_entity.sql
+-- what an audit entry is about, e.g. license:<key>, webhook:<id>, apikey:<id>
+alter table audit_log add column if not exists entity text not null default '';
+update audit_log set entity = case
+    when license_key <> '' then 'license:' || license_key
+    when action like 'webhook.%' and details ? 'webhook_id' then 'webhook:' || (details->>'webhook_id')
+    when action like 'apikey.%' and details ? 'key_id' then 'apikey:' || (details->>'key_id')
+    else '' end
+  where entity = '';
@@ -0,0 +1,11 @@
+-- internal/db/migrations/0034_events_outbox.sql
+-- license events, written in the transaction of the change they announce and
+-- handed to webhooks and the live stream afterwards
+create table if not exists events (
+    id uuid primary key,
+    event text not null,
+    data jsonb not null,
+    created_at timestamptz not null,
+    dispatched_at timestamptz null               -- null until dispatched
+);
+create index if not exists idx_events_pending on events(dispatched_at, created_at);
@@ -0,0 +1,6 @@
+-- internal/db/migrations/0035_machine_unique.sql
+-- licenses issued while licensing.unique_machine is on claim their
+-- customer+machine; at most one such claim is held while not revoked or archived
+alter table licenses add column if not exists machine_unique boolean not null default false;
+create unique index if not exists idx_licenses_machine_unique on licenses(customer, machine_id)
+    where machine_unique and not revoked and archived_at is null;
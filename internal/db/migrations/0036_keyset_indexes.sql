@@ -0,0 +1,22 @@
+-- internal/db/migrations/0036_keyset_indexes.sql
+-- indexes ending in the (time, id) order of the paginated lists, so a cursor
+-- seeks straight to its page instead of scanning every page before it; each
+-- replaces the shorter index it extends, which the earlier files no longer
+-- create, since a database migrated before schema_migrations was tracked
+-- reruns them once. On a large table, create
+-- them by hand first with "create index concurrently" to avoid blocking writes.
+create index if not exists idx_licenses_created_at on licenses(created_at, id);
+create index if not exists idx_licenses_product_created on licenses(product_id, created_at, id);
+drop index if exists idx_licenses_product_id;
+create index if not exists idx_licenses_tenant_created on licenses(tenant, created_at, id);
+drop index if exists idx_licenses_tenant;
+create index if not exists idx_activations_license_activated on activations(license_key, activated_at, machine_id);
+drop index if exists idx_activations_license_key;
+create index if not exists idx_license_transfers_license_transferred on license_transfers(license_key, transferred_at, id);
+drop index if exists idx_license_transfers_license_key;
+create index if not exists idx_validation_events_license_created on validation_events(license_key, created_at, id);
+drop index if exists idx_validation_events_license;
+create index if not exists idx_audit_log_license_created on audit_log(license_key, created_at, id);
+drop index if exists idx_audit_log_license_key;
+create index if not exists idx_audit_log_entity_created on audit_log(entity, created_at, id);
+drop index if exists idx_audit_log_entity;
@@ -0,0 +1,3 @@
+-- internal/db/migrations/0037_machines_last_seen.sql
+-- lets the metrics job count recently seen machines without a table scan
+create index if not exists idx_machines_last_seen on machines(last_seen_at);
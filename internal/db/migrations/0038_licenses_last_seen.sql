@@ -0,0 +1,4 @@
+-- internal/db/migrations/0038_licenses_last_seen.sql
+-- the stale license job counts, and GET /api/v1/licenses/stale pages
+-- through, licenses by last heartbeat
+create index if not exists idx_licenses_last_seen on licenses(last_seen_at, license_key);
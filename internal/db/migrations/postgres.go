@@ -0,0 +1,124 @@
+// Package migrations holds the Postgres schema, also used on CockroachDB.
+// As with the SQLite and MySQL migrations, each file is applied once and
+// recorded in schema_migrations.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed *.sql
+var postgresMigrations embed.FS
+
+// Versions returns the embedded migration versions in order.
+func Versions() ([]string, error) {
+	names, err := fs.Glob(postgresMigrations, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("embedded postgres migrations are empty")
+	}
+	sort.Strings(names)
+	versions := make([]string, len(names))
+	for i, name := range names {
+		versions[i] = strings.TrimSuffix(name, ".sql")
+	}
+	return versions, nil
+}
+
+// ApplyPostgres applies the embedded migrations not yet recorded in
+// schema_migrations, oldest first, each in one transaction with its record.
+// It returns the versions applied. A database migrated before the table
+// existed gets every file once more; they are written to be rerun.
+func ApplyPostgres(ctx context.Context, db *sql.DB) ([]string, error) {
+	if _, err := db.ExecContext(ctx, `create table if not exists schema_migrations (
+		version text primary key,
+		applied_at timestamptz not null default now()
+	)`); err != nil {
+		return nil, fmt.Errorf("schema_migrations: %w", err)
+	}
+	pending, _, err := StatusPostgres(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for i, version := range pending {
+		body, err := postgresMigrations.ReadFile(version + ".sql")
+		if err != nil {
+			return pending[:i], err
+		}
+		if err := applyMigration(ctx, db, version, string(body)); err != nil {
+			return pending[:i], fmt.Errorf("apply %s: %w", version, err)
+		}
+	}
+	return pending, nil
+}
+
+// StatusPostgres compares schema_migrations with the embedded migrations.
+// pending are embedded but not recorded, oldest first, and all of them when
+// the table is missing; unknown are recorded but not embedded, so applied by
+// a newer binary.
+func StatusPostgres(ctx context.Context, db *sql.DB) (pending, unknown []string, err error) {
+	versions, err := Versions()
+	if err != nil {
+		return nil, nil, err
+	}
+	var exists bool
+	if err := db.QueryRowContext(ctx, `select to_regclass('schema_migrations') is not null`).Scan(&exists); err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return versions, nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `select version from schema_migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	applied := map[string]bool{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, nil, err
+		}
+		applied[v] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	embedded := map[string]bool{}
+	for _, v := range versions {
+		embedded[v] = true
+		if !applied[v] {
+			pending = append(pending, v)
+		}
+	}
+	for v := range applied {
+		if !embedded[v] {
+			unknown = append(unknown, v)
+		}
+	}
+	sort.Strings(unknown)
+	return pending, unknown, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version, body string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `insert into schema_migrations (version) values ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
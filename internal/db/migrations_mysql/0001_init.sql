@@ -0,0 +1,216 @@
+-- internal/db/migrations_mysql/0001_init.sql (MySQL/MariaDB)
+-- The schema as of 0031_admin_totp in one go; later migrations carry on
+-- from 0032. Times are DATETIME(6) in UTC, JSON columns are JSON, and text
+-- compares byte for byte (utf8mb4_bin) as it does on Postgres.
+CREATE TABLE IF NOT EXISTS licenses (
+    id CHAR(36) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL UNIQUE,
+    customer VARCHAR(1024) NOT NULL,
+    machine_id VARCHAR(255) NOT NULL,
+    features JSON NOT NULL DEFAULT ('{}'),
+    expires_at DATETIME(6) NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    last_seen_at DATETIME(6) NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+    max_activations INT NOT NULL DEFAULT 1,
+    floating_seats INT NOT NULL DEFAULT 0,
+    grace_days INT NULL,
+    suspended BOOLEAN NOT NULL DEFAULT FALSE,
+    entitlements JSON NOT NULL DEFAULT ('{}'),
+    product_id VARCHAR(255) NOT NULL DEFAULT '',
+    metadata JSON NOT NULL DEFAULT ('{}'),
+    notes TEXT NOT NULL DEFAULT (''),
+    archived_at DATETIME(6) NULL,
+    expiry_notified_at DATETIME(6) NULL,
+    stale_notified_at DATETIME(6) NULL,
+    features_recipient_key TEXT NOT NULL DEFAULT (''),
+    require_nonce BOOLEAN NOT NULL DEFAULT FALSE,
+    tenant VARCHAR(255) NOT NULL DEFAULT '',
+    signing_key_id VARCHAR(255) NOT NULL DEFAULT '',
+    request_secret VARCHAR(255) NOT NULL DEFAULT '',
+    INDEX idx_licenses_product_id (product_id),
+    INDEX idx_licenses_archived_at (archived_at),
+    INDEX idx_licenses_tenant (tenant),
+    INDEX idx_licenses_signing_key_id (signing_key_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS activations (
+    id CHAR(36) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL,
+    machine_id VARCHAR(255) NOT NULL,
+    activated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE (license_key, machine_id),
+    FOREIGN KEY (license_key) REFERENCES licenses(license_key) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id CHAR(36) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL,
+    machine_id VARCHAR(255) NOT NULL,
+    checked_out_at DATETIME(6) NOT NULL,
+    last_heartbeat_at DATETIME(6) NOT NULL,
+    INDEX idx_sessions_license_key (license_key),
+    INDEX idx_sessions_last_heartbeat_at (last_heartbeat_at),
+    FOREIGN KEY (license_key) REFERENCES licenses(license_key) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS license_transfers (
+    id CHAR(36) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL,
+    from_machine_id VARCHAR(255) NOT NULL,
+    to_machine_id VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL DEFAULT (''),
+    transferred_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_license_transfers_license_key (license_key, transferred_at),
+    FOREIGN KEY (license_key) REFERENCES licenses(license_key) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS usage_records (
+    license_key VARCHAR(255) NOT NULL,
+    metric VARCHAR(255) NOT NULL,
+    period VARCHAR(32) NOT NULL,          -- e.g. 2025-01 (monthly) or 2025-01-31 (daily)
+    amount BIGINT NOT NULL DEFAULT 0,
+    updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (license_key, metric, period),
+    FOREIGN KEY (license_key) REFERENCES licenses(license_key) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id CHAR(36) PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events JSON NOT NULL DEFAULT ('[]'),  -- empty = every event
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id CHAR(36) PRIMARY KEY,
+    webhook_id CHAR(36) NOT NULL,
+    event VARCHAR(255) NOT NULL,
+    payload JSON NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',   -- pending | delivered | failed
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) NOT NULL,
+    last_error TEXT NOT NULL DEFAULT (''),
+    response_code INT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    delivered_at DATETIME(6) NULL,
+    INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+    INDEX idx_webhook_deliveries_webhook (webhook_id, created_at),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    "key" VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(255) NOT NULL,
+    license_key VARCHAR(255) NOT NULL,
+    response JSON NULL,                   -- null until the license file is signed
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_idempotency_keys_license_key (license_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id CHAR(36) PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,          -- admin key id, e.g. key:1a2b3c4d5e6f
+    action VARCHAR(255) NOT NULL,         -- license.issue, license.revoke, ...
+    license_key VARCHAR(255) NOT NULL DEFAULT '',
+    details JSON NOT NULL DEFAULT ('{}'),
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    remote_ip VARCHAR(255) NOT NULL DEFAULT '',
+    changes JSON NOT NULL DEFAULT ('{}'), -- {"field":{"before":..,"after":..}}
+    INDEX idx_audit_log_created_at (created_at, id),
+    INDEX idx_audit_log_license_key (license_key, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS machines (
+    machine_id VARCHAR(255) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL DEFAULT '',
+    os VARCHAR(255) NOT NULL DEFAULT '',
+    fingerprint JSON NOT NULL DEFAULT ('{}'),   -- component name -> value
+    first_seen_at DATETIME(6) NOT NULL,
+    last_seen_at DATETIME(6) NOT NULL,
+    last_license_key VARCHAR(255) NOT NULL DEFAULT '',
+    INDEX idx_machines_first_seen (first_seen_at, machine_id),
+    INDEX idx_machines_hostname (hostname)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id CHAR(36) PRIMARY KEY,
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    key_prefix VARCHAR(64) NOT NULL,      -- first characters of the key, for display
+    key_hash VARCHAR(64) NOT NULL UNIQUE, -- sha256 hex of the full key
+    created_at DATETIME(6) NOT NULL,
+    rotated_at DATETIME(6) NULL,
+    last_used_at DATETIME(6) NULL,
+    revoked_at DATETIME(6) NULL,
+    role VARCHAR(32) NOT NULL DEFAULT 'admin',
+    scopes JSON NOT NULL DEFAULT ('[]'),
+    product_ids JSON NOT NULL DEFAULT ('[]'),
+    customers JSON NOT NULL DEFAULT ('[]'),
+    previous_key_hash VARCHAR(64) NOT NULL DEFAULT '',  -- secret before the last rotation
+    previous_expires_at DATETIME(6) NULL,               -- when it stops working
+    INDEX idx_api_keys_previous_key_hash (previous_key_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS validation_events (
+    id CHAR(36) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL,
+    machine_id VARCHAR(255) NOT NULL,
+    valid BOOLEAN NOT NULL,
+    reason TEXT NOT NULL DEFAULT (''),
+    ip VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_validation_events_license (license_key, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS license_history (
+    id CHAR(36) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL,
+    action VARCHAR(255) NOT NULL,         -- license.issue, license.update, ...
+    actor VARCHAR(255) NOT NULL,
+    changes JSON NOT NULL DEFAULT ('{}'), -- {"field":{"before":..,"after":..}}
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_license_history_license_key (license_key, created_at, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS validation_nonces (
+    nonce VARCHAR(255) PRIMARY KEY,
+    license_key VARCHAR(255) NOT NULL,
+    machine_id VARCHAR(255) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_validation_nonces_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    id CHAR(36) PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,   -- sha256 hex of the session token
+    subject VARCHAR(255) NOT NULL,            -- the provider's sub claim
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(32) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    actor VARCHAR(255) NOT NULL DEFAULT '',    -- '' = OIDC, actor from subject/email
+    api_key_id VARCHAR(36) NOT NULL DEFAULT '', -- managed key the session stands in for
+    INDEX admin_sessions_expires_at_idx (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS auth_failures (
+    client VARCHAR(255) PRIMARY KEY,      -- client IP
+    failures INT NOT NULL,
+    last_failure_at DATETIME(6) NOT NULL,
+    locked_until DATETIME(6) NULL,
+    INDEX idx_auth_failures_last_failure_at (last_failure_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+CREATE TABLE IF NOT EXISTS admin_totp (
+    actor VARCHAR(255) PRIMARY KEY,       -- audit actor the factor belongs to
+    secret_enc TEXT NOT NULL,             -- secret encrypted under server.totp.encryption_key
+    confirmed_at DATETIME(6) NULL,        -- null until a first code is confirmed
+    last_step BIGINT NOT NULL DEFAULT 0,  -- time step of the last accepted code, against replays
+    created_at DATETIME(6) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
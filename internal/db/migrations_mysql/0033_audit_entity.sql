@@ -0,0 +1,9 @@
+-- internal/db/migrations_mysql/0033_audit_entity.sql (MySQL/MariaDB)
+ALTER TABLE audit_log ADD COLUMN entity VARCHAR(255) NOT NULL DEFAULT '',   -- license:<key>, webhook:<id>, apikey:<id>
+    ADD INDEX idx_audit_log_entity (entity, created_at);
+UPDATE audit_log SET entity = CASE
+    WHEN license_key <> '' THEN CONCAT('license:', license_key)
+    WHEN action LIKE 'webhook.%' AND JSON_EXTRACT(details, '$.webhook_id') IS NOT NULL THEN CONCAT('webhook:', JSON_UNQUOTE(JSON_EXTRACT(details, '$.webhook_id')))
+    WHEN action LIKE 'apikey.%' AND JSON_EXTRACT(details, '$.key_id') IS NOT NULL THEN CONCAT('apikey:', JSON_UNQUOTE(JSON_EXTRACT(details, '$.key_id')))
+    ELSE '' END
+  WHERE entity = '';
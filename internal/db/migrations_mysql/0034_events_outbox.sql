@@ -0,0 +1,9 @@
+-- internal/db/migrations_mysql/0034_events_outbox.sql (MySQL/MariaDB)
+CREATE TABLE IF NOT EXISTS events (
+    id CHAR(36) PRIMARY KEY,
+    event VARCHAR(255) NOT NULL,
+    data JSON NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    dispatched_at DATETIME(6) NULL,               -- null until dispatched
+    INDEX idx_events_pending (dispatched_at, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,7 @@
+-- internal/db/migrations_mysql/0035_machine_unique.sql (MySQL/MariaDB)
+-- no partial indexes here: machine_claim is 1 for a held claim and NULL
+-- otherwise, and a unique index ignores rows with a NULL column. customer is
+-- indexed by prefix to stay within InnoDB's key length.
+ALTER TABLE licenses ADD COLUMN machine_unique BOOLEAN NOT NULL DEFAULT FALSE,   -- set by licensing.unique_machine
+    ADD COLUMN machine_claim TINYINT AS (IF(machine_unique AND NOT revoked AND archived_at IS NULL, 1, NULL)) VIRTUAL,
+    ADD UNIQUE INDEX idx_licenses_machine_unique (customer(255), machine_id, machine_claim);
@@ -0,0 +1,18 @@
+-- internal/db/migrations_mysql/0036_keyset_indexes.sql (MySQL/MariaDB)
+-- indexes ending in the (time, id) order of the paginated lists; each
+-- replaces the shorter index it extends (activations' unique key already
+-- serves its foreign key, so its new index is only added)
+ALTER TABLE licenses ADD INDEX idx_licenses_created_at (created_at, id),
+    ADD INDEX idx_licenses_product_created (product_id, created_at, id),
+    ADD INDEX idx_licenses_tenant_created (tenant, created_at, id),
+    DROP INDEX idx_licenses_product_id,
+    DROP INDEX idx_licenses_tenant;
+ALTER TABLE activations ADD INDEX idx_activations_license_activated (license_key, activated_at, machine_id);
+ALTER TABLE license_transfers ADD INDEX idx_license_transfers_license_transferred (license_key, transferred_at, id),
+    DROP INDEX idx_license_transfers_license_key;
+ALTER TABLE validation_events ADD INDEX idx_validation_events_license_created (license_key, created_at, id),
+    DROP INDEX idx_validation_events_license;
+ALTER TABLE audit_log ADD INDEX idx_audit_log_license_created (license_key, created_at, id),
+    ADD INDEX idx_audit_log_entity_created (entity, created_at, id),
+    DROP INDEX idx_audit_log_license_key,
+    DROP INDEX idx_audit_log_entity;
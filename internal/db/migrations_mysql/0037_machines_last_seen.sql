@@ -0,0 +1,3 @@
+-- internal/db/migrations_mysql/0037_machines_last_seen.sql (MySQL/MariaDB)
+-- lets the metrics job count recently seen machines without a table scan
+ALTER TABLE machines ADD INDEX idx_machines_last_seen (last_seen_at);
@@ -0,0 +1,4 @@
+-- internal/db/migrations_mysql/0038_licenses_last_seen.sql (MySQL/MariaDB)
+-- the stale license job counts, and GET /api/v1/licenses/stale pages
+-- through, licenses by last heartbeat
+ALTER TABLE licenses ADD INDEX idx_licenses_last_seen (last_seen_at, license_key);
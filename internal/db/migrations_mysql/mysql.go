@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed *.sql
+var mysqlMigrations embed.FS
+
+// EnsureMySQLSchema applies the embedded MySQL migrations in order, each
+// exactly once, recording them in schema_migrations. MySQL commits DDL as
+// it goes, so a migration that fails halfway has to be finished by hand;
+// the files use IF NOT EXISTS where they can to make re-running safe.
+func EnsureMySQLSchema(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(255) PRIMARY KEY,
+		applied_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`); err != nil {
+		return fmt.Errorf("schema_migrations: %w", err)
+	}
+	pending, err := PendingMySQL(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, version := range pending {
+		body, err := mysqlMigrations.ReadFile(version + ".sql")
+		if err != nil {
+			return err
+		}
+		for _, stmt := range statements(string(body)) {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("apply %s: %w", version, err)
+			}
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			return fmt.Errorf("record %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// PendingMySQL lists the embedded migrations not yet recorded in
+// schema_migrations, oldest first; all of them when the table is missing.
+func PendingMySQL(ctx context.Context, db *sql.DB) ([]string, error) {
+	pending, _, err := StatusMySQL(ctx, db)
+	return pending, err
+}
+
+// StatusMySQL compares schema_migrations with the embedded migrations.
+// pending are embedded but not recorded, oldest first, and all of them when
+// the table is missing; unknown are recorded but not embedded, so applied by
+// a newer binary.
+func StatusMySQL(ctx context.Context, db *sql.DB) (pending, unknown []string, err error) {
+	versions, err := embeddedVersions()
+	if err != nil {
+		return nil, nil, err
+	}
+	var tables int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM information_schema.tables WHERE table_schema = database() AND table_name = 'schema_migrations'`).Scan(&tables); err != nil {
+		return nil, nil, err
+	}
+	if tables == 0 {
+		return versions, nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	applied := map[string]bool{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, nil, err
+		}
+		applied[v] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, v := range versions {
+		if !applied[v] {
+			pending = append(pending, v)
+		}
+		delete(applied, v)
+	}
+	for v := range applied {
+		unknown = append(unknown, v)
+	}
+	sort.Strings(unknown)
+	return pending, unknown, nil
+}
+
+// embeddedVersions returns the embedded migration versions in order.
+func embeddedVersions() ([]string, error) {
+	names, err := fs.Glob(mysqlMigrations, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("embedded mysql migrations are empty")
+	}
+	sort.Strings(names)
+	versions := make([]string, len(names))
+	for i, name := range names {
+		versions[i] = strings.TrimSuffix(name, ".sql")
+	}
+	return versions, nil
+}
+
+// statements splits a migration into its statements, one per ";" at the
+// end of a line and without it, since the server runs one per call.
+func statements(body string) []string {
+	var out []string
+	var cur strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "--") {
+			continue
+		}
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(line, ";") {
+			out = append(out, strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"))
+			cur.Reset()
+		}
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// stripComment drops a trailing "-- ..." comment from line.
+func stripComment(line string) string {
+	if i := strings.Index(line, "--"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
@@ -0,0 +1,24 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatements(t *testing.T) {
+	got := statements("-- header\nCREATE TABLE a (\n    x INT -- note; not the end\n);\n\nCREATE INDEX i ON a (x); -- trailing\nINSERT INTO a VALUES (1)")
+	if len(got) != 3 || !strings.HasPrefix(got[0], "CREATE TABLE a (") || !strings.HasSuffix(got[0], ")") || got[2] != "INSERT INTO a VALUES (1)" {
+		t.Fatalf("statements = %q", got)
+	}
+
+	versions, err := embeddedVersions()
+	if err != nil || len(versions) == 0 || versions[0] != "0001_init" {
+		t.Fatalf("embedded versions %v %v", versions, err)
+	}
+	body, _ := mysqlMigrations.ReadFile("0001_init.sql")
+	for _, stmt := range statements(string(body)) {
+		if !strings.HasPrefix(stmt, "CREATE ") || strings.Contains(stmt, ";") {
+			t.Errorf("bad statement split: %.60q", stmt)
+		}
+	}
+}
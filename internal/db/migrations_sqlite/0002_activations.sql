@@ -0,0 +1,11 @@
+-- internal/db/migrations_sqlite/0002_activations.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN max_activations INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS activations (
+    id TEXT PRIMARY KEY,
+    license_key TEXT NOT NULL REFERENCES licenses(license_key) ON DELETE CASCADE,
+    machine_id TEXT NOT NULL,
+    activated_at TEXT NOT NULL DEFAULT (datetime('now')),
+    UNIQUE (license_key, machine_id)
+);
+CREATE INDEX IF NOT EXISTS idx_activations_license_key ON activations(license_key);
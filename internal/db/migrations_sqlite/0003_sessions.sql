@@ -0,0 +1,12 @@
+-- internal/db/migrations_sqlite/0003_sessions.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN floating_seats INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    license_key TEXT NOT NULL REFERENCES licenses(license_key) ON DELETE CASCADE,
+    machine_id TEXT NOT NULL,
+    checked_out_at TEXT NOT NULL,        -- fixed-width RFC3339 (sortable)
+    last_heartbeat_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_license_key ON sessions(license_key);
+CREATE INDEX IF NOT EXISTS idx_sessions_last_heartbeat_at ON sessions(last_heartbeat_at);
@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0004_grace_period.sql (SQLite)
+-- NULL means "use the server default (licensing.grace_days)"
+ALTER TABLE licenses ADD COLUMN grace_days INTEGER NULL;
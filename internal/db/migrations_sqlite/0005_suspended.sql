@@ -0,0 +1,2 @@
+-- internal/db/migrations_sqlite/0005_suspended.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN suspended INTEGER NOT NULL DEFAULT 0; -- 0=false, 1=true
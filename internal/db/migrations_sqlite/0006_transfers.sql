@@ -0,0 +1,10 @@
+-- internal/db/migrations_sqlite/0006_transfers.sql (SQLite)
+CREATE TABLE IF NOT EXISTS license_transfers (
+    id TEXT PRIMARY KEY,
+    license_key TEXT NOT NULL REFERENCES licenses(license_key) ON DELETE CASCADE,
+    from_machine_id TEXT NOT NULL,
+    to_machine_id TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    transferred_at TEXT NOT NULL          -- fixed-width RFC3339 (sortable)
+);
+CREATE INDEX IF NOT EXISTS idx_license_transfers_license_key ON license_transfers(license_key, transferred_at);
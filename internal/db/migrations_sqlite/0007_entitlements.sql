@@ -0,0 +1,2 @@
+-- internal/db/migrations_sqlite/0007_entitlements.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN entitlements TEXT NOT NULL DEFAULT '{}'; -- store JSON as TEXT
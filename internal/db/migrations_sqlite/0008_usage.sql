@@ -0,0 +1,9 @@
+-- internal/db/migrations_sqlite/0008_usage.sql (SQLite)
+CREATE TABLE IF NOT EXISTS usage_records (
+    license_key TEXT NOT NULL REFERENCES licenses(license_key) ON DELETE CASCADE,
+    metric TEXT NOT NULL,
+    period TEXT NOT NULL,          -- e.g. 2025-01 (monthly) or 2025-01-31 (daily)
+    amount INTEGER NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (license_key, metric, period)
+);
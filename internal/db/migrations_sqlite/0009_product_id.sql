@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0009_product_id.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN product_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_licenses_product_id ON licenses(product_id);
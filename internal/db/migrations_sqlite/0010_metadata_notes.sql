@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0010_metadata_notes.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}'; -- store JSON as TEXT
+ALTER TABLE licenses ADD COLUMN notes TEXT NOT NULL DEFAULT '';
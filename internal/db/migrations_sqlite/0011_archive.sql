@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0011_archive.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN archived_at TEXT NULL;
+CREATE INDEX IF NOT EXISTS idx_licenses_archived_at ON licenses(archived_at);
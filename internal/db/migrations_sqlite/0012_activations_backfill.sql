@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0012_activations_backfill.sql (SQLite)
+INSERT OR IGNORE INTO activations (id, license_key, machine_id, activated_at)
+SELECT lower(hex(randomblob(16))), license_key, machine_id, created_at FROM licenses;
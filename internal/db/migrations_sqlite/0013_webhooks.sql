@@ -0,0 +1,28 @@
+-- internal/db/migrations_sqlite/0013_webhooks.sql (SQLite)
+CREATE TABLE IF NOT EXISTS webhooks (
+    id TEXT PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '[]',            -- JSON array; empty = every event
+    active INTEGER NOT NULL DEFAULT 1,            -- 0=false, 1=true
+    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    webhook_id TEXT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',       -- pending | delivered | failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TEXT NOT NULL,                -- fixed-width RFC3339 (sortable)
+    last_error TEXT NOT NULL DEFAULT '',
+    response_code INTEGER NULL,
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    delivered_at TEXT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);
+
+ALTER TABLE licenses ADD COLUMN expiry_notified_at TEXT NULL;
+ALTER TABLE licenses ADD COLUMN stale_notified_at TEXT NULL;
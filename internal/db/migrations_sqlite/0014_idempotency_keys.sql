@@ -0,0 +1,9 @@
+-- internal/db/migrations_sqlite/0014_idempotency_keys.sql (SQLite)
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    license_key TEXT NOT NULL,
+    response TEXT NULL,                   -- null until the license file is signed
+    created_at TEXT NOT NULL              -- fixed-width RFC3339 (sortable)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_license_key ON idempotency_keys(license_key);
@@ -0,0 +1,12 @@
+-- internal/db/migrations_sqlite/0015_audit_log.sql (SQLite)
+CREATE TABLE IF NOT EXISTS audit_log (
+    id TEXT PRIMARY KEY,
+    actor TEXT NOT NULL,                  -- admin key id, e.g. key:1a2b3c4d5e6f
+    action TEXT NOT NULL,                 -- license.issue, license.revoke, ...
+    license_key TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '{}',   -- JSON
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL              -- fixed-width RFC3339 (sortable)
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_license_key ON audit_log(license_key, created_at);
@@ -0,0 +1,12 @@
+-- internal/db/migrations_sqlite/0016_machines.sql (SQLite)
+CREATE TABLE IF NOT EXISTS machines (
+    machine_id TEXT PRIMARY KEY,
+    hostname TEXT NOT NULL DEFAULT '',
+    os TEXT NOT NULL DEFAULT '',
+    fingerprint TEXT NOT NULL DEFAULT '{}',       -- JSON object, component name -> value
+    first_seen_at TEXT NOT NULL,                  -- fixed-width RFC3339 (sortable)
+    last_seen_at TEXT NOT NULL,
+    last_license_key TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_machines_first_seen ON machines(first_seen_at, machine_id);
+CREATE INDEX IF NOT EXISTS idx_machines_hostname ON machines(hostname);
@@ -0,0 +1,11 @@
+-- internal/db/migrations_sqlite/0017_api_keys.sql (SQLite)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    label TEXT NOT NULL DEFAULT '',
+    key_prefix TEXT NOT NULL,                     -- first characters of the key, for display
+    key_hash TEXT NOT NULL UNIQUE,                -- sha256 hex of the full key
+    created_at TEXT NOT NULL,                     -- fixed-width RFC3339 (sortable)
+    rotated_at TEXT NULL,
+    last_used_at TEXT NULL,
+    revoked_at TEXT NULL
+);
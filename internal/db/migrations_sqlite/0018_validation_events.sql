@@ -0,0 +1,11 @@
+-- internal/db/migrations_sqlite/0018_validation_events.sql (SQLite)
+CREATE TABLE IF NOT EXISTS validation_events (
+    id TEXT PRIMARY KEY,
+    license_key TEXT NOT NULL,
+    machine_id TEXT NOT NULL,
+    valid INTEGER NOT NULL,                       -- 0=false, 1=true
+    reason TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL                      -- fixed-width RFC3339 (sortable)
+);
+CREATE INDEX IF NOT EXISTS idx_validation_events_license ON validation_events(license_key, created_at);
@@ -0,0 +1,11 @@
+-- internal/db/migrations_sqlite/0019_license_history.sql (SQLite)
+CREATE TABLE IF NOT EXISTS license_history (
+    id TEXT PRIMARY KEY,
+    license_key TEXT NOT NULL,
+    action TEXT NOT NULL,                 -- license.issue, license.update, ...
+    actor TEXT NOT NULL,
+    changes TEXT NOT NULL DEFAULT '{}',   -- JSON {"field":{"before":..,"after":..}}
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL              -- fixed-width RFC3339 (sortable)
+);
+CREATE INDEX IF NOT EXISTS idx_license_history_license_key ON license_history(license_key, created_at, id);
@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0020_features_recipient.sql (SQLite)
+-- client public key license file features are encrypted to; empty = plaintext
+ALTER TABLE licenses ADD COLUMN features_recipient_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- internal/db/migrations_sqlite/0021_validation_nonces.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN require_nonce INTEGER NOT NULL DEFAULT 0; -- 0=false, 1=true
+CREATE TABLE IF NOT EXISTS validation_nonces (
+    nonce TEXT PRIMARY KEY,
+    license_key TEXT NOT NULL,
+    machine_id TEXT NOT NULL,
+    expires_at TEXT NOT NULL,             -- fixed-width RFC3339 (sortable)
+    created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_validation_nonces_expires_at ON validation_nonces(expires_at);
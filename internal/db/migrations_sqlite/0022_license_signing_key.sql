@@ -0,0 +1,5 @@
+-- internal/db/migrations_sqlite/0022_license_signing_key.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE licenses ADD COLUMN signing_key_id TEXT NOT NULL DEFAULT ''; -- JWKS kid of the last signing key
+CREATE INDEX IF NOT EXISTS idx_licenses_tenant ON licenses(tenant);
+CREATE INDEX IF NOT EXISTS idx_licenses_signing_key_id ON licenses(signing_key_id);
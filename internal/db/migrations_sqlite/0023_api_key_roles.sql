@@ -0,0 +1,2 @@
+-- internal/db/migrations_sqlite/0023_api_key_roles.sql (SQLite)
+ALTER TABLE api_keys ADD COLUMN role TEXT NOT NULL DEFAULT 'admin'; -- admin, issuer, support or viewer
@@ -0,0 +1,4 @@
+-- internal/db/migrations_sqlite/0024_api_key_scopes.sql (SQLite)
+ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT '[]';      -- JSON array; empty = every route
+ALTER TABLE api_keys ADD COLUMN product_ids TEXT NOT NULL DEFAULT '[]'; -- JSON array; empty = every product
+ALTER TABLE api_keys ADD COLUMN customers TEXT NOT NULL DEFAULT '[]';   -- JSON array; empty = every customer
@@ -0,0 +1,11 @@
+-- internal/db/migrations_sqlite/0025_admin_sessions.sql (SQLite)
+CREATE TABLE IF NOT EXISTS admin_sessions (
+    id TEXT PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,              -- sha256 hex of the session token
+    subject TEXT NOT NULL,                        -- the provider's sub claim
+    email TEXT NOT NULL DEFAULT '',
+    role TEXT NOT NULL,
+    created_at TEXT NOT NULL,                     -- fixed-width RFC3339 (sortable)
+    expires_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS admin_sessions_expires_at_idx ON admin_sessions (expires_at);
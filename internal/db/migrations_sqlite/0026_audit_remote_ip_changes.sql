@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0026_audit_remote_ip_changes.sql (SQLite)
+ALTER TABLE audit_log ADD COLUMN remote_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN changes TEXT NOT NULL DEFAULT '{}';   -- JSON {"field":{"before":..,"after":..}}
@@ -0,0 +1,8 @@
+-- internal/db/migrations_sqlite/0027_auth_failures.sql (SQLite)
+CREATE TABLE IF NOT EXISTS auth_failures (
+    client TEXT PRIMARY KEY,                      -- client IP
+    failures INTEGER NOT NULL,
+    last_failure_at TEXT NOT NULL,                -- fixed-width RFC3339 (sortable)
+    locked_until TEXT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_auth_failures_last_failure_at ON auth_failures(last_failure_at);
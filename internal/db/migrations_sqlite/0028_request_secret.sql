@@ -0,0 +1,2 @@
+-- internal/db/migrations_sqlite/0028_request_secret.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN request_secret TEXT NOT NULL DEFAULT '';   -- '' = requests need no signature
@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0029_admin_session_keys.sql (SQLite)
+ALTER TABLE admin_sessions ADD COLUMN actor TEXT NOT NULL DEFAULT '';        -- '' = OIDC, actor from subject/email
+ALTER TABLE admin_sessions ADD COLUMN api_key_id TEXT NOT NULL DEFAULT '';   -- managed key the session stands in for
@@ -0,0 +1,4 @@
+-- internal/db/migrations_sqlite/0030_api_key_overlap.sql (SQLite)
+ALTER TABLE api_keys ADD COLUMN previous_key_hash TEXT NOT NULL DEFAULT '';   -- secret before the last rotation
+ALTER TABLE api_keys ADD COLUMN previous_expires_at TEXT NULL;                -- fixed-width RFC3339; when it stops working
+CREATE INDEX IF NOT EXISTS idx_api_keys_previous_key_hash ON api_keys(previous_key_hash);
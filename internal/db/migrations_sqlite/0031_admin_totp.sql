@@ -0,0 +1,8 @@
+-- internal/db/migrations_sqlite/0031_admin_totp.sql (SQLite)
+CREATE TABLE IF NOT EXISTS admin_totp (
+    actor TEXT PRIMARY KEY,                       -- audit actor the factor belongs to
+    secret_enc TEXT NOT NULL,                     -- secret encrypted under server.totp.encryption_key
+    confirmed_at TEXT,                            -- null until a first code is confirmed
+    last_step INTEGER NOT NULL DEFAULT 0,         -- time step of the last accepted code, against replays
+    created_at TEXT NOT NULL                      -- fixed-width RFC3339 (sortable)
+);
@@ -0,0 +1,37 @@
+-- internal/db/migrations_sqlite/0032_canonical_times.sql (SQLite)
+-- Every timestamp is stored as fixed-width UTC RFC3339 (sortable), as the server
+-- writes them. Rewrite older values: datetime('now') defaults and variable-width
+-- RFC3339, possibly with an offset. Sub-millisecond digits of those are lost.
+DROP TRIGGER IF EXISTS trg_licenses_updated_at;
+
+UPDATE licenses SET expires_at = strftime('%Y-%m-%dT%H:%M:%f', expires_at) || '000000Z'
+  WHERE expires_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', expires_at) IS NOT NULL;
+UPDATE licenses SET last_seen_at = strftime('%Y-%m-%dT%H:%M:%f', last_seen_at) || '000000Z'
+  WHERE last_seen_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', last_seen_at) IS NOT NULL;
+UPDATE licenses SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000000Z'
+  WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+UPDATE licenses SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000000Z'
+  WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+UPDATE licenses SET archived_at = strftime('%Y-%m-%dT%H:%M:%f', archived_at) || '000000Z'
+  WHERE archived_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', archived_at) IS NOT NULL;
+UPDATE licenses SET expiry_notified_at = strftime('%Y-%m-%dT%H:%M:%f', expiry_notified_at) || '000000Z'
+  WHERE expiry_notified_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', expiry_notified_at) IS NOT NULL;
+UPDATE licenses SET stale_notified_at = strftime('%Y-%m-%dT%H:%M:%f', stale_notified_at) || '000000Z'
+  WHERE stale_notified_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', stale_notified_at) IS NOT NULL;
+UPDATE activations SET activated_at = strftime('%Y-%m-%dT%H:%M:%f', activated_at) || '000000Z'
+  WHERE activated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', activated_at) IS NOT NULL;
+UPDATE usage_records SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', updated_at) || '000000Z'
+  WHERE updated_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', updated_at) IS NOT NULL;
+UPDATE webhooks SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000000Z'
+  WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+UPDATE webhook_deliveries SET created_at = strftime('%Y-%m-%dT%H:%M:%f', created_at) || '000000Z'
+  WHERE created_at NOT GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9].[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]Z' AND strftime('%Y-%m-%dT%H:%M:%f', created_at) IS NOT NULL;
+
+-- only when the statement didn't set updated_at itself
+CREATE TRIGGER IF NOT EXISTS trg_licenses_updated_at
+AFTER UPDATE ON licenses
+FOR EACH ROW
+WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+  UPDATE licenses SET updated_at = strftime('%Y-%m-%dT%H:%M:%f', 'now') || '000000Z' WHERE id = OLD.id;
+END;
@@ -0,0 +1,9 @@
+-- internal/db/migrations_sqlite/0033_audit_entity.sql (SQLite)
+ALTER TABLE audit_log ADD COLUMN entity TEXT NOT NULL DEFAULT '';   -- license:<key>, webhook:<id>, apikey:<id>
+UPDATE audit_log SET entity = CASE
+    WHEN license_key <> '' THEN 'license:' || license_key
+    WHEN action LIKE 'webhook.%' AND json_extract(details, '$.webhook_id') IS NOT NULL THEN 'webhook:' || json_extract(details, '$.webhook_id')
+    WHEN action LIKE 'apikey.%' AND json_extract(details, '$.key_id') IS NOT NULL THEN 'apikey:' || json_extract(details, '$.key_id')
+    ELSE '' END
+  WHERE entity = '';
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity, created_at);
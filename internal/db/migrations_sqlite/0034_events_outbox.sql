@@ -0,0 +1,9 @@
+-- internal/db/migrations_sqlite/0034_events_outbox.sql (SQLite)
+CREATE TABLE IF NOT EXISTS events (
+    id TEXT PRIMARY KEY,
+    event TEXT NOT NULL,
+    data TEXT NOT NULL,                           -- JSON
+    created_at TEXT NOT NULL,
+    dispatched_at TEXT NULL                       -- null until dispatched
+);
+CREATE INDEX IF NOT EXISTS idx_events_pending ON events(dispatched_at, created_at);
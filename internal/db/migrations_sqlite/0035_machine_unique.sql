@@ -0,0 +1,4 @@
+-- internal/db/migrations_sqlite/0035_machine_unique.sql (SQLite)
+ALTER TABLE licenses ADD COLUMN machine_unique INTEGER NOT NULL DEFAULT 0;   -- set by licensing.unique_machine
+CREATE UNIQUE INDEX IF NOT EXISTS idx_licenses_machine_unique ON licenses(customer, machine_id)
+    WHERE machine_unique AND NOT revoked AND archived_at IS NULL;
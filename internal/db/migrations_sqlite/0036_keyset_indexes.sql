@@ -0,0 +1,18 @@
+-- internal/db/migrations_sqlite/0036_keyset_indexes.sql (SQLite)
+-- indexes ending in the (time, id) order of the paginated lists; each
+-- replaces the shorter index it extends
+CREATE INDEX IF NOT EXISTS idx_licenses_created_at ON licenses(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_licenses_product_created ON licenses(product_id, created_at, id);
+DROP INDEX IF EXISTS idx_licenses_product_id;
+CREATE INDEX IF NOT EXISTS idx_licenses_tenant_created ON licenses(tenant, created_at, id);
+DROP INDEX IF EXISTS idx_licenses_tenant;
+CREATE INDEX IF NOT EXISTS idx_activations_license_activated ON activations(license_key, activated_at, machine_id);
+DROP INDEX IF EXISTS idx_activations_license_key;
+CREATE INDEX IF NOT EXISTS idx_license_transfers_license_transferred ON license_transfers(license_key, transferred_at, id);
+DROP INDEX IF EXISTS idx_license_transfers_license_key;
+CREATE INDEX IF NOT EXISTS idx_validation_events_license_created ON validation_events(license_key, created_at, id);
+DROP INDEX IF EXISTS idx_validation_events_license;
+CREATE INDEX IF NOT EXISTS idx_audit_log_license_created ON audit_log(license_key, created_at, id);
+DROP INDEX IF EXISTS idx_audit_log_license_key;
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity_created ON audit_log(entity, created_at, id);
+DROP INDEX IF EXISTS idx_audit_log_entity;
@@ -0,0 +1,3 @@
+-- internal/db/migrations_sqlite/0037_machines_last_seen.sql (SQLite)
+-- lets the metrics job count recently seen machines without a table scan
+CREATE INDEX IF NOT EXISTS idx_machines_last_seen ON machines(last_seen_at);
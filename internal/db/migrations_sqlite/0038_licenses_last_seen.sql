@@ -0,0 +1,4 @@
+-- internal/db/migrations_sqlite/0038_licenses_last_seen.sql (SQLite)
+-- the stale license job counts, and GET /api/v1/licenses/stale pages
+-- through, licenses by last heartbeat
+CREATE INDEX IF NOT EXISTS idx_licenses_last_seen ON licenses(last_seen_at, license_key);
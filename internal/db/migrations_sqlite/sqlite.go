@@ -3,18 +3,130 @@ package migrate
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
 	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
 )
 
-//go:embed "0001_init.sql"
-var sqliteInitSQL string
+//go:embed *.sql
+var sqliteMigrations embed.FS
 
-// EnsureSQLiteSchema applies the idempotent SQLite schema.
+// EnsureSQLiteSchema applies the embedded SQLite migrations in order.
+// 0001_init.sql is idempotent and always safe to re-run; later files are
+// recorded in schema_migrations and applied exactly once.
 func EnsureSQLiteSchema(ctx context.Context, db *sql.DB) error {
-	if sqliteInitSQL == "" {
-		return fmt.Errorf("embedded sqlite migration is empty")
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT (datetime('now'))
+	)`); err != nil {
+		return fmt.Errorf("schema_migrations: %w", err)
 	}
-	_, err := db.ExecContext(ctx, sqliteInitSQL)
-	return err
+
+	versions, err := embeddedVersions()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		var applied int
+		if err := db.QueryRowContext(ctx, `SELECT count(*) FROM schema_migrations WHERE version=$1`, version).Scan(&applied); err != nil {
+			return fmt.Errorf("check %s: %w", version, err)
+		}
+		if applied > 0 {
+			continue
+		}
+		body, err := sqliteMigrations.ReadFile(version + ".sql")
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(ctx, db, version, string(body)); err != nil {
+			return fmt.Errorf("apply %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// PendingSQLite lists the embedded migrations not yet recorded in
+// schema_migrations, oldest first; all of them when the table is missing.
+func PendingSQLite(ctx context.Context, db *sql.DB) ([]string, error) {
+	pending, _, err := StatusSQLite(ctx, db)
+	return pending, err
+}
+
+// StatusSQLite compares schema_migrations with the embedded migrations.
+// pending are embedded but not recorded, oldest first, and all of them when
+// the table is missing; unknown are recorded but not embedded, so applied by
+// a newer binary.
+func StatusSQLite(ctx context.Context, db *sql.DB) (pending, unknown []string, err error) {
+	versions, err := embeddedVersions()
+	if err != nil {
+		return nil, nil, err
+	}
+	var tables int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'`).Scan(&tables); err != nil {
+		return nil, nil, err
+	}
+	if tables == 0 {
+		return versions, nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	applied := map[string]bool{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, nil, err
+		}
+		applied[v] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, v := range versions {
+		if !applied[v] {
+			pending = append(pending, v)
+		}
+		delete(applied, v)
+	}
+	for v := range applied {
+		unknown = append(unknown, v)
+	}
+	sort.Strings(unknown)
+	return pending, unknown, nil
+}
+
+// embeddedVersions returns the embedded migration versions in order.
+func embeddedVersions() ([]string, error) {
+	names, err := fs.Glob(sqliteMigrations, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("embedded sqlite migrations are empty")
+	}
+	sort.Strings(names)
+	versions := make([]string, len(names))
+	for i, name := range names {
+		versions[i] = strings.TrimSuffix(name, ".sql")
+	}
+	return versions, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version, body string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
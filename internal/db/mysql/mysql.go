@@ -0,0 +1,184 @@
+// Package mysql connects the server to MySQL 8.0.13+ and MariaDB 10.5+.
+//
+// The server's SQL is written for Postgres and SQLite. Connections opened
+// here read it the same way: $N placeholders are rewritten to ?, and every
+// session runs with ANSI_QUOTES, PIPES_AS_CONCAT and NO_BACKSLASH_ESCAPES,
+// strict mode and a UTC time zone, with DATETIME columns scanned as
+// time.Time. What is left (upserts, RETURNING, JSON casts, ilike) is up to
+// the callers.
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+)
+
+// sqlMode is the session sql_mode: strict, plus the ANSI behaviour the
+// queries assume.
+const sqlMode = "'ANSI_QUOTES,PIPES_AS_CONCAT,NO_BACKSLASH_ESCAPES,STRICT_ALL_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION'"
+
+// Open connects to dsn, a go-sql-driver DSN such as
+// "user:pass@tcp(db:3306)/raalisence". Its session settings are overridden
+// as described in the package comment.
+func Open(dsn string) (*sql.DB, error) {
+	cfg, err := gomysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("mysql dsn: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["sql_mode"] = sqlMode
+	cfg.Params["time_zone"] = "'+00:00'"
+	if _, ok := cfg.Params["charset"]; !ok {
+		cfg.Params["charset"] = "utf8mb4"
+	}
+	c, err := gomysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("mysql dsn: %w", err)
+	}
+	return sql.OpenDB(connector{c}), nil
+}
+
+// Rebind rewrites the $N placeholders in query to ?, returning for each ?
+// the index of the argument it stands for. Placeholders in quoted strings,
+// quoted identifiers and comments are left alone.
+func Rebind(query string) (string, []int) {
+	out := make([]byte, 0, len(query))
+	var order []int
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(query) && query[end] != c {
+				end++
+			}
+			out = append(out, query[i:min(end+1, len(query))]...)
+			i = end
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := i
+			for end < len(query) && query[end] != '\n' {
+				end++
+			}
+			out = append(out, query[i:end]...)
+			i = end - 1
+		case c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			end := i + 1
+			for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(query[i+1 : end])
+			order = append(order, n-1)
+			out = append(out, '?')
+			i = end - 1
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out), order
+}
+
+// rebindArgs puts args in the order Rebind returned, repeating any that
+// are used more than once.
+func rebindArgs(order []int, args []driver.NamedValue) ([]driver.NamedValue, error) {
+	if len(order) == 0 {
+		return args, nil
+	}
+	out := make([]driver.NamedValue, len(order))
+	for i, n := range order {
+		if n < 0 || n >= len(args) {
+			return nil, fmt.Errorf("mysql: query uses $%d but has %d arguments", n+1, len(args))
+		}
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: args[n].Value}
+	}
+	return out, nil
+}
+
+type connector struct{ driver.Connector }
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{cn}, nil
+}
+
+// conn rebinds the queries of a go-sql-driver connection. That connection
+// implements all of the optional interfaces used here.
+type conn struct{ driver.Conn }
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	q, order := Rebind(query)
+	st, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: st, order: order}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	q, order := Rebind(query)
+	args, err := rebindArgs(order, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, q, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, order := Rebind(query)
+	args, err := rebindArgs(order, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, q, args)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *conn) Ping(ctx context.Context) error { return c.Conn.(driver.Pinger).Ping(ctx) }
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *conn) IsValid() bool { return c.Conn.(driver.Validator).IsValid() }
+
+type stmt struct {
+	driver.Stmt
+	order []int
+}
+
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	args, err := rebindArgs(s.order, args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	args, err := rebindArgs(s.order, args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
@@ -0,0 +1,39 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	cases := []struct {
+		in, out string
+		order   []int
+	}{
+		{`select 1`, `select 1`, nil},
+		{`update t set a=$1 where b=$2`, `update t set a=? where b=?`, []int{0, 1}},
+		{`select * from t where (c < $1 or (c = $1 and id < $2))`, `select * from t where (c < ? or (c = ? and id < ?))`, []int{0, 0, 1}},
+		{`insert into t (a, "key") values ($10, '$1 it''s')`, `insert into t (a, "key") values (?, '$1 it''s')`, []int{9}},
+		{"select $1 -- not $2\n, `$3`", "select ? -- not $2\n, `$3`", []int{0}},
+		{`customer like $3 escape '\'`, `customer like ? escape '\'`, []int{2}},
+	}
+	for _, c := range cases {
+		out, order := Rebind(c.in)
+		if out != c.out || !reflect.DeepEqual(order, c.order) {
+			t.Errorf("Rebind(%q) = %q %v, want %q %v", c.in, out, order, c.out, c.order)
+		}
+	}
+}
+
+func TestRebindArgs(t *testing.T) {
+	args := []driver.NamedValue{{Ordinal: 1, Value: "a"}, {Ordinal: 2, Value: int64(2)}}
+	got, err := rebindArgs([]int{1, 0, 1}, args)
+	want := []driver.NamedValue{{Ordinal: 1, Value: int64(2)}, {Ordinal: 2, Value: "a"}, {Ordinal: 3, Value: int64(2)}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("rebindArgs = %v %v", got, err)
+	}
+	if _, err := rebindArgs([]int{2}, args); err == nil {
+		t.Fatal("missing argument accepted")
+	}
+}
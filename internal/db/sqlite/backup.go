@@ -0,0 +1,148 @@
+// Package sqlite backs up and restores the server's SQLite database with
+// SQLite's online backup API, which copies a consistent snapshot page by
+// page while the server keeps reading and writing.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup copies db, a go-sqlite3 database (a file or :memory:), to the new
+// file path. The copy is written next to path and renamed into place, so
+// path never holds a partial backup; an existing path is an error.
+func Backup(ctx context.Context, db *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	src, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := copyInto(ctx, tmp.Name(), src); err != nil {
+		return err
+	}
+	// the copy takes the live database's journal mode; leave it as one
+	// self-contained file rather than one that grows -wal and -shm files
+	// when opened
+	if err := setJournalMode(ctx, tmp.Name(), "DELETE"); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Restore replaces the database at path with the backup at src, after
+// checking src is an intact raalisence database. The current contents are
+// first backed up beside path as <path>.before-restore-<time>, which is
+// returned. The server must not be running against path.
+func Restore(ctx context.Context, src, path string) (string, error) {
+	from, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return "", err
+	}
+	defer from.Close()
+	if err := check(ctx, from); err != nil {
+		return "", fmt.Errorf("%s: %w", src, err)
+	}
+
+	var saved string
+	if _, err := os.Stat(path); err == nil {
+		current, err := sql.Open("sqlite3", path)
+		if err != nil {
+			return "", err
+		}
+		saved = path + ".before-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		err = Backup(ctx, current, saved)
+		current.Close()
+		if err != nil {
+			return "", fmt.Errorf("save current database: %w", err)
+		}
+	}
+
+	conn, err := from.Conn(ctx)
+	if err != nil {
+		return saved, err
+	}
+	defer conn.Close()
+	return saved, copyInto(ctx, path, conn)
+}
+
+// check reports a database that fails SQLite's integrity check or was never
+// migrated by the server.
+func check(ctx context.Context, db *sql.DB) error {
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	var tables int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return errors.New("not a raalisence database (no schema_migrations)")
+	}
+	return nil
+}
+
+func setJournalMode(ctx context.Context, path, mode string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, `PRAGMA journal_mode=`+mode)
+	return err
+}
+
+// copyInto overwrites the database file dst with the database of src.
+func copyInto(ctx context.Context, dst string, src *sql.Conn) error {
+	to, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+	conn, err := to.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(d any) error {
+		return src.Raw(func(s any) error {
+			dc, ok := d.(*sqlite3.SQLiteConn)
+			sc, ok2 := s.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("backups need the sqlite3 driver")
+			}
+			b, err := dc.Backup("main", sc, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
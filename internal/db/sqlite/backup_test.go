@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	migrate "github.com/rpattn/raalisence/internal/db/migrations_sqlite"
+)
+
+func openMigrated(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.EnsureSQLiteSchema(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func count(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM licenses`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.db")
+	db := openMigrated(t, live)
+	insert := func(key string) {
+		if _, err := db.Exec(`INSERT INTO licenses (id, license_key, customer, machine_id, expires_at) VALUES ($1, $1, 'acme', 'm1', '2030-01-01T00:00:00.000000000Z')`, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("key-1")
+
+	backup := filepath.Join(dir, "backup.db")
+	if err := Backup(ctx, db, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := Backup(ctx, db, backup); err == nil {
+		t.Fatal("backup overwrote an existing file")
+	}
+	insert("key-2")
+	db.Close()
+
+	saved, err := Restore(ctx, backup, live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, openMigrated(t, live)); n != 1 {
+		t.Fatalf("restored %d licenses, want 1", n)
+	}
+	if n := count(t, openMigrated(t, saved)); n != 2 {
+		t.Fatalf("saved %d licenses, want 2", n)
+	}
+
+	// a database the server never created is refused
+	other := filepath.Join(dir, "other.db")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(ctx, other, live); err == nil {
+		t.Fatal("restored a database without schema_migrations")
+	}
+}
@@ -0,0 +1,150 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckpointResult is SQLite's answer to PRAGMA wal_checkpoint.
+type CheckpointResult struct {
+	Busy         bool // a reader or writer kept it from finishing
+	Log          int  // frames in the WAL
+	Checkpointed int  // frames copied into the database
+}
+
+// CheckpointOptions configure Checkpoint.
+type CheckpointOptions struct {
+	Mode string // PASSIVE, FULL, RESTART or TRUNCATE
+	// PreHook and PostHook are shell commands run before and after the
+	// checkpoint, with RAAL_DB_PATH and RAAL_CHECKPOINT_MODE set; PostHook
+	// also gets RAAL_CHECKPOINT_BUSY, _LOG, _CHECKPOINTED and, when it
+	// failed, _ERROR.
+	PreHook  string
+	PostHook string
+	Path     string // the database file, for the hooks
+}
+
+// Checkpoint runs PRAGMA wal_checkpoint on db between o's hooks. A failing
+// PreHook skips the checkpoint, so a replicator can hold it off; PostHook
+// runs whether or not the checkpoint worked.
+func Checkpoint(ctx context.Context, db *sql.DB, o CheckpointOptions) (CheckpointResult, error) {
+	var res CheckpointResult
+	switch o.Mode {
+	case "PASSIVE", "FULL", "RESTART", "TRUNCATE":
+	default:
+		return res, fmt.Errorf("unknown checkpoint mode %q", o.Mode)
+	}
+	env := []string{"RAAL_DB_PATH=" + o.Path, "RAAL_CHECKPOINT_MODE=" + o.Mode}
+	if err := runHook(ctx, o.PreHook, env); err != nil {
+		return res, fmt.Errorf("pre_hook: %w", err)
+	}
+	var busy int
+	err := db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(`+o.Mode+`)`).Scan(&busy, &res.Log, &res.Checkpointed)
+	res.Busy = busy != 0
+	env = append(env,
+		"RAAL_CHECKPOINT_BUSY="+strconv.FormatBool(res.Busy),
+		"RAAL_CHECKPOINT_LOG="+strconv.Itoa(res.Log),
+		"RAAL_CHECKPOINT_CHECKPOINTED="+strconv.Itoa(res.Checkpointed))
+	if err != nil {
+		env = append(env, "RAAL_CHECKPOINT_ERROR="+err.Error())
+	}
+	if herr := runHook(ctx, o.PostHook, env); herr != nil && err == nil {
+		err = fmt.Errorf("post_hook: %w", herr)
+	}
+	return res, err
+}
+
+// runHook runs command with sh -c, adding env to the server's environment.
+// Its output is returned in the error when it fails.
+func runHook(ctx context.Context, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// SnapshotOptions configure Snapshot.
+type SnapshotOptions struct {
+	// Dir keeps the snapshots, the Keep newest of them (0 keeps all);
+	// empty writes each to a temporary directory and removes it once
+	// uploaded.
+	Dir  string
+	Keep int
+	// Upload, if set, copies the snapshot file at path off-box as name.
+	Upload func(ctx context.Context, name, path string) error
+}
+
+// snapshotPrefix starts the names of snapshot files, which then sort by
+// the time they were taken.
+const snapshotPrefix = "raalisence-snapshot-"
+
+// Snapshot backs db up as raalisence-snapshot-<time>.db and hands it to
+// o.Upload, then prunes o.Dir. It returns the snapshot's name and size.
+func Snapshot(ctx context.Context, db *sql.DB, o SnapshotOptions) (string, int64, error) {
+	dir := o.Dir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "raalisence-snapshot")
+		if err != nil {
+			return "", 0, err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	} else if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", 0, err
+	}
+	name := snapshotPrefix + time.Now().UTC().Format("20060102T150405.000000000Z") + ".db"
+	path := filepath.Join(dir, name)
+	if err := Backup(ctx, db, path); err != nil {
+		return "", 0, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if o.Upload != nil {
+		if err := o.Upload(ctx, name, path); err != nil {
+			return name, fi.Size(), fmt.Errorf("upload: %w", err)
+		}
+	}
+	if o.Dir != "" && o.Keep > 0 {
+		if err := prune(o.Dir, o.Keep); err != nil {
+			return name, fi.Size(), fmt.Errorf("prune: %w", err)
+		}
+	}
+	return name, fi.Size(), nil
+}
+
+// prune removes all but the keep newest snapshots in dir.
+func prune(dir string, keep int) error {
+	names, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*.db"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
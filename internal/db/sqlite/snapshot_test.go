@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointHooks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.db")
+	db := openMigrated(t, live+"?_journal_mode=WAL")
+	if _, err := db.Exec(`INSERT INTO licenses (id, license_key, customer, machine_id, expires_at) VALUES ('id-1', 'key-1', 'acme', 'm1', '2030-01-01')`); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "hook.out")
+	o := CheckpointOptions{
+		Mode:     "TRUNCATE",
+		PreHook:  `echo "pre $RAAL_CHECKPOINT_MODE $RAAL_DB_PATH" >> ` + out,
+		PostHook: `echo "post busy=$RAAL_CHECKPOINT_BUSY err=$RAAL_CHECKPOINT_ERROR" >> ` + out,
+		Path:     live,
+	}
+	res, err := Checkpoint(ctx, db, o)
+	if err != nil || res.Busy || res.Log != 0 {
+		t.Fatalf("checkpoint = %+v, %v", res, err)
+	}
+	if fi, err := os.Stat(live + "-wal"); err != nil || fi.Size() != 0 {
+		t.Fatalf("TRUNCATE left the WAL: %v %v", fi, err)
+	}
+
+	// a failing pre hook holds the checkpoint off, and the post hook too
+	o.PreHook = `echo replicator behind >&2; exit 3`
+	if _, err := Checkpoint(ctx, db, o); err == nil || !strings.Contains(err.Error(), "pre_hook") || !strings.Contains(err.Error(), "replicator behind") {
+		t.Fatalf("pre hook failure err = %v", err)
+	}
+	b, _ := os.ReadFile(out)
+	if got := string(b); got != "pre TRUNCATE "+live+"\npost busy=false err=\n" {
+		t.Fatalf("hook output = %q", got)
+	}
+	if _, err := Checkpoint(ctx, db, CheckpointOptions{Mode: "NOW"}); err == nil {
+		t.Fatal("bad mode accepted")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := openMigrated(t, filepath.Join(dir, "live.db"))
+	snaps := filepath.Join(dir, "snaps")
+	var uploaded []string
+	o := SnapshotOptions{Dir: snaps, Keep: 2, Upload: func(_ context.Context, name, path string) error {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		uploaded = append(uploaded, name)
+		return nil
+	}}
+	for i := 0; i < 3; i++ {
+		name, size, err := Snapshot(ctx, db, o)
+		if err != nil || size == 0 || !strings.HasPrefix(name, snapshotPrefix) {
+			t.Fatalf("snapshot = %s %d %v", name, size, err)
+		}
+		for _, n := range uploaded[:len(uploaded)-1] {
+			if n == name {
+				t.Fatalf("snapshot name %s reused", name)
+			}
+		}
+	}
+	kept, _ := filepath.Glob(filepath.Join(snaps, snapshotPrefix+"*"))
+	if len(uploaded) != 3 || len(kept) != 2 || filepath.Base(kept[1]) != uploaded[2] {
+		t.Fatalf("uploaded %v, kept %v", uploaded, kept)
+	}
+
+	// without a dir, only the upload is left
+	o.Dir = ""
+	var tmp string
+	o.Upload = func(_ context.Context, _, path string) error { tmp = path; return nil }
+	if _, _, err := Snapshot(ctx, db, o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("temporary snapshot %s left behind: %v", tmp, err)
+	}
+}
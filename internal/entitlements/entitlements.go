@@ -0,0 +1,120 @@
+// Package entitlements defines the typed feature model carried by licenses:
+// boolean flags, integer quotas and string tiers, plus the server-side
+// schema they are validated against on issue/update.
+package entitlements
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	TypeFlag  = "flag"
+	TypeQuota = "quota"
+	TypeTier  = "tier"
+)
+
+// Entitlement is a single typed feature grant. Exactly one of Enabled, Limit
+// or Tier is meaningful, selected by Type.
+type Entitlement struct {
+	Type    string `json:"type"`
+	Enabled *bool  `json:"enabled,omitempty"`
+	Limit   *int64 `json:"limit,omitempty"`
+	Tier    string `json:"tier,omitempty"`
+}
+
+// Set maps entitlement names to grants.
+type Set map[string]Entitlement
+
+// Spec describes one allowed entitlement in the server schema.
+type Spec struct {
+	Type string `mapstructure:"type"`
+	// Quota bounds; Max of 0 means unbounded.
+	Min int64 `mapstructure:"min"`
+	Max int64 `mapstructure:"max"`
+	// Values lists the allowed tiers.
+	Values []string `mapstructure:"values"`
+}
+
+// Schema maps entitlement names to their spec. An empty schema accepts any
+// well-formed entitlement.
+type Schema map[string]Spec
+
+// Validate checks that every entitlement is well formed and, when the schema
+// is non-empty, declared in it with a matching type and in-range value.
+// Errors are sorted by entitlement name so messages are deterministic.
+func (s Schema) Validate(set Set) error {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		if err := s.validateOne(name, set[name]); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid entitlements: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s Schema) validateOne(name string, e Entitlement) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("entitlement name must not be empty")
+	}
+	switch e.Type {
+	case TypeFlag:
+		if e.Enabled == nil || e.Limit != nil || e.Tier != "" {
+			return fmt.Errorf("%s: flag requires only enabled", name)
+		}
+	case TypeQuota:
+		if e.Limit == nil || e.Enabled != nil || e.Tier != "" {
+			return fmt.Errorf("%s: quota requires only limit", name)
+		}
+		if *e.Limit < 0 {
+			return fmt.Errorf("%s: limit must not be negative", name)
+		}
+	case TypeTier:
+		if e.Tier == "" || e.Enabled != nil || e.Limit != nil {
+			return fmt.Errorf("%s: tier requires only tier", name)
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %q", name, e.Type)
+	}
+
+	if len(s) == 0 {
+		return nil
+	}
+	spec, ok := s[name]
+	if !ok {
+		return fmt.Errorf("%s: not defined in schema", name)
+	}
+	if spec.Type != e.Type {
+		return fmt.Errorf("%s: expected type %s, got %s", name, spec.Type, e.Type)
+	}
+	switch e.Type {
+	case TypeQuota:
+		if *e.Limit < spec.Min || (spec.Max > 0 && *e.Limit > spec.Max) {
+			return fmt.Errorf("%s: limit %d outside [%d, %d]", name, *e.Limit, spec.Min, spec.Max)
+		}
+	case TypeTier:
+		if len(spec.Values) > 0 && !contains(spec.Values, e.Tier) {
+			return fmt.Errorf("%s: tier %q not one of %s", name, e.Tier, strings.Join(spec.Values, ","))
+		}
+	}
+	return nil
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package entitlements
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	on := true
+	limit := int64(500)
+	big := int64(5000)
+
+	schema := Schema{
+		"export":    {Type: TypeFlag},
+		"api_calls": {Type: TypeQuota, Max: 1000},
+		"support":   {Type: TypeTier, Values: []string{"basic", "pro"}},
+	}
+
+	ok := Set{
+		"export":    {Type: TypeFlag, Enabled: &on},
+		"api_calls": {Type: TypeQuota, Limit: &limit},
+		"support":   {Type: TypeTier, Tier: "pro"},
+	}
+	if err := schema.Validate(ok); err != nil {
+		t.Fatalf("expected valid set, got %v", err)
+	}
+
+	cases := map[string]Set{
+		"over max":      {"api_calls": {Type: TypeQuota, Limit: &big}},
+		"unknown tier":  {"support": {Type: TypeTier, Tier: "gold"}},
+		"wrong type":    {"export": {Type: TypeQuota, Limit: &limit}},
+		"undeclared":    {"sso": {Type: TypeFlag, Enabled: &on}},
+		"missing value": {"export": {Type: TypeFlag}},
+	}
+	for name, set := range cases {
+		if err := schema.Validate(set); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// an empty schema still enforces shape
+	if err := (Schema{}).Validate(Set{"anything": {Type: TypeTier, Tier: "x"}}); err != nil {
+		t.Fatalf("empty schema should accept well-formed entitlement: %v", err)
+	}
+	err := (Schema{}).Validate(Set{"b": {Type: "bogus"}, "a": {Type: TypeFlag}})
+	if err == nil || strings.Index(err.Error(), "a:") > strings.Index(err.Error(), "b:") {
+		t.Fatalf("expected sorted errors, got %v", err)
+	}
+}
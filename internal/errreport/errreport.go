@@ -0,0 +1,248 @@
+// Package errreport sends the server's internal errors to Sentry and/or a
+// generic HTTP sink, so the cause of a 500 outlives the log line.
+//
+// Events are queued and sent by one goroutine; when the sink is slow or
+// down and the queue is full, events are dropped rather than holding up
+// requests. Only Sentry's HTTP (envelope) API is used; no SDK is needed.
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+// queueSize is how many events wait to be sent before new ones are dropped.
+const queueSize = 64
+
+// Frame is one call in an Event's stack.
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Event is one internal error.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"` // what failed, as passed to internalError
+	Error     string    `json:"error"`
+	RequestID string    `json:"request_id,omitempty"`
+	Route     string    `json:"route,omitempty"` // the route pattern, e.g. POST /api/v1/licenses/{license_key}/revoke
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Stack     []Frame   `json:"stack,omitempty"` // innermost call first
+
+	Environment string `json:"environment,omitempty"`
+	Release     string `json:"release,omitempty"`
+}
+
+// Callers returns the stack of its caller's caller skip levels up: 0 is the
+// function calling Callers.
+func Callers(skip int) []Frame {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []Frame
+	for {
+		f, more := frames.Next()
+		stack = append(stack, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// Options configure a Reporter. DSN is a Sentry DSN
+// (https://<key>@<host>/<project>); URL receives each Event as JSON.
+type Options struct {
+	DSN         string
+	URL         string
+	Environment string
+	Release     string
+
+	HTTPClient *http.Client // default: 10s timeout
+}
+
+// Reporter sends Events. A nil Reporter drops them.
+type Reporter struct {
+	sentry      *sentryDSN
+	url         string
+	environment string
+	release     string
+	client      *http.Client
+
+	queue chan Event
+	done  chan struct{}
+	once  sync.Once
+}
+
+// New starts a Reporter, or returns nil when neither a DSN nor a URL is
+// set.
+func New(o Options) (*Reporter, error) {
+	if o.DSN == "" && o.URL == "" {
+		return nil, nil
+	}
+	r := &Reporter{
+		url: o.URL, environment: o.Environment, release: o.Release, client: o.HTTPClient,
+		queue: make(chan Event, queueSize), done: make(chan struct{}),
+	}
+	if o.DSN != "" {
+		d, err := parseDSN(o.DSN)
+		if err != nil {
+			return nil, err
+		}
+		r.sentry = d
+	}
+	if o.URL != "" {
+		if u, err := url.Parse(o.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("error reporting url: want an http(s) URL, got %q", o.URL)
+		}
+	}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	go r.run()
+	return r, nil
+}
+
+// Report queues e, stamped with the time, environment and release.
+func (r *Reporter) Report(e Event) {
+	if r == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Environment, e.Release = r.environment, r.release
+	select {
+	case r.queue <- e:
+	default:
+		logging.Warnf("error report dropped: queue full op=%s", e.Op)
+	}
+}
+
+// Close sends what is queued, giving up when ctx ends. The Reporter must
+// not be used afterwards.
+func (r *Reporter) Close(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.once.Do(func() { close(r.queue) })
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Reporter) run() {
+	defer close(r.done)
+	for e := range r.queue {
+		if r.sentry != nil {
+			if err := r.sendSentry(e); err != nil {
+				logging.Warnf("sentry report error op=%s err=%v", e.Op, err)
+			}
+		}
+		if r.url != "" {
+			b, _ := json.Marshal(e)
+			if err := r.post(r.url, "application/json", b, nil); err != nil {
+				logging.Warnf("error report error op=%s err=%v", e.Op, err)
+			}
+		}
+	}
+}
+
+func (r *Reporter) post(target, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// sentryDSN is a parsed Sentry DSN.
+type sentryDSN struct {
+	raw, key, envelopeURL string
+}
+
+func parseDSN(dsn string) (*sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("error reporting dsn: want https://<key>@<host>/<project>")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	project := path[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("error reporting dsn: no project id")
+	}
+	env := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path[:i] + "/api/" + project + "/envelope/"}
+	return &sentryDSN{raw: dsn, key: u.User.Username(), envelopeURL: env.String()}, nil
+}
+
+// sendSentry sends e as a Sentry error event in an envelope.
+func (r *Reporter) sendSentry(e Event) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	eventID := hex.EncodeToString(id)
+
+	// Sentry wants the outermost call first
+	frames := make([]map[string]any, 0, len(e.Stack))
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		f := e.Stack[i]
+		frames = append(frames, map[string]any{
+			"function": f.Function, "abs_path": f.File, "lineno": f.Line,
+			"in_app": strings.Contains(f.Function, "raalisence/"),
+		})
+	}
+	event := map[string]any{
+		"event_id":    eventID,
+		"timestamp":   e.Time.Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       "error",
+		"logger":      "raalisence",
+		"transaction": e.Route,
+		"environment": e.Environment,
+		"release":     e.Release,
+		"tags":        map[string]string{"op": e.Op, "request_id": e.RequestID, "route": e.Route},
+		"request":     map[string]string{"method": e.Method, "url": e.Path},
+		"exception": map[string]any{"values": []any{map[string]any{
+			"type": e.Op, "value": e.Error, "stacktrace": map[string]any{"frames": frames},
+		}}},
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": eventID, "dsn": r.sentry.raw, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	body, _ := json.Marshal(event)
+	var b bytes.Buffer
+	b.Write(header)
+	b.WriteString("\n{\"type\":\"event\"}\n")
+	b.Write(body)
+	b.WriteString("\n")
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=raalisence/1.0, sentry_key=%s", r.sentry.key)
+	return r.post(r.sentry.envelopeURL, "application/x-sentry-envelope", b.Bytes(), http.Header{"X-Sentry-Auth": {auth}})
+}
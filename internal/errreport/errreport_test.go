@@ -0,0 +1,81 @@
+package errreport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	type got struct {
+		path, auth string
+		body       []byte
+	}
+	reqs := make(chan got, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		reqs <- got{r.URL.Path, r.Header.Get("X-Sentry-Auth"), b}
+	}))
+	defer ts.Close()
+
+	dsn := strings.Replace(ts.URL, "://", "://pubkey@", 1) + "/sentry/42"
+	rep, err := New(Options{DSN: dsn, URL: ts.URL + "/hook", Environment: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep.Report(Event{Op: "revoke.update", Error: "disk full", RequestID: "req-1", Route: "POST /api/v1/licenses/{license_key}/revoke", Stack: Callers(0)})
+	if err := rep.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s := <-reqs
+	if s.path != "/sentry/api/42/envelope/" || !strings.Contains(s.auth, "sentry_key=pubkey") {
+		t.Fatalf("sentry request path=%s auth=%s", s.path, s.auth)
+	}
+	lines := bufio.NewScanner(strings.NewReader(string(s.body)))
+	var items []map[string]any
+	for lines.Scan() {
+		var m map[string]any
+		if err := json.Unmarshal(lines.Bytes(), &m); err != nil {
+			t.Fatalf("envelope line %q: %v", lines.Text(), err)
+		}
+		items = append(items, m)
+	}
+	if len(items) != 3 || items[1]["type"] != "event" {
+		t.Fatalf("envelope %s", s.body)
+	}
+	ev := items[2]
+	tags, _ := ev["tags"].(map[string]any)
+	values := ev["exception"].(map[string]any)["values"].([]any)
+	exc := values[0].(map[string]any)
+	frames := exc["stacktrace"].(map[string]any)["frames"].([]any)
+	last := frames[len(frames)-1].(map[string]any)
+	if ev["environment"] != "prod" || tags["request_id"] != "req-1" || exc["value"] != "disk full" ||
+		!strings.HasSuffix(last["function"].(string), "TestReport") || last["in_app"] != true {
+		t.Fatalf("event %v", ev)
+	}
+
+	h := <-reqs
+	var e Event
+	if err := json.Unmarshal(h.body, &e); err != nil || h.path != "/hook" || e.Op != "revoke.update" || e.Environment != "prod" || e.Time.IsZero() {
+		t.Fatalf("hook request path=%s event=%+v (%v)", h.path, e, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if r, err := New(Options{}); r != nil || err != nil {
+		t.Fatalf("no sink: %v %v", r, err)
+	}
+	var r *Reporter
+	r.Report(Event{}) // a nil Reporter drops events
+	for _, dsn := range []string{"https://sentry.io/1", "https://key@sentry.io/", "key@sentry.io/1"} {
+		if _, err := New(Options{DSN: dsn}); err == nil {
+			t.Fatalf("accepted dsn %q", dsn)
+		}
+	}
+}
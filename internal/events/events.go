@@ -0,0 +1,63 @@
+// Package events fans license activity out to in-process subscribers such as
+// the admin SSE stream. Delivery is best effort: a subscriber that falls
+// behind misses events rather than slowing down the request that raised them.
+package events
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event is one piece of license activity.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Broker publishes events to every current subscriber.
+type Broker struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: map[chan Event]struct{}{}}
+}
+
+// Subscribe returns a channel receiving events published from now on, with
+// room for buf undelivered events, and a func that ends the subscription.
+func (b *Broker) Subscribe(buf int) (<-chan Event, func()) {
+	ch := make(chan Event, buf)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish stamps the event with a sequence ID and time, then hands it to
+// each subscriber whose buffer has room.
+func (b *Broker) Publish(typ string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev := Event{ID: strconv.FormatUint(b.seq, 10), Type: typ, Time: time.Now().UTC(), Data: data}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default: // slow subscriber; drop
+		}
+	}
+	return ev
+}
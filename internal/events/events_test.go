@@ -0,0 +1,36 @@
+package events
+
+import "testing"
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	a, cancelA := b.Subscribe(1)
+	slow, cancelSlow := b.Subscribe(1)
+	defer cancelSlow()
+
+	b.Publish("license.issued", map[string]any{"license_key": "k1"})
+	if ev := <-a; ev.Type != "license.issued" || ev.ID != "1" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// slow's buffer is full, so the second event is dropped for it only
+	b.Publish("license.revoked", nil)
+	if ev := <-a; ev.ID != "2" {
+		t.Fatalf("expected second event, got %+v", ev)
+	}
+	if ev := <-slow; ev.ID != "1" {
+		t.Fatalf("expected first event, got %+v", ev)
+	}
+	select {
+	case ev := <-slow:
+		t.Fatalf("expected drop, got %+v", ev)
+	default:
+	}
+
+	cancelA()
+	cancelA() // idempotent
+	if _, ok := <-a; ok {
+		t.Fatal("expected closed channel after cancel")
+	}
+	b.Publish("license.issued", nil) // must not panic on the closed channel
+}
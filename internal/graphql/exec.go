@@ -0,0 +1,451 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// maxDepth bounds how deeply selections may nest, so one request cannot fan
+// out without limit.
+const maxDepth = 10
+
+// Schema is the root query type.
+type Schema struct {
+	Query *Object
+}
+
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is one field of an Object. Type is the object type of the result
+// (a single value, or a slice of them) and nil for scalars, which are
+// returned as their JSON encoding. A nil Resolve reads the source's struct
+// field with the same JSON name.
+type Field struct {
+	Type    *Object
+	Args    []string
+	Resolve func(ctx context.Context, source any, args Args) (any, error)
+}
+
+// Args are a field's arguments with variables substituted.
+type Args map[string]any
+
+// ArgumentError reports an argument of the wrong type or out of range. It
+// is the caller's fault, so its message is safe to return.
+type ArgumentError struct {
+	Msg string
+}
+
+func (e *ArgumentError) Error() string { return e.Msg }
+
+func argErrorf(format string, args ...any) error {
+	return &ArgumentError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// String returns the string argument name, "" when it is absent or null.
+func (a Args) String(name string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", argErrorf("argument %q must be a string", name)
+}
+
+// Int returns the integer argument name, def when it is absent or null.
+// Variables arrive as JSON numbers, so whole floats are accepted.
+func (a Args) Int(name string, def int) (int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, argErrorf("argument %q must be an integer", name)
+}
+
+// Bool returns the boolean argument name, nil when it is absent or null.
+func (a Args) Bool(name string) (*bool, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	}
+	return nil, argErrorf("argument %q must be a boolean", name)
+}
+
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+type Response struct {
+	Data   any     `json:"data"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Execute runs req. Request errors (syntax, unknown fields or arguments)
+// leave Data nil; a failing resolver nulls its field and adds an error with
+// the field's path, while the rest of the result is still returned.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	if op.Type != "query" {
+		return Response{Errors: []Error{{Message: "only queries are supported"}}}
+	}
+	ex := &executor{doc: doc, vars: map[string]any{}}
+	for _, v := range op.Variables {
+		if val, ok := req.Variables[v.Name]; ok {
+			ex.vars[v.Name] = val
+		} else {
+			ex.vars[v.Name] = v.Default
+		}
+	}
+	if err := ex.validate(s.Query, op.Selections, 1, map[string]bool{}); err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	data := ex.object(ctx, s.Query, nil, op.Selections, nil)
+	return Response{Data: data, Errors: ex.errs}
+}
+
+func (d *Document) operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document has several operations")
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type executor struct {
+	doc  *Document
+	vars map[string]any
+	errs []Error
+}
+
+// validate checks sels against obj before anything is resolved.
+func (ex *executor) validate(obj *Object, sels []Selection, depth int, spreading map[string]bool) error {
+	if depth > maxDepth {
+		return fmt.Errorf("query is nested more than %d levels deep", maxDepth)
+	}
+	for _, sel := range sels {
+		if sel.Spread != "" || sel.Inline != nil {
+			f, err := ex.fragment(obj, sel)
+			if err != nil {
+				return err
+			}
+			if sel.Spread != "" {
+				if spreading[sel.Spread] {
+					return fmt.Errorf("fragment %q spreads itself", sel.Spread)
+				}
+				spreading[sel.Spread] = true
+			}
+			if err := ex.validate(obj, f.Selections, depth, spreading); err != nil {
+				return err
+			}
+			delete(spreading, sel.Spread)
+			continue
+		}
+		if sel.Name == "__typename" {
+			continue
+		}
+		field, ok := obj.Fields[sel.Name]
+		if !ok {
+			return fmt.Errorf("cannot query field %q on type %s", sel.Name, obj.Name)
+		}
+		for name, v := range sel.Args {
+			if !contains(field.Args, name) {
+				return fmt.Errorf("unknown argument %q on field %s.%s", name, obj.Name, sel.Name)
+			}
+			if err := ex.checkVars(v); err != nil {
+				return err
+			}
+		}
+		switch {
+		case field.Type == nil && len(sel.Selections) > 0:
+			return fmt.Errorf("field %s.%s is a scalar and takes no selections", obj.Name, sel.Name)
+		case field.Type != nil && len(sel.Selections) == 0:
+			return fmt.Errorf("field %s.%s of type %s needs a selection", obj.Name, sel.Name, field.Type.Name)
+		case field.Type != nil:
+			if err := ex.validate(field.Type, sel.Selections, depth+1, spreading); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ex *executor) checkVars(v any) error {
+	switch x := v.(type) {
+	case Variable:
+		if _, ok := ex.vars[string(x)]; !ok {
+			return fmt.Errorf("variable $%s is not defined", x)
+		}
+	case []any:
+		for _, e := range x {
+			if err := ex.checkVars(e); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, e := range x {
+			if err := ex.checkVars(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ex *executor) fragment(obj *Object, sel Selection) (*Fragment, error) {
+	f := sel.Inline
+	if sel.Spread != "" {
+		if f = ex.doc.Fragments[sel.Spread]; f == nil {
+			return nil, fmt.Errorf("unknown fragment %q", sel.Spread)
+		}
+	}
+	if f.On != "" && f.On != obj.Name {
+		return nil, fmt.Errorf("fragment on %s cannot be spread on type %s", f.On, obj.Name)
+	}
+	return f, nil
+}
+
+// collect flattens fragments into the fields to resolve, merging the
+// sub-selections of fields requested twice under the same key.
+func (ex *executor) collect(obj *Object, sels []Selection, out []Selection) []Selection {
+	for _, sel := range sels {
+		if sel.Spread != "" || sel.Inline != nil {
+			f, _ := ex.fragment(obj, sel) // validated
+			out = ex.collect(obj, f.Selections, out)
+			continue
+		}
+		merged := false
+		for i := range out {
+			if out[i].key() == sel.key() {
+				out[i].Selections = append(append([]Selection(nil), out[i].Selections...), sel.Selections...)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, sel)
+		}
+	}
+	return out
+}
+
+func (ex *executor) object(ctx context.Context, obj *Object, source any, sels []Selection, path []any) orderedMap {
+	var out orderedMap
+	for _, sel := range ex.collect(obj, sels, nil) {
+		key := sel.key()
+		if sel.Name == "__typename" {
+			out = append(out, entry{key, obj.Name})
+			continue
+		}
+		field := obj.Fields[sel.Name]
+		fieldPath := append(append([]any(nil), path...), key)
+		args := Args{}
+		for name, v := range sel.Args {
+			args[name] = ex.resolveVars(v)
+		}
+		resolve := field.Resolve
+		if resolve == nil {
+			resolve = func(_ context.Context, src any, _ Args) (any, error) { return jsonField(src, sel.Name), nil }
+		}
+		v, err := resolve(ctx, source, args)
+		if err != nil {
+			ex.errs = append(ex.errs, Error{Message: err.Error(), Path: fieldPath})
+			out = append(out, entry{key, nil})
+			continue
+		}
+		out = append(out, entry{key, ex.complete(ctx, field.Type, v, sel.Selections, fieldPath)})
+	}
+	return out
+}
+
+// complete resolves the sub-selections of an object-typed result.
+func (ex *executor) complete(ctx context.Context, typ *Object, v any, sels []Selection, path []any) any {
+	if typ == nil || v == nil {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+	case reflect.Slice:
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = ex.complete(ctx, typ, rv.Index(i).Interface(), sels, append(append([]any(nil), path...), i))
+		}
+		return list
+	}
+	return ex.object(ctx, typ, v, sels, path)
+}
+
+func (ex *executor) resolveVars(v any) any {
+	switch x := v.(type) {
+	case Variable:
+		return ex.vars[string(x)]
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = ex.resolveVars(e)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			out[k] = ex.resolveVars(e)
+		}
+		return out
+	}
+	return v
+}
+
+// jsonField reads the field of a struct (or key of a map) that encodes to
+// JSON as name.
+func jsonField(src any, name string) any {
+	rv := reflect.ValueOf(src)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		if v := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key())); v.IsValid() {
+			return v.Interface()
+		}
+	case reflect.Struct:
+		if idx, ok := jsonFields(rv.Type())[name]; ok {
+			f, err := rv.FieldByIndexErr(idx)
+			if err != nil { // nil embedded pointer
+				return nil
+			}
+			if (f.Kind() == reflect.Pointer || f.Kind() == reflect.Map || f.Kind() == reflect.Slice) && f.IsNil() {
+				return nil
+			}
+			return f.Interface()
+		}
+	}
+	return nil
+}
+
+var fieldCache sync.Map // reflect.Type -> map[string][]int
+
+// jsonFields maps JSON names to struct field indexes, following embedded
+// structs the way encoding/json does (for the simple cases).
+func jsonFields(t reflect.Type) map[string][]int {
+	if m, ok := fieldCache.Load(t); ok {
+		return m.(map[string][]int)
+	}
+	m := map[string][]int{}
+	var walk func(t reflect.Type, prefix []int)
+	walk = func(t reflect.Type, prefix []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			idx := append(append([]int(nil), prefix...), i)
+			tag := f.Tag.Get("json")
+			name, _, _ := strings.Cut(tag, ",")
+			if name == "-" {
+				continue
+			}
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft, idx)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if _, shadowed := m[name]; !shadowed || len(idx) < len(m[name]) {
+				m[name] = idx
+			}
+		}
+	}
+	walk(t, nil)
+	fieldCache.Store(t, m)
+	return m
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// orderedMap is a JSON object that keeps the order fields were selected in.
+type orderedMap []entry
+
+type entry struct {
+	key string
+	val any
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(e.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(e.val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,150 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type author struct {
+	Name  string `json:"name"`
+	Books []book `json:"-"`
+}
+
+type book struct {
+	Title string  `json:"title"`
+	Pages int     `json:"pages"`
+	Note  *string `json:"note,omitempty"`
+}
+
+func testSchema() *Schema {
+	authors := []author{
+		{Name: "Ann", Books: []book{{Title: "A1", Pages: 10}, {Title: "A2", Pages: 20}}},
+		{Name: "Bob"},
+	}
+	bookType := &Object{Name: "Book", Fields: map[string]*Field{
+		"title": {}, "pages": {}, "note": {},
+		"broken": {Resolve: func(context.Context, any, Args) (any, error) { return nil, errors.New("boom") }},
+	}}
+	authorType := &Object{Name: "Author", Fields: map[string]*Field{
+		"name": {},
+		"books": {Type: bookType, Args: []string{"limit"}, Resolve: func(_ context.Context, src any, args Args) (any, error) {
+			limit, err := args.Int("limit", 100)
+			if err != nil {
+				return nil, err
+			}
+			b := src.(author).Books
+			if limit < len(b) {
+				b = b[:limit]
+			}
+			return b, nil
+		}},
+	}}
+	return &Schema{Query: &Object{Name: "Query", Fields: map[string]*Field{
+		"authors": {Type: authorType, Resolve: func(context.Context, any, Args) (any, error) { return authors, nil }},
+		"author": {Type: authorType, Args: []string{"name"}, Resolve: func(_ context.Context, _ any, args Args) (any, error) {
+			name, err := args.String("name")
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range authors {
+				if a.Name == name {
+					return a, nil
+				}
+			}
+			return nil, nil
+		}},
+	}}}
+}
+
+func run(t *testing.T, req Request) (string, []Error) {
+	t.Helper()
+	resp := testSchema().Execute(context.Background(), req)
+	b, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), resp.Errors
+}
+
+func TestExecute(t *testing.T) {
+	cases := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"nested", Request{Query: `{ authors { name books { title } } }`},
+			`{"authors":[{"name":"Ann","books":[{"title":"A1"},{"title":"A2"}]},{"name":"Bob","books":[]}]}`},
+		{"alias and args", Request{Query: `query { ann: author(name: "Ann") { __typename books(limit: 1) { title, pages } } }`},
+			`{"ann":{"__typename":"Author","books":[{"title":"A1","pages":10}]}}`},
+		{"variables", Request{Query: `query Q($n: String!, $l: Int = 5) { author(name: $n) { books(limit: $l) { title } } }`, Variables: map[string]any{"n": "Ann", "l": 1.0}},
+			`{"author":{"books":[{"title":"A1"}]}}`},
+		{"fragments", Request{Query: `{ author(name: "Ann") { ...F ... on Author { books(limit: 1) { pages } } } } fragment F on Author { name books(limit: 1) { title } }`},
+			`{"author":{"name":"Ann","books":[{"title":"A1","pages":10}]}}`},
+		{"null object", Request{Query: `{ author(name: "Zed") { name } }`}, `{"author":null}`},
+		{"operation name", Request{Query: `query A { authors { name } } query B { author(name: "Bob") { name } }`, OperationName: "B"},
+			`{"author":{"name":"Bob"}}`},
+		{"nil pointer scalar", Request{Query: `{ author(name: "Ann") { books(limit: 1) { note } } }`},
+			`{"author":{"books":[{"note":null}]}}`},
+	}
+	for _, c := range cases {
+		got, errs := run(t, c.req)
+		if len(errs) > 0 || got != c.want {
+			t.Errorf("%s: got %s errs=%v\nwant %s", c.name, got, errs, c.want)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	// request errors: no data
+	for query, want := range map[string]string{
+		`{ authors { nope } }`:                               `cannot query field "nope" on type Author`,
+		`{ author(id: 1) { name } }`:                         `unknown argument "id"`,
+		`{ authors }`:                                        `needs a selection`,
+		`{ authors { name { x } } }`:                         `is a scalar`,
+		`{ author(name: $n) { name } }`:                      `variable $n is not defined`,
+		`mutation { authors { name } }`:                      `only queries are supported`,
+		`{ authors { ...F } } fragment F on Book { title }`:  `cannot be spread on type Author`,
+		`{ authors { ...F } } fragment F on Author { ...F }`: `spreads itself`,
+		`{ authors @skip(if: true) { name } }`:               `directives are not supported`,
+		`{ authors { name }`:                                 `syntax error`,
+		`{ author(name: "x) { name } }`:                      `unterminated string`,
+	} {
+		resp := testSchema().Execute(context.Background(), Request{Query: query})
+		if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, want) {
+			t.Errorf("%s: got data=%v errors=%v, want %q", query, resp.Data, resp.Errors, want)
+		}
+	}
+
+	// field errors: null with a path, the rest still resolves
+	got, errs := run(t, Request{Query: `{ author(name: "Ann") { name books(limit: 1) { broken } } }`})
+	if got != `{"author":{"name":"Ann","books":[{"broken":null}]}}` {
+		t.Fatalf("got %s", got)
+	}
+	if len(errs) != 1 || errs[0].Message != "boom" {
+		t.Fatalf("errors: %+v", errs)
+	}
+	if path, _ := json.Marshal(errs[0].Path); string(path) != `["author","books",0,"broken"]` {
+		t.Fatalf("path: %s", path)
+	}
+	if _, errs := run(t, Request{Query: `{ author(name: 5) { name } }`}); len(errs) != 1 || !strings.Contains(errs[0].Message, "must be a string") {
+		t.Fatalf("bad argument type: %+v", errs)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	self := &Object{Name: "Node", Fields: map[string]*Field{"id": {}}}
+	self.Fields["next"] = &Field{Type: self, Resolve: func(context.Context, any, Args) (any, error) { return map[string]any{"id": 1}, nil }}
+	s := &Schema{Query: &Object{Name: "Query", Fields: map[string]*Field{"node": self.Fields["next"]}}}
+
+	q := "{ node { " + strings.Repeat("next { ", maxDepth) + "id" + strings.Repeat(" }", maxDepth) + " } }"
+	if resp := s.Execute(context.Background(), Request{Query: q}); len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "levels deep") {
+		t.Fatalf("expected depth error, got %+v", resp.Errors)
+	}
+	q = "{ node { next { id } } }"
+	if resp := s.Execute(context.Background(), Request{Query: q}); len(resp.Errors) != 0 {
+		t.Fatalf("unexpected errors %+v", resp.Errors)
+	}
+}
@@ -0,0 +1,445 @@
+// Package graphql executes the read-only subset of GraphQL the admin panel
+// needs: query operations with nested selections, aliases, arguments,
+// variables and fragments, resolved against a schema of Go resolvers.
+// Mutations, subscriptions, directives and introspection are not supported.
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Document is a parsed request.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+	Type       string // "query", "mutation" or "subscription"
+	Name       string
+	Variables  []VariableDef
+	Selections []Selection
+}
+
+type VariableDef struct {
+	Name    string
+	Default any // nil when there is none
+}
+
+type Fragment struct {
+	Name       string
+	On         string
+	Selections []Selection
+}
+
+// Selection is a field, a fragment spread (Spread set) or an inline fragment
+// (Inline set).
+type Selection struct {
+	Alias      string
+	Name       string
+	Args       map[string]any // literal values, with Variable for $refs
+	Selections []Selection
+
+	Spread string
+	Inline *Fragment
+}
+
+// Variable is an argument value that refers to a request variable.
+type Variable string
+
+// key is the name the field is returned under.
+func (s Selection) key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+// Parse parses a GraphQL request document.
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	doc := &Document{Fragments: map[string]*Fragment{}}
+	for p.tok.kind != tokEOF {
+		if p.err != nil {
+			return nil, p.err
+		}
+		switch {
+		case p.peek("{"):
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", Selections: p.selectionSet()})
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			doc.Operations = append(doc.Operations, p.operation())
+		case p.peekName("fragment"):
+			f := p.fragment()
+			if _, dup := doc.Fragments[f.Name]; dup && p.err == nil {
+				p.fail("duplicate fragment %q", f.Name)
+			}
+			doc.Fragments[f.Name] = f
+		default:
+			p.fail("unexpected %s", p.tok)
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("document has no operation")
+	}
+	return doc, nil
+}
+
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+func (p *parser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("syntax error at %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+	}
+	p.tok = token{kind: tokEOF, pos: p.tok.pos}
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	t, err := p.lex.next()
+	if err != nil {
+		p.err = err
+		t = token{kind: tokEOF}
+	}
+	p.tok = t
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.val == punct
+}
+
+func (p *parser) peekName(name string) bool {
+	return p.tok.kind == tokName && p.tok.val == name
+}
+
+func (p *parser) expect(punct string) {
+	if !p.peek(punct) {
+		p.fail("expected %q, got %s", punct, p.tok)
+		return
+	}
+	p.next()
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.fail("expected a name, got %s", p.tok)
+		return ""
+	}
+	n := p.tok.val
+	p.next()
+	return n
+}
+
+func (p *parser) operation() *Operation {
+	op := &Operation{Type: p.name()}
+	if p.tok.kind == tokName {
+		op.Name = p.name()
+	}
+	if p.peek("(") {
+		p.next()
+		for p.err == nil && !p.peek(")") {
+			p.expect("$")
+			v := VariableDef{Name: p.name()}
+			p.expect(":")
+			p.typeRef()
+			if p.peek("=") {
+				p.next()
+				v.Default = p.value(true)
+			}
+			op.Variables = append(op.Variables, v)
+		}
+		p.expect(")")
+	}
+	p.directives()
+	op.Selections = p.selectionSet()
+	return op
+}
+
+// typeRef skips a variable's type; values are checked by the resolvers.
+func (p *parser) typeRef() {
+	if p.peek("[") {
+		p.next()
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	if p.peek("!") {
+		p.next()
+	}
+}
+
+func (p *parser) directives() {
+	if p.peek("@") {
+		p.fail("directives are not supported")
+	}
+}
+
+func (p *parser) fragment() *Fragment {
+	p.name() // "fragment"
+	f := &Fragment{Name: p.name()}
+	if f.Name == "on" {
+		p.fail("fragment cannot be named \"on\"")
+	}
+	if !p.peekName("on") {
+		p.fail("expected \"on\", got %s", p.tok)
+	}
+	p.next()
+	f.On = p.name()
+	p.directives()
+	f.Selections = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []Selection {
+	p.expect("{")
+	var sels []Selection
+	for p.err == nil && !p.peek("}") {
+		sels = append(sels, p.selection())
+	}
+	p.expect("}")
+	if p.err == nil && len(sels) == 0 {
+		p.fail("empty selection set")
+	}
+	return sels
+}
+
+func (p *parser) selection() Selection {
+	if p.peek("...") {
+		p.next()
+		if p.tok.kind == tokName && !p.peekName("on") {
+			s := Selection{Spread: p.name()}
+			p.directives()
+			return s
+		}
+		f := &Fragment{}
+		if p.peekName("on") {
+			p.next()
+			f.On = p.name()
+		}
+		p.directives()
+		f.Selections = p.selectionSet()
+		return Selection{Inline: f}
+	}
+	s := Selection{Name: p.name()}
+	if p.peek(":") {
+		p.next()
+		s.Alias, s.Name = s.Name, p.name()
+	}
+	if p.peek("(") {
+		p.next()
+		s.Args = map[string]any{}
+		for p.err == nil && !p.peek(")") {
+			n := p.name()
+			p.expect(":")
+			s.Args[n] = p.value(false)
+		}
+		p.expect(")")
+	}
+	p.directives()
+	if p.peek("{") {
+		s.Selections = p.selectionSet()
+	}
+	return s
+}
+
+// value parses an argument value; constant values (variable defaults) may
+// not refer to variables.
+func (p *parser) value(constant bool) any {
+	t := p.tok
+	switch t.kind {
+	case tokInt:
+		p.next()
+		n, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			p.fail("invalid int %s", t.val)
+		}
+		return n
+	case tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			p.fail("invalid float %s", t.val)
+		}
+		return f
+	case tokString:
+		p.next()
+		return t.val
+	case tokName:
+		p.next()
+		switch t.val {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return t.val // enum value
+	}
+	switch {
+	case p.peek("$") && !constant:
+		p.next()
+		return Variable(p.name())
+	case p.peek("["):
+		p.next()
+		list := []any{}
+		for p.err == nil && !p.peek("]") {
+			list = append(list, p.value(constant))
+		}
+		p.expect("]")
+		return list
+	case p.peek("{"):
+		p.next()
+		obj := map[string]any{}
+		for p.err == nil && !p.peek("}") {
+			n := p.name()
+			p.expect(":")
+			obj[n] = p.value(constant)
+		}
+		p.expect("}")
+		return obj
+	}
+	p.fail("unexpected %s", t)
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of document"
+	}
+	return strconv.Quote(t.val)
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	// whitespace, commas and comments are insignificant
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokPunct, val: "...", pos: start}, nil
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, val: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, val: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	return token{}, fmt.Errorf("syntax error at %d: unexpected character %q", start, c)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() {
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	digits()
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		digits()
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		digits()
+	}
+	return token{kind: kind, val: l.src[start:l.pos], pos: start}, nil
+}
+
+// string reads a quoted string. Block strings are not supported; escapes
+// are the same as JSON's.
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		return token{}, fmt.Errorf("syntax error at %d: block strings are not supported", start)
+	}
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\n':
+			return token{}, fmt.Errorf("syntax error at %d: unterminated string", start)
+		case '"':
+			l.pos++
+			var s string
+			if err := json.Unmarshal([]byte(l.src[start:l.pos]), &s); err != nil {
+				return token{}, fmt.Errorf("syntax error at %d: invalid string", start)
+			}
+			return token{kind: tokString, val: s, pos: start}, nil
+		}
+		l.pos++
+	}
+	return token{}, fmt.Errorf("syntax error at %d: unterminated string", start)
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+type ActivateResponse struct {
+	Activated      bool   `json:"activated"`
+	Activations    int    `json:"activations"`
+	MaxActivations int    `json:"max_activations"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertActivation(ctx context.Context, db execer, cfg *config.Config, licenseKey, machineID string) error {
+	_, err := db.ExecContext(ctx, `insert into activations (id, license_key, machine_id, activated_at) values ($1,$2,$3,$4)`,
+		uuid.NewString(), licenseKey, machineID, dbTime(cfg, time.Now()))
+	return err
+}
+
+func isActivated(ctx context.Context, db queryer, licenseKey, machineID string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `select count(*) from activations where license_key=$1 and machine_id=$2`, licenseKey, machineID).Scan(&n)
+	return n > 0, err
+}
+
+func countActivations(ctx context.Context, db queryer, licenseKey string) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `select count(*) from activations where license_key=$1`, licenseKey).Scan(&n)
+	return n, err
+}
+
+// ActivateLicense records a machine against a license, consuming a seat.
+// Re-activating an already recorded machine is a no-op; new machines are
+// rejected once max_activations seats are in use.
+func ActivateLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.MachineID == "" {
+			http.Error(w, "license_key and machine_id required", http.StatusBadRequest)
+			return
+		}
+		if err := req.Machine.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var resp ActivateResponse
+		if !inTx(w, r, db, "activate", func(tx *sql.Tx) error {
+			var code int
+			var err error
+			resp, code, err = activateMachine(r.Context(), tx, cfg, req.LicenseKey, req.MachineID, req.Machine)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return err
+			}
+			if code != http.StatusOK {
+				return txAnswer{code, resp}
+			}
+			return nil
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// activateMachine applies the activation rules inside tx and returns the
+// response and status to send; on success the machine is recorded with info
+// (which may be nil). The caller commits when the status is 200.
+// sql.ErrNoRows is returned for unknown licenses.
+func activateMachine(ctx context.Context, tx *sql.Tx, cfg *config.Config, licenseKey, machineID string, info *MachineInfo) (ActivateResponse, int, error) {
+	// lock the license row so concurrent activations can't both take the last seat
+	st, err := loadLicenseState(ctx, tx, cfg, licenseKey, true)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ActivateResponse{}, http.StatusNotFound, err
+		}
+		return ActivateResponse{}, 0, fmt.Errorf("lookup: %w", err)
+	}
+	maxActivations := st.MaxActivations
+	switch {
+	case st.Revoked:
+		return ActivateResponse{MaxActivations: maxActivations, Reason: "revoked"}, http.StatusForbidden, nil
+	case st.Archived:
+		return ActivateResponse{MaxActivations: maxActivations, Reason: "archived"}, http.StatusForbidden, nil
+	case st.Suspended:
+		return ActivateResponse{MaxActivations: maxActivations, Reason: "suspended"}, http.StatusForbidden, nil
+	case time.Now().After(st.graceEnd(cfg)):
+		return ActivateResponse{MaxActivations: maxActivations, Reason: "expired"}, http.StatusForbidden, nil
+	}
+
+	existing, err := isActivated(ctx, tx, licenseKey, machineID)
+	if err != nil {
+		return ActivateResponse{}, 0, fmt.Errorf("existing: %w", err)
+	}
+	count, err := countActivations(ctx, tx, licenseKey)
+	if err != nil {
+		return ActivateResponse{}, 0, fmt.Errorf("count: %w", err)
+	}
+	if !existing {
+		if count >= maxActivations {
+			return ActivateResponse{Activations: count, MaxActivations: maxActivations, Reason: "seat limit exceeded"}, http.StatusConflict, nil
+		}
+		if err := insertActivation(ctx, tx, cfg, licenseKey, machineID); err != nil {
+			return ActivateResponse{}, 0, fmt.Errorf("insert: %w", err)
+		}
+		count++
+	}
+	if err := recordMachine(ctx, tx, cfg, machineID, licenseKey, info); err != nil {
+		return ActivateResponse{}, 0, fmt.Errorf("machine: %w", err)
+	}
+	return ActivateResponse{Activated: true, Activations: count, MaxActivations: maxActivations}, http.StatusOK, nil
+}
+
+type Activation struct {
+	ID          string    `json:"id"`
+	LicenseKey  string    `json:"license_key"`
+	MachineID   string    `json:"machine_id"`
+	ActivatedAt time.Time `json:"activated_at"`
+	// from the machines table, once the machine has activated or sent a
+	// heartbeat itself
+	Hostname   string     `json:"hostname,omitempty"`
+	OS         string     `json:"os,omitempty"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+type ListActivationsResponse struct {
+	Activations    []Activation `json:"activations"`
+	MaxActivations int          `json:"max_activations"`
+	NextCursor     string       `json:"next_cursor,omitempty"`
+}
+
+// ListActivations returns the machines activated against ?license_key=,
+// oldest first, paginated with ?limit= and ?cursor= like ListLicenses.
+func ListActivations(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		limit, ok := listLimit(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		st, err := loadLicenseState(ctx, db, cfg, key, false)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, "activations.list.lookup", err)
+			return
+		}
+		query := `select a.id, a.license_key, a.machine_id, a.activated_at, coalesce(m.hostname, ''), coalesce(m.os, ''), m.last_seen_at
+			from activations a left join machines m on m.machine_id=a.machine_id
+			where a.license_key=$1`
+		args := []any{key}
+		if v := r.URL.Query().Get("cursor"); v != "" {
+			at, machineID, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, at, machineID)
+			query += " and " + keysetCond("a.activated_at", "a.machine_id", ">", 2)
+		}
+		query += fmt.Sprintf(" order by a.activated_at, a.machine_id limit %d", limit+1)
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			internalError(w, "activations.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListActivationsResponse{Activations: []Activation{}, MaxActivations: st.MaxActivations}
+		for rows.Next() {
+			if len(resp.Activations) == limit {
+				last := resp.Activations[limit-1]
+				resp.NextCursor = encodeListCursor(cursorTime(cfg, last.ActivatedAt), last.MachineID)
+				break
+			}
+			var a Activation
+			var at, seen nullTime
+			if err := rows.Scan(&a.ID, &a.LicenseKey, &a.MachineID, &at, &a.Hostname, &a.OS, &seen); err != nil {
+				internalError(w, "activations.list.scan", err)
+				return
+			}
+			a.ActivatedAt = at.Time
+			if seen.Valid {
+				a.LastSeenAt = &seen.Time
+			}
+			resp.Activations = append(resp.Activations, a)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "activations.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// DeactivateMachine frees a seat by removing one machine's activation (and
+// any floating sessions it holds). The machine fails validation until it is
+// activated again.
+func DeactivateMachine(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.MachineID == "" {
+			http.Error(w, "license_key and machine_id required", http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		var count int
+		if !inTx(w, r, db, "deactivate", func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, `delete from activations where license_key=$1 and machine_id=$2`, req.LicenseKey, req.MachineID)
+			if err != nil {
+				return fmt.Errorf("delete: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if _, err := tx.ExecContext(ctx, `delete from sessions where license_key=$1 and machine_id=$2`, req.LicenseKey, req.MachineID); err != nil {
+				return fmt.Errorf("sessions: %w", err)
+			}
+			if count, err = countActivations(ctx, tx, req.LicenseKey); err != nil {
+				return fmt.Errorf("count: %w", err)
+			}
+			if err := auditTx(r, tx, cfg, AuditLicenseDeactivate, req.LicenseKey, map[string]any{"machine_id": req.MachineID}); err != nil {
+				return fmt.Errorf("audit: %w", err)
+			}
+			return nil
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "activations": count})
+	})
+}
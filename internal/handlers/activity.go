@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+const (
+	defaultActivityDays  = 30
+	maxActivityDays      = 365
+	defaultActivityLimit = 50
+	maxActivityLimit     = 500
+)
+
+type ValidationAttempt struct {
+	MachineID string    `json:"machine_id"`
+	Valid     bool      `json:"valid"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// LicenseActivity summarises how a license has been phoning home. The counts
+// cover the ?days= window ending now; LastValidatedAt and LastSeenAt (the
+// last heartbeat) are all-time.
+type LicenseActivity struct {
+	LicenseKey       string              `json:"license_key"`
+	Since            time.Time           `json:"since"`
+	Attempts         int                 `json:"attempts"`
+	Failures         int                 `json:"failures"`
+	DistinctMachines int                 `json:"distinct_machines"`
+	DistinctIPs      int                 `json:"distinct_ips"`
+	LastAttemptAt    *time.Time          `json:"last_attempt_at,omitempty"`
+	LastValidatedAt  *time.Time          `json:"last_validated_at,omitempty"`
+	LastSeenAt       *time.Time          `json:"last_seen_at,omitempty"`
+	Recent           []ValidationAttempt `json:"recent"`
+}
+
+// recordValidation stores the outcome of validating a known license. It
+// never fails the validation itself.
+func recordValidation(ctx context.Context, r *http.Request, db *sql.DB, cfg *config.Config, req ValidateRequest, resp ValidateResponse) {
+	logging.Debugf("validate license_key=%s machine_id=%s valid=%t reason=%s remote=%s", req.LicenseKey, req.MachineID, resp.Valid, resp.Reason, middleware.ClientIP(r))
+	_, err := db.ExecContext(ctx, `insert into validation_events (id, license_key, machine_id, valid, reason, ip, created_at) values ($1,$2,$3,$4,$5,$6,$7)`,
+		uuid.NewString(), req.LicenseKey, req.MachineID, resp.Valid, resp.Reason, middleware.ClientIP(r), dbTime(cfg, time.Now()))
+	if err != nil {
+		logging.Errorf("validation record error license_key=%s err=%v", req.LicenseKey, err)
+	}
+}
+
+// GetLicenseActivity returns validation activity for the {license_key}
+// license: totals over the last ?days= (default 30) and the ?limit= (default
+// 50) most recent attempts.
+func GetLicenseActivity(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		days, limit := defaultActivityDays, defaultActivityLimit
+		if v := q.Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxActivityDays {
+				http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxActivityDays), http.StatusBadRequest)
+				return
+			}
+			days = n
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxActivityLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxActivityLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		ctx := r.Context()
+		resp := LicenseActivity{LicenseKey: key, Since: time.Now().UTC().AddDate(0, 0, -days), Recent: []ValidationAttempt{}}
+		var lastSeen nullTime
+		if err := db.QueryRowContext(ctx, `select last_seen_at from licenses where license_key=$1`, key).Scan(&lastSeen); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, "activity.lookup", err)
+			return
+		}
+		resp.LastSeenAt = lastSeen.Ptr()
+
+		var lastAttempt, lastValid nullTime
+		err := db.QueryRowContext(ctx, `select count(*),
+			coalesce(sum(case when valid then 0 else 1 end), 0),
+			count(distinct machine_id),
+			count(distinct nullif(ip, '')),
+			max(created_at)
+			from validation_events where license_key=$1 and created_at >= $2`, key, dbTime(cfg, resp.Since)).
+			Scan(&resp.Attempts, &resp.Failures, &resp.DistinctMachines, &resp.DistinctIPs, &lastAttempt)
+		if err != nil {
+			internalError(w, "activity.totals", err)
+			return
+		}
+		resp.LastAttemptAt = lastAttempt.Ptr()
+		if err := db.QueryRowContext(ctx, `select max(created_at) from validation_events where license_key=$1 and valid=true`, key).Scan(&lastValid); err != nil {
+			internalError(w, "activity.last_valid", err)
+			return
+		}
+		resp.LastValidatedAt = lastValid.Ptr()
+
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`select machine_id, valid, reason, ip, created_at from validation_events
+			where license_key=$1 order by created_at desc, id desc limit %d`, limit), key)
+		if err != nil {
+			internalError(w, "activity.recent", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a ValidationAttempt
+			var at nullTime
+			if err := rows.Scan(&a.MachineID, &a.Valid, &a.Reason, &a.IP, &at); err != nil {
+				internalError(w, "activity.scan", err)
+				return
+			}
+			a.At = at.Time
+			resp.Recent = append(resp.Recent, a)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "activity.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+const (
+	// apiKeyPrefix marks database-managed keys so bootstrap keys skip the
+	// lookup.
+	apiKeyPrefix        = "raal_"
+	apiKeyDisplayLen    = 12
+	maxAPIKeyLabelLen   = 200
+	apiKeyTouchInterval = time.Minute // last_used_at granularity
+	maxAPIKeyOverlap    = 30 * 24 * time.Hour
+	// apiKeyActorPrefix plus the key's id is its audit actor.
+	apiKeyActorPrefix = "apikey:"
+)
+
+// CreateAPIKeyRequest names a new key. Role defaults to admin; scopes,
+// product_ids and customers, when given, narrow the key further (see
+// middleware.KeyScope).
+type CreateAPIKeyRequest struct {
+	Label      string   `json:"label"`
+	Role       string   `json:"role,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	ProductIDs []string `json:"product_ids,omitempty"`
+	Customers  []string `json:"customers,omitempty"`
+}
+
+// UpdateAPIKeyRequest changes the given fields of a key; an empty list
+// lifts that limit.
+type UpdateAPIKeyRequest struct {
+	Label      string    `json:"label,omitempty"`
+	Role       string    `json:"role,omitempty"`
+	Scopes     *[]string `json:"scopes,omitempty"`
+	ProductIDs *[]string `json:"product_ids,omitempty"`
+	Customers  *[]string `json:"customers,omitempty"`
+}
+
+// APIKey describes an admin key. Key, the plaintext, is only returned when
+// the key is created or rotated; the server keeps just its hash.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label"`
+	Prefix     string     `json:"prefix"`
+	Role       string     `json:"role"`
+	Scopes     []string   `json:"scopes,omitempty"`
+	ProductIDs []string   `json:"product_ids,omitempty"`
+	Customers  []string   `json:"customers,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// PreviousExpiresAt is when the secret from before the last rotation
+	// stops working; unset once it has.
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+}
+
+const apiKeyColumns = `id, label, key_prefix, role, scopes, product_ids, customers, created_at, rotated_at, last_used_at, revoked_at, previous_expires_at`
+
+func scanAPIKey(sc rowScanner) (APIKey, error) {
+	var k APIKey
+	var scopes, products, customers []byte
+	var created, rotated, used, revoked, previous nullTime
+	if err := sc.Scan(&k.ID, &k.Label, &k.Prefix, &k.Role, &scopes, &products, &customers, &created, &rotated, &used, &revoked, &previous); err != nil {
+		return k, err
+	}
+	_ = json.Unmarshal(scopes, &k.Scopes)
+	_ = json.Unmarshal(products, &k.ProductIDs)
+	_ = json.Unmarshal(customers, &k.Customers)
+	k.CreatedAt = created.Time
+	k.RotatedAt, k.LastUsedAt, k.RevokedAt = rotated.Ptr(), used.Ptr(), revoked.Ptr()
+	if previous.Valid && previous.Time.After(time.Now()) {
+		k.PreviousExpiresAt = previous.Ptr()
+	}
+	return k, nil
+}
+
+// checkKeyScopes answers 400 unless every scope is known and no product or
+// customer is empty.
+func checkKeyScopes(w http.ResponseWriter, ks middleware.KeyScope) bool {
+	for _, sc := range ks.Scopes {
+		if !middleware.ValidScope(sc) {
+			http.Error(w, fmt.Sprintf("unknown scope %q (want one of %s)", sc, strings.Join(middleware.Scopes, ", ")), http.StatusBadRequest)
+			return false
+		}
+	}
+	if slices.Contains(ks.ProductIDs, "") || slices.Contains(ks.Customers, "") {
+		http.Error(w, "product_ids and customers must not contain empty strings", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// jsonList encodes a scope column; nil is stored as [].
+func jsonList(v []string) string {
+	if len(v) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// updateList is the argument for an optional scope column in an update: NULL
+// (so coalesce keeps the column) when v is nil.
+func updateList(v *[]string) any {
+	if v == nil {
+		return nil
+	}
+	return jsonList(*v)
+}
+
+// auditDetails lists the changed fields and their new values.
+func (req UpdateAPIKeyRequest) auditDetails(id string) map[string]any {
+	var changes map[string]any
+	if b, err := json.Marshal(req); err == nil {
+		_ = json.Unmarshal(b, &changes)
+	}
+	if changes == nil {
+		changes = map[string]any{}
+	}
+	changes["key_id"] = id
+	return changes
+}
+
+// newAPIKey returns a fresh key and its stored hash.
+func newAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, hashAPIKey(key), nil
+}
+
+// hashAPIKey is a plain sha256: keys are random, so unlike the bootstrap
+// keys they need no bcrypt, and the hash can be looked up directly.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// AdminKeyLookup checks bearer tokens against the api_keys table for the
+// admin middleware and records when each key was last used. The actor is
+// "apikey:<id>". OIDC session tokens are checked against admin_sessions.
+func AdminKeyLookup(db *sql.DB, cfg *config.Config) middleware.AdminKeyLookup {
+	return func(ctx context.Context, token string) (middleware.AdminKey, bool) {
+		if strings.HasPrefix(token, sessionPrefix) {
+			return lookupSession(ctx, db, cfg, token)
+		}
+		if !strings.HasPrefix(token, apiKeyPrefix) {
+			return middleware.AdminKey{}, false
+		}
+		// a rotated key's old secret works until its overlap runs out
+		k, err := scanAPIKey(db.QueryRowContext(ctx, `select `+apiKeyColumns+` from api_keys
+			where (key_hash=$1 or (previous_key_hash=$1 and previous_expires_at > $2)) and revoked_at is null`,
+			hashAPIKey(token), dbTime(cfg, time.Now())))
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				logging.Errorf("api key lookup error err=%v", err)
+			}
+			return middleware.AdminKey{}, false
+		}
+		now := time.Now()
+		if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval {
+			if _, err := db.ExecContext(ctx, `update api_keys set last_used_at=$1 where id=$2`, dbTime(cfg, now), k.ID); err != nil {
+				logging.Errorf("api key touch error id=%s err=%v", k.ID, err)
+			}
+		}
+		return apiKeyAdminKey(k), true
+	}
+}
+
+func apiKeyAdminKey(k APIKey) middleware.AdminKey {
+	return middleware.AdminKey{
+		Actor:    apiKeyActorPrefix + k.ID,
+		Role:     k.Role,
+		KeyScope: middleware.KeyScope{Scopes: k.Scopes, ProductIDs: k.ProductIDs, Customers: k.Customers},
+	}
+}
+
+// CreateAPIKey makes a new admin key with the requested role. The plaintext
+// is in the response and cannot be retrieved again.
+func CreateAPIKey(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req CreateAPIKeyRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Label == "" || len(req.Label) > maxAPIKeyLabelLen {
+			http.Error(w, fmt.Sprintf("label required (at most %d bytes)", maxAPIKeyLabelLen), http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = middleware.RoleAdmin
+		}
+		if !middleware.ValidRole(req.Role) {
+			http.Error(w, "role must be admin, issuer, support or viewer", http.StatusBadRequest)
+			return
+		}
+		if !checkKeyScopes(w, middleware.KeyScope{Scopes: req.Scopes, ProductIDs: req.ProductIDs, Customers: req.Customers}) {
+			return
+		}
+		key, hash, err := newAPIKey()
+		if err != nil {
+			internalError(w, "apikeys.create.generate", err)
+			return
+		}
+		now := time.Now().UTC()
+		k := APIKey{ID: uuid.NewString(), Label: req.Label, Prefix: key[:apiKeyDisplayLen], Role: req.Role,
+			Scopes: req.Scopes, ProductIDs: req.ProductIDs, Customers: req.Customers, Key: key, CreatedAt: now}
+		if !inTx(w, r, db, "apikeys.create", func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(r.Context(), `insert into api_keys (id, label, key_prefix, role, scopes, product_ids, customers, key_hash, created_at) values ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+				k.ID, k.Label, k.Prefix, k.Role, jsonList(k.Scopes), jsonList(k.ProductIDs), jsonList(k.Customers), hash, dbTime(cfg, now)); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			return auditTx(r, tx, cfg, AuditAPIKeyCreate, "", map[string]any{"key_id": k.ID, "label": k.Label, "role": k.Role,
+				"scopes": k.Scopes, "product_ids": k.ProductIDs, "customers": k.Customers})
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, k)
+	})
+}
+
+// ListAPIKeys returns every managed key, revoked ones included, oldest first.
+func ListAPIKeys(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rows, err := db.QueryContext(r.Context(), `select `+apiKeyColumns+` from api_keys order by created_at, id`)
+		if err != nil {
+			internalError(w, "apikeys.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListAPIKeysResponse{APIKeys: []APIKey{}}
+		for rows.Next() {
+			k, err := scanAPIKey(rows)
+			if err != nil {
+				internalError(w, "apikeys.list.scan", err)
+				return
+			}
+			resp.APIKeys = append(resp.APIKeys, k)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "apikeys.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// UpdateAPIKey changes the label, role or scope of the {key_id} key;
+// omitted fields are left as they are.
+func UpdateAPIKey(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req UpdateAPIKeyRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Label == "" && req.Role == "" && req.Scopes == nil && req.ProductIDs == nil && req.Customers == nil {
+			http.Error(w, "nothing to update", http.StatusBadRequest)
+			return
+		}
+		if len(req.Label) > maxAPIKeyLabelLen {
+			http.Error(w, fmt.Sprintf("label too long (at most %d bytes)", maxAPIKeyLabelLen), http.StatusBadRequest)
+			return
+		}
+		if req.Role != "" && !middleware.ValidRole(req.Role) {
+			http.Error(w, "role must be admin, issuer, support or viewer", http.StatusBadRequest)
+			return
+		}
+		var ks middleware.KeyScope
+		if req.Scopes != nil {
+			ks.Scopes = *req.Scopes
+		}
+		if req.ProductIDs != nil {
+			ks.ProductIDs = *req.ProductIDs
+		}
+		if req.Customers != nil {
+			ks.Customers = *req.Customers
+		}
+		if !checkKeyScopes(w, ks) {
+			return
+		}
+		id, ok := apiKeyIDParam(w, r)
+		if !ok {
+			return
+		}
+		if !inTx(w, r, db, "apikeys.update", func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(r.Context(), `update api_keys set label=coalesce(nullif($1,''), label), role=coalesce(nullif($2,''), role),
+				scopes=coalesce($3, scopes), product_ids=coalesce($4, product_ids), customers=coalesce($5, customers) where id=$6`,
+				req.Label, req.Role, updateList(req.Scopes), updateList(req.ProductIDs), updateList(req.Customers), id)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			return auditTx(r, tx, cfg, AuditAPIKeyUpdate, "", req.auditDetails(id))
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
+
+// RotateAPIKey replaces the secret of the {key_id} key, keeping its id and
+// label. The old secret keeps working for ?overlap= (a duration, by default
+// server.api_key_overlap, 0 meaning it stops at once) so deployments can
+// switch over; the new plaintext is returned once.
+func RotateAPIKey(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, ok := apiKeyIDParam(w, r)
+		if !ok {
+			return
+		}
+		overlap := cfg.Server.APIKeyOverlap
+		if v := r.URL.Query().Get("overlap"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 || d > maxAPIKeyOverlap {
+				http.Error(w, fmt.Sprintf("overlap must be a duration between 0s and %v", maxAPIKeyOverlap), http.StatusBadRequest)
+				return
+			}
+			overlap = d
+		}
+		key, hash, err := newAPIKey()
+		if err != nil {
+			internalError(w, "apikeys.rotate.generate", err)
+			return
+		}
+		ctx := r.Context()
+		now := time.Now().UTC()
+		k := APIKey{ID: id, Prefix: key[:apiKeyDisplayLen], Key: key, RotatedAt: &now}
+		// the old secret becomes the previous one, good for overlap; an
+		// earlier previous secret stops working now
+		var prevExpires any
+		if overlap > 0 {
+			prevExpires = dbTime(cfg, now.Add(overlap))
+		}
+		var stored APIKey
+		if !inTx(w, r, db, "apikeys.rotate", func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, `update api_keys set key_prefix=$1, previous_key_hash=key_hash, key_hash=$2, rotated_at=$3, previous_expires_at=$4
+				where id=$5 and revoked_at is null`,
+				k.Prefix, hash, dbTime(cfg, now), prevExpires, k.ID)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return txAnswer{http.StatusNotFound, "not found or revoked"}
+			}
+			if stored, err = scanAPIKey(tx.QueryRowContext(ctx, `select `+apiKeyColumns+` from api_keys where id=$1`, k.ID)); err != nil {
+				return fmt.Errorf("lookup: %w", err)
+			}
+			return auditTx(r, tx, cfg, AuditAPIKeyRotate, "", map[string]any{"key_id": k.ID, "overlap": overlap.String()})
+		}) {
+			return
+		}
+		stored.Key, stored.RotatedAt = k.Key, k.RotatedAt
+		k = stored
+		writeJSON(w, http.StatusOK, k)
+	})
+}
+
+// RevokeAPIKey disables the {key_id} key for good. The row is kept so the
+// audit log's actor stays resolvable.
+func RevokeAPIKey(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, ok := apiKeyIDParam(w, r)
+		if !ok {
+			return
+		}
+		if !inTx(w, r, db, "apikeys.revoke", func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(r.Context(), `update api_keys set revoked_at=$1 where id=$2 and revoked_at is null`, dbTime(cfg, time.Now()), id)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return txAnswer{http.StatusNotFound, "not found or already revoked"}
+			}
+			return auditTx(r, tx, cfg, AuditAPIKeyRevoke, "", map[string]any{"key_id": id})
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
+
+// apiKeyIDParam reads {key_id}, answering 404 for anything that is not a
+// uuid (which Postgres would reject with an error).
+func apiKeyIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("key_id")
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return "", false
+	}
+	return id, true
+}
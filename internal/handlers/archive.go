@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// ArchiveLicense soft-deletes a license: it disappears from the default list
+// and fails validation with reason "archived", but the row (and everything
+// referencing it) is kept for audit. RestoreLicense undoes it.
+func ArchiveLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest // re-use with license_key
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		err := dbStore(db, cfg).Archive(withAudit(r, AuditLicenseArchive, nil), req.LicenseKey)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not found or already archived", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			internalError(w, "archive.update", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
+
+// RestoreLicense brings an archived license back into the list and validation.
+func RestoreLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest // re-use with license_key
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		err := dbStore(db, cfg).Restore(withAudit(r, AuditLicenseRestore, nil), req.LicenseKey)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "not found or not archived", http.StatusNotFound)
+			return
+		case isUniqueViolation(err):
+			http.Error(w, machineTakenError{}.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			internalError(w, "restore.update", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
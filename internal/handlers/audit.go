@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// Audit actions.
+const (
+	AuditLicenseIssue              = "license.issue"
+	AuditLicenseImport             = "license.import"
+	AuditLicenseUpdate             = "license.update"
+	AuditLicenseRevoke             = "license.revoke"
+	AuditLicenseSuspend            = "license.suspend"
+	AuditLicenseResume             = "license.resume"
+	AuditLicenseArchive            = "license.archive"
+	AuditLicenseRestore            = "license.restore"
+	AuditLicenseDelete             = "license.delete"
+	AuditLicensePrune              = "license.prune"
+	AuditLicenseSupersede          = "license.supersede"
+	AuditLicenseReissue            = "license.reissue"
+	AuditLicenseTransfer           = "license.transfer"
+	AuditLicenseDeactivate         = "license.deactivate"
+	AuditLicenseOfflineActivate    = "license.offline_activate"
+	AuditLicenseRequestSecret      = "license.request_secret"
+	AuditLicenseRequestSecretClear = "license.request_secret_clear"
+	AuditWebhookCreate             = "webhook.create"
+	AuditWebhookDelete             = "webhook.delete"
+	AuditAPIKeyCreate              = "apikey.create"
+	AuditAPIKeyUpdate              = "apikey.update"
+	AuditAPIKeyRotate              = "apikey.rotate"
+	AuditAPIKeyRevoke              = "apikey.revoke"
+	AuditSessionLogin              = "session.login"
+	AuditSessionLogout             = "session.logout"
+	AuditTOTPEnroll                = "totp.enroll"
+	AuditTOTPDisable               = "totp.disable"
+	AuditBackupCreate              = "backup.create"
+	AuditLogLevelSet               = "log_level.set"
+	AuditLogLevelReset             = "log_level.reset"
+)
+
+type AuditEntry struct {
+	ID         string         `json:"id"`
+	Actor      string         `json:"actor"`
+	Action     string         `json:"action"`
+	Entity     string         `json:"entity,omitempty"` // e.g. license:<key>, webhook:<id>, apikey:<id>
+	LicenseKey string         `json:"license_key,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	RequestID  string         `json:"request_id,omitempty"`
+	RemoteIP   string         `json:"remote_ip,omitempty"`
+	// Changes is what the action did to the license, field by field.
+	Changes   map[string]FieldChange `json:"changes,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+type ListAuditResponse struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// recordAudit notes a successful admin action that changes no rows, such
+// as a backup or a log level. Having nothing to roll back, it logs a
+// failure rather than failing the request.
+func recordAudit(r *http.Request, db *sql.DB, cfg *config.Config, action, licenseKey string, details map[string]any) {
+	if err := requestAudit(r, action, licenseKey, details).write(r.Context(), db, cfg); err != nil {
+		logging.Errorf("audit record error action=%s license_key=%s err=%v", action, licenseKey, err)
+	}
+}
+
+// auditTx writes the audit entry of an admin action in tx, the transaction
+// of the change, so neither commits without the other.
+func auditTx(r *http.Request, tx *sql.Tx, cfg *config.Config, action, licenseKey string, details map[string]any) error {
+	return requestAudit(r, action, licenseKey, details).write(r.Context(), tx, cfg)
+}
+
+// withAudit returns r's context carrying the store.Audit of an admin
+// action, so the license store writes its audit entry, and license_history,
+// in the transaction of the change.
+func withAudit(r *http.Request, action string, details map[string]any) context.Context {
+	a := requestAudit(r, action, "", details)
+	return store.WithAudit(r.Context(), store.Audit{
+		Actor: a.actor, RequestID: a.requestID, RemoteIP: a.remoteIP,
+		Action: action, Details: details, Diff: diffLicenses,
+	})
+}
+
+// writeAudit is auditTx for callers that name their own actor, such as an
+// OIDC login, which has no admin actor until its session exists.
+func writeAudit(ctx context.Context, tx *sql.Tx, cfg *config.Config, actor, requestID, action, licenseKey string, details map[string]any) error {
+	return auditRecord{actor: actor, requestID: requestID, action: action, licenseKey: licenseKey, details: details}.write(ctx, tx, cfg)
+}
+
+// auditRecord is one audit_log row.
+type auditRecord struct {
+	actor, requestID, remoteIP string
+	action, licenseKey         string
+	details                    map[string]any
+}
+
+// requestAudit starts the audit record of an admin request: the admin
+// actor, request ID and client IP.
+func requestAudit(r *http.Request, action, licenseKey string, details map[string]any) auditRecord {
+	actor := middleware.GetAdminActor(r)
+	if actor == "" {
+		actor = "unknown"
+	}
+	return auditRecord{
+		actor: actor, requestID: middleware.GetRequestID(r), remoteIP: middleware.ClientIP(r),
+		action: action, licenseKey: licenseKey, details: details,
+	}
+}
+
+func (a auditRecord) write(ctx context.Context, q store.Querier, cfg *config.Config) error {
+	return store.WriteAudit(ctx, q, dialect(cfg), store.AuditEntry{
+		Actor: a.actor, Action: a.action, Entity: a.entity(), LicenseKey: a.licenseKey,
+		Details: a.details, RequestID: a.requestID, RemoteIP: a.remoteIP,
+	})
+}
+
+// entity is what the record is about: the license, or the webhook or API
+// key its details name.
+func (a auditRecord) entity() string {
+	if a.licenseKey != "" {
+		return store.LicenseEntity(a.licenseKey)
+	}
+	for _, e := range []struct{ prefix, detail string }{{"webhook", "webhook_id"}, {"apikey", "key_id"}} {
+		if id, ok := a.details[e.detail].(string); ok && strings.HasPrefix(a.action, e.prefix+".") {
+			return e.prefix + ":" + id
+		}
+	}
+	return ""
+}
+
+// ListAudit pages through the audit log, newest first. Filters: actor,
+// action, entity and license_key match exactly; since/until (RFC3339) bound
+// created_at. Paging works like ListLicenses (limit, cursor).
+func ListAudit(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, ok := listLimit(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		var conds []string
+		var args []any
+		if v := q.Get("cursor"); v != "" {
+			createdAt, id, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, createdAt, id)
+			conds = append(conds, keysetCond("created_at", "id", "<", len(args)-1))
+		}
+		for _, col := range []string{"actor", "action", "entity", "license_key"} {
+			if v := q.Get(col); v != "" {
+				args = append(args, v)
+				conds = append(conds, fmt.Sprintf("%s=$%d", col, len(args)))
+			}
+		}
+		for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+			v := q.Get(f.param)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, f.param+" must be RFC3339", http.StatusBadRequest)
+				return
+			}
+			args = append(args, dbTime(cfg, t))
+			conds = append(conds, fmt.Sprintf("created_at %s $%d", f.op, len(args)))
+		}
+		query := `select ` + auditColumns + ` from audit_log`
+		if len(conds) > 0 {
+			query += " where " + strings.Join(conds, " and ")
+		}
+		query += fmt.Sprintf(" order by created_at desc, id desc limit %d", limit+1)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			internalError(w, "audit.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListAuditResponse{Entries: []AuditEntry{}}
+		var lastCreatedAt string
+		for rows.Next() {
+			if len(resp.Entries) == limit {
+				resp.NextCursor = encodeListCursor(lastCreatedAt, resp.Entries[limit-1].ID)
+				break
+			}
+			e, createdAt, err := scanAuditEntry(rows)
+			if err != nil {
+				internalError(w, "audit.list.scan", err)
+				return
+			}
+			lastCreatedAt = cursorTime(cfg, createdAt)
+			resp.Entries = append(resp.Entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "audit.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+const auditColumns = `id, actor, action, entity, license_key, details, request_id, remote_ip, changes, created_at`
+
+// scanAuditEntry scans auditColumns, also returning created_at as a time.
+func scanAuditEntry(row rowScanner) (AuditEntry, time.Time, error) {
+	var e AuditEntry
+	var details, changes []byte
+	var createdAt nullTime
+	if err := row.Scan(&e.ID, &e.Actor, &e.Action, &e.Entity, &e.LicenseKey, &details, &e.RequestID, &e.RemoteIP, &changes, &createdAt); err != nil {
+		return e, time.Time{}, err
+	}
+	if err := json.Unmarshal(details, &e.Details); err != nil || len(e.Details) == 0 {
+		e.Details = nil
+	}
+	if err := json.Unmarshal(changes, &e.Changes); err != nil || len(e.Changes) == 0 {
+		e.Changes = nil
+	}
+	e.CreatedAt = createdAt.Time.Format(time.RFC3339Nano)
+	return e, createdAt.Time, nil
+}
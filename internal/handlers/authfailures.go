@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+// AuthFailureStore keeps failed admin logins and lockouts in the
+// auth_failures table, so they survive restarts and hold on every replica.
+func AuthFailureStore(db *sql.DB, cfg *config.Config) middleware.FailureStore {
+	return &authFailureStore{db: db, cfg: cfg}
+}
+
+type authFailureStore struct {
+	db  *sql.DB
+	cfg *config.Config
+}
+
+func (s *authFailureStore) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	return dbStore(s.db, s.cfg).RecordAuthFailure(ctx, key, now, window)
+}
+
+func (s *authFailureStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `update auth_failures set locked_until=$1 where client=$2`, dbTime(s.cfg, until), key)
+	return err
+}
+
+func (s *authFailureStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var until nullTime
+	err := s.db.QueryRowContext(ctx, `select locked_until from auth_failures where client=$1`, key).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return until.Time, err
+}
+
+func (s *authFailureStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `delete from auth_failures where client=$1`, key)
+	return err
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/db/sqlite"
+)
+
+// Backup describes a database backup file.
+type Backup struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CreateBackup copies the SQLite database to a new file in
+// db.sqlite.backup_dir, named after the time, while the server keeps
+// running. Without a backup_dir, or on another driver, it answers 404.
+func CreateBackup(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dir := cfg.DB.SQLite.BackupDir
+		if dir == "" || !isSQLite(cfg) {
+			http.Error(w, "backups are not configured on this server", http.StatusNotFound)
+			return
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			internalError(w, "backup.dir", err)
+			return
+		}
+		now := time.Now().UTC()
+		path := filepath.Join(dir, "raalisence-"+now.Format("20060102T150405.000Z")+".db")
+		if err := sqlite.Backup(r.Context(), db, path); err != nil {
+			internalError(w, "backup.copy", err)
+			return
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			internalError(w, "backup.stat", err)
+			return
+		}
+		recordAudit(r, db, cfg, AuditBackupCreate, "", map[string]any{"path": path, "size_bytes": fi.Size()})
+		writeJSON(w, http.StatusOK, Backup{Path: path, SizeBytes: fi.Size(), CreatedAt: now.Format(time.RFC3339Nano)})
+	})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+const (
+	maxBatchJSONBody = 4 << 20 // 4MiB; room for ~1000 issue requests
+	maxBatchSize     = 1000
+)
+
+// IssueBatchRequest takes either an explicit list of licenses or a count
+// plus a template. In the template form "{n}" in customer, machine_id or
+// notes is replaced with the 1-based index of each license.
+type IssueBatchRequest struct {
+	Licenses []IssueRequest `json:"licenses,omitempty"`
+	Count    int            `json:"count,omitempty"`
+	Template *IssueRequest  `json:"template,omitempty"`
+}
+
+type IssueBatchResponse struct {
+	Licenses []LicenseFile `json:"licenses"`
+}
+
+// IssueBatch issues many licenses in a single transaction: either all rows
+// are stored and every signed file is returned, or nothing is stored.
+func IssueBatch(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req IssueBatchRequest
+		if !decodeJSONLimit(w, r, &req, maxBatchJSONBody) {
+			return
+		}
+		reqs, err := req.expand()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i := range reqs {
+			if err := reqs[i].normalize(cfg); err != nil {
+				http.Error(w, fmt.Sprintf("licenses[%d]: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+		if !issueInScope(w, r, reqs...) {
+			return
+		}
+
+		ctx := r.Context()
+		now := time.Now().UTC()
+		keys := make([]string, len(reqs))
+
+		var resp IssueBatchResponse
+		if !inTx(w, r, db, "issue_batch", func(tx *sql.Tx) error {
+			for i, ir := range reqs {
+				keys[i] = uuid.NewString()
+				actx := withAudit(r, AuditLicenseIssue, map[string]any{"customer": ir.Customer, "product_id": ir.ProductID, "batch": true})
+				if err := insertLicense(actx, tx, cfg, ir, keys[i]); err != nil {
+					return fmt.Errorf("insert: %w", err)
+				}
+				if err := claimMachine(r, tx, cfg, ir, keys[i]); err != nil {
+					var taken machineTakenError
+					if errors.As(err, &taken) {
+						return txAnswer{http.StatusConflict, fmt.Sprintf("licenses[%d]: %v", i, err)}
+					}
+					return fmt.Errorf("claim: %w", err)
+				}
+				if err := queueEvent(ctx, tx, cfg, EventLicenseIssued, ir.eventData(keys[i])); err != nil {
+					return fmt.Errorf("event: %w", err)
+				}
+			}
+
+			// sign before committing so a signing failure leaves no rows behind
+			resp = IssueBatchResponse{Licenses: make([]LicenseFile, 0, len(reqs))}
+			for i, ir := range reqs {
+				lf, err := signLicenseFile(cfg, ir, keys[i], now)
+				if err != nil {
+					return fmt.Errorf("sign: %w", err)
+				}
+				resp.Licenses = append(resp.Licenses, lf)
+			}
+			return nil
+		}) {
+			return
+		}
+		flushEvents(ctx, db, cfg)
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+func (b IssueBatchRequest) expand() ([]IssueRequest, error) {
+	switch {
+	case len(b.Licenses) > 0 && (b.Count > 0 || b.Template != nil):
+		return nil, fmt.Errorf("use either licenses or count+template, not both")
+	case len(b.Licenses) > 0:
+		if len(b.Licenses) > maxBatchSize {
+			return nil, fmt.Errorf("at most %d licenses per batch", maxBatchSize)
+		}
+		return b.Licenses, nil
+	case b.Template != nil:
+		if b.Count <= 0 || b.Count > maxBatchSize {
+			return nil, fmt.Errorf("count must be between 1 and %d", maxBatchSize)
+		}
+		out := make([]IssueRequest, b.Count)
+		for i := range out {
+			n := strconv.Itoa(i + 1)
+			ir := *b.Template
+			ir.Customer = strings.ReplaceAll(ir.Customer, "{n}", n)
+			ir.MachineID = strings.ReplaceAll(ir.MachineID, "{n}", n)
+			ir.Notes = strings.ReplaceAll(ir.Notes, "{n}", n)
+			out[i] = ir
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("licenses or count+template required")
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// licenseCertChain returns the x5c chain for the key that signs req, if
+// one is configured.
+func licenseCertChain(cfg *config.Config, req IssueRequest) ([]string, error) {
+	chainPEM := cfg.SigningCertChain(req.Tenant, req.ProductID)
+	if chainPEM == "" {
+		return nil, nil
+	}
+	certs, err := crypto.ParseCertChain(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.EncodeCertChain(certs), nil
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// challengeTTL is how long a server-issued nonce can be used to validate.
+const challengeTTL = 2 * time.Minute
+
+// maxNonceLen bounds client-chosen nonces.
+const maxNonceLen = 128
+
+type ChallengeResponse struct {
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssueChallenge hands out a single-use nonce for the next validation of
+// a license on one machine. Licenses issued with require_nonce only validate
+// with one, so every valid response the client accepts is fresh.
+func IssueChallenge(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.MachineID == "" {
+			http.Error(w, "license_key and machine_id required", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		var n int
+		if err := db.QueryRowContext(ctx, `select count(*) from licenses where license_key=$1`, req.LicenseKey).Scan(&n); err != nil {
+			internalError(w, "challenge.lookup", err)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			internalError(w, "challenge.rand", err)
+			return
+		}
+		now := time.Now().UTC()
+		resp := ChallengeResponse{Nonce: base64.RawURLEncoding.EncodeToString(b), ExpiresAt: now.Add(challengeTTL)}
+		// expired nonces are only ever deleted here
+		if _, err := db.ExecContext(ctx, `delete from validation_nonces where expires_at <= $1`, dbTime(cfg, now)); err != nil {
+			internalError(w, "challenge.prune", err)
+			return
+		}
+		if _, err := db.ExecContext(ctx, `insert into validation_nonces (nonce, license_key, machine_id, expires_at, created_at) values ($1,$2,$3,$4,$5)`,
+			resp.Nonce, req.LicenseKey, req.MachineID, dbTime(cfg, resp.ExpiresAt), dbTime(cfg, now)); err != nil {
+			internalError(w, "challenge.insert", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// useNonce consumes req.Nonce if it was issued for req's license and
+// machine and hasn't expired, reporting whether it was.
+func useNonce(ctx context.Context, db *sql.DB, cfg *config.Config, req ValidateRequest, now time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, `delete from validation_nonces where nonce=$1 and license_key=$2 and machine_id=$3 and expires_at > $4`,
+		req.Nonce, req.LicenseKey, req.MachineID, dbTime(cfg, now))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// signValidation stamps resp with the request's nonce and signs it with the
+// license's key. The signature covers license_key and machine_id from the
+// request and nonce, valid, revoked, suspended, expires_at, validated_at
+// and, when set, reason, grace, grace_days_remaining and quota_exceeded.
+func signValidation(cfg *config.Config, st licenseState, req ValidateRequest, resp *ValidateResponse, now time.Time) error {
+	priv, pubPEM, err := cfg.SigningKey(st.Tenant, st.ProductID)
+	if err != nil {
+		return err
+	}
+	validatedAt := now.UTC()
+	resp.Nonce, resp.ValidatedAt = req.Nonce, &validatedAt
+	if resp.Signature, err = crypto.SignJSON(priv, resp.payload(req)); err != nil {
+		return err
+	}
+	resp.KeyID = keyFingerprint(pubPEM)
+	return nil
+}
+
+// payload returns the fields covered by the signature.
+func (resp ValidateResponse) payload(req ValidateRequest) map[string]any {
+	p := map[string]any{
+		"license_key": req.LicenseKey,
+		"machine_id":  req.MachineID,
+		"nonce":       resp.Nonce,
+		"valid":       resp.Valid,
+		"revoked":     resp.Revoked,
+		"suspended":   resp.Suspended,
+		"expires_at":  resp.ExpiresAt.UTC().Format(time.RFC3339Nano),
+	}
+	if resp.ValidatedAt != nil {
+		p["validated_at"] = resp.ValidatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if resp.Reason != "" {
+		p["reason"] = resp.Reason
+	}
+	if resp.Grace {
+		p["grace"] = true
+		p["grace_days_remaining"] = resp.GraceDaysRemaining
+	}
+	if len(resp.QuotaExceeded) > 0 {
+		p["quota_exceeded"] = resp.QuotaExceeded
+	}
+	return p
+}
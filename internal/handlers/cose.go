@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+	"github.com/rpattn/raalisence/internal/entitlements"
+)
+
+// formatCOSE selects a COSE_Sign1 license instead of a JSON license file.
+const formatCOSE = "cose"
+
+// coseLicense is the payload of a COSE license: the fields a license file
+// signs, CBOR-encoded, with times as Unix seconds.
+type coseLicense struct {
+	ProductID         string           `cbor:"product_id,omitempty"`
+	Tenant            string           `cbor:"tenant,omitempty"`
+	Customer          string           `cbor:"customer"`
+	MachineID         string           `cbor:"machine_id"`
+	LicenseKey        string           `cbor:"license_key"`
+	ExpiresAt         int64            `cbor:"expires_at"`
+	IssuedAt          int64            `cbor:"issued_at"`
+	Features          map[string]any   `cbor:"features,omitempty"`
+	EncryptedFeatures *crypto.Sealed   `cbor:"encrypted_features,omitempty"`
+	MaxActivations    int              `cbor:"max_activations"`
+	FloatingSeats     int              `cbor:"floating_seats,omitempty"`
+	GraceDays         *int             `cbor:"grace_days,omitempty"`
+	Entitlements      entitlements.Set `cbor:"entitlements,omitempty"`
+}
+
+// CoseLicense is the issue response for ?format=cose: the license as a
+// tagged COSE_Sign1 message for clients too small for JSON and PEM. The
+// message carries the key ID in its kid header; the public key is not
+// embedded.
+type CoseLicense struct {
+	LicenseKey string `json:"license_key"`
+	Format     string `json:"format"`  // "cose"
+	License    string `json:"license"` // standard base64
+	KeyID      string `json:"key_id"`
+}
+
+// signCOSELicense builds and signs the COSE license for req with the key
+// selected for its product.
+func signCOSELicense(cfg *config.Config, req IssueRequest, licenseKey string, issuedAt time.Time) (CoseLicense, error) {
+	priv, pubPEM, err := cfg.SigningKey(req.Tenant, req.ProductID)
+	if err != nil {
+		return CoseLicense{}, err
+	}
+	lic := coseLicense{
+		ProductID:      req.ProductID,
+		Tenant:         req.Tenant,
+		Customer:       req.Customer,
+		MachineID:      req.MachineID,
+		LicenseKey:     licenseKey,
+		ExpiresAt:      req.ExpiresAt.Unix(),
+		IssuedAt:       issuedAt.Unix(),
+		Features:       req.Features,
+		MaxActivations: req.MaxActivations,
+		FloatingSeats:  req.FloatingSeats,
+		GraceDays:      req.GraceDays,
+		Entitlements:   req.Entitlements,
+	}
+	if lic.EncryptedFeatures, err = sealFeatures(req); err != nil {
+		return CoseLicense{}, err
+	}
+	if lic.EncryptedFeatures != nil {
+		lic.Features = nil
+	}
+	payload, err := crypto.MarshalCBOR(lic)
+	if err != nil {
+		return CoseLicense{}, err
+	}
+	kid := keyFingerprint(pubPEM)
+	msg, err := crypto.SignCOSE(priv, kid, payload)
+	if err != nil {
+		return CoseLicense{}, err
+	}
+	return CoseLicense{
+		LicenseKey: licenseKey,
+		Format:     formatCOSE,
+		License:    base64.StdEncoding.EncodeToString(msg),
+		KeyID:      kid,
+	}, nil
+}
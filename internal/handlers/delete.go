@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// DeleteLicense permanently removes the {license_key} license and every row
+// referencing it. A license that would still validate (not revoked, archived
+// or past its grace period) is refused with 409 unless ?force=true.
+func DeleteLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := r.PathValue("license_key")
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		if !inTx(w, r, db, "license.delete", func(tx *sql.Tx) error {
+			st, err := loadLicenseState(ctx, tx, cfg, key, true)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return fmt.Errorf("lookup: %w", err)
+			}
+			active := !st.Revoked && !st.Archived && !time.Now().After(st.graceEnd(cfg))
+			if active && r.URL.Query().Get("force") != "true" {
+				return txAnswer{http.StatusConflict, "license is still active; revoke or archive it first, or pass force=true"}
+			}
+
+			actx := withAudit(r, AuditLicenseDelete, map[string]any{"customer": st.Customer, "forced": active})
+			return dbStore(tx, cfg).Delete(actx, key)
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// formatDetached selects a license body with a detached signature.
+const formatDetached = "detached"
+
+// DetachedLicense is the issue response for ?format=detached: the license
+// as two files for tooling that checks detached signatures (e.g. openssl
+// dgst -verify). Both are base64 so the exact bytes survive.
+type DetachedLicense struct {
+	LicenseKey    string   `json:"license_key"`
+	Format        string   `json:"format"`         // "detached"
+	SchemaVersion int      `json:"schema_version"` // as in LicenseFile: the layout of license.json
+	License       string   `json:"license"`        // license.json: the signed fields as canonical JSON
+	Signature     string   `json:"signature"`      // license.sig: the raw signature over license.json
+	Alg           string   `json:"alg"`
+	PublicKey     string   `json:"public_key_pem"`
+	KeyID         string   `json:"key_id"`
+	CertChain     []string `json:"cert_chain,omitempty"` // as in LicenseFile
+	Timestamp     string   `json:"timestamp,omitempty"`  // as in LicenseFile, over license.sig
+}
+
+// signDetachedLicense signs the license file for req and splits it into
+// the signed bytes and the signature.
+func signDetachedLicense(cfg *config.Config, req IssueRequest, licenseKey string, issuedAt time.Time) (DetachedLicense, error) {
+	lf, err := signLicenseFile(cfg, req, licenseKey, issuedAt)
+	if err != nil {
+		return DetachedLicense{}, err
+	}
+	// SignJSON signed exactly these bytes
+	body, err := crypto.Canonicalize(lf.payload())
+	if err != nil {
+		return DetachedLicense{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(lf.Signature)
+	if err != nil {
+		return DetachedLicense{}, err
+	}
+	return DetachedLicense{
+		LicenseKey:    licenseKey,
+		Format:        formatDetached,
+		SchemaVersion: lf.SchemaVersion,
+		License:       base64.StdEncoding.EncodeToString(body),
+		Signature:     base64.StdEncoding.EncodeToString(sig),
+		Alg:           lf.Alg,
+		PublicKey:     lf.PublicKey,
+		KeyID:         lf.KeyID,
+		CertChain:     lf.CertChain,
+		Timestamp:     lf.Timestamp,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// SigningInfo describes the key that signs (and reissues) a license file.
+type SigningInfo struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"` // sha256 of the DER public key, hex
+	PublicKey string `json:"public_key"`
+}
+
+type LicenseDetail struct {
+	LicenseSummary
+	IssuedAt  string      `json:"issued_at"`
+	CreatedAt string      `json:"created_at"`
+	UpdatedAt string      `json:"updated_at"`
+	Signing   SigningInfo `json:"signing"`
+}
+
+// GetLicense returns a single license by the {license_key} path value.
+// Archived licenses are returned too; archived_at says so.
+func GetLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := r.PathValue("license_key")
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+
+		var createdAt, updatedAt nullTime
+		row := db.QueryRowContext(r.Context(), `select `+licenseSummaryColumns+`, created_at, updated_at from licenses where license_key=$1`, key)
+		sum, err := scanLicenseSummary(row, cfg, &createdAt, &updatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, "license.get", err)
+			return
+		}
+
+		priv, pubPEM, err := cfg.SigningKey(sum.Tenant, sum.ProductID)
+		if err != nil {
+			internalError(w, "license.get.key", err)
+			return
+		}
+		alg, err := crypto.Algorithm(priv)
+		if err != nil {
+			internalError(w, "license.get.alg", err)
+			return
+		}
+		created := createdAt.Time.UTC().Format(time.RFC3339Nano)
+		writeJSON(w, http.StatusOK, LicenseDetail{
+			LicenseSummary: sum,
+			// issued_at is not stored separately; the original file was signed at creation
+			IssuedAt:  created,
+			CreatedAt: created,
+			UpdatedAt: updatedAt.Time.UTC().Format(time.RFC3339Nano),
+			Signing: SigningInfo{
+				Algorithm: alg,
+				KeyID:     keyFingerprint(pubPEM),
+				PublicKey: pubPEM,
+			},
+		})
+	})
+}
+
+func keyFingerprint(pubPEM string) string {
+	block, _ := pem.Decode([]byte(pubPEM))
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:])
+}
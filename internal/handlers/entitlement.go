@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+	"github.com/rpattn/raalisence/internal/entitlements"
+)
+
+// entitlementTTL is how long a client may rely on an assertion before
+// asking again. It never outlasts the license's grace period.
+const entitlementTTL = time.Hour
+
+type EntitlementRequest struct {
+	LicenseKey string `json:"license_key"`
+	MachineID  string `json:"machine_id"`
+	ProductID  string `json:"product_id,omitempty"`
+	Feature    string `json:"feature"`
+}
+
+func (req *EntitlementRequest) fromPath(r *http.Request) bool {
+	return setFromPath(r, "license_key", &req.LicenseKey)
+}
+
+// EntitlementAssertion says whether one feature is usable on one machine.
+// It is signed with the license's product key (key_id, as published in the
+// JWKS) and covers every field but signature and key_id.
+type EntitlementAssertion struct {
+	LicenseKey string    `json:"license_key"`
+	MachineID  string    `json:"machine_id"`
+	Feature    string    `json:"feature"`
+	Enabled    bool      `json:"enabled"`
+	Limit      *int64    `json:"limit,omitempty"`
+	Used       *int64    `json:"used,omitempty"` // quota usage this period
+	Tier       string    `json:"tier,omitempty"`
+	Reason     string    `json:"reason,omitempty"` // why enabled is false
+	IssuedAt   time.Time `json:"issued_at"`
+	ValidUntil time.Time `json:"valid_until"`
+	Signature  string    `json:"signature"`
+	KeyID      string    `json:"key_id"`
+}
+
+// payload returns the fields covered by the signature.
+func (a EntitlementAssertion) payload() map[string]any {
+	p := map[string]any{
+		"license_key": a.LicenseKey,
+		"machine_id":  a.MachineID,
+		"feature":     a.Feature,
+		"enabled":     a.Enabled,
+		"issued_at":   a.IssuedAt.UTC().Format(time.RFC3339Nano),
+		"valid_until": a.ValidUntil.UTC().Format(time.RFC3339Nano),
+	}
+	if a.Limit != nil {
+		p["limit"] = *a.Limit
+	}
+	if a.Used != nil {
+		p["used"] = *a.Used
+	}
+	if a.Tier != "" {
+		p["tier"] = a.Tier
+	}
+	if a.Reason != "" {
+		p["reason"] = a.Reason
+	}
+	return p
+}
+
+// CheckEntitlement answers "is feature X enabled, and with what limit or
+// tier" for a license and machine. The license must pass validation first;
+// otherwise the assertion is disabled with the validation reason. Typed
+// entitlements take precedence over free-form features of the same name.
+func CheckEntitlement(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, ok := bufferBody(w, r)
+		if !ok {
+			return
+		}
+		var req EntitlementRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.MachineID == "" || req.Feature == "" {
+			http.Error(w, "license_key, machine_id and feature required", http.StatusBadRequest)
+			return
+		}
+		if !requireClientCert(w, r, db, cfg, req.LicenseKey, req.MachineID) {
+			return
+		}
+		if !requireRequestSignature(w, r, db, req.LicenseKey, body) {
+			return
+		}
+
+		ctx := r.Context()
+		now := time.Now().UTC()
+		a := EntitlementAssertion{LicenseKey: req.LicenseKey, MachineID: req.MachineID, Feature: req.Feature, IssuedAt: now, ValidUntil: now.Add(entitlementTTL)}
+		st, vr, err := checkLicense(ctx, db, cfg, ValidateRequest{LicenseKey: req.LicenseKey, MachineID: req.MachineID, ProductID: req.ProductID}, now)
+		if err != nil {
+			internalError(w, "entitlement", err)
+			return
+		}
+		if vr.Valid {
+			if err := evalFeature(ctx, db, cfg, st, &a, now); err != nil {
+				internalError(w, "entitlement.usage", err)
+				return
+			}
+			if end := st.graceEnd(cfg); end.Before(a.ValidUntil) {
+				a.ValidUntil = end.UTC()
+			}
+		} else {
+			a.Reason = vr.Reason
+		}
+
+		priv, pubPEM, err := cfg.SigningKey(st.Tenant, st.ProductID)
+		if err != nil {
+			internalError(w, "entitlement.key", err)
+			return
+		}
+		if a.Signature, err = crypto.SignJSON(priv, a.payload()); err != nil {
+			internalError(w, "entitlement.sign", err)
+			return
+		}
+		a.KeyID = keyFingerprint(pubPEM)
+		writeJSON(w, http.StatusOK, a)
+	})
+}
+
+// evalFeature fills in a from the license's grant for a.Feature. Quotas
+// report this period's usage and, with usage enforcement "fail", are
+// disabled once exceeded. Free-form features map booleans to enabled,
+// numbers to a limit and strings to a tier.
+func evalFeature(ctx context.Context, db *sql.DB, cfg *config.Config, st licenseState, a *EntitlementAssertion, now time.Time) error {
+	if e, ok := st.Entitlements[a.Feature]; ok {
+		switch e.Type {
+		case entitlements.TypeFlag:
+			a.Enabled = e.Enabled != nil && *e.Enabled
+		case entitlements.TypeQuota:
+			a.Limit = e.Limit
+			var used int64
+			err := db.QueryRowContext(ctx, `select amount from usage_records where license_key=$1 and period=$2 and metric=$3`,
+				a.LicenseKey, cfg.UsagePeriod(now), a.Feature).Scan(&used)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			a.Used = &used
+			a.Enabled = e.Limit != nil && *e.Limit > 0
+			if a.Enabled && used > *e.Limit && cfg.Usage.Enforcement == "fail" {
+				a.Enabled, a.Reason = false, "quota exceeded"
+			}
+		case entitlements.TypeTier:
+			a.Tier = e.Tier
+			a.Enabled = e.Tier != ""
+		}
+		if !a.Enabled && a.Reason == "" {
+			a.Reason = "disabled"
+		}
+		return nil
+	}
+
+	v, ok := st.Features[a.Feature]
+	switch t := v.(type) {
+	case nil:
+		a.Reason = "not granted"
+		if ok {
+			a.Reason = "disabled"
+		}
+		return nil
+	case bool:
+		a.Enabled = t
+	case float64:
+		n := int64(t)
+		a.Limit = &n
+		a.Enabled = n > 0
+	case string:
+		a.Tier = t
+		a.Enabled = t != ""
+	default:
+		a.Enabled = true
+	}
+	if !a.Enabled {
+		a.Reason = "disabled"
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/errreport"
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+// ReportErrors sends each internalError of h to rep with the request ID,
+// route (pattern) and stack. With a nil rep, h is returned as is.
+func ReportErrors(rep *errreport.Reporter, pattern string, h http.Handler) http.Handler {
+	if rep == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&reportWriter{ResponseWriter: w, rep: rep, route: pattern, r: r}, r)
+	})
+}
+
+// reportWriter hands internalError the request it is answering.
+type reportWriter struct {
+	http.ResponseWriter
+	rep   *errreport.Reporter
+	route string
+	r     *http.Request
+}
+
+func (w *reportWriter) reportError(op string, err error) {
+	w.rep.Report(errreport.Event{
+		Op: op, Error: err.Error(),
+		RequestID: middleware.GetRequestID(w.r), Route: w.route, Method: w.r.Method, Path: w.r.URL.Path,
+		Stack: errreport.Callers(2), // from internalError's caller
+	})
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *reportWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
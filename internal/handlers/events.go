@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/events"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// EventLicenseValidationFailed is streamed to admins but never sent to
+// webhooks; clients can fail validation far too often for that.
+const EventLicenseValidationFailed = "license.validation_failed"
+
+const (
+	eventStreamBuffer    = 64
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+// liveEvents feeds the admin event stream.
+var liveEvents = events.NewBroker()
+
+// emitEvent publishes an event that changes nothing, such as a failed
+// validation, to live streams and queues it for subscribed webhooks. It is
+// best effort, so a failure to queue is logged rather than returned; events
+// announcing a change go through queueEvent instead.
+func emitEvent(ctx context.Context, db *sql.DB, cfg *config.Config, event string, data any) {
+	liveEvents.Publish(event, data)
+	if !webhookEvents[event] {
+		return
+	}
+	if err := enqueueWebhook(ctx, db, cfg, event, data, time.Now().UTC()); err != nil {
+		logging.Errorf("webhook enqueue error event=%s err=%v", event, err)
+	}
+}
+
+// queueEvent adds event to the events outbox through q, the transaction of
+// the change it announces, so the event is committed exactly when the
+// change is. DispatchEvents delivers it; callers run flushEvents after the
+// commit so it goes out straight away.
+func queueEvent(ctx context.Context, q store.Querier, cfg *config.Config, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `insert into events (id, event, data, created_at) values ($1,$2,$3,$4)`,
+		uuid.NewString(), event, string(b), dbTime(cfg, time.Now()))
+	return err
+}
+
+// flushEvents runs DispatchEvents after a commit. The events are already
+// safe in the outbox, so a failure is logged and left to the next run of
+// the background dispatcher.
+func flushEvents(ctx context.Context, db *sql.DB, cfg *config.Config) {
+	if _, err := DispatchEvents(ctx, db, cfg); err != nil {
+		logging.Errorf("event dispatch error: %v", err)
+	}
+}
+
+// DispatchEvents hands undispatched outbox events, oldest first, to live
+// streams and subscribed webhooks. Each event is marked dispatched in the
+// transaction that queues its webhook deliveries, so concurrent dispatchers
+// (a request's flushEvents and the background loop) never deliver it twice.
+// It returns the number of events dispatched.
+func DispatchEvents(ctx context.Context, db *sql.DB, cfg *config.Config) (int, error) {
+	rows, err := db.QueryContext(ctx, `select id, event, data, created_at from events
+		where dispatched_at is null order by created_at, id limit `+fmt.Sprint(webhookBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	type queued struct {
+		id, event string
+		data      []byte
+		at        nullTime
+	}
+	var batch []queued
+	for rows.Next() {
+		var e queued
+		if err := rows.Scan(&e.id, &e.event, &e.data, &e.at); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, e := range batch {
+		ok, err := dispatchEvent(ctx, db, cfg, e.id, e.event, json.RawMessage(e.data), e.at.Time)
+		if err != nil {
+			return n, fmt.Errorf("event %s: %w", e.id, err)
+		}
+		if ok {
+			liveEvents.Publish(e.event, json.RawMessage(e.data))
+			n++
+		}
+	}
+	return n, nil
+}
+
+// dispatchEvent claims one outbox event and queues its webhook deliveries,
+// reporting false when another dispatcher claimed it first.
+func dispatchEvent(ctx context.Context, db *sql.DB, cfg *config.Config, id, event string, data json.RawMessage, at time.Time) (bool, error) {
+	var claimed bool
+	err := store.RetryTx(ctx, db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `update events set dispatched_at=$1 where id=$2 and dispatched_at is null`, dbTime(cfg, time.Now()), id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			claimed = false
+			return nil
+		}
+		claimed = true
+		if webhookEvents[event] {
+			return enqueueWebhook(ctx, tx, cfg, event, data, at)
+		}
+		return nil
+	})
+	return claimed, err
+}
+
+// EventStream streams license events as server-sent events. ?types= takes a
+// comma-separated list of event types to receive; by default all are sent.
+// Events raised while nobody is connected are not replayed.
+func EventStream() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var types map[string]bool
+		if raw := r.URL.Query().Get("types"); raw != "" {
+			types = map[string]bool{}
+			for _, t := range strings.Split(raw, ",") {
+				types[strings.TrimSpace(t)] = true
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		// the stream outlives the server's write timeout
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		sub, cancel := liveEvents.Subscribe(eventStreamBuffer)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+		if err := rc.Flush(); err != nil {
+			internalError(w, "events.flush", err)
+			return
+		}
+		fmt.Fprint(w, ": connected\n\n")
+		_ = rc.Flush()
+
+		keepAlive := time.NewTicker(eventStreamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case ev, ok := <-sub:
+				if !ok {
+					return
+				}
+				if types != nil && !types[ev.Type] {
+					continue
+				}
+				b, err := json.Marshal(ev)
+				if err != nil {
+					logging.Errorf("event stream marshal error event=%s err=%v", ev.Type, err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, b)
+			}
+			if err := rc.Flush(); err != nil {
+				return // client went away
+			}
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// exportFlushEvery bounds how many rows sit in the response buffer.
+const exportFlushEvery = 500
+
+// exportColumns are the fixed export columns; one "features.<key>" column
+// per feature key follows them, nested feature objects joined with dots.
+var exportColumns = []string{
+	"id", "license_key", "product_id", "customer", "machine_id", "expires_at",
+	"revoked", "suspended", "max_activations", "floating_seats", "grace_days",
+	"last_seen_at", "archived_at", "notes",
+}
+
+// ndjsonContentType is newline-delimited JSON: one value per line.
+const ndjsonContentType = "application/x-ndjson"
+
+// ExportLicenses streams every license, archived ones included, as CSV
+// (?format=csv, the default), a JSON array (?format=json) or one JSON record
+// per line (?format=ndjson, also chosen by Accept: application/x-ndjson or
+// ?stream=1). Rows are written as they are read, so memory stays flat
+// however large the table.
+func ExportLicenses(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		switch {
+		case format != "":
+		case wantsNDJSON(r):
+			format = "ndjson"
+		default:
+			format = "csv"
+		}
+		if format != "csv" && format != "json" && format != "ndjson" {
+			http.Error(w, "format must be csv, json or ndjson", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		ls := dbStore(db, cfg)
+		// the CSV header needs every feature key before the first row
+		featureKeys, err := exportFeatureKeys(ctx, ls)
+		if err != nil {
+			internalError(w, "export.features", err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// large exports outlive the server's write timeout
+		_ = rc.SetWriteDeadline(time.Time{})
+		name := "licenses-" + time.Now().UTC().Format("20060102")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+
+		var out exportWriter
+		switch format {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			out = newCSVExport(w, featureKeys)
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			out = &jsonExport{w: w}
+		default:
+			w.Header().Set("Content-Type", ndjsonContentType)
+			out = &ndjsonExport{enc: json.NewEncoder(w)}
+		}
+
+		// headers are sent with the first write; failures after that can
+		// only truncate the body, so they are logged
+		n, gone := 0, false
+		err = ls.Each(ctx, func(l store.License) error {
+			if err := out.write(exportRecord(licenseSummary(l))); err != nil {
+				gone = true // client went away
+				return err
+			}
+			if n++; n%exportFlushEvery == 0 {
+				if err := out.flush(); err != nil {
+					gone = true
+					return err
+				}
+				_ = rc.Flush()
+			}
+			return nil
+		})
+		switch {
+		case gone:
+			return
+		case err != nil && n == 0:
+			internalError(w, "export.query", err)
+			return
+		case err != nil:
+			logging.Errorf("handler error op=export.rows err=%v", err)
+			return
+		}
+		if err := out.close(); err != nil {
+			return
+		}
+		_ = rc.Flush()
+	})
+}
+
+// exportFeatureKeys returns the sorted, flattened feature keys across all
+// licenses.
+func exportFeatureKeys(ctx context.Context, ls store.LicenseStore) ([]string, error) {
+	seen := map[string]bool{}
+	err := ls.Each(ctx, func(l store.License) error {
+		flat := map[string]any{}
+		flattenFeatures("features", l.Features, flat)
+		for k := range flat {
+			seen[k] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// flattenFeatures copies v into out, joining nested object keys with dots
+// under prefix. Arrays are kept whole.
+func flattenFeatures(prefix string, v map[string]any, out map[string]any) {
+	for k, val := range v {
+		if nested, ok := val.(map[string]any); ok {
+			flattenFeatures(prefix+"."+k, nested, out)
+			continue
+		}
+		out[prefix+"."+k] = val
+	}
+}
+
+// exportRecord flattens a license into export column -> value.
+func exportRecord(sum LicenseSummary) map[string]any {
+	rec := map[string]any{
+		"id":              sum.ID,
+		"license_key":     sum.LicenseKey,
+		"product_id":      sum.ProductID,
+		"customer":        sum.Customer,
+		"machine_id":      sum.MachineID,
+		"expires_at":      sum.ExpiresAt,
+		"revoked":         sum.Revoked,
+		"suspended":       sum.Suspended,
+		"max_activations": sum.MaxActivations,
+		"floating_seats":  sum.FloatingSeats,
+		"grace_days":      sum.GraceDays,
+		"last_seen_at":    sum.LastSeenAt,
+		"archived_at":     sum.ArchivedAt,
+		"notes":           sum.Notes,
+	}
+	flattenFeatures("features", sum.Features, rec)
+	return rec
+}
+
+type exportWriter interface {
+	write(rec map[string]any) error
+	flush() error
+	close() error
+}
+
+type csvExport struct {
+	cw      *csv.Writer
+	columns []string
+	row     []string
+}
+
+func newCSVExport(w io.Writer, featureKeys []string) *csvExport {
+	columns := append(append([]string{}, exportColumns...), featureKeys...)
+	e := &csvExport{cw: csv.NewWriter(w), columns: columns, row: make([]string, len(columns))}
+	_ = e.cw.Write(columns)
+	return e
+}
+
+func (e *csvExport) write(rec map[string]any) error {
+	for i, col := range e.columns {
+		e.row[i] = csvCell(rec[col])
+	}
+	return e.cw.Write(e.row)
+}
+
+func (e *csvExport) flush() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+func (e *csvExport) close() error { return e.flush() }
+
+// csvCell renders a value for CSV. Text that a spreadsheet would treat as a
+// formula is prefixed with a quote so opening an export can't run it.
+func csvCell(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case *int:
+		if t == nil {
+			return ""
+		}
+		return strconv.Itoa(*t)
+	case *string:
+		if t == nil {
+			return ""
+		}
+		return csvCell(*t)
+	case string:
+		if t != "" && strings.ContainsRune("=+-@\t\r", rune(t[0])) {
+			return "'" + t
+		}
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case int:
+		return strconv.Itoa(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
+
+// jsonExport writes a JSON array one record at a time.
+type jsonExport struct {
+	w    io.Writer
+	rows int
+}
+
+func (e *jsonExport) write(rec map[string]any) error {
+	sep := ",\n"
+	if e.rows == 0 {
+		sep = "[\n"
+	}
+	e.rows++
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonExport) flush() error { return nil }
+
+func (e *jsonExport) close() error {
+	end := "\n]\n"
+	if e.rows == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// ndjsonExport writes one JSON record per line.
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExport) write(rec map[string]any) error { return e.enc.Encode(rec) }
+func (e *ndjsonExport) flush() error                   { return nil }
+func (e *ndjsonExport) close() error                   { return nil }
+
+// wantsNDJSON reports whether the client asked for a streamed list, by
+// Accept: application/x-ndjson or ?stream=1.
+func wantsNDJSON(r *http.Request) bool {
+	if v := r.URL.Query().Get("stream"); v != "" {
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mt), ndjsonContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamNDJSON writes each row of rows, as returned by scan, on its own
+// line, flushing every exportFlushEvery rows. As with exports, errors after
+// the first write can only truncate the body, so they are logged under op.
+func streamNDJSON(w http.ResponseWriter, rows *sql.Rows, op string, scan func(rowScanner) (any, error)) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	n := 0
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			logging.Errorf("handler error op=%s.scan err=%v", op, err)
+			return
+		}
+		if err := enc.Encode(v); err != nil {
+			return // client went away
+		}
+		if n++; n%exportFlushEvery == 0 {
+			_ = rc.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logging.Errorf("handler error op=%s.rows err=%v", op, err)
+		return
+	}
+	_ = rc.Flush()
+}
@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/graphql"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// Customer groups the licenses issued under one customer name.
+type Customer struct {
+	Name         string `json:"name"`
+	LicenseCount int    `json:"license_count"`
+}
+
+// GraphQL serves the read-only admin query API. Field names match the JSON
+// of the REST responses; every list takes limit (default 100, max 1000).
+func GraphQL(db *sql.DB, cfg *config.Config) http.Handler {
+	schema := graphQLSchema(&gqlResolver{db: db, cfg: cfg})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req graphql.Request
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			http.Error(w, "query required", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, schema.Execute(r.Context(), req))
+	})
+}
+
+func graphQLSchema(g *gqlResolver) *graphql.Schema {
+	license := &graphql.Object{Name: "License", Fields: scalarFields(
+		"id", "license_key", "product_id", "customer", "machine_id", "expires_at", "revoked", "suspended",
+		"max_activations", "floating_seats", "grace_days", "last_seen_at", "archived_at",
+		"features", "entitlements", "metadata", "notes", "tenant", "key_id")}
+	machine := &graphql.Object{Name: "Machine", Fields: scalarFields(
+		"machine_id", "hostname", "os", "fingerprint", "first_seen_at", "last_seen_at", "last_license_key")}
+	activation := &graphql.Object{Name: "Activation", Fields: scalarFields(
+		"id", "license_key", "machine_id", "activated_at", "hostname", "os", "last_seen_at")}
+	transfer := &graphql.Object{Name: "Transfer", Fields: scalarFields(
+		"id", "license_key", "from_machine_id", "to_machine_id", "reason", "transferred_at")}
+	audit := &graphql.Object{Name: "AuditEvent", Fields: scalarFields(
+		"id", "actor", "action", "license_key", "details", "request_id", "remote_ip", "changes", "created_at")}
+	customer := &graphql.Object{Name: "Customer", Fields: scalarFields("name", "license_count")}
+
+	r := gqlResolve
+	license.Fields["activations"] = &graphql.Field{Type: activation, Args: []string{"limit"}, Resolve: r("licenseActivations", g.licenseActivations)}
+	license.Fields["transfers"] = &graphql.Field{Type: transfer, Args: []string{"limit"}, Resolve: r("licenseTransfers", g.licenseTransfers)}
+	license.Fields["audit_events"] = &graphql.Field{Type: audit, Args: []string{"limit"}, Resolve: r("licenseAudit", g.licenseAudit)}
+	machine.Fields["licenses"] = &graphql.Field{Type: license, Args: []string{"include_archived", "limit"}, Resolve: r("machineLicenses", g.machineLicenses)}
+	activation.Fields["machine"] = &graphql.Field{Type: machine, Resolve: r("activationMachine", g.activationMachine)}
+	audit.Fields["license"] = &graphql.Field{Type: license, Resolve: r("auditLicense", g.auditLicense)}
+	customer.Fields["licenses"] = &graphql.Field{Type: license, Args: []string{"include_archived", "limit"}, Resolve: r("customerLicenses", g.customerLicenses)}
+
+	return &graphql.Schema{Query: &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"license":      {Type: license, Args: []string{"license_key"}, Resolve: r("license", g.license)},
+		"licenses":     {Type: license, Args: []string{"customer", "product_id", "machine_id", "revoked", "include_archived", "limit"}, Resolve: r("licenses", g.licenses)},
+		"customer":     {Type: customer, Args: []string{"name"}, Resolve: r("customer", g.customer)},
+		"customers":    {Type: customer, Args: []string{"search", "limit"}, Resolve: r("customers", g.customers)},
+		"machine":      {Type: machine, Args: []string{"machine_id"}, Resolve: r("machine", g.machine)},
+		"machines":     {Type: machine, Args: []string{"license_key", "hostname", "limit"}, Resolve: r("machines", g.machines)},
+		"audit_events": {Type: audit, Args: []string{"actor", "action", "license_key", "since", "until", "limit"}, Resolve: r("auditEvents", g.auditEvents)},
+	}}}
+}
+
+// gqlResolve logs a resolver's database errors and hides them from the
+// client, as internalError does; argument errors pass through.
+func gqlResolve(op string, fn func(context.Context, any, graphql.Args) (any, error)) func(context.Context, any, graphql.Args) (any, error) {
+	return func(ctx context.Context, src any, args graphql.Args) (any, error) {
+		v, err := fn(ctx, src, args)
+		var argErr *graphql.ArgumentError
+		if err != nil && !errors.As(err, &argErr) {
+			logging.Errorf("handler error op=graphql.%s err=%v", op, err)
+			return nil, errors.New("internal server error")
+		}
+		return v, err
+	}
+}
+
+func scalarFields(names ...string) map[string]*graphql.Field {
+	m := make(map[string]*graphql.Field, len(names))
+	for _, n := range names {
+		m[n] = &graphql.Field{}
+	}
+	return m
+}
+
+type gqlResolver struct {
+	db  *sql.DB
+	cfg *config.Config
+}
+
+// filter accumulates where clauses with numbered placeholders.
+type filter struct {
+	conds []string
+	args  []any
+}
+
+// add appends cond, with each %d replaced by the placeholder number of v.
+func (f *filter) add(cond string, v any) {
+	f.args = append(f.args, v)
+	f.conds = append(f.conds, strings.ReplaceAll(cond, "%d", fmt.Sprint(len(f.args))))
+}
+
+func (f *filter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(f.conds, " and ")
+}
+
+// like returns the case-insensitive substring operator for the driver.
+func (g *gqlResolver) like() string { return likeOp(g.cfg) }
+
+func gqlLimit(args graphql.Args) (int, error) {
+	n, err := args.Int("limit", defaultListLimit)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > maxListLimit {
+		return 0, &graphql.ArgumentError{Msg: fmt.Sprintf("limit must be between 1 and %d", maxListLimit)}
+	}
+	return n, nil
+}
+
+// queryLicenses returns the licenses matching f, newest first, up to the
+// limit argument. Archived licenses are left out unless include_archived
+// is true.
+func (g *gqlResolver) queryLicenses(ctx context.Context, f store.LicenseFilter, args graphql.Args) ([]LicenseSummary, error) {
+	limit, err := gqlLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := args.Bool("include_archived")
+	if err != nil {
+		return nil, err
+	}
+	f.Limit, f.IncludeArchived = limit, archived != nil && *archived
+	ls, err := dbStore(g.db, g.cfg).Find(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]LicenseSummary, len(ls))
+	for i, l := range ls {
+		out[i] = licenseSummary(l)
+	}
+	return out, nil
+}
+
+func (g *gqlResolver) license(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	key, err := args.String("license_key")
+	if err != nil {
+		return nil, err
+	}
+	l, err := dbStore(g.db, g.cfg).Get(ctx, key, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return licenseSummary(l), nil
+}
+
+func (g *gqlResolver) licenses(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	var f store.LicenseFilter
+	for _, arg := range []struct {
+		name string
+		to   *string
+	}{{"customer", &f.CustomerLike}, {"product_id", &f.ProductID}, {"machine_id", &f.MachineID}} {
+		v, err := args.String(arg.name)
+		if err != nil {
+			return nil, err
+		}
+		*arg.to = v
+	}
+	revoked, err := args.Bool("revoked")
+	if err != nil {
+		return nil, err
+	}
+	f.Revoked = revoked
+	return g.queryLicenses(ctx, f, args)
+}
+
+func (g *gqlResolver) customerLicenses(ctx context.Context, src any, args graphql.Args) (any, error) {
+	return g.queryLicenses(ctx, store.LicenseFilter{Customer: src.(Customer).Name}, args)
+}
+
+func (g *gqlResolver) machineLicenses(ctx context.Context, src any, args graphql.Args) (any, error) {
+	return g.queryLicenses(ctx, store.LicenseFilter{ActivatedOn: src.(Machine).MachineID}, args)
+}
+
+func (g *gqlResolver) auditLicense(ctx context.Context, src any, _ graphql.Args) (any, error) {
+	key := src.(AuditEntry).LicenseKey
+	if key == "" {
+		return nil, nil
+	}
+	return g.license(ctx, nil, graphql.Args{"license_key": key})
+}
+
+func (g *gqlResolver) customer(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	name, err := args.String("name")
+	if err != nil {
+		return nil, err
+	}
+	n, err := dbStore(g.db, g.cfg).CustomerLicenses(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return Customer{Name: name, LicenseCount: n}, nil
+}
+
+// customers lists customer names alphabetically; search matches a
+// substring, case-insensitively.
+func (g *gqlResolver) customers(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	limit, err := gqlLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	search, err := args.String("search")
+	if err != nil {
+		return nil, err
+	}
+	cs, err := dbStore(g.db, g.cfg).Customers(ctx, search, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Customer, len(cs))
+	for i, c := range cs {
+		out[i] = Customer(c)
+	}
+	return out, nil
+}
+
+func (g *gqlResolver) machine(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	id, err := args.String("machine_id")
+	if err != nil {
+		return nil, err
+	}
+	m, err := scanMachine(g.db.QueryRowContext(ctx, `select `+machineColumns+` from machines where machine_id=$1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (g *gqlResolver) activationMachine(ctx context.Context, src any, _ graphql.Args) (any, error) {
+	return g.machine(ctx, nil, graphql.Args{"machine_id": src.(Activation).MachineID})
+}
+
+// machines lists machines, most recently first seen first.
+func (g *gqlResolver) machines(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	limit, err := gqlLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	var f filter
+	key, err := args.String("license_key")
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		f.add("exists (select 1 from activations a where a.machine_id=machines.machine_id and a.license_key=$%d)", key)
+	}
+	host, err := args.String("hostname")
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		f.add(`hostname `+g.like()+` $%d escape '\'`, "%"+store.EscapeLike(host)+"%")
+	}
+	rows, err := g.db.QueryContext(ctx, `select `+machineColumns+` from machines`+f.where()+
+		fmt.Sprintf(" order by first_seen_at desc, machine_id desc limit %d", limit), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Machine{}
+	for rows.Next() {
+		m, err := scanMachine(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (g *gqlResolver) licenseActivations(ctx context.Context, src any, args graphql.Args) (any, error) {
+	limit, err := gqlLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := g.db.QueryContext(ctx, fmt.Sprintf(`select a.id, a.license_key, a.machine_id, a.activated_at, coalesce(m.hostname, ''), coalesce(m.os, ''), m.last_seen_at
+		from activations a left join machines m on m.machine_id=a.machine_id
+		where a.license_key=$1 order by a.activated_at, a.machine_id limit %d`, limit), src.(LicenseSummary).LicenseKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Activation{}
+	for rows.Next() {
+		var a Activation
+		var at, seen nullTime
+		if err := rows.Scan(&a.ID, &a.LicenseKey, &a.MachineID, &at, &a.Hostname, &a.OS, &seen); err != nil {
+			return nil, err
+		}
+		a.ActivatedAt, a.LastSeenAt = at.Time, seen.Ptr()
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (g *gqlResolver) licenseTransfers(ctx context.Context, src any, args graphql.Args) (any, error) {
+	limit, err := gqlLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := g.db.QueryContext(ctx, fmt.Sprintf(`select id, license_key, from_machine_id, to_machine_id, reason, transferred_at
+		from license_transfers where license_key=$1 order by transferred_at desc, id desc limit %d`, limit), src.(LicenseSummary).LicenseKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Transfer{}
+	for rows.Next() {
+		var t Transfer
+		var at nullTime
+		if err := rows.Scan(&t.ID, &t.LicenseKey, &t.FromMachineID, &t.ToMachineID, &t.Reason, &at); err != nil {
+			return nil, err
+		}
+		t.TransferredAt = at.Time
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (g *gqlResolver) licenseAudit(ctx context.Context, src any, args graphql.Args) (any, error) {
+	return g.auditEvents(ctx, nil, graphql.Args{"license_key": src.(LicenseSummary).LicenseKey, "limit": args["limit"]})
+}
+
+// auditEvents queries the audit log, newest first, with the filters of
+// ListAudit.
+func (g *gqlResolver) auditEvents(ctx context.Context, _ any, args graphql.Args) (any, error) {
+	limit, err := gqlLimit(args)
+	if err != nil {
+		return nil, err
+	}
+	var f filter
+	for _, col := range []string{"actor", "action", "license_key"} {
+		v, err := args.String(col)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" {
+			f.add(col+"=$%d", v)
+		}
+	}
+	for _, b := range []struct{ arg, op string }{{"since", ">="}, {"until", "<"}} {
+		v, err := args.String(b.arg)
+		if err != nil {
+			return nil, err
+		}
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, &graphql.ArgumentError{Msg: b.arg + " must be RFC3339"}
+		}
+		f.add("created_at "+b.op+" $%d", dbTime(g.cfg, t))
+	}
+	rows, err := g.db.QueryContext(ctx, `select `+auditColumns+` from audit_log`+f.where()+
+		fmt.Sprintf(" order by created_at desc, id desc limit %d", limit), f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []AuditEntry{}
+	for rows.Next() {
+		e, _, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -1,9 +1,29 @@
 package handlers
 
 import (
+	"context"
+	gocrypto "crypto"
+	"database/sql"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// readyCheckTimeout bounds each readiness check so a hung database fails the
+// probe instead of stalling it; the ping itself gets less, as a database
+// that takes longer to answer one is as good as down.
+const (
+	readyCheckTimeout = 2 * time.Second
+	dbPingTimeout     = 500 * time.Millisecond
 )
 
+// Health answers /livez (and the older /healthz): the process is up and
+// serving requests. It never touches the database, so a database outage
+// doesn't get the pod restarted.
 func Health() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -11,3 +31,113 @@ func Health() http.Handler {
 		_, _ = w.Write([]byte(`{"ok":true}`))
 	})
 }
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	OK         bool   `json:"ok"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Readiness is the /readyz response body.
+type Readiness struct {
+	OK       bool                   `json:"ok"`
+	Database DatabaseStatus         `json:"database"`
+	Checks   map[string]CheckResult `json:"checks"`
+}
+
+// DatabaseStatus describes the instance's database connection.
+type DatabaseStatus struct {
+	Driver    string  `json:"driver"`
+	LatencyMS float64 `json:"latency_ms"` // of the ping
+	// MigrationVersion is the latest migration recorded in
+	// schema_migrations; empty when the database can't be read.
+	MigrationVersion string `json:"migration_version,omitempty"`
+}
+
+// Ready answers /readyz: 200 when the database answers a ping within
+// dbPingTimeout, the signing keys parse and the schema is up to date, 503
+// otherwise, with the result of every check and the database's driver,
+// latency and migration version in the body.
+func Ready(db *sql.DB, cfg *config.Config) http.Handler {
+	checks := map[string]func(ctx context.Context) (string, error){
+		"database": func(ctx context.Context) (string, error) {
+			ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+			defer cancel()
+			return "", db.PingContext(ctx)
+		},
+		"signing_key": func(context.Context) (string, error) {
+			return checkSigningKeys(cfg)
+		},
+		"migrations": func(ctx context.Context) (string, error) {
+			pending, _, err := store.MigrationStatus(ctx, db, driverName(cfg))
+			if err != nil {
+				return "", err
+			}
+			if len(pending) > 0 {
+				return "", fmt.Errorf("%d pending: %s", len(pending), strings.Join(pending, ", "))
+			}
+			return "up to date", nil
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := Readiness{OK: true, Database: DatabaseStatus{Driver: dialect(cfg).Driver()}, Checks: make(map[string]CheckResult, len(checks))}
+		for name, check := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+			start := time.Now()
+			detail, err := check(ctx)
+			cancel()
+			took := time.Since(start)
+			if name == "database" {
+				resp.Database.LatencyMS = float64(took.Microseconds()) / 1000
+			}
+			res := CheckResult{OK: err == nil, Detail: detail, DurationMS: took.Milliseconds()}
+			if err != nil {
+				res.Error = err.Error()
+				resp.OK = false
+			}
+			resp.Checks[name] = res
+		}
+		if resp.Checks["database"].OK {
+			ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+			resp.Database.MigrationVersion = store.MigrationVersion(ctx, db)
+			cancel()
+		}
+		code := http.StatusOK
+		if !resp.OK {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, resp)
+	})
+}
+
+// checkSigningKeys parses the default signing pair and every per-product and
+// per-tenant pair, and checks the default private key matches its public key.
+func checkSigningKeys(cfg *config.Config) (string, error) {
+	priv, err := cfg.PrivateKey()
+	if err != nil {
+		return "", err
+	}
+	pub, err := cfg.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	if !pub.(interface{ Equal(gocrypto.PublicKey) bool }).Equal(priv.Public()) {
+		return "", fmt.Errorf("signing.private_key_pem does not match signing.public_key_pem")
+	}
+	_, productPairs, tenantPairs := cfg.KeyPairs()
+	products := sortedKeys(productPairs)
+	for _, id := range products {
+		if _, _, err := cfg.ProductSigningKey(id); err != nil {
+			return "", err
+		}
+	}
+	tenants := sortedKeys(tenantPairs)
+	for _, id := range tenants {
+		if _, _, err := cfg.SigningKey(id, ""); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%d product keys, %d tenant keys", len(products), len(tenants)), nil
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// FieldChange is one field's value before and after a change; Before is
+// null for a newly issued license.
+type FieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+type HistoryEntry struct {
+	ID        string                 `json:"id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	Changes   map[string]FieldChange `json:"changes"`
+	RequestID string                 `json:"request_id,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+type LicenseHistoryResponse struct {
+	LicenseKey string         `json:"license_key"`
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// untrackedFields change without an admin action (last_seen_at on every
+// validation) or never change at all.
+var untrackedFields = []string{"id", "license_key", "last_seen_at"}
+
+// snapshotOf reads the license fields license_history tracks, keyed by
+// their LicenseSummary JSON names; nil for nil.
+func snapshotOf(l *store.License) map[string]any {
+	if l == nil {
+		return nil
+	}
+	b, err := json.Marshal(licenseSummary(*l))
+	if err != nil {
+		return nil
+	}
+	var snap map[string]any
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil
+	}
+	for _, f := range untrackedFields {
+		delete(snap, f)
+	}
+	return snap
+}
+
+// diffSnapshots returns the fields whose value differs between two
+// snapshots; a field missing from one side is null there.
+func diffSnapshots(before, after map[string]any) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for k, a := range after {
+		if b := before[k]; !reflect.DeepEqual(b, a) {
+			changes[k] = FieldChange{Before: b, After: a}
+		}
+	}
+	for k, b := range before {
+		if _, ok := after[k]; !ok {
+			changes[k] = FieldChange{Before: b}
+		}
+	}
+	return changes
+}
+
+// diffLicenses is diffSnapshots of two licenses, as the store.Audit Diff of
+// changes made through the license store.
+func diffLicenses(before, after *store.License) map[string]any {
+	changes := map[string]any{}
+	for k, c := range diffSnapshots(snapshotOf(before), snapshotOf(after)) {
+		changes[k] = c
+	}
+	return changes
+}
+
+// GetLicenseHistory pages through a license's changes, newest first. Paging
+// works like ListLicenses (limit, cursor).
+func GetLicenseHistory(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		limit, ok := listLimit(w, r)
+		if !ok {
+			return
+		}
+		args := []any{key}
+		query := `select id, action, actor, changes, request_id, created_at from license_history where license_key=$1`
+		if v := r.URL.Query().Get("cursor"); v != "" {
+			createdAt, id, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, createdAt, id)
+			query += " and " + keysetCond("created_at", "id", "<", 2)
+		}
+		query += fmt.Sprintf(" order by created_at desc, id desc limit %d", limit+1)
+
+		ctx := r.Context()
+		var exists int
+		if err := db.QueryRowContext(ctx, `select count(*) from licenses where license_key=$1`, key).Scan(&exists); err != nil {
+			internalError(w, "history.lookup", err)
+			return
+		}
+		if exists == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			internalError(w, "history.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := LicenseHistoryResponse{LicenseKey: key, Entries: []HistoryEntry{}}
+		var lastCreatedAt string
+		for rows.Next() {
+			if len(resp.Entries) == limit {
+				resp.NextCursor = encodeListCursor(lastCreatedAt, resp.Entries[limit-1].ID)
+				break
+			}
+			var e HistoryEntry
+			var changes []byte
+			var createdAt nullTime
+			if err := rows.Scan(&e.ID, &e.Action, &e.Actor, &changes, &e.RequestID, &createdAt); err != nil {
+				internalError(w, "history.scan", err)
+				return
+			}
+			if err := json.Unmarshal(changes, &e.Changes); err != nil {
+				internalError(w, "history.changes", err)
+				return
+			}
+			e.CreatedAt = createdAt.Time.Format(time.RFC3339Nano)
+			lastCreatedAt = cursorTime(cfg, createdAt.Time)
+			resp.Entries = append(resp.Entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "history.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+const (
+	// idempotencyTTL is how long an Idempotency-Key is remembered.
+	idempotencyTTL         = 24 * time.Hour
+	maxIdempotencyKeyLen   = 255
+	idempotencyReplayedHdr = "Idempotent-Replayed"
+)
+
+// requestHash fingerprints a decoded request so a reused Idempotency-Key
+// with a different body can be told apart from a retry.
+func requestHash(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotencyTaken is a failure to claim an Idempotency-Key, most likely
+// because a concurrent retry claimed it first.
+type idempotencyTaken struct{ err error }
+
+func (e idempotencyTaken) Error() string { return e.err.Error() }
+func (e idempotencyTaken) Unwrap() error { return e.err }
+
+// replayIdempotent answers a retried issue request from idempotency_keys.
+// It returns false when key is unused (or expired) and the request should
+// go ahead; otherwise the response has been written.
+func replayIdempotent(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, key, hash string) bool {
+	ctx := r.Context()
+	if _, err := db.ExecContext(ctx, `delete from idempotency_keys where "key"=$1 and created_at < $2`,
+		key, dbTime(cfg, time.Now().Add(-idempotencyTTL))); err != nil {
+		internalError(w, "idempotency.expire", err)
+		return true
+	}
+	var storedHash, licenseKey string
+	var resp []byte
+	err := db.QueryRowContext(ctx, `select request_hash, license_key, response from idempotency_keys where "key"=$1`, key).
+		Scan(&storedHash, &licenseKey, &resp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		internalError(w, "idempotency.lookup", err)
+		return true
+	}
+	if storedHash != hash {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return true
+	}
+	if resp == nil {
+		// the license is stored but its file was not (by a server that
+		// signed after committing, and failed), so render it now
+		lf, code, err := renderLicenseFile(ctx, db, cfg, licenseKey)
+		if code == http.StatusInternalServerError {
+			internalError(w, "idempotency.render", err)
+			return true
+		}
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return true
+		}
+		if resp, err = json.Marshal(lf); err != nil {
+			internalError(w, "idempotency.marshal", err)
+			return true
+		}
+		storeIdempotentResponse(ctx, db, key, resp)
+	}
+	w.Header().Set(idempotencyReplayedHdr, "true")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(resp, '\n'))
+	return true
+}
+
+// storeIdempotentResponse records the response for later replays. The
+// license is already committed, so a failure only costs a re-render.
+func storeIdempotentResponse(ctx context.Context, db *sql.DB, key string, resp []byte) {
+	if _, err := db.ExecContext(ctx, `update idempotency_keys set response=$1 where "key"=$2 and response is null`, string(resp), key); err != nil {
+		logging.Errorf("idempotency store error key=%s err=%v", key, err)
+	}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// ImportRequest carries licenses from another system, either as JSON
+// records or as CSV text. CSV needs a header row; the columns match the
+// export (license_key, customer, machine_id, expires_at, features.<key>, ...)
+// and a "features" column holding a JSON object is accepted too.
+type ImportRequest struct {
+	Licenses []ImportLicense `json:"licenses,omitempty"`
+	CSV      string          `json:"csv,omitempty"`
+	// Resign returns a freshly signed LicenseFile for every imported
+	// license, for customers moving to raalisence-verified files.
+	Resign bool `json:"resign,omitempty"`
+}
+
+// ImportLicense is an IssueRequest that keeps its existing key. An empty
+// license_key gets a new one.
+type ImportLicense struct {
+	LicenseKey string `json:"license_key,omitempty"`
+	Revoked    bool   `json:"revoked,omitempty"`
+	IssueRequest
+}
+
+type ImportResponse struct {
+	Imported    int           `json:"imported"`
+	LicenseKeys []string      `json:"license_keys"`
+	Licenses    []LicenseFile `json:"licenses,omitempty"` // only with resign
+}
+
+// ImportLicenses inserts existing licenses in one transaction: if any
+// record is invalid or its key is already taken nothing is stored.
+func ImportLicenses(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ImportRequest
+		if !decodeJSONLimit(w, r, &req, maxBatchJSONBody) {
+			return
+		}
+		recs := req.Licenses
+		switch {
+		case len(recs) > 0 && req.CSV != "":
+			http.Error(w, "use either licenses or csv, not both", http.StatusBadRequest)
+			return
+		case req.CSV != "":
+			var err error
+			if recs, err = parseImportCSV(req.CSV); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(recs) == 0 || len(recs) > maxBatchSize {
+			http.Error(w, fmt.Sprintf("between 1 and %d licenses per import", maxBatchSize), http.StatusBadRequest)
+			return
+		}
+		seen := map[string]bool{}
+		for i := range recs {
+			if err := recs[i].normalize(cfg); err != nil {
+				http.Error(w, fmt.Sprintf("licenses[%d]: %v", i, err), http.StatusBadRequest)
+				return
+			}
+			if recs[i].LicenseKey == "" {
+				recs[i].LicenseKey = uuid.NewString()
+			}
+			if seen[recs[i].LicenseKey] {
+				http.Error(w, fmt.Sprintf("licenses[%d]: duplicate license_key %s", i, recs[i].LicenseKey), http.StatusBadRequest)
+				return
+			}
+			seen[recs[i].LicenseKey] = true
+		}
+
+		ctx := r.Context()
+		now := time.Now().UTC()
+		var resp ImportResponse
+		if !inTx(w, r, db, "import", func(tx *sql.Tx) error {
+			for i, rec := range recs {
+				var exists int
+				err := tx.QueryRowContext(ctx, `select 1 from licenses where license_key=$1`, rec.LicenseKey).Scan(&exists)
+				if err == nil {
+					return txAnswer{http.StatusConflict, fmt.Sprintf("licenses[%d]: license_key %s already exists", i, rec.LicenseKey)}
+				}
+				if !errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("lookup: %w", err)
+				}
+				actx := withAudit(r, AuditLicenseImport, map[string]any{"customer": rec.Customer, "product_id": rec.ProductID})
+				if err := insertLicense(actx, tx, cfg, rec.IssueRequest, rec.LicenseKey); err != nil {
+					return fmt.Errorf("insert: %w", err)
+				}
+				if rec.Revoked {
+					actx := withAudit(r, AuditLicenseRevoke, map[string]any{"import": true})
+					if err := dbStore(tx, cfg).Revoke(actx, rec.LicenseKey); err != nil {
+						return fmt.Errorf("revoke: %w", err)
+					}
+				}
+			}
+
+			resp = ImportResponse{Imported: len(recs), LicenseKeys: make([]string, len(recs))}
+			for i, rec := range recs {
+				resp.LicenseKeys[i] = rec.LicenseKey
+				if !req.Resign {
+					continue
+				}
+				// sign before committing so a signing failure leaves no rows behind
+				lf, err := signLicenseFile(cfg, rec.IssueRequest, rec.LicenseKey, now)
+				if err != nil {
+					return fmt.Errorf("sign: %w", err)
+				}
+				resp.Licenses = append(resp.Licenses, lf)
+			}
+			return nil
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// importIgnored are export columns that describe server state rather than
+// the license itself.
+var importIgnored = map[string]bool{"id": true, "suspended": true, "last_seen_at": true, "archived_at": true}
+
+// parseImportCSV reads CSV with a header row into import records. Errors
+// name the line so large files can be fixed quickly.
+func parseImportCSV(text string) ([]ImportLicense, error) {
+	cr := csv.NewReader(strings.NewReader(text))
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv: missing header: %v", err)
+	}
+	for _, col := range header {
+		if !importColumn(col) {
+			return nil, fmt.Errorf("csv: unknown column %q", col)
+		}
+	}
+	var out []ImportLicense
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %v", err)
+		}
+		line, _ := cr.FieldPos(0)
+		rec, err := importRecord(header, row)
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: %v", line, err)
+		}
+		out = append(out, rec)
+	}
+}
+
+func importColumn(col string) bool {
+	switch col {
+	case "license_key", "product_id", "customer", "machine_id", "expires_at", "revoked",
+		"max_activations", "floating_seats", "grace_days", "notes", "features":
+		return true
+	}
+	return importIgnored[col] || strings.HasPrefix(col, "features.") && len(col) > len("features.")
+}
+
+func importRecord(header, row []string) (ImportLicense, error) {
+	var rec ImportLicense
+	for i, col := range header {
+		v := csvUnescape(row[i])
+		if v == "" || importIgnored[col] {
+			continue
+		}
+		var err error
+		switch col {
+		case "license_key":
+			rec.LicenseKey = v
+		case "product_id":
+			rec.ProductID = v
+		case "customer":
+			rec.Customer = v
+		case "machine_id":
+			rec.MachineID = v
+		case "notes":
+			rec.Notes = v
+		case "expires_at":
+			if rec.ExpiresAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return rec, fmt.Errorf("expires_at must be RFC3339")
+			}
+		case "revoked":
+			if rec.Revoked, err = strconv.ParseBool(v); err != nil {
+				return rec, fmt.Errorf("revoked must be true or false")
+			}
+		case "max_activations":
+			if rec.MaxActivations, err = strconv.Atoi(v); err != nil {
+				return rec, fmt.Errorf("max_activations must be an integer")
+			}
+		case "floating_seats":
+			if rec.FloatingSeats, err = strconv.Atoi(v); err != nil {
+				return rec, fmt.Errorf("floating_seats must be an integer")
+			}
+		case "grace_days":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return rec, fmt.Errorf("grace_days must be an integer")
+			}
+			rec.GraceDays = &n
+		case "features":
+			if err := json.Unmarshal([]byte(v), &rec.Features); err != nil {
+				return rec, fmt.Errorf("features must be a JSON object")
+			}
+		default: // features.<key>
+			if rec.Features == nil {
+				rec.Features = map[string]any{}
+			}
+			setFeature(rec.Features, strings.Split(strings.TrimPrefix(col, "features."), "."), v)
+		}
+	}
+	return rec, nil
+}
+
+// setFeature stores a flattened feature cell back into its nested place.
+// Cells holding JSON values (numbers, booleans, arrays) keep their type;
+// anything else is a string.
+func setFeature(feats map[string]any, path []string, cell string) {
+	for _, k := range path[:len(path)-1] {
+		next, ok := feats[k].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			feats[k] = next
+		}
+		feats = next
+	}
+	var v any = cell
+	var parsed any
+	if json.Unmarshal([]byte(cell), &parsed) == nil {
+		if _, isObj := parsed.(map[string]any); !isObj {
+			v = parsed
+		}
+	}
+	feats[path[len(path)-1]] = v
+}
+
+// csvUnescape undoes csvCell's formula guard so exports import unchanged.
+func csvUnescape(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(cell[1])) {
+		return cell[1:]
+	}
+	return cell
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// JWK is an EC, RSA or (for Ed25519) OKP public signing key (RFC 7517, RFC
+// 8037). ProductID is set for keys configured under signing.products.
+type JWK struct {
+	Kty       string `json:"kty"`
+	Crv       string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"` // EC only
+	N         string `json:"n,omitempty"` // RSA only
+	E         string `json:"e,omitempty"` // RSA only
+	Use       string `json:"use"`
+	Alg       string `json:"alg"`
+	Kid       string `json:"kid"` // same as a license file's key_id
+	ProductID string `json:"product_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+type JWKSResponse struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public keys that sign license files, so verifiers can
+// fetch and pin them by key_id instead of trusting the PEM embedded in each
+// file. The default key comes first, then per-product keys by product_id,
+// then per-tenant keys by tenant.
+func JWKS(cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp, err := buildJWKS(cfg)
+		if err != nil {
+			internalError(w, "jwks", err)
+			return
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			internalError(w, "jwks.marshal", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(b)
+	})
+}
+
+func buildJWKS(cfg *config.Config) (JWKSResponse, error) {
+	defPair, products, tenants := cfg.KeyPairs()
+	def, err := publicJWK(defPair.PublicKeyPEM)
+	if err != nil {
+		return JWKSResponse{}, fmt.Errorf("signing.public_key_pem: %w", err)
+	}
+	resp := JWKSResponse{Keys: []JWK{def}}
+
+	for _, id := range sortedKeys(products) {
+		k, err := publicJWK(products[id].PublicKeyPEM)
+		if err != nil {
+			return JWKSResponse{}, fmt.Errorf("signing.products.%s: %w", id, err)
+		}
+		k.ProductID = id
+		resp.Keys = append(resp.Keys, k)
+	}
+	for _, id := range sortedKeys(tenants) {
+		k, err := publicJWK(tenants[id].PublicKeyPEM)
+		if err != nil {
+			return JWKSResponse{}, fmt.Errorf("signing.tenants.%s: %w", id, err)
+		}
+		k.Tenant = id
+		resp.Keys = append(resp.Keys, k)
+	}
+	return resp, nil
+}
+
+func sortedKeys(pairs map[string]config.KeyPair) []string {
+	ids := make([]string, 0, len(pairs))
+	for id := range pairs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func publicJWK(pubPEM string) (JWK, error) {
+	pub, err := crypto.ParsePublicKey(pubPEM)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{Use: "sig", Kid: keyFingerprint(pubPEM)}
+	enc := base64.RawURLEncoding
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		params := k.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk.Kty, jwk.Crv, jwk.Alg = "EC", params.Name, crypto.AlgES256
+		jwk.X = enc.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = enc.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv, jwk.Alg = "OKP", "Ed25519", crypto.AlgEdDSA
+		jwk.X = enc.EncodeToString(k)
+	case *rsa.PublicKey:
+		jwk.Kty, jwk.Alg = "RSA", crypto.AlgPS256
+		jwk.N = enc.EncodeToString(k.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+	return jwk, nil
+}
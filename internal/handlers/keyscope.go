@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+// LicenseInScope guards {license_key} routes for API keys restricted to
+// some products or customers: licenses outside the key's restrictions are
+// answered with 404, as if they did not exist. Legacy routes, which carry
+// the key in the body, are refused to restricted keys.
+func LicenseInScope(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks := middleware.GetKeyScope(r)
+		if !ks.Restricted() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.PathValue("license_key")
+		if key == "" {
+			http.Error(w, "forbidden: restricted keys must use /api/v1/licenses/{license_key} routes", http.StatusForbidden)
+			return
+		}
+		var customer, productID string
+		err := db.QueryRowContext(r.Context(), `select customer, product_id from licenses where license_key=$1`, key).Scan(&customer, &productID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !ks.AllowsLicense(customer, productID) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			internalError(w, "license.scope", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// issueInScope answers 403 unless the calling key may issue licenses for
+// every request in reqs.
+func issueInScope(w http.ResponseWriter, r *http.Request, reqs ...IssueRequest) bool {
+	ks := middleware.GetKeyScope(r)
+	for _, req := range reqs {
+		if !ks.AllowsLicense(req.Customer, req.ProductID) {
+			http.Error(w, fmt.Sprintf("forbidden: this key may not issue licenses for customer %q, product %q", req.Customer, req.ProductID), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// scopeConds appends the calling key's restrictions to a licenses query.
+func scopeConds(r *http.Request, conds []string, args []any) ([]string, []any) {
+	ks := middleware.GetKeyScope(r)
+	for _, f := range []struct {
+		column string
+		values []string
+	}{{"product_id", ks.ProductIDs}, {"customer", ks.Customers}} {
+		if len(f.values) == 0 {
+			continue
+		}
+		in := ""
+		for i, v := range f.values {
+			args = append(args, v)
+			if i > 0 {
+				in += ","
+			}
+			in += fmt.Sprintf("$%d", len(args))
+		}
+		conds = append(conds, fmt.Sprintf("%s in (%s)", f.column, in))
+	}
+	return conds, args
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// licenseAuthScheme is the Authorization scheme of routes that
+// server.auth_policy puts behind license-key auth.
+const licenseAuthScheme = "License "
+
+// RequireLicenseKey makes next take "Authorization: License <key>" for an
+// existing license, answering 401 otherwise. When the call names a license,
+// in the path or as license_key in its JSON body, it must be that one.
+func RequireLicenseKey(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), licenseAuthScheme)
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			w.Header().Set("WWW-Authenticate", "License")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var one int
+		err := db.QueryRowContext(r.Context(), `select 1 from licenses where license_key=$1`, key).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			internalError(w, "license_auth.lookup", err)
+			return
+		}
+		named := licenseKeyParam(r)
+		if named == "" && r.Body != nil && r.ContentLength != 0 {
+			body, ok := bufferBody(w, r)
+			if !ok {
+				return
+			}
+			var req struct {
+				LicenseKey string `json:"license_key"`
+			}
+			// the handler reports malformed bodies itself
+			_ = json.Unmarshal(body, &req)
+			named = req.LicenseKey
+		}
+		if named != "" && named != key {
+			http.Error(w, "forbidden: license key does not match", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
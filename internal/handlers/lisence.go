@@ -1,71 +1,166 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rpattn/raalisence/internal/config"
 	"github.com/rpattn/raalisence/internal/crypto"
+	"github.com/rpattn/raalisence/internal/entitlements"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/store"
 )
 
 const maxJSONBody = 64 * 1024 // 64KiB upper bound for JSON payloads
 
 type IssueRequest struct {
-	Customer  string         `json:"customer"`
-	MachineID string         `json:"machine_id"`
-	ExpiresAt time.Time      `json:"expires_at"`
-	Features  map[string]any `json:"features"`
+	ProductID      string           `json:"product_id,omitempty"`
+	Tenant         string           `json:"tenant,omitempty"` // selects a signing.tenants key pair
+	Customer       string           `json:"customer"`
+	MachineID      string           `json:"machine_id"`
+	ExpiresAt      time.Time        `json:"expires_at"`
+	Features       map[string]any   `json:"features"`
+	MaxActivations int              `json:"max_activations,omitempty"` // seats; defaults to 1
+	FloatingSeats  int              `json:"floating_seats,omitempty"`  // concurrent checkouts; 0 disables
+	GraceDays      *int             `json:"grace_days,omitempty"`      // nil uses licensing.grace_days
+	Entitlements   entitlements.Set `json:"entitlements,omitempty"`
+	// Metadata and Notes are internal bookkeeping (CRM ids, support notes);
+	// they are stored and listed but never signed into the license file.
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Notes    string         `json:"notes,omitempty"`
+	// FeaturesRecipientKey (an X25519 or P-256 PEM public key, or a bare
+	// base64url X25519 key) encrypts the license file's features to the
+	// client holding the private key; see crypto.Sealed.
+	FeaturesRecipientKey string `json:"features_recipient_key,omitempty"`
+	// RequireNonce makes validation fail unless it presents a nonce from
+	// the challenge endpoint, so a recorded valid response can't be replayed.
+	RequireNonce bool `json:"require_nonce,omitempty"`
 }
 
 type LicenseFile struct {
-	Customer   string         `json:"customer"`
-	MachineID  string         `json:"machine_id"`
-	LicenseKey string         `json:"license_key"`
-	ExpiresAt  time.Time      `json:"expires_at"`
-	Features   map[string]any `json:"features"`
-	IssuedAt   time.Time      `json:"issued_at"`
-	Signature  string         `json:"signature"`
-	PublicKey  string         `json:"public_key_pem"`
+	// SchemaVersion is the layout of the signed payload (see
+	// licenseSchemaVersion); not signed, verifiers dispatch on it.
+	SchemaVersion     int              `json:"schema_version,omitempty"`
+	ProductID         string           `json:"product_id,omitempty"`
+	Tenant            string           `json:"tenant,omitempty"`
+	Customer          string           `json:"customer"`
+	MachineID         string           `json:"machine_id"`
+	LicenseKey        string           `json:"license_key"`
+	ExpiresAt         time.Time        `json:"expires_at"`
+	Features          map[string]any   `json:"features"`
+	EncryptedFeatures *crypto.Sealed   `json:"encrypted_features,omitempty"` // instead of Features with a features_recipient_key; signed as is
+	MaxActivations    int              `json:"max_activations"`
+	FloatingSeats     int              `json:"floating_seats,omitempty"`
+	GraceDays         *int             `json:"grace_days,omitempty"`
+	Entitlements      entitlements.Set `json:"entitlements,omitempty"`
+	IssuedAt          time.Time        `json:"issued_at"`
+	Signature         string           `json:"signature"`
+	Alg               string           `json:"alg"` // ES256, EdDSA or PS256, from the key; not signed
+	PublicKey         string           `json:"public_key_pem"`
+	KeyID             string           `json:"key_id,omitempty"` // kid in /.well-known/jwks.json; not signed
+	// CertChain is the signing key's certificate and any intermediates
+	// (x5c: leaf first, base64 DER) when signing.cert_chain_pem is set;
+	// not signed.
+	CertChain []string `json:"cert_chain,omitempty"`
+	// Timestamp is an RFC 3161 TimeStampToken (base64 DER) over the raw
+	// signature bytes when signing.timestamp is configured; not signed.
+	Timestamp string `json:"timestamp,omitempty"`
 }
 
 type ValidateRequest struct {
-	LicenseKey string `json:"license_key"`
-	MachineID  string `json:"machine_id"`
+	LicenseKey string       `json:"license_key"`
+	MachineID  string       `json:"machine_id"`
+	ProductID  string       `json:"product_id,omitempty"` // when set, must match the license's product
+	Machine    *MachineInfo `json:"machine,omitempty"`    // recorded by activate and heartbeat
+	// Nonce, when set, is echoed in a signed validation response. Licenses
+	// issued with require_nonce need one from the challenge endpoint.
+	Nonce string `json:"nonce,omitempty"`
 }
 
 type ValidateResponse struct {
 	Valid     bool      `json:"valid"`
 	Revoked   bool      `json:"revoked"`
+	Suspended bool      `json:"suspended,omitempty"`
 	ExpiresAt time.Time `json:"expires_at"`
 	Reason    string    `json:"reason,omitempty"`
+	// Grace is set when the license has expired but is inside its grace period.
+	Grace              bool `json:"grace,omitempty"`
+	GraceDaysRemaining int  `json:"grace_days_remaining,omitempty"`
+	// QuotaExceeded lists quota entitlements used up in the current period.
+	QuotaExceeded []string `json:"quota_exceeded,omitempty"`
+	// The rest are only set when the request carried a nonce; see
+	// signValidation.
+	Nonce       string     `json:"nonce,omitempty"`
+	ValidatedAt *time.Time `json:"validated_at,omitempty"`
+	Signature   string     `json:"signature,omitempty"`
+	KeyID       string     `json:"key_id,omitempty"`
 }
 
 type LicenseSummary struct {
-	ID         string         `json:"id"`
-	LicenseKey string         `json:"license_key"`
-	Customer   string         `json:"customer"`
-	MachineID  string         `json:"machine_id"`
-	ExpiresAt  string         `json:"expires_at"`
-	Revoked    bool           `json:"revoked"`
-	LastSeenAt *string        `json:"last_seen_at,omitempty"`
-	Features   map[string]any `json:"features,omitempty"`
+	ID             string           `json:"id"`
+	LicenseKey     string           `json:"license_key"`
+	ProductID      string           `json:"product_id,omitempty"`
+	Customer       string           `json:"customer"`
+	MachineID      string           `json:"machine_id"`
+	ExpiresAt      string           `json:"expires_at"`
+	Revoked        bool             `json:"revoked"`
+	Suspended      bool             `json:"suspended"`
+	MaxActivations int              `json:"max_activations"`
+	FloatingSeats  int              `json:"floating_seats"`
+	GraceDays      *int             `json:"grace_days,omitempty"`
+	LastSeenAt     *string          `json:"last_seen_at,omitempty"`
+	ArchivedAt     *string          `json:"archived_at,omitempty"`
+	Features       map[string]any   `json:"features,omitempty"`
+	Entitlements   entitlements.Set `json:"entitlements,omitempty"`
+	Metadata       map[string]any   `json:"metadata,omitempty"`
+	Notes          string           `json:"notes,omitempty"`
+	Tenant         string           `json:"tenant,omitempty"`
+	KeyID          string           `json:"key_id,omitempty"` // signing key last used, as in the JWKS
 }
 
 type ListLicensesResponse struct {
-	Licenses []LicenseSummary `json:"licenses"`
+	Licenses   []LicenseSummary `json:"licenses"`
+	NextCursor string           `json:"next_cursor,omitempty"`
 }
 
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
 type UpdateLicenseRequest struct {
-	LicenseKey string         `json:"license_key"`
-	ExpiresAt  *string        `json:"expires_at,omitempty"`
-	Features   map[string]any `json:"features,omitempty"`
+	LicenseKey     string           `json:"license_key"`
+	ExpiresAt      *string          `json:"expires_at,omitempty"`
+	Features       map[string]any   `json:"features,omitempty"`
+	MaxActivations *int             `json:"max_activations,omitempty"`
+	FloatingSeats  *int             `json:"floating_seats,omitempty"`
+	GraceDays      *int             `json:"grace_days,omitempty"`
+	Entitlements   entitlements.Set `json:"entitlements,omitempty"`
+	Metadata       map[string]any   `json:"metadata,omitempty"`
+	Notes          *string          `json:"notes,omitempty"`
+	RequireNonce   *bool            `json:"require_nonce,omitempty"`
+}
+
+// auditDetails lists the changed fields and their new values.
+func (req UpdateLicenseRequest) auditDetails() map[string]any {
+	var changes map[string]any
+	if b, err := json.Marshal(req); err == nil {
+		_ = json.Unmarshal(b, &changes)
+	}
+	delete(changes, "license_key")
+	return changes
 }
 
 func IssueLicense(db *sql.DB, cfg *config.Config) http.Handler {
@@ -74,70 +169,269 @@ func IssueLicense(db *sql.DB, cfg *config.Config) http.Handler {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != formatCOSE && format != formatDetached {
+			http.Error(w, "format must be json, cose or detached", http.StatusBadRequest)
+			return
+		}
 		var req IssueRequest
 		if !decodeJSON(w, r, &req) {
 			return
 		}
-		if req.Customer == "" || req.MachineID == "" || req.ExpiresAt.IsZero() {
-			http.Error(w, "customer, machine_id, expires_at required", http.StatusBadRequest)
+		if err := req.normalize(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !issueInScope(w, r, req) {
 			return
 		}
 
+		// Idempotency-Key makes retries return the first response instead of
+		// issuing a duplicate license.
+		idemKey := r.Header.Get("Idempotency-Key")
+		var idemHash string
+		if idemKey != "" {
+			if len(idemKey) > maxIdempotencyKeyLen {
+				http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if idemHash, err = requestHash(req); err != nil {
+				internalError(w, "issue.idempotency.hash", err)
+				return
+			}
+			if replayIdempotent(w, r, db, cfg, idemKey, idemHash) {
+				return
+			}
+		}
+
 		ctx := r.Context()
 		licenseKey := uuid.NewString()
 		now := time.Now().UTC()
 
-		// insert
-		const insert = `insert into licenses (id, license_key, customer, machine_id, features, expires_at, revoked, last_seen_at, created_at, updated_at)
-		values ($1,$2,$3,$4,$5,$6,false,null,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)`
-		featuresJSON, _ := json.Marshal(req.Features)
-		expVal := any(req.ExpiresAt.UTC())
-		if cfg.DB.Driver == "sqlite3" {
-			expVal = req.ExpiresAt.UTC().Format(time.RFC3339Nano)
-		}
-		_, err := db.ExecContext(ctx, insert, uuid.New(), licenseKey, req.Customer, req.MachineID, string(featuresJSON), expVal)
-		if err != nil {
-			internalError(w, "issue.insert", err)
-			return
-		}
-
-		priv, err := cfg.PrivateKey()
-		if err != nil {
-			internalError(w, "issue.private_key", err)
-			return
-		}
+		var b []byte
+		err := store.RetryTx(ctx, db, func(tx *sql.Tx) error {
+			actx := withAudit(r, AuditLicenseIssue, map[string]any{"customer": req.Customer, "product_id": req.ProductID})
+			if err := insertLicense(actx, tx, cfg, req, licenseKey); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			if err := claimMachine(r, tx, cfg, req, licenseKey); err != nil {
+				var taken machineTakenError
+				if errors.As(err, &taken) {
+					return txAnswer{http.StatusConflict, err.Error()}
+				}
+				return fmt.Errorf("claim: %w", err)
+			}
+			if err := queueEvent(ctx, tx, cfg, EventLicenseIssued, req.eventData(licenseKey)); err != nil {
+				return fmt.Errorf("event: %w", err)
+			}
+			if idemKey != "" {
+				if _, err := tx.ExecContext(ctx, `insert into idempotency_keys ("key", request_hash, license_key, created_at) values ($1,$2,$3,$4)`,
+					idemKey, idemHash, licenseKey, dbTime(cfg, now)); err != nil {
+					return idempotencyTaken{err}
+				}
+			}
 
-		payload := map[string]any{
-			"customer":    req.Customer,
-			"machine_id":  req.MachineID,
-			"license_key": licenseKey,
-			"expires_at":  req.ExpiresAt.UTC().Format(time.RFC3339Nano),
-			"issued_at":   now.Format(time.RFC3339Nano),
-			"features":    req.Features,
+			// sign before committing so a signing failure leaves no rows behind
+			var out any
+			var err error
+			switch format {
+			case formatCOSE:
+				out, err = signCOSELicense(cfg, req, licenseKey, now)
+			case formatDetached:
+				out, err = signDetachedLicense(cfg, req, licenseKey, now)
+			default:
+				out, err = signLicenseFile(cfg, req, licenseKey, now)
+			}
+			if err != nil {
+				return fmt.Errorf("sign: %w", err)
+			}
+			if b, err = json.Marshal(out); err != nil {
+				return fmt.Errorf("marshal: %w", err)
+			}
+			if idemKey != "" {
+				if _, err := tx.ExecContext(ctx, `update idempotency_keys set response=$1 where "key"=$2`, string(b), idemKey); err != nil {
+					return fmt.Errorf("idempotency response: %w", err)
+				}
+			}
+			return nil
+		})
+		var taken idempotencyTaken
+		if errors.As(err, &taken) {
+			// a concurrent retry claimed the key first; answer as it did
+			if replayIdempotent(w, r, db, cfg, idemKey, idemHash) {
+				return
+			}
+			err = fmt.Errorf("idempotency insert: %w", taken.err)
 		}
-		sig, err := crypto.SignJSON(priv, payload)
 		if err != nil {
-			internalError(w, "issue.sign", err)
+			txFailed(w, "issue", err)
 			return
 		}
+		flushEvents(ctx, db, cfg)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(b, '\n'))
+	})
+}
 
-		pubPEM := cfg.Signing.PublicKeyPEM
-		lf := LicenseFile{
-			Customer:   req.Customer,
-			MachineID:  req.MachineID,
-			LicenseKey: licenseKey,
-			ExpiresAt:  req.ExpiresAt.UTC(),
-			Features:   req.Features,
-			IssuedAt:   now,
-			Signature:  sig,
-			PublicKey:  pubPEM,
+// normalize validates an issue request and fills in defaults. The returned
+// error is safe to show to the caller.
+func (req *IssueRequest) normalize(cfg *config.Config) error {
+	if req.Customer == "" || req.MachineID == "" || req.ExpiresAt.IsZero() {
+		return errors.New("customer, machine_id, expires_at required")
+	}
+	if req.MaxActivations < 0 {
+		return errors.New("max_activations must be positive")
+	}
+	if req.FloatingSeats < 0 {
+		return errors.New("floating_seats must not be negative")
+	}
+	if req.GraceDays != nil && *req.GraceDays < 0 {
+		return errors.New("grace_days must not be negative")
+	}
+	if err := cfg.Entitlements.Validate(req.Entitlements); err != nil {
+		return err
+	}
+	if req.FeaturesRecipientKey != "" {
+		if _, err := crypto.ParseRecipientKey(req.FeaturesRecipientKey); err != nil {
+			return fmt.Errorf("features_recipient_key: %w", err)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(lf)
+	}
+	if req.Entitlements == nil {
+		req.Entitlements = entitlements.Set{}
+	}
+	if req.Metadata == nil {
+		req.Metadata = map[string]any{}
+	}
+	if req.MaxActivations == 0 {
+		req.MaxActivations = 1
+	}
+	return nil
+}
+
+// insertLicense stores a normalized license and its first activation (the
+// issuing machine) inside tx.
+func insertLicense(ctx context.Context, tx store.Querier, cfg *config.Config, req IssueRequest, licenseKey string) error {
+	// the key is chosen now, and recorded, so its licenses can be found
+	_, pubPEM, err := cfg.SigningKey(req.Tenant, req.ProductID)
+	if err != nil {
+		return err
+	}
+	err = dbStore(tx, cfg).Insert(ctx, store.License{
+		ID:                   uuid.NewString(),
+		LicenseKey:           licenseKey,
+		ProductID:            req.ProductID,
+		Tenant:               req.Tenant,
+		Customer:             req.Customer,
+		MachineID:            req.MachineID,
+		ExpiresAt:            req.ExpiresAt,
+		MaxActivations:       req.MaxActivations,
+		FloatingSeats:        req.FloatingSeats,
+		GraceDays:            req.GraceDays,
+		Features:             req.Features,
+		Entitlements:         req.Entitlements,
+		Metadata:             req.Metadata,
+		Notes:                req.Notes,
+		FeaturesRecipientKey: req.FeaturesRecipientKey,
+		RequireNonce:         req.RequireNonce,
+		SigningKeyID:         keyFingerprint(pubPEM),
 	})
+	if err != nil {
+		return err
+	}
+	return insertActivation(ctx, tx, cfg, licenseKey, req.MachineID)
 }
 
-func RevokeLicense(db *sql.DB) http.Handler {
+// signLicenseFile builds and signs the license file for req with the key
+// selected for its tenant and product.
+func signLicenseFile(cfg *config.Config, req IssueRequest, licenseKey string, issuedAt time.Time) (LicenseFile, error) {
+	priv, pubPEM, err := cfg.SigningKey(req.Tenant, req.ProductID)
+	if err != nil {
+		return LicenseFile{}, err
+	}
+	alg, err := crypto.Algorithm(priv)
+	if err != nil {
+		return LicenseFile{}, err
+	}
+	lf := LicenseFile{
+		SchemaVersion:  licenseSchemaVersion,
+		ProductID:      req.ProductID,
+		Tenant:         req.Tenant,
+		Customer:       req.Customer,
+		MachineID:      req.MachineID,
+		LicenseKey:     licenseKey,
+		ExpiresAt:      req.ExpiresAt.UTC(),
+		Features:       req.Features,
+		MaxActivations: req.MaxActivations,
+		FloatingSeats:  req.FloatingSeats,
+		GraceDays:      req.GraceDays,
+		Entitlements:   req.Entitlements,
+		IssuedAt:       issuedAt.UTC(),
+		Alg:            alg,
+		PublicKey:      pubPEM,
+		KeyID:          keyFingerprint(pubPEM),
+	}
+	if lf.CertChain, err = licenseCertChain(cfg, req); err != nil {
+		return LicenseFile{}, err
+	}
+	if lf.EncryptedFeatures, err = sealFeatures(req); err != nil {
+		return LicenseFile{}, err
+	}
+	if lf.EncryptedFeatures != nil {
+		lf.Features = nil
+	}
+	if lf.Signature, err = crypto.SignJSON(priv, lf.payload()); err != nil {
+		return LicenseFile{}, err
+	}
+	if lf.Timestamp, err = timestampSignature(cfg, lf.Signature); err != nil {
+		return LicenseFile{}, err
+	}
+	return lf, nil
+}
+
+// payload returns the fields covered by the signature.
+func (lf LicenseFile) payload() map[string]any {
+	p := map[string]any{
+		"customer":        lf.Customer,
+		"machine_id":      lf.MachineID,
+		"license_key":     lf.LicenseKey,
+		"expires_at":      lf.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		"issued_at":       lf.IssuedAt.UTC().Format(time.RFC3339Nano),
+		"features":        lf.Features,
+		"max_activations": lf.MaxActivations,
+		"floating_seats":  lf.FloatingSeats,
+		"entitlements":    lf.Entitlements,
+	}
+	if lf.ProductID != "" {
+		p["product_id"] = lf.ProductID
+	}
+	if lf.Tenant != "" {
+		p["tenant"] = lf.Tenant
+	}
+	if lf.GraceDays != nil {
+		p["grace_days"] = *lf.GraceDays
+	}
+	if lf.EncryptedFeatures != nil {
+		delete(p, "features")
+		p["encrypted_features"] = lf.EncryptedFeatures
+	}
+	return p
+}
+
+// sealFeatures encrypts req's features to its features_recipient_key, if
+// it has one.
+func sealFeatures(req IssueRequest) (*crypto.Sealed, error) {
+	if req.FeaturesRecipientKey == "" {
+		return nil, nil
+	}
+	recipient, err := crypto.ParseRecipientKey(req.FeaturesRecipientKey)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.SealJSON(recipient, req.Features)
+}
+
+func RevokeLicense(db *sql.DB, cfg *config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -152,26 +446,45 @@ func RevokeLicense(db *sql.DB) http.Handler {
 			return
 		}
 		ctx := r.Context()
-		res, err := db.ExecContext(ctx, `update licenses set revoked=true, updated_at=CURRENT_TIMESTAMP where license_key=$1`, req.LicenseKey)
-		if err != nil {
-			internalError(w, "revoke.update", err)
-			return
-		}
-		if n, _ := res.RowsAffected(); n == 0 {
-			http.Error(w, "not found", http.StatusNotFound)
+		if !inTx(w, r, db, "revoke", func(tx *sql.Tx) error {
+			err := dbStore(tx, cfg).Revoke(withAudit(r, AuditLicenseRevoke, nil), req.LicenseKey)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			if err := queueEvent(ctx, tx, cfg, EventLicenseRevoked, map[string]any{"license_key": req.LicenseKey}); err != nil {
+				return fmt.Errorf("event: %w", err)
+			}
+			return nil
+		}) {
 			return
 		}
+		flushEvents(ctx, db, cfg)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"ok":true}`))
 	})
 }
 
 func ValidateLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return ValidateLicenseFrom(NewReadDB(db, nil), cfg)
+}
+
+// ValidateLicenseFrom is ValidateLicense looking the license up on reads'
+// replica. A rejection is confirmed on the primary, so replication lag can
+// delay a revocation but never fail a license just issued or activated.
+func ValidateLicenseFrom(reads *ReadDB, cfg *config.Config) http.Handler {
+	db := reads.Primary
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		body, ok := bufferBody(w, r)
+		if !ok {
+			return
+		}
 		var req ValidateRequest
 		if !decodeJSON(w, r, &req) {
 			return
@@ -180,69 +493,147 @@ func ValidateLicense(db *sql.DB, cfg *config.Config) http.Handler {
 			http.Error(w, "license_key and machine_id required", http.StatusBadRequest)
 			return
 		}
+		if len(req.Nonce) > maxNonceLen {
+			http.Error(w, "nonce too long", http.StatusBadRequest)
+			return
+		}
+		if !requireClientCert(w, r, db, cfg, req.LicenseKey, req.MachineID) {
+			return
+		}
+		if !requireRequestSignature(w, r, db, req.LicenseKey, body) {
+			return
+		}
 
 		ctx := r.Context()
-		var revoked bool
-		var machine string
-		var expires time.Time
-
-		if cfg.DB.Driver == "sqlite3" {
-			// SQLite stores expires_at as TEXT (RFC3339)
-			var expStr string
-			if err := db.QueryRowContext(ctx, `select revoked, expires_at, machine_id from licenses where license_key=$1`, req.LicenseKey).
-				Scan(&revoked, &expStr, &machine); err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					writeJSON(w, http.StatusOK, ValidateResponse{Valid: false, Reason: "unknown license"})
+		now := time.Now()
+		var st licenseState
+		respond := func(resp ValidateResponse) {
+			if req.Nonce != "" {
+				if err := signValidation(cfg, st, req, &resp, now); err != nil {
+					internalError(w, "validate.sign", err)
 					return
 				}
-				internalError(w, "validate.lookup", err)
-				return
 			}
-			// parse with RFC3339Nano then fall back to RFC3339
-			var perr error
-			expires, perr = time.Parse(time.RFC3339Nano, expStr)
-			if perr != nil {
-				expires, perr = time.Parse(time.RFC3339, expStr)
+			writeJSON(w, http.StatusOK, resp)
+		}
+		reject := func(resp ValidateResponse) {
+			emitEvent(ctx, db, cfg, EventLicenseValidationFailed, map[string]any{
+				"license_key": req.LicenseKey, "machine_id": req.MachineID, "reason": resp.Reason,
+			})
+			if resp.Reason != "unknown license" {
+				recordValidation(ctx, r, db, cfg, req, resp)
 			}
-			if perr != nil {
-				http.Error(w, "bad expires_at format", http.StatusInternalServerError)
-				return
+			respond(resp)
+		}
+		var resp ValidateResponse
+		err := reads.read(ctx, "validate", func(q *sql.DB) (err error) {
+			st, resp, err = checkLicense(ctx, q, cfg, req, now)
+			if err == nil && !resp.Valid && q != db {
+				return errConfirmOnPrimary
 			}
-		} else {
-			// Postgres path: timestamptz → time.Time
-			if err := db.QueryRowContext(ctx, `select revoked, expires_at, machine_id from licenses where license_key=$1`, req.LicenseKey).
-				Scan(&revoked, &expires, &machine); err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					writeJSON(w, http.StatusOK, ValidateResponse{Valid: false, Reason: "unknown license"})
+			return err
+		})
+		if err != nil {
+			internalError(w, "validate", err)
+			return
+		}
+		if !resp.Valid {
+			reject(resp)
+			return
+		}
+		if st.RequireNonce {
+			used := false
+			if req.Nonce != "" {
+				if used, err = useNonce(ctx, db, cfg, req, now); err != nil {
+					internalError(w, "validate.nonce", err)
 					return
 				}
-				internalError(w, "validate.lookup", err)
+			}
+			if !used {
+				reason := "invalid nonce"
+				if req.Nonce == "" {
+					reason = "nonce required"
+				}
+				reject(ValidateResponse{Valid: false, ExpiresAt: st.ExpiresAt, Reason: reason})
 				return
 			}
 		}
-
-		if machine != req.MachineID {
-			writeJSON(w, http.StatusOK, ValidateResponse{Valid: false, Reason: "machine mismatch"})
-			return
-		}
-		if revoked {
-			writeJSON(w, http.StatusOK, ValidateResponse{Valid: false, Revoked: true, ExpiresAt: expires, Reason: "revoked"})
+		exceeded, err := exceededQuotas(ctx, db, cfg, req.LicenseKey, st.Entitlements, now)
+		if err != nil {
+			internalError(w, "validate.usage", err)
 			return
 		}
-		if time.Now().After(expires) {
-			writeJSON(w, http.StatusOK, ValidateResponse{Valid: false, ExpiresAt: expires, Reason: "expired"})
+		if len(exceeded) > 0 && cfg.Usage.Enforcement == "fail" {
+			reject(ValidateResponse{Valid: false, ExpiresAt: st.ExpiresAt, Reason: "quota exceeded", QuotaExceeded: exceeded})
 			return
 		}
-		writeJSON(w, http.StatusOK, ValidateResponse{Valid: true, Revoked: false, ExpiresAt: expires})
+		resp.QuotaExceeded = exceeded
+		recordValidation(ctx, r, db, cfg, req, resp)
+		respond(resp)
 	})
 }
 
-func Heartbeat(db *sql.DB) http.Handler {
+// checkLicense applies the validation rules short of usage quotas for
+// req's license and machine. A failed check is reported in the response
+// (Valid false, with a reason), not as an error.
+func checkLicense(ctx context.Context, db *sql.DB, cfg *config.Config, req ValidateRequest, now time.Time) (licenseState, ValidateResponse, error) {
+	st, err := loadLicenseState(ctx, db, cfg, req.LicenseKey, false)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return st, ValidateResponse{Valid: false, Reason: "unknown license"}, nil
+		}
+		return st, ValidateResponse{}, fmt.Errorf("lookup: %w", err)
+	}
+	expires := st.ExpiresAt
+
+	if st.Archived {
+		return st, ValidateResponse{Valid: false, Reason: "archived"}, nil
+	}
+	if req.ProductID != "" && st.ProductID != req.ProductID {
+		return st, ValidateResponse{Valid: false, Reason: "product mismatch"}, nil
+	}
+	activated, err := isActivated(ctx, db, req.LicenseKey, req.MachineID)
+	if err != nil {
+		return st, ValidateResponse{}, fmt.Errorf("membership: %w", err)
+	}
+	if !activated {
+		return st, ValidateResponse{Valid: false, Reason: "machine mismatch"}, nil
+	}
+	if st.Revoked {
+		return st, ValidateResponse{Valid: false, Revoked: true, ExpiresAt: expires, Reason: "revoked"}, nil
+	}
+	graceEnd := st.graceEnd(cfg)
+	if st.Suspended {
+		return st, ValidateResponse{Valid: false, Suspended: true, ExpiresAt: expires, Reason: "suspended"}, nil
+	}
+	if now.After(graceEnd) {
+		return st, ValidateResponse{Valid: false, ExpiresAt: expires, Reason: "expired"}, nil
+	}
+	activations, err := countActivations(ctx, db, req.LicenseKey)
+	if err != nil {
+		return st, ValidateResponse{}, fmt.Errorf("activations: %w", err)
+	}
+	if activations > st.MaxActivations {
+		return st, ValidateResponse{Valid: false, ExpiresAt: expires, Reason: "seat limit exceeded"}, nil
+	}
+	resp := ValidateResponse{Valid: true, ExpiresAt: expires}
+	if now.After(expires) {
+		resp.Grace = true
+		resp.GraceDaysRemaining = int(math.Ceil(graceEnd.Sub(now).Hours() / 24))
+	}
+	return st, resp, nil
+}
+
+func Heartbeat(db *sql.DB, cfg *config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		body, ok := bufferBody(w, r)
+		if !ok {
+			return
+		}
 		var req ValidateRequest
 		if !decodeJSON(w, r, &req) {
 			return
@@ -251,15 +642,31 @@ func Heartbeat(db *sql.DB) http.Handler {
 			http.Error(w, "license_key required", http.StatusBadRequest)
 			return
 		}
+		if err := req.Machine.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !requireClientCert(w, r, db, cfg, req.LicenseKey, req.MachineID) {
+			return
+		}
+		if !requireRequestSignature(w, r, db, req.LicenseKey, body) {
+			return
+		}
 		ctx := r.Context()
-		res, err := db.ExecContext(ctx, `update licenses set last_seen_at=CURRENT_TIMESTAMP, updated_at=CURRENT_TIMESTAMP where license_key=$1`, req.LicenseKey)
+		err := dbStore(db, cfg).Touch(ctx, req.LicenseKey, time.Now())
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			internalError(w, "heartbeat.update", err)
 			return
 		}
-		if n, _ := res.RowsAffected(); n == 0 {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
+		if req.MachineID != "" {
+			if err := recordMachine(ctx, db, cfg, req.MachineID, req.LicenseKey, req.Machine); err != nil {
+				internalError(w, "heartbeat.machine", err)
+				return
+			}
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"ok":true}`))
@@ -268,7 +675,7 @@ func Heartbeat(db *sql.DB) http.Handler {
 
 func UpdateLicense(db *sql.DB, cfg *config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
+		if r.Method != http.MethodPost && r.Method != http.MethodPatch {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
@@ -281,10 +688,7 @@ func UpdateLicense(db *sql.DB, cfg *config.Config) http.Handler {
 			return
 		}
 
-		ctx := r.Context()
-		updates := make([]string, 0, 3)
-		args := make([]any, 0, 3)
-
+		var u store.LicenseUpdate
 		if req.ExpiresAt != nil {
 			parsed, err := time.Parse(time.RFC3339Nano, *req.ExpiresAt)
 			if err != nil {
@@ -294,45 +698,45 @@ func UpdateLicense(db *sql.DB, cfg *config.Config) http.Handler {
 				http.Error(w, "expires_at must be RFC3339", http.StatusBadRequest)
 				return
 			}
-			parsed = parsed.UTC()
-			updates = append(updates, fmt.Sprintf("expires_at=$%d", len(args)+1))
-			if cfg != nil && cfg.DB.Driver == "sqlite3" {
-				args = append(args, parsed.Format(time.RFC3339Nano))
-			} else {
-				args = append(args, parsed)
-			}
+			u.ExpiresAt = &parsed
 		}
-
-		if req.Features != nil {
-			featuresJSON, err := json.Marshal(req.Features)
-			if err != nil {
-				http.Error(w, "bad features payload", http.StatusBadRequest)
-				return
+		if req.MaxActivations != nil && *req.MaxActivations < 1 {
+			http.Error(w, "max_activations must be positive", http.StatusBadRequest)
+			return
+		}
+		if req.FloatingSeats != nil && *req.FloatingSeats < 0 {
+			http.Error(w, "floating_seats must not be negative", http.StatusBadRequest)
+			return
+		}
+		if req.GraceDays != nil && *req.GraceDays < 0 {
+			http.Error(w, "grace_days must not be negative", http.StatusBadRequest)
+			return
+		}
+		if req.Entitlements != nil {
+			var schema entitlements.Schema
+			if cfg != nil {
+				schema = cfg.Entitlements
 			}
-			clause := fmt.Sprintf("features=$%d", len(args)+1)
-			if cfg != nil && cfg.DB.Driver != "sqlite3" {
-				clause += "::jsonb"
+			if err := schema.Validate(req.Entitlements); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			updates = append(updates, clause)
-			args = append(args, string(featuresJSON))
 		}
-
-		if len(updates) == 0 {
+		u.Features, u.Entitlements, u.Metadata = req.Features, req.Entitlements, req.Metadata
+		u.MaxActivations, u.FloatingSeats, u.GraceDays = req.MaxActivations, req.FloatingSeats, req.GraceDays
+		u.Notes, u.RequireNonce = req.Notes, req.RequireNonce
+		if u.Empty() {
 			http.Error(w, "no updates requested", http.StatusBadRequest)
 			return
 		}
 
-		updates = append(updates, "updated_at=CURRENT_TIMESTAMP")
-		args = append(args, req.LicenseKey)
-		query := fmt.Sprintf("update licenses set %s where license_key=$%d", strings.Join(updates, ", "), len(args))
-
-		res, err := db.ExecContext(ctx, query, args...)
-		if err != nil {
-			internalError(w, "license.update", err)
+		err := dbStore(db, cfg).Update(withAudit(r, AuditLicenseUpdate, req.auditDetails()), req.LicenseKey, u)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		if n, _ := res.RowsAffected(); n == 0 {
-			http.Error(w, "not found", http.StatusNotFound)
+		if err != nil {
+			internalError(w, "license.update", err)
 			return
 		}
 
@@ -340,7 +744,16 @@ func UpdateLicense(db *sql.DB, cfg *config.Config) http.Handler {
 	})
 }
 
+// ListLicenses pages through licenses, newest first. With Accept:
+// application/x-ndjson (or ?stream=1) it instead writes every match, one
+// license per line, as rows are read; ?limit= is then optional and there is
+// no next_cursor.
 func ListLicenses(db *sql.DB, cfg *config.Config) http.Handler {
+	return ListLicensesFrom(NewReadDB(db, nil), cfg)
+}
+
+// ListLicensesFrom is ListLicenses reading from reads' replica.
+func ListLicensesFrom(reads *ReadDB, cfg *config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -348,56 +761,123 @@ func ListLicenses(db *sql.DB, cfg *config.Config) http.Handler {
 		}
 
 		ctx := r.Context()
-		rows, err := db.QueryContext(ctx, `select id, license_key, customer, machine_id, features, expires_at, revoked, last_seen_at from licenses order by created_at desc`)
+		query := `select ` + licenseSummaryColumns + `, created_at from licenses`
+		var conds []string
+		var args []any
+		q := r.URL.Query()
+		stream := wantsNDJSON(r)
+		limit := defaultListLimit
+		if stream {
+			limit = 0 // everything
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxListLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if v := q.Get("cursor"); v != "" {
+			createdAt, id, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, createdAt, id)
+			conds = append(conds, keysetCond("created_at", "id", "<", len(args)-1))
+		}
+		if product := q.Get("product_id"); product != "" {
+			args = append(args, product)
+			conds = append(conds, fmt.Sprintf("product_id=$%d", len(args)))
+		}
+		if tenant := q.Get("tenant"); tenant != "" {
+			args = append(args, tenant)
+			conds = append(conds, fmt.Sprintf("tenant=$%d", len(args)))
+		}
+		if kid := q.Get("key_id"); kid != "" {
+			args = append(args, kid)
+			conds = append(conds, fmt.Sprintf("signing_key_id=$%d", len(args)))
+		}
+		if customer := q.Get("customer"); customer != "" {
+			args = append(args, "%"+store.EscapeLike(customer)+"%")
+			conds = append(conds, fmt.Sprintf(`customer %s $%d escape '\'`, likeOp(cfg), len(args)))
+		}
+		if machine := q.Get("machine_id"); machine != "" {
+			args = append(args, machine)
+			conds = append(conds, fmt.Sprintf("(machine_id=$%d or exists (select 1 from activations a where a.license_key=licenses.license_key and a.machine_id=$%d))", len(args), len(args)))
+		}
+		if v := q.Get("revoked"); v != "" {
+			revoked, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "revoked must be true or false", http.StatusBadRequest)
+				return
+			}
+			args = append(args, revoked)
+			conds = append(conds, fmt.Sprintf("revoked=$%d", len(args)))
+		}
+		for _, f := range []struct{ param, op string }{{"expires_before", "<"}, {"expires_after", ">"}} {
+			v := q.Get(f.param)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, f.param+" must be RFC3339", http.StatusBadRequest)
+				return
+			}
+			args = append(args, dbTime(cfg, t))
+			conds = append(conds, fmt.Sprintf("expires_at %s $%d", f.op, len(args)))
+		}
+		if q.Get("include_archived") != "true" {
+			conds = append(conds, "archived_at is null")
+		}
+		conds, args = scopeConds(r, conds, args)
+		if len(conds) > 0 {
+			query += " where " + strings.Join(conds, " and ")
+		}
+		query += " order by created_at desc, id desc"
+		switch {
+		case stream && limit > 0:
+			query += fmt.Sprintf(" limit %d", limit)
+		case !stream:
+			// fetch one extra row to learn whether another page follows
+			query += fmt.Sprintf(" limit %d", limit+1)
+		}
+		var rows *sql.Rows
+		err := reads.read(ctx, "licenses.list", func(db *sql.DB) (err error) {
+			rows, err = db.QueryContext(ctx, query, args...)
+			return err
+		})
 		if err != nil {
 			internalError(w, "licenses.list.query", err)
 			return
 		}
 		defer rows.Close()
+		if stream {
+			streamNDJSON(w, rows, "licenses.list", func(sc rowScanner) (any, error) {
+				var createdAt nullTime
+				return scanLicenseSummary(sc, cfg, &createdAt)
+			})
+			return
+		}
 
 		resp := ListLicensesResponse{}
+		var lastCreatedAt string
 		for rows.Next() {
-			var sum LicenseSummary
-			if cfg != nil && cfg.DB.Driver == "sqlite3" {
-				var features string
-				var expires string
-				var lastSeen sql.NullString
-				if err := rows.Scan(&sum.ID, &sum.LicenseKey, &sum.Customer, &sum.MachineID, &features, &expires, &sum.Revoked, &lastSeen); err != nil {
-					internalError(w, "licenses.list.scan", err)
-					return
-				}
-				sum.ExpiresAt = expires
-				if features != "" {
-					var feats map[string]any
-					if err := json.Unmarshal([]byte(features), &feats); err == nil {
-						sum.Features = feats
-					}
-				}
-				if lastSeen.Valid && lastSeen.String != "" {
-					ls := lastSeen.String
-					sum.LastSeenAt = &ls
-				}
-			} else {
-				var features []byte
-				var expires time.Time
-				var lastSeen sql.NullTime
-				if err := rows.Scan(&sum.ID, &sum.LicenseKey, &sum.Customer, &sum.MachineID, &features, &expires, &sum.Revoked, &lastSeen); err != nil {
-					internalError(w, "licenses.list.scan", err)
-					return
-				}
-				sum.ExpiresAt = expires.UTC().Format(time.RFC3339Nano)
-				if len(features) > 0 {
-					var feats map[string]any
-					if err := json.Unmarshal(features, &feats); err == nil {
-						sum.Features = feats
-					}
-				}
-				if lastSeen.Valid {
-					ls := lastSeen.Time.UTC().Format(time.RFC3339Nano)
-					sum.LastSeenAt = &ls
-				}
+			if len(resp.Licenses) == limit {
+				last := resp.Licenses[limit-1]
+				resp.NextCursor = encodeListCursor(lastCreatedAt, last.ID)
+				break
+			}
+			var createdAt nullTime
+			sum, err := scanLicenseSummary(rows, cfg, &createdAt)
+			if err != nil {
+				internalError(w, "licenses.list.scan", err)
+				return
 			}
 			resp.Licenses = append(resp.Licenses, sum)
+			lastCreatedAt = cursorTime(cfg, createdAt.Time)
 		}
 		if err := rows.Err(); err != nil {
 			internalError(w, "licenses.list.rows", err)
@@ -407,8 +887,207 @@ func ListLicenses(db *sql.DB, cfg *config.Config) http.Handler {
 	})
 }
 
+// isSQLite reports whether the configured driver stores times and JSON as TEXT.
+func isSQLite(cfg *config.Config) bool {
+	return cfg != nil && cfg.DB.Driver == "sqlite3"
+}
+
+// dbStore returns the store.Store of the configured driver, working through
+// q.
+func dbStore(q store.Querier, cfg *config.Config) store.Store {
+	return store.New(q, driverName(cfg))
+}
+
+// dialect returns the configured driver's store.Dialect.
+func dialect(cfg *config.Config) store.Dialect {
+	return store.DialectFor(driverName(cfg))
+}
+
+func driverName(cfg *config.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	return cfg.DB.Driver
+}
+
+// jsonCast is appended to a placeholder for a JSON column in an UPDATE.
+func jsonCast(cfg *config.Config) string { return dialect(cfg).JSONCast() }
+
+// likeOp returns the case-insensitive LIKE operator for the driver.
+func likeOp(cfg *config.Config) string { return dialect(cfg).Like() }
+
+// licenseState is a license as the client-facing endpoints see it when
+// deciding whether it is usable.
+type licenseState struct {
+	store.License
+	Archived bool
+}
+
+// licenseSummaryColumns are the columns scanLicenseSummary expects, in order.
+const licenseSummaryColumns = store.LicenseColumns
+
+type rowScanner = store.Scanner
+
+// scanLicenseSummary scans licenseSummaryColumns followed by any extra
+// columns into extra.
+func scanLicenseSummary(sc rowScanner, cfg *config.Config, extra ...any) (LicenseSummary, error) {
+	l, err := store.ScanLicense(sc, extra...)
+	if err != nil {
+		return LicenseSummary{}, err
+	}
+	return licenseSummary(l), nil
+}
+
+// licenseSummary is how the admin API shows l.
+func licenseSummary(l store.License) LicenseSummary {
+	sum := LicenseSummary{
+		ID:             l.ID,
+		LicenseKey:     l.LicenseKey,
+		ProductID:      l.ProductID,
+		Customer:       l.Customer,
+		MachineID:      l.MachineID,
+		ExpiresAt:      l.ExpiresAt.Format(time.RFC3339Nano),
+		Revoked:        l.Revoked,
+		Suspended:      l.Suspended,
+		MaxActivations: l.MaxActivations,
+		FloatingSeats:  l.FloatingSeats,
+		GraceDays:      l.GraceDays,
+		Features:       l.Features,
+		Entitlements:   l.Entitlements,
+		Metadata:       l.Metadata,
+		Notes:          l.Notes,
+		Tenant:         l.Tenant,
+		KeyID:          l.SigningKeyID,
+	}
+	if l.LastSeenAt != nil {
+		ls := l.LastSeenAt.Format(time.RFC3339Nano)
+		sum.LastSeenAt = &ls
+	}
+	if l.ArchivedAt != nil {
+		at := l.ArchivedAt.Format(time.RFC3339Nano)
+		sum.ArchivedAt = &at
+	}
+	return sum
+}
+
+// encodeListCursor makes the opaque next_cursor for ListLicenses from the
+// last row's created_at (as the driver returns it) and id.
+func encodeListCursor(createdAt, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt + "|" + id))
+}
+
+// listLimit reads ?limit= for the paginated lists, answering 400 when it is
+// out of range.
+func listLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultListLimit, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxListLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// cursorTime formats t for a list cursor. The cursor has to compare equal
+// to the stored column value.
+func cursorTime(cfg *config.Config, t time.Time) string { return dialect(cfg).CursorTime(t) }
+
+// keysetCond selects the rows past a list cursor whose time and id are
+// arguments $n and $n+1, for a list ordered by timeCol, idCol: descending
+// with op "<", ascending with ">". The bare bound on timeCol lets the
+// database seek straight to the cursor in an index ending in (timeCol,
+// idCol), where the equivalent or-expression alone makes it scan every
+// earlier page.
+func keysetCond(timeCol, idCol, op string, n int) string {
+	return fmt.Sprintf("(%[1]s %[3]s= $%[4]d and (%[1]s %[3]s $%[4]d or %[2]s %[3]s $%[5]d))", timeCol, idCol, op, n, n+1)
+}
+
+func decodeListCursor(cursor string) (createdAt, id string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", err
+	}
+	createdAt, id, ok := strings.Cut(string(b), "|")
+	if !ok || createdAt == "" || id == "" {
+		return "", "", errors.New("malformed cursor")
+	}
+	return createdAt, id, nil
+}
+
+// issueRequest rebuilds the request a license was issued from, as far as it
+// is covered by the signed license file.
+func (st licenseState) issueRequest() IssueRequest {
+	req := IssueRequest{
+		ProductID:      st.ProductID,
+		Tenant:         st.Tenant,
+		Customer:       st.Customer,
+		MachineID:      st.MachineID,
+		ExpiresAt:      st.ExpiresAt,
+		Features:       st.Features,
+		MaxActivations: st.MaxActivations,
+		FloatingSeats:  st.FloatingSeats,
+		Entitlements:   st.Entitlements,
+
+		FeaturesRecipientKey: st.FeaturesRecipientKey,
+	}
+	if req.Entitlements == nil {
+		req.Entitlements = entitlements.Set{}
+	}
+	if st.GraceDays != nil {
+		d := *st.GraceDays
+		req.GraceDays = &d
+	}
+	return req
+}
+
+// graceEnd is the instant after which the license no longer validates.
+func (st licenseState) graceEnd(cfg *config.Config) time.Time {
+	return st.ExpiresAt.Add(gracePeriod(cfg, st.GraceDays))
+}
+
+// loadLicenseState reads a license by key. forUpdate locks the row where
+// the driver can and must only be used inside a transaction.
+func loadLicenseState(ctx context.Context, q store.Querier, cfg *config.Config, licenseKey string, forUpdate bool) (licenseState, error) {
+	l, err := dbStore(q, cfg).Get(ctx, licenseKey, forUpdate)
+	if err != nil {
+		return licenseState{}, err
+	}
+	if l.Entitlements == nil {
+		l.Entitlements = entitlements.Set{}
+	}
+	return licenseState{License: l, Archived: l.ArchivedAt != nil}, nil
+}
+
+// gracePeriod returns how long past expires_at a license keeps validating:
+// the per-license grace_days when set, else licensing.grace_days.
+func gracePeriod(cfg *config.Config, perLicense *int) time.Duration {
+	days := 0
+	if cfg != nil {
+		days = cfg.Licensing.GraceDays
+	}
+	if perLicense != nil {
+		days = *perLicense
+	}
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// dbTime converts t into the representation the configured driver expects.
+func dbTime(cfg *config.Config, t time.Time) any { return dialect(cfg).Time(t) }
+
+// nullTime scans a timestamp column from any driver.
+type nullTime = store.NullTime
+
 func internalError(w http.ResponseWriter, op string, err error) {
-	log.Printf("handler error op=%s err=%v", op, err)
+	logging.Errorf("handler error op=%s err=%v", op, err)
+	if rw, ok := w.(interface{ reportError(string, error) }); ok {
+		rw.reportError(op, err) // ReportErrors
+	}
 	http.Error(w, "internal server error", http.StatusInternalServerError)
 }
 
@@ -418,15 +1097,63 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// pathParams is implemented by request bodies whose identifying field can also
+// come from the URL on the RESTful routes (e.g. POST /api/v1/licenses/{license_key}/revoke).
+// fromPath copies the path values into the request and reports whether any were set.
+type pathParams interface {
+	fromPath(r *http.Request) bool
+}
+
+func (req *ValidateRequest) fromPath(r *http.Request) bool {
+	return setFromPath(r, "license_key", &req.LicenseKey)
+}
+
+func (req *UpdateLicenseRequest) fromPath(r *http.Request) bool {
+	return setFromPath(r, "license_key", &req.LicenseKey)
+}
+
+func setFromPath(r *http.Request, name string, dst *string) bool {
+	if v := r.PathValue(name); v != "" {
+		*dst = v
+		return true
+	}
+	return false
+}
+
+// licenseKeyParam returns the {license_key} path value, falling back to the
+// ?license_key= query parameter used by the legacy routes.
+func licenseKeyParam(r *http.Request) string {
+	if v := r.PathValue("license_key"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("license_key")
+}
+
 func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
-	limited := http.MaxBytesReader(w, r.Body, maxJSONBody)
+	return decodeJSONLimit(w, r, v, maxJSONBody)
+}
+
+// decodeJSONLimit is decodeJSON with a caller-chosen body size cap, for the
+// few endpoints (batch issuance) that legitimately take large payloads. A
+// limit the server set for the route (server.body_limits) wins over either.
+func decodeJSONLimit(w http.ResponseWriter, r *http.Request, v any, limit int64) bool {
+	limited := http.MaxBytesReader(w, r.Body, middleware.BodyLimit(r, limit))
 	defer limited.Close()
 
 	dec := json.NewDecoder(limited)
-	if err := dec.Decode(v); err != nil {
+	err := dec.Decode(v)
+	// path values win over the body, and make the body optional
+	fromPath := false
+	if pp, ok := v.(pathParams); ok {
+		fromPath = pp.fromPath(r)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) && fromPath {
+			return true
+		}
 		var maxErr *http.MaxBytesError
 		if errors.As(err, &maxErr) {
-			log.Printf("request body too large path=%s remote=%s", r.URL.Path, r.RemoteAddr)
+			logging.Warnf("request body too large path=%s remote=%s", r.URL.Path, r.RemoteAddr)
 			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
 			return false
 		}
@@ -1,21 +1,63 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	gocrypto "crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/digitorus/timestamp"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/rpattn/raalisence/internal/config"
 	"github.com/rpattn/raalisence/internal/crypto"
+	migrate "github.com/rpattn/raalisence/internal/db/migrations_sqlite"
+	"github.com/rpattn/raalisence/internal/entitlements"
+	"github.com/rpattn/raalisence/internal/errreport"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/metrics"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/offline"
+	"github.com/rpattn/raalisence/internal/oidc"
+	"github.com/rpattn/raalisence/internal/oidc/oidctest"
+	"github.com/rpattn/raalisence/internal/store"
+	"github.com/rpattn/raalisence/internal/totp"
+	"github.com/rpattn/raalisence/pkg/licensefile"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -34,19 +76,17 @@ func TestIssueValidateFlow(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// naive create table for test (ok if already exists)
-	_, _ = db.Exec(`create table if not exists licenses (
-		id uuid primary key,
-		license_key text unique not null,
-		customer text not null,
-		machine_id text not null,
-		features jsonb not null default '{}',
-		expires_at timestamptz not null,
-		revoked boolean not null default false,
-		last_seen_at timestamptz null,
-		created_at timestamptz not null default now(),
-		updated_at timestamptz not null default now()
-	)`)
+	// apply the (idempotent) postgres migrations
+	files, _ := filepath.Glob("../db/migrations/*.sql")
+	for _, f := range files {
+		body, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(body)); err != nil {
+			t.Fatalf("migrate %s: %v", f, err)
+		}
+	}
 
 	cfg := testConfig(t)
 
@@ -92,29 +132,9 @@ func TestDecodeJSONBodyTooLarge(t *testing.T) {
 }
 
 func TestListLicensesSQLite(t *testing.T) {
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer db.Close()
-
-	_, err = db.Exec(`create table licenses (
-                id text primary key,
-                license_key text unique not null,
-                customer text not null,
-                machine_id text not null,
-                features text not null default '{}',
-                expires_at text not null,
-                revoked integer not null default 0,
-                last_seen_at text null,
-                created_at text not null default current_timestamp,
-                updated_at text not null default current_timestamp
-        )`)
-	if err != nil {
-		t.Fatal(err)
-	}
+	db := testSQLiteDB(t)
 
-	_, err = db.Exec(`insert into licenses (id, license_key, customer, machine_id, features, expires_at, revoked) values
+	_, err := db.Exec(`insert into licenses (id, license_key, customer, machine_id, features, expires_at, revoked) values
                 ('id-1', 'key-1', 'Acme', 'MID-1', '{}', '2025-01-01T00:00:00Z', 0),
                 ('id-2', 'key-2', 'Beta', 'MID-2', '{}', '2026-01-01T00:00:00Z', 1)
         `)
@@ -145,21 +165,3894 @@ func TestListLicensesSQLite(t *testing.T) {
 	}
 }
 
-// minimal config with ephemeral keys for tests.
-func testConfig(t *testing.T) *config.Config {
-	t.Helper()
+func TestActivateSeatLimitSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), MaxActivations: 2})
+	if lf.MaxActivations != 2 {
+		t.Fatalf("expected max_activations=2 got %d", lf.MaxActivations)
+	}
+
+	activate := func(machine string) (int, ActivateResponse) {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: machine})
+		rr := httptest.NewRecorder()
+		ActivateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/activate", bytes.NewReader(b)))
+		var resp ActivateResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	if code, resp := activate("MID-1"); code != http.StatusOK || resp.Activations != 1 {
+		t.Fatalf("re-activating issuing machine: code=%d resp=%+v", code, resp)
+	}
+	if code, resp := activate("MID-2"); code != http.StatusOK || resp.Activations != 2 {
+		t.Fatalf("second seat: code=%d resp=%+v", code, resp)
+	}
+	if code, resp := activate("MID-3"); code != http.StatusConflict || resp.Reason != "seat limit exceeded" {
+		t.Fatalf("third seat: code=%d resp=%+v", code, resp)
+	}
+
+	// lowering the limit below current usage over-subscribes the license
+	if _, err := db.Exec(`update licenses set max_activations=1 where license_key=$1`, lf.LicenseKey); err != nil {
+		t.Fatal(err)
+	}
+	resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+	if resp.Valid || resp.Reason != "seat limit exceeded" {
+		t.Fatalf("expected seat limit exceeded, got %+v", resp)
+	}
+}
+
+func TestMultiMachineActivationsSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), MaxActivations: 2})
+
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-2"); resp.Valid || resp.Reason != "machine mismatch" {
+		t.Fatalf("unactivated machine should fail, got %+v", resp)
+	}
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-2"})
+	rr := httptest.NewRecorder()
+	ActivateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/activate", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("activate: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	for _, m := range []string{"MID-1", "MID-2"} {
+		if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, m); !resp.Valid {
+			t.Fatalf("%s should validate, got %+v", m, resp)
+		}
+	}
+
+	list := func() ListActivationsResponse {
+		rr := httptest.NewRecorder()
+		ListActivations(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/activations?license_key="+lf.LicenseKey, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list: code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp ListActivationsResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+	if got := list(); len(got.Activations) != 2 || got.MaxActivations != 2 {
+		t.Fatalf("expected 2 of 2 activations, got %+v", got)
+	}
+
+	b, _ = json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-1"})
+	rr = httptest.NewRecorder()
+	DeactivateMachine(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/deactivate", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("deactivate: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); resp.Valid {
+		t.Fatalf("deactivated machine should fail, got %+v", resp)
+	}
+	if got := list(); len(got.Activations) != 1 || got.Activations[0].MachineID != "MID-2" {
+		t.Fatalf("expected only MID-2 left, got %+v", got)
+	}
+}
+
+func TestLicenseActivitySQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lic := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	for i := 0; i < 2; i++ {
+		if resp := validateTestLicense(t, db, cfg, lic.LicenseKey, "MID-1"); !resp.Valid {
+			t.Fatalf("validate: %+v", resp)
+		}
+	}
+	if resp := validateTestLicense(t, db, cfg, lic.LicenseKey, "MID-2"); resp.Valid {
+		t.Fatalf("expected machine mismatch: %+v", resp)
+	}
+	validateTestLicense(t, db, cfg, "nope", "MID-1")
+
+	get := func(key, query string) (int, LicenseActivity) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/licenses/"+key+"/activity"+query, nil)
+		req.SetPathValue("license_key", key)
+		rr := httptest.NewRecorder()
+		GetLicenseActivity(db, cfg).ServeHTTP(rr, req)
+		var a LicenseActivity
+		_ = json.Unmarshal(rr.Body.Bytes(), &a)
+		return rr.Code, a
+	}
+	code, a := get(lic.LicenseKey, "")
+	if code != http.StatusOK {
+		t.Fatalf("activity code=%d", code)
+	}
+	if a.Attempts != 3 || a.Failures != 1 || a.DistinctMachines != 2 || a.DistinctIPs != 1 {
+		t.Fatalf("unexpected totals %+v", a)
+	}
+	if a.LastValidatedAt == nil || a.LastAttemptAt == nil || len(a.Recent) != 3 {
+		t.Fatalf("unexpected activity %+v", a)
+	}
+	if _, a := get(lic.LicenseKey, "?limit=1"); len(a.Recent) != 1 {
+		t.Fatalf("limit: %+v", a.Recent)
+	}
+	if code, _ := get(lic.LicenseKey, "?days=0"); code != http.StatusBadRequest {
+		t.Fatalf("days=0 code=%d", code)
+	}
+	if code, _ := get("nope", ""); code != http.StatusNotFound {
+		t.Fatalf("unknown license code=%d", code)
+	}
+}
+
+func TestLicenseHistorySQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lic := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+	post := func(h http.Handler, v any) {
+		t.Helper()
+		b, _ := json.Marshal(v)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("code=%d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	renewed := "2031-01-01T00:00:00Z"
+	post(UpdateLicense(db, cfg), UpdateLicenseRequest{LicenseKey: lic.LicenseKey, ExpiresAt: &renewed})
+	post(UpdateLicense(db, cfg), UpdateLicenseRequest{LicenseKey: lic.LicenseKey, ExpiresAt: &renewed}) // no change
+	post(RevokeLicense(db, cfg), ValidateRequest{LicenseKey: lic.LicenseKey})
+	validateTestLicense(t, db, cfg, lic.LicenseKey, "MID-1") // last_seen_at is not history
+
+	get := func(key, query string) (int, LicenseHistoryResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/licenses/"+key+"/history"+query, nil)
+		req.SetPathValue("license_key", key)
+		rr := httptest.NewRecorder()
+		GetLicenseHistory(db, cfg).ServeHTTP(rr, req)
+		var h LicenseHistoryResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &h)
+		return rr.Code, h
+	}
+	code, h := get(lic.LicenseKey, "")
+	if code != http.StatusOK || len(h.Entries) != 3 {
+		t.Fatalf("history code=%d %+v", code, h)
+	}
+	revoke, update, issue := h.Entries[0], h.Entries[1], h.Entries[2]
+	if revoke.Action != AuditLicenseRevoke || len(revoke.Changes) != 1 || revoke.Changes["revoked"] != (FieldChange{Before: false, After: true}) {
+		t.Fatalf("revoke entry %+v", revoke)
+	}
+	if c := update.Changes["expires_at"]; update.Action != AuditLicenseUpdate || len(update.Changes) != 1 || c.Before == nil || c.After == c.Before {
+		t.Fatalf("update entry %+v", update)
+	}
+	if c := issue.Changes["customer"]; issue.Action != AuditLicenseIssue || c.Before != nil || c.After != "Acme" || issue.Actor != "unknown" {
+		t.Fatalf("issue entry %+v", issue)
+	}
+
+	_, page := get(lic.LicenseKey, "?limit=2")
+	if len(page.Entries) != 2 || page.NextCursor == "" {
+		t.Fatalf("first page %+v", page)
+	}
+	if _, rest := get(lic.LicenseKey, "?limit=2&cursor="+page.NextCursor); len(rest.Entries) != 1 || rest.Entries[0].ID != issue.ID {
+		t.Fatalf("second page %+v", rest)
+	}
+	if code, _ := get("nope", ""); code != http.StatusNotFound {
+		t.Fatalf("unknown license code=%d", code)
+	}
+}
+
+func TestMachinesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	a := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), MaxActivations: 2})
+	b := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	post := func(h http.Handler, req ValidateRequest) int {
+		body, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+		return rr.Code
+	}
+	info := &MachineInfo{Hostname: "build-01", OS: "linux", Fingerprint: map[string]string{"cpu": "x86", "mac": "aa:bb"}}
+	if code := post(ActivateLicense(db, cfg), ValidateRequest{LicenseKey: a.LicenseKey, MachineID: "MID-1", Machine: info}); code != http.StatusOK {
+		t.Fatalf("activate code=%d", code)
+	}
+	if code := post(ActivateLicense(db, cfg), ValidateRequest{LicenseKey: a.LicenseKey, MachineID: "MID-2"}); code != http.StatusOK {
+		t.Fatalf("activate MID-2 code=%d", code)
+	}
+	// a heartbeat without details keeps what activation reported
+	if code := post(Heartbeat(db, cfg), ValidateRequest{LicenseKey: b.LicenseKey, MachineID: "MID-1"}); code != http.StatusOK {
+		t.Fatalf("heartbeat code=%d", code)
+	}
+	huge := &MachineInfo{Fingerprint: map[string]string{"disk": strings.Repeat("x", maxMachineFieldLen+1)}}
+	if code := post(Heartbeat(db, cfg), ValidateRequest{LicenseKey: b.LicenseKey, MachineID: "MID-1", Machine: huge}); code != http.StatusBadRequest {
+		t.Fatalf("oversized fingerprint code=%d", code)
+	}
+
+	get := func(h http.Handler, target string, v any) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if id, ok := strings.CutPrefix(target, "/api/v1/machines/"); ok {
+			req.SetPathValue("machine_id", id)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		_ = json.Unmarshal(rr.Body.Bytes(), v)
+		return rr.Code
+	}
+	var m MachineDetail
+	if code := get(GetMachine(db), "/api/v1/machines/MID-1", &m); code != http.StatusOK {
+		t.Fatalf("get code=%d", code)
+	}
+	if m.Hostname != "build-01" || m.OS != "linux" || m.Fingerprint["mac"] != "aa:bb" || m.LastLicenseKey != b.LicenseKey {
+		t.Fatalf("unexpected machine %+v", m.Machine)
+	}
+	// activations made in the same second have no defined order
+	if len(m.Licenses) != 2 || m.Licenses[0].Customer == m.Licenses[1].Customer {
+		t.Fatalf("expected the Acme and Beta licenses, got %+v", m.Licenses)
+	}
+	if code := get(GetMachine(db), "/api/v1/machines/MID-9", &m); code != http.StatusNotFound {
+		t.Fatalf("unknown machine code=%d", code)
+	}
+
+	var list ListMachinesResponse
+	if get(ListMachines(db, cfg), "/api/v1/machines?hostname=BUILD", &list); len(list.Machines) != 1 || list.Machines[0].MachineID != "MID-1" {
+		t.Fatalf("hostname filter: %+v", list)
+	}
+	if get(ListMachines(db, cfg), "/api/v1/machines?license_key="+b.LicenseKey, &list); len(list.Machines) != 1 {
+		t.Fatalf("license_key filter: %+v", list)
+	}
+	list = ListMachinesResponse{}
+	if get(ListMachines(db, cfg), "/api/v1/machines?limit=1", &list); len(list.Machines) != 1 || list.NextCursor == "" {
+		t.Fatalf("first page: %+v", list)
+	}
+	first, cursor := list.Machines[0].MachineID, list.NextCursor
+	list = ListMachinesResponse{}
+	if get(ListMachines(db, cfg), "/api/v1/machines?limit=1&cursor="+cursor, &list); len(list.Machines) != 1 || list.Machines[0].MachineID == first || list.NextCursor != "" {
+		t.Fatalf("second page: %+v", list)
+	}
+
+	var acts ListActivationsResponse
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetPathValue("license_key", a.LicenseKey)
+	rr := httptest.NewRecorder()
+	ListActivations(db, cfg).ServeHTTP(rr, req)
+	_ = json.Unmarshal(rr.Body.Bytes(), &acts)
+	if len(acts.Activations) != 2 || acts.Activations[0].Hostname != "build-01" || acts.Activations[0].LastSeenAt == nil {
+		t.Fatalf("activations should carry machine details, got %+v", acts.Activations)
+	}
+	page := func(query string) (p ListActivationsResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/"+query, nil)
+		req.SetPathValue("license_key", a.LicenseKey)
+		rr := httptest.NewRecorder()
+		ListActivations(db, cfg).ServeHTTP(rr, req)
+		_ = json.Unmarshal(rr.Body.Bytes(), &p)
+		return p
+	}
+	if p := page("?limit=1"); len(p.Activations) != 1 || p.Activations[0].MachineID != "MID-1" || p.NextCursor == "" {
+		t.Fatalf("first activations page: %+v", p)
+	} else if p := page("?limit=1&cursor=" + p.NextCursor); len(p.Activations) != 1 || p.Activations[0].MachineID != "MID-2" || p.NextCursor != "" {
+		t.Fatalf("second activations page: %+v", p)
+	}
+}
+
+func TestOfflineActivationSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), MaxActivations: 2})
+
+	req := offline.Request{LicenseKey: lf.LicenseKey, MachineID: "AIR-1", Nonce: "nonce-1", CreatedAt: time.Now()}
+	code, err := offline.EncodeRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(OfflineActivateRequest{RequestCode: code})
+	rr := httptest.NewRecorder()
+	OfflineActivate(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/offline-activate", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("offline activate: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var out OfflineActivateResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := offline.DecodeResponse(out.ResponseCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := cfg.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Verify(pub, req); err != nil {
+		t.Fatalf("response should verify with server key: %v", err)
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "AIR-1"); !resp.Valid {
+		t.Fatalf("offline-activated machine should validate, got %+v", resp)
+	}
+
+	// seat rules still apply
+	req.MachineID, req.Nonce = "AIR-2", "nonce-2"
+	code, _ = offline.EncodeRequest(req)
+	b, _ = json.Marshal(OfflineActivateRequest{RequestCode: code})
+	rr = httptest.NewRecorder()
+	OfflineActivate(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/offline-activate", bytes.NewReader(b)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected seat limit, got code=%d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReissueLicenseSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{ProductID: "pro", Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), Features: map[string]any{"seats": 1}})
+	if _, err := db.Exec(`update licenses set features=$1 where license_key=$2`, `{"seats":5}`, lf.LicenseKey); err != nil {
+		t.Fatal(err)
+	}
+	// rotate: "pro" now signs with its own key
 	priv, pub, err := crypto.GeneratePEM()
 	if err != nil {
 		t.Fatal(err)
 	}
-	cfg := &config.Config{}
-	hash, err := bcrypt.GenerateFromPassword([]byte("test-admin"), bcrypt.DefaultCost)
+	cfg.Signing.Products = map[string]config.KeyPair{"pro": {PrivateKeyPEM: priv, PublicKeyPEM: pub}}
+
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey})
+	rr := httptest.NewRecorder()
+	ReissueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/reissue", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reissue: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var re LicenseFile
+	if err := json.Unmarshal(rr.Body.Bytes(), &re); err != nil {
+		t.Fatal(err)
+	}
+	if re.LicenseKey != lf.LicenseKey || re.PublicKey != pub || re.Signature == lf.Signature {
+		t.Fatalf("expected same key re-signed with rotated key, got %+v", re)
+	}
+	if seats, _ := re.Features["seats"].(float64); seats != 5 {
+		t.Fatalf("expected current features, got %v", re.Features)
+	}
+}
+
+func TestEncryptedFeaturesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	client, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	recipient := base64.RawURLEncoding.EncodeToString(client.PublicKey().Bytes())
+	features := map[string]any{"tier": "enterprise", "seats": float64(5)}
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), Features: features, FeaturesRecipientKey: recipient})
+	if lf.Features != nil || lf.EncryptedFeatures == nil {
+		t.Fatalf("expected only encrypted features, got %+v", lf)
+	}
+	lf.Entitlements = entitlements.Set{} // signed as {}, omitted from the file
+	verifyLicenseFile(t, lf)
+	var got map[string]any
+	if err := lf.EncryptedFeatures.OpenJSON(client, &got); err != nil || !reflect.DeepEqual(got, features) {
+		t.Fatalf("decrypted %v (%v)", got, err)
+	}
+
+	// reissued files stay encrypted to the same client
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey})
+	rr := httptest.NewRecorder()
+	ReissueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/reissue", bytes.NewReader(b)))
+	var re LicenseFile
+	if err := json.Unmarshal(rr.Body.Bytes(), &re); err != nil || re.EncryptedFeatures == nil {
+		t.Fatalf("reissue: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	re.Entitlements = entitlements.Set{} // signed as {}, omitted from the file
+	verifyLicenseFile(t, re)
+	if err := re.EncryptedFeatures.OpenJSON(client, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ = json.Marshal(IssueRequest{Customer: "Acme", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour), FeaturesRecipientKey: "nope"})
+	rr = httptest.NewRecorder()
+	IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/issue", bytes.NewReader(b)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad recipient key: code=%d", rr.Code)
+	}
+}
+
+func TestIssueCOSESQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	issue := func(format string, ir IssueRequest) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(ir)
+		rr := httptest.NewRecorder()
+		IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/issue?format="+format, bytes.NewReader(b)))
+		return rr
+	}
+	rr := issue("cose", IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: expires, Features: map[string]any{"pro": true}, MaxActivations: 2})
+	var cl CoseLicense
+	if err := json.Unmarshal(rr.Body.Bytes(), &cl); err != nil || rr.Code != http.StatusOK || cl.Format != "cose" {
+		t.Fatalf("issue code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	msg, err := base64.StdEncoding.DecodeString(cl.License)
 	if err != nil {
 		t.Fatal(err)
 	}
-	cfg.Server.AdminAPIKeyHashes = []string{string(hash)}
-	cfg.Server.Addr = ":0"
-	cfg.Signing.PrivateKeyPEM = priv
-	cfg.Signing.PublicKeyPEM = pub
-	return cfg
+	m, err := crypto.ParseCOSESign1(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := crypto.ParsePublicKey(cfg.Signing.PublicKeyPEM)
+	if err := m.Verify(pub); err != nil {
+		t.Fatal(err)
+	}
+	if m.KeyID != cl.KeyID || m.Alg != crypto.COSEAlgES256 {
+		t.Fatalf("headers: kid=%q alg=%d", m.KeyID, m.Alg)
+	}
+	var lic coseLicense
+	if err := cbor.Unmarshal(m.Payload, &lic); err != nil {
+		t.Fatal(err)
+	}
+	if lic.LicenseKey != cl.LicenseKey || lic.ExpiresAt != expires.Unix() || lic.MaxActivations != 2 || lic.Features["pro"] != true {
+		t.Fatalf("payload %+v", lic)
+	}
+	if resp := validateTestLicense(t, db, cfg, cl.LicenseKey, "MID-1"); !resp.Valid {
+		t.Fatalf("COSE-issued license does not validate: %+v", resp)
+	}
+
+	if rr := issue("xml", IssueRequest{Customer: "Acme", MachineID: "MID-2", ExpiresAt: expires}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: code=%d", rr.Code)
+	}
+}
+
+func TestIssueDetachedSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	b, _ := json.Marshal(IssueRequest{Customer: "Acme <EU>", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), Features: map[string]any{"seats": 3}})
+	rr := httptest.NewRecorder()
+	IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/issue?format=detached", bytes.NewReader(b)))
+	var dl DetachedLicense
+	if err := json.Unmarshal(rr.Body.Bytes(), &dl); err != nil || rr.Code != http.StatusOK || dl.Format != "detached" {
+		t.Fatalf("issue code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	body, _ := base64.StdEncoding.DecodeString(dl.License)
+	sig, _ := base64.StdEncoding.DecodeString(dl.Signature)
+
+	// what openssl dgst -sha256 -verify pub.pem -signature license.sig license.json checks
+	pub, err := crypto.ParsePublicKey(dl.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(body)
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), h[:], sig) {
+		t.Fatal("detached signature does not verify over the license body")
+	}
+	var lic map[string]any
+	if err := json.Unmarshal(body, &lic); err != nil || lic["license_key"] != dl.LicenseKey || lic["customer"] != "Acme <EU>" {
+		t.Fatalf("license body %s (%v)", body, err)
+	}
+	if _, ok := lic["signature"]; ok {
+		t.Fatal("license body carries the signature")
+	}
+}
+
+func TestValidationNonceSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	pub, err := crypto.ParsePublicKey(cfg.Signing.PublicKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func(req ValidateRequest) ValidateResponse {
+		t.Helper()
+		b, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		ValidateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/validate", bytes.NewReader(b)))
+		var resp ValidateResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("validate code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		return resp
+	}
+	challenge := func(key, machine string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: key, MachineID: machine})
+		rr := httptest.NewRecorder()
+		IssueChallenge(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/challenge", bytes.NewReader(b)))
+		return rr
+	}
+
+	// a client nonce is echoed and signed; plain validation stays unsigned
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	req := ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-1", Nonce: "client-nonce-1"}
+	resp := validate(req)
+	if !resp.Valid || resp.Nonce != req.Nonce || resp.Signature == "" || resp.ValidatedAt == nil {
+		t.Fatalf("expected signed valid response, got %+v", resp)
+	}
+	if ok, err := crypto.VerifyJSON(pub, resp.payload(req), resp.Signature); err != nil || !ok {
+		t.Fatalf("signature: ok=%v err=%v", ok, err)
+	}
+	forged := resp
+	forged.Nonce = "client-nonce-2"
+	if ok, _ := crypto.VerifyJSON(pub, forged.payload(req), resp.Signature); ok {
+		t.Fatal("signature verified for a different nonce")
+	}
+	if plain := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); plain.Signature != "" {
+		t.Fatalf("unexpected signature without a nonce: %+v", plain)
+	}
+
+	// require_nonce licenses need a fresh server nonce for this machine
+	lf = issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour), RequireNonce: true})
+	if resp := validate(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-2"}); resp.Valid || resp.Reason != "nonce required" {
+		t.Fatalf("expected nonce required, got %+v", resp)
+	}
+	if resp := validate(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-2", Nonce: "made-up"}); resp.Valid || resp.Reason != "invalid nonce" || resp.Signature == "" {
+		t.Fatalf("expected signed invalid nonce, got %+v", resp)
+	}
+	rr := challenge(lf.LicenseKey, "MID-2")
+	var ch ChallengeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &ch); err != nil || rr.Code != http.StatusOK || ch.Nonce == "" {
+		t.Fatalf("challenge code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	req = ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-2", Nonce: ch.Nonce}
+	if resp := validate(req); !resp.Valid || resp.Nonce != ch.Nonce {
+		t.Fatalf("expected valid with server nonce, got %+v", resp)
+	}
+	if resp := validate(req); resp.Valid || resp.Reason != "invalid nonce" {
+		t.Fatalf("nonce reused: %+v", resp)
+	}
+
+	// nonces are bound to the machine they were issued for
+	_ = json.Unmarshal(challenge(lf.LicenseKey, "MID-3").Body.Bytes(), &ch)
+	if resp := validate(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-2", Nonce: ch.Nonce}); resp.Valid {
+		t.Fatalf("nonce for another machine accepted: %+v", resp)
+	}
+	if rr := challenge("no-such-license", "MID-2"); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown license challenge code=%d", rr.Code)
+	}
+}
+
+func TestDownloadLicenseFileSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme Corp/EU", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	download := func(key string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		mux.Handle("GET /api/v1/licenses/{license_key}/file", DownloadLicenseFile(db, cfg))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/"+key+"/file", nil))
+		return rr
+	}
+
+	rr := download(lf.LicenseKey)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="Acme_CorpEU.lic"` {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+	var got LicenseFile
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || got.LicenseKey != lf.LicenseKey || got.Signature == "" {
+		t.Fatalf("bad license file (%v): %s", err, rr.Body.String())
+	}
+
+	if _, err := db.Exec(`update licenses set revoked=true where license_key=$1`, lf.LicenseKey); err != nil {
+		t.Fatal(err)
+	}
+	if rr := download(lf.LicenseKey); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for revoked license, got %d", rr.Code)
+	}
+	if rr := download("missing"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestIssueIdempotencyKeySQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	expires := time.Now().Add(time.Hour).UTC()
+	issue := func(key string, ir IssueRequest) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(ir)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses", bytes.NewReader(b))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		IssueLicense(db, cfg).ServeHTTP(rr, req)
+		return rr
+	}
+	ir := IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: expires}
+
+	first := issue("prov-42", ir)
+	retry := issue("prov-42", ir)
+	if first.Code != http.StatusOK || retry.Code != http.StatusOK {
+		t.Fatalf("codes %d/%d body=%s", first.Code, retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || first.Body.String() != retry.Body.String() {
+		t.Fatalf("expected identical replay:\n%s\n%s", first.Body.String(), retry.Body.String())
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from licenses`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("expected 1 license, got %d (%v)", n, err)
+	}
+
+	// same key, different request
+	ir.MachineID = "MID-2"
+	if rr := issue("prov-42", ir); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rr.Code)
+	}
+
+	// a key whose response was never stored (signing failed) is re-rendered
+	if _, err := db.Exec(`update idempotency_keys set response=null`); err != nil {
+		t.Fatal(err)
+	}
+	ir.MachineID = "MID-1"
+	rr := issue("prov-42", ir)
+	var lf LicenseFile
+	_ = json.Unmarshal(rr.Body.Bytes(), &lf)
+	var orig LicenseFile
+	_ = json.Unmarshal(first.Body.Bytes(), &orig)
+	if rr.Code != http.StatusOK || lf.LicenseKey != orig.LicenseKey || lf.Signature == "" {
+		t.Fatalf("expected re-rendered file for %s, got %d %s", orig.LicenseKey, rr.Code, rr.Body.String())
+	}
+
+	// expired keys are forgotten
+	if _, err := db.Exec(`update idempotency_keys set created_at=$1`, dbTime(cfg, time.Now().Add(-2*idempotencyTTL))); err != nil {
+		t.Fatal(err)
+	}
+	if rr := issue("prov-42", ir); rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected fresh issue after TTL, got %d", rr.Code)
+	}
+}
+
+func TestCertChainSigningSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	// an offline root signs the leaf for the configured key
+	issue := func(cn string, pub gocrypto.PublicKey, signer gocrypto.Signer, parent *x509.Certificate) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: cn},
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign, BasicConstraintsValid: true, IsCA: parent == nil,
+		}
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := issue("root", rootKey.Public(), rootKey, nil)
+	leafPub, _ := crypto.ParsePublicKey(cfg.Signing.PublicKeyPEM)
+	leaf := issue("leaf", leafPub, rootKey, root)
+	cfg.Signing.CertChainPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}))
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if len(lf.CertChain) != 1 {
+		t.Fatalf("expected the leaf in cert_chain, got %d certs", len(lf.CertChain))
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	if err := lf.Verify(LicenseTrust{Roots: roots}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.Verify(LicenseTrust{}); err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other := x509.NewCertPool()
+	other.AddCert(issue("other", otherKey.Public(), otherKey, nil))
+	if err := lf.Verify(LicenseTrust{Roots: other}); err == nil {
+		t.Fatal("verified against a root that did not issue the leaf")
+	}
+	forged := lf
+	forged.MaxActivations++
+	if err := forged.Verify(LicenseTrust{Roots: roots}); err == nil {
+		t.Fatal("tampered license verified")
+	}
+	forged = lf
+	forged.CertChain = nil
+	if err := forged.Verify(LicenseTrust{Roots: roots}); err == nil {
+		t.Fatal("verified without a chain")
+	}
+}
+
+func TestLicenseTimestampSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	// a self-signed TSA that answers RFC 3161 queries
+	tsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "tsa"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, tsaKey.Public(), tsaKey)
+	tsaCert, _ := x509.ParseCertificate(der)
+	var calls atomic.Int32
+	tsa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		req, err := timestamp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts := timestamp.Timestamp{HashAlgorithm: req.HashAlgorithm, HashedMessage: req.HashedMessage, Time: time.Now(),
+			Nonce: req.Nonce, Policy: []int{1, 2, 3}, AddTSACertificate: req.Certificates}
+		resp, _ := ts.CreateResponseWithOpts(tsaCert, tsaKey, gocrypto.SHA256)
+		w.Write(resp)
+	}))
+	defer tsa.Close()
+	cfg.Signing.Timestamp.URL = tsa.URL
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if lf.Timestamp == "" || calls.Load() != 1 {
+		t.Fatalf("expected a timestamped license, TSA called %d times", calls.Load())
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(tsaCert)
+	at, err := lf.VerifyTimestamp(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.Before(lf.IssuedAt.Add(-time.Second)) || time.Since(at) > time.Minute {
+		t.Fatalf("timestamp %v, issued %v", at, lf.IssuedAt)
+	}
+	if err := lf.Verify(LicenseTrust{}); err != nil {
+		t.Fatal(err)
+	}
+	forged := lf
+	forged.Signature = issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour)}).Signature
+	if _, err := forged.VerifyTimestamp(roots); err == nil {
+		t.Fatal("timestamp verified for another signature")
+	}
+
+	// a TSA outage only blocks issuance when the timestamp is required
+	tsa.Close()
+	lf = issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-3", ExpiresAt: time.Now().Add(time.Hour)})
+	if lf.Timestamp != "" {
+		t.Fatal("timestamp from a closed TSA")
+	}
+	cfg.Signing.Timestamp.Required = true
+	b, _ := json.Marshal(IssueRequest{Customer: "Acme", MachineID: "MID-4", ExpiresAt: time.Now().Add(time.Hour)})
+	rr := httptest.NewRecorder()
+	IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses", bytes.NewReader(b)))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 with a required TSA down, got %d", rr.Code)
+	}
+	// the license that couldn't be signed was never stored
+	var n int
+	if err := db.QueryRow(`select count(*) from licenses where machine_id='MID-4'`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("licenses left by the failed issue: %d (%v)", n, err)
+	}
+	if err := db.QueryRow(`select count(*) from activations where machine_id='MID-4'`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("activations left by the failed issue: %d (%v)", n, err)
+	}
+}
+
+func TestLicenseEnvelopeSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	edPriv, edPub, _ := crypto.GenerateKeyPEM(crypto.AlgEdDSA)
+	cfg.Signing.Products = map[string]config.KeyPair{"pro": {PrivateKeyPEM: edPriv, PublicKeyPEM: edPub}}
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if lf.SchemaVersion != licenseSchemaVersion || lf.Alg != crypto.AlgES256 || lf.KeyID == "" {
+		t.Fatalf("envelope: schema_version=%d alg=%s kid=%s", lf.SchemaVersion, lf.Alg, lf.KeyID)
+	}
+	pro := issueTestLicense(t, db, cfg, IssueRequest{ProductID: "pro", Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// pin both keys by kid, as a client would from the JWKS
+	keys := map[string]gocrypto.PublicKey{}
+	for _, pemStr := range []string{cfg.Signing.PublicKeyPEM, edPub} {
+		pub, _ := crypto.ParsePublicKey(pemStr)
+		keys[keyFingerprint(pemStr)] = pub
+	}
+	for _, l := range []LicenseFile{lf, pro} {
+		if err := l.Verify(LicenseTrust{Keys: keys}); err != nil {
+			t.Fatalf("%s: %v", l.Alg, err)
+		}
+	}
+	// the embedded key is not trusted once keys are pinned
+	delete(keys, pro.KeyID)
+	if err := pro.Verify(LicenseTrust{Keys: keys}); err == nil {
+		t.Fatal("verified with an unpinned kid")
+	}
+
+	legacy := lf
+	legacy.SchemaVersion = 0 // files from before schema_version
+	if err := legacy.Verify(LicenseTrust{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		edit func(*LicenseFile)
+	}{
+		{"future schema", func(l *LicenseFile) { l.SchemaVersion = 2 }},
+		{"unknown alg", func(l *LicenseFile) { l.Alg = "HS256" }},
+		{"alg of another key type", func(l *LicenseFile) { l.Alg = crypto.AlgEdDSA }},
+	} {
+		bad := lf
+		tc.edit(&bad)
+		if err := bad.Verify(LicenseTrust{}); err == nil {
+			t.Fatalf("%s: verified", tc.name)
+		}
+	}
+}
+
+func TestResignLicensesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	ctx := context.Background()
+
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer receiver.Close()
+	b, _ := json.Marshal(CreateWebhookRequest{URL: receiver.URL, Events: []string{EventLicenseReissued}})
+	CreateWebhook(db, cfg).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewReader(b)))
+
+	var old []LicenseFile
+	for _, m := range []string{"MID-1", "MID-2", "MID-3"} {
+		old = append(old, issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: m, ExpiresAt: time.Now().Add(time.Hour)}))
+	}
+	RevokeLicense(db, cfg).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{"license_key":"`+old[2].LicenseKey+`"}`)))
+
+	// retire the old key
+	priv, pub, _ := crypto.GenerateKeyPEM(crypto.AlgEdDSA)
+	cfg.Signing.PrivateKeyPEM, cfg.Signing.PublicKeyPEM = priv, pub
+	cfg = &config.Config{Server: cfg.Server, DB: cfg.DB, Signing: cfg.Signing} // drop the cached key
+
+	resign := func(f ResignFilter) []LicenseFile {
+		b, _ := json.Marshal(f)
+		rr := httptest.NewRecorder()
+		ResignLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/resign", bytes.NewReader(b)))
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != ndjsonContentType {
+			t.Fatalf("resign code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var out []LicenseFile
+		dec := json.NewDecoder(rr.Body)
+		for dec.More() {
+			var lf LicenseFile
+			if err := dec.Decode(&lf); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, lf)
+		}
+		return out
+	}
+	got := resign(ResignFilter{KeyID: old[0].KeyID})
+	if len(got) != 2 || got[0].LicenseKey > got[1].LicenseKey {
+		t.Fatalf("expected the 2 active licenses in key order, got %d", len(got))
+	}
+	for _, lf := range got {
+		if lf.PublicKey != pub || lf.Alg != crypto.AlgEdDSA {
+			t.Fatalf("%s signed with the old key", lf.LicenseKey)
+		}
+		if err := lf.Verify(LicenseTrust{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nothing is left on the retired key
+	if again := resign(ResignFilter{KeyID: old[0].KeyID}); len(again) != 0 {
+		t.Fatalf("re-signed %d licenses twice", len(again))
+	}
+
+	if n, err := DeliverWebhooks(ctx, db, cfg, receiver.Client()); err != nil || n != 2 {
+		t.Fatalf("expected 2 license.reissued deliveries, got %d (%v)", n, err)
+	}
+	var delivered struct {
+		Event string      `json:"event"`
+		Data  LicenseFile `json:"data"`
+	}
+	if err := json.Unmarshal(<-bodies, &delivered); err != nil || delivered.Event != EventLicenseReissued || delivered.Data.PublicKey != pub {
+		t.Fatalf("delivery is not the new license file: %v", err)
+	}
+	var audits int
+	db.QueryRow(`select count(*) from audit_log where action=$1`, AuditLicenseReissue).Scan(&audits)
+	if audits != 2 {
+		t.Fatalf("expected 2 audit entries, got %d", audits)
+	}
+}
+
+// TestLicenseFilePackageSQLite checks that pkg/licensefile, which clients
+// embed, accepts what the server signs.
+func TestLicenseFilePackageSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	grace, limit, on := 3, int64(100), true
+	recipient, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	for _, ir := range []IssueRequest{
+		{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)},
+		{ProductID: "cad", Tenant: "reseller", Customer: "Acme é", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour),
+			Features: map[string]any{"tier": "pro", "seats": 2.5, "modules": []any{"a", "b"}}, MaxActivations: 3, FloatingSeats: 2, GraceDays: &grace,
+			Entitlements: entitlements.Set{"api_calls": {Type: entitlements.TypeQuota, Limit: &limit}, "export": {Type: entitlements.TypeFlag, Enabled: &on}}},
+		{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), Features: map[string]any{"tier": "pro"},
+			FeaturesRecipientKey: base64.RawURLEncoding.EncodeToString(recipient.PublicKey().Bytes())},
+	} {
+		b, _ := json.Marshal(ir)
+		rr := httptest.NewRecorder()
+		IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses", bytes.NewReader(b)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("issue code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		f, err := licensefile.Verify(rr.Body.Bytes(), cfg.Signing.PublicKeyPEM)
+		if err != nil {
+			t.Fatalf("%s: %v", rr.Body.String(), err)
+		}
+		if err := f.Check("MID-1", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTenantSigningKeysSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	proPriv, proPub, _ := crypto.GeneratePEM()
+	acmePriv, acmePub, _ := crypto.GenerateKeyPEM(crypto.AlgEdDSA)
+	cfg.Signing.Products = map[string]config.KeyPair{"pro": {PrivateKeyPEM: proPriv, PublicKeyPEM: proPub}}
+	cfg.Signing.Tenants = map[string]config.KeyPair{"acme": {PrivateKeyPEM: acmePriv, PublicKeyPEM: acmePub}}
+
+	// the tenant's key wins over the product's
+	acme := issueTestLicense(t, db, cfg, IssueRequest{ProductID: "pro", Tenant: "acme", Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	other := issueTestLicense(t, db, cfg, IssueRequest{ProductID: "pro", Customer: "Other", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour)})
+	if acme.PublicKey != acmePub || acme.Tenant != "acme" || other.PublicKey != proPub {
+		t.Fatalf("wrong keys: acme=%s other=%s", acme.Alg, other.Alg)
+	}
+	acme.Entitlements = entitlements.Set{} // signed as {}, omitted from the file
+	verifyLicenseFile(t, acme)
+
+	byKey := func(kid string) []LicenseSummary {
+		rr := httptest.NewRecorder()
+		ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses?key_id="+kid, nil))
+		var resp ListLicensesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("list code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		return resp.Licenses
+	}
+	if got := byKey(acme.KeyID); len(got) != 1 || got[0].LicenseKey != acme.LicenseKey || got[0].Tenant != "acme" {
+		t.Fatalf("licenses signed by the tenant key: %+v", got)
+	}
+
+	// the tenant key is retired: reissuing moves its licenses to the product key
+	delete(cfg.Signing.Tenants, "acme")
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: acme.LicenseKey})
+	rr := httptest.NewRecorder()
+	ReissueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/reissue", bytes.NewReader(b)))
+	var re LicenseFile
+	if err := json.Unmarshal(rr.Body.Bytes(), &re); err != nil || re.PublicKey != proPub {
+		t.Fatalf("reissue code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := byKey(acme.KeyID); len(got) != 0 {
+		t.Fatalf("still recorded against the retired key: %+v", got)
+	}
+	if got := byKey(other.KeyID); len(got) != 2 {
+		t.Fatalf("expected both licenses on the product key, got %d", len(got))
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	cfg := testConfig(t)
+	priv, pub, err := crypto.GeneratePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Signing.Products = map[string]config.KeyPair{"pro": {PrivateKeyPEM: priv, PublicKeyPEM: pub}}
+
+	rr := httptest.NewRecorder()
+	JWKS(cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var set JWKSResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil || len(set.Keys) != 2 {
+		t.Fatalf("code=%d body=%s (%v)", rr.Code, rr.Body.String(), err)
+	}
+	if set.Keys[0].ProductID != "" || set.Keys[1].ProductID != "pro" || set.Keys[1].Crv != "P-256" {
+		t.Fatalf("unexpected keys %+v", set.Keys)
+	}
+
+	// the JWK coordinates are the PEM key, and kid matches license files
+	parsed, _ := crypto.ParsePublicKey(pub)
+	want := parsed.(*ecdsa.PublicKey)
+	x, _ := base64.RawURLEncoding.DecodeString(set.Keys[1].X)
+	y, _ := base64.RawURLEncoding.DecodeString(set.Keys[1].Y)
+	if new(big.Int).SetBytes(x).Cmp(want.X) != 0 || new(big.Int).SetBytes(y).Cmp(want.Y) != 0 {
+		t.Fatal("JWK coordinates don't match the public key")
+	}
+	lf, err := signLicenseFile(cfg, IssueRequest{ProductID: "pro", Customer: "Acme", MachineID: "MID", ExpiresAt: time.Now()}, "key", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lf.KeyID != set.Keys[1].Kid {
+		t.Fatalf("license key_id %s not in JWKS", lf.KeyID)
+	}
+}
+
+func TestEd25519Signing(t *testing.T) {
+	cfg := testConfig(t)
+	priv, pub, err := crypto.GenerateKeyPEM(crypto.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Signing.Products = map[string]config.KeyPair{
+		"edge":  {PrivateKeyPEM: priv, PublicKeyPEM: pub, Algorithm: crypto.AlgEdDSA},
+		"wrong": {PrivateKeyPEM: priv, PublicKeyPEM: pub, Algorithm: crypto.AlgES256},
+	}
+
+	expires := time.Now().Add(time.Hour)
+	lf, err := signLicenseFile(cfg, IssueRequest{ProductID: "edge", Customer: "Acme", MachineID: "MID", ExpiresAt: expires}, "key", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lf.Alg != crypto.AlgEdDSA {
+		t.Fatalf("alg = %q", lf.Alg)
+	}
+	edPub := verifyLicenseFile(t, lf)
+	if def, _ := signLicenseFile(cfg, IssueRequest{Customer: "Acme", MachineID: "MID", ExpiresAt: expires}, "key", time.Now()); def.Alg != crypto.AlgES256 {
+		t.Fatalf("default key alg = %q", def.Alg)
+	}
+	if _, _, err := cfg.ProductSigningKey("wrong"); err == nil || !strings.Contains(err.Error(), "algorithm is ES256 but the key is EdDSA") {
+		t.Fatalf("expected algorithm mismatch, got %v", err)
+	}
+
+	delete(cfg.Signing.Products, "wrong")
+	set, err := buildJWKS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := set.Keys[1]
+	x, _ := base64.RawURLEncoding.DecodeString(k.X)
+	if k.Kty != "OKP" || k.Crv != "Ed25519" || k.Alg != crypto.AlgEdDSA || k.Y != "" || !bytes.Equal(x, edPub.(ed25519.PublicKey)) || k.Kid != lf.KeyID {
+		t.Fatalf("unexpected ed25519 JWK %+v", k)
+	}
+}
+
+func TestRSAPSSSigning(t *testing.T) {
+	cfg := testConfig(t)
+	priv, pub, err := crypto.GenerateKeyPEM(crypto.AlgPS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Signing.Products = map[string]config.KeyPair{"legacy": {PrivateKeyPEM: priv, PublicKeyPEM: pub}}
+
+	lf, err := signLicenseFile(cfg, IssueRequest{ProductID: "legacy", Customer: "Acme", MachineID: "MID", ExpiresAt: time.Now().Add(time.Hour)}, "key", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lf.Alg != crypto.AlgPS256 {
+		t.Fatalf("alg = %q", lf.Alg)
+	}
+	rsaPub := verifyLicenseFile(t, lf).(*rsa.PublicKey)
+
+	// what openssl dgst -sigopt rsa_padding_mode:pss -sigopt rsa_pss_saltlen:32 checks
+	sig, _ := base64.RawURLEncoding.DecodeString(lf.Signature)
+	body, _ := json.Marshal(licenseFilePayload(lf))
+	h := sha256.Sum256(body)
+	if err := rsa.VerifyPSS(rsaPub, gocrypto.SHA256, h[:], sig, &rsa.PSSOptions{SaltLength: 32}); err != nil {
+		t.Fatalf("PSS salt length: %v", err)
+	}
+
+	set, err := buildJWKS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(set.Keys[1].N)
+	if k := set.Keys[1]; k.Kty != "RSA" || k.Alg != crypto.AlgPS256 || k.E != "AQAB" || new(big.Int).SetBytes(n).Cmp(rsaPub.N) != 0 {
+		t.Fatalf("unexpected RSA JWK %+v", k)
+	}
+
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	weakPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(weak)}))
+	if _, err := crypto.ParsePrivateKey(weakPEM); err == nil || !strings.Contains(err.Error(), "1024 bits") {
+		t.Fatalf("expected weak key rejection, got %v", err)
+	}
+}
+
+// licenseFilePayload rebuilds the signed payload of a license file the way a
+// client would.
+func licenseFilePayload(lf LicenseFile) map[string]any {
+	p := map[string]any{
+		"customer":        lf.Customer,
+		"machine_id":      lf.MachineID,
+		"license_key":     lf.LicenseKey,
+		"expires_at":      lf.ExpiresAt.Format(time.RFC3339Nano),
+		"issued_at":       lf.IssuedAt.Format(time.RFC3339Nano),
+		"features":        lf.Features,
+		"max_activations": lf.MaxActivations,
+		"floating_seats":  lf.FloatingSeats,
+		"entitlements":    lf.Entitlements,
+	}
+	if lf.ProductID != "" {
+		p["product_id"] = lf.ProductID
+	}
+	if lf.Tenant != "" {
+		p["tenant"] = lf.Tenant
+	}
+	if lf.GraceDays != nil {
+		p["grace_days"] = *lf.GraceDays
+	}
+	if lf.EncryptedFeatures != nil {
+		delete(p, "features")
+		p["encrypted_features"] = lf.EncryptedFeatures
+	}
+	return p
+}
+
+// verifyLicenseFile checks lf's signature with its embedded public key and
+// returns the key.
+func verifyLicenseFile(t *testing.T, lf LicenseFile) gocrypto.PublicKey {
+	t.Helper()
+	pub, err := crypto.ParsePublicKey(lf.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := crypto.VerifyJSON(pub, licenseFilePayload(lf), lf.Signature); err != nil || !ok {
+		t.Fatalf("%s license does not verify: ok=%v err=%v", lf.Alg, ok, err)
+	}
+	return pub
+}
+
+func TestListLicensesPaginationSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	want := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+		want[lf.LicenseKey] = true
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		rr := httptest.NewRecorder()
+		ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses?limit=2&cursor="+cursor, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list: code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp ListLicensesResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if len(resp.Licenses) > 2 {
+			t.Fatalf("page larger than limit: %d", len(resp.Licenses))
+		}
+		for _, l := range resp.Licenses {
+			if seen[l.LicenseKey] {
+				t.Fatalf("license %s returned twice", l.LicenseKey)
+			}
+			seen[l.LicenseKey] = true
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("expected %d licenses across pages, got %d", len(want), len(seen))
+	}
+
+	for _, q := range []string{"?limit=0", "?cursor=not-a-cursor"} {
+		rr := httptest.NewRecorder()
+		ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses"+q, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, rr.Code)
+		}
+	}
+
+	// a deep page seeks through the (created_at, id) index
+	var plan strings.Builder
+	rows, err := db.Query("explain query plan select id from licenses where "+keysetCond("created_at", "id", "<", 1)+" order by created_at desc, id desc limit 3", cursorTime(cfg, time.Now()), "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan.WriteString(detail + "\n")
+	}
+	rows.Close()
+	if p := plan.String(); !strings.Contains(p, "idx_licenses_created_at") || strings.Contains(p, "TEMP B-TREE") {
+		t.Fatalf("cursor page does not use the keyset index:\n%s", p)
+	}
+
+	// streamed: every license, one per line, no paging
+	for q, n := range map[string]int{"?stream=1": 5, "?stream=1&limit=3": 3} {
+		rr := httptest.NewRecorder()
+		ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses"+q, nil))
+		dec := json.NewDecoder(rr.Body)
+		got := 0
+		for dec.More() {
+			var l LicenseSummary
+			if err := dec.Decode(&l); err != nil || !want[l.LicenseKey] {
+				t.Fatalf("%s: bad line %+v (%v)", q, l, err)
+			}
+			got++
+		}
+		if got != n || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("%s: %d lines, content-type %s", q, got, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestListLicensesFiltersSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	now := time.Now()
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme Corp", MachineID: "MID-1", ExpiresAt: now.Add(time.Hour)})
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "acme_labs", MachineID: "MID-2", ExpiresAt: now.Add(48 * time.Hour)})
+	globex := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Globex", MachineID: "MID-3", ExpiresAt: now.Add(time.Hour)})
+	if _, err := db.Exec(`update licenses set revoked=true where license_key=$1`, globex.LicenseKey); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) (int, []LicenseSummary) {
+		rr := httptest.NewRecorder()
+		ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses?"+query, nil))
+		var resp ListLicensesResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Licenses
+	}
+
+	cases := map[string]int{
+		"customer=ACME":    2,
+		"customer=_":       1, // wildcard is matched literally
+		"machine_id=MID-2": 1,
+		"revoked=true":     1,
+		"revoked=false":    2,
+		"expires_after=" + now.Add(24*time.Hour).UTC().Format(time.RFC3339):                     1,
+		"expires_before=" + now.Add(24*time.Hour).UTC().Format(time.RFC3339) + "&customer=acme": 1,
+	}
+	for query, want := range cases {
+		code, got := list(query)
+		if code != http.StatusOK || len(got) != want {
+			t.Errorf("%s: code=%d got %d licenses, want %d", query, code, len(got), want)
+		}
+	}
+	for _, query := range []string{"revoked=maybe", "expires_before=tomorrow"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestGetLicenseSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), Notes: "vip"})
+
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/licenses/"+key, nil)
+		req.SetPathValue("license_key", key)
+		rr := httptest.NewRecorder()
+		GetLicense(db, cfg).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(lf.LicenseKey)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var d LicenseDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.LicenseKey != lf.LicenseKey || d.Notes != "vip" || d.CreatedAt == "" || d.UpdatedAt == "" {
+		t.Fatalf("unexpected detail %+v", d)
+	}
+	if d.Signing.PublicKey != lf.PublicKey || d.Signing.KeyID == "" || d.Signing.Algorithm != "ES256" {
+		t.Fatalf("unexpected signing info %+v", d.Signing)
+	}
+	if rr := get("missing"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestDeleteLicenseSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if err := recordMachine(context.Background(), db, cfg, "MID-1", lf.LicenseKey, &MachineInfo{Hostname: "build-01"}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-1"})
+	rr := httptest.NewRecorder()
+	IssueChallenge(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/challenge", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("challenge code=%d", rr.Code)
+	}
+
+	del := func(query string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/licenses/"+lf.LicenseKey+query, nil)
+		req.SetPathValue("license_key", lf.LicenseKey)
+		rr := httptest.NewRecorder()
+		DeleteLicense(db, cfg).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := del(""); code != http.StatusConflict {
+		t.Fatalf("active license: expected 409, got %d", code)
+	}
+	if code := del("?force=true"); code != http.StatusOK {
+		t.Fatalf("forced delete: expected 200, got %d", code)
+	}
+	for _, q := range []string{
+		`select count(*) from licenses where license_key=$1`,
+		`select count(*) from activations where license_key=$1`,
+		`select count(*) from validation_nonces where license_key=$1`,
+		`select count(*) from machines where last_license_key=$1`,
+	} {
+		var n int
+		if err := db.QueryRow(q, lf.LicenseKey).Scan(&n); err != nil || n != 0 {
+			t.Fatalf("%s: expected no rows, got %d (%v)", q, n, err)
+		}
+	}
+	if code := del("?force=true"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", code)
+	}
+}
+
+func TestPruneDataSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Retention.Licenses = 24 * time.Hour
+	cfg.Retention.Validations = 24 * time.Hour
+	cfg.Retention.Audit = 24 * time.Hour
+	ctx := context.Background()
+
+	now := time.Now()
+	longExpired := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: now.Add(-48 * time.Hour)})
+	three := 3
+	inGrace := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-2", ExpiresAt: now.Add(-48 * time.Hour), GraceDays: &three})
+	recent := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-3", ExpiresAt: now.Add(-time.Hour)})
+	revoked := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-4", ExpiresAt: now.Add(time.Hour)})
+	validateTestLicense(t, db, cfg, recent.LicenseKey, "MID-3")
+	old := dbTime(cfg, now.Add(-48*time.Hour))
+	for _, q := range []string{
+		`update licenses set revoked=true, updated_at=$1 where license_key='` + revoked.LicenseKey + `'`,
+		`update validation_events set created_at=$1`,
+		`update audit_log set created_at=$1 where license_key='` + recent.LicenseKey + `'`,
+	} {
+		if _, err := db.Exec(q, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := PruneData(ctx, db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Licenses != 2 || p.Validations != 1 || p.Audit == 0 {
+		t.Fatalf("pruned %+v", p)
+	}
+	for key, want := range map[string]int{longExpired.LicenseKey: 0, revoked.LicenseKey: 0, inGrace.LicenseKey: 1, recent.LicenseKey: 1} {
+		var n int
+		if err := db.QueryRow(`select count(*) from licenses where license_key=$1`, key).Scan(&n); err != nil || n != want {
+			t.Fatalf("%s: %d licenses (%v), want %d", key, n, err, want)
+		}
+	}
+	var audits int
+	if err := db.QueryRow(`select count(*) from audit_log where action=$1 and actor='retention'`, AuditLicensePrune).Scan(&audits); err != nil || audits != 2 {
+		t.Fatalf("prune audit entries = %d (%v)", audits, err)
+	}
+	if p, err := PruneData(ctx, db, cfg); err != nil || p.Total() != 0 {
+		t.Fatalf("second run pruned %+v (%v)", p, err)
+	}
+}
+
+func TestUniqueMachineSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Licensing.UniqueMachine = "reject"
+
+	issue := func(customer, machine string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(IssueRequest{Customer: customer, MachineID: machine, ExpiresAt: time.Now().Add(time.Hour)})
+		rr := httptest.NewRecorder()
+		IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/issue", bytes.NewReader(b)))
+		return rr
+	}
+	first := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if rr := issue("Acme", "MID-1"); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), first.LicenseKey) {
+		t.Fatalf("duplicate: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	// other customers and machines are unaffected
+	for _, c := range [][2]string{{"Beta", "MID-1"}, {"Acme", "MID-2"}} {
+		if rr := issue(c[0], c[1]); rr.Code != http.StatusOK {
+			t.Fatalf("%v: code=%d body=%s", c, rr.Code, rr.Body.String())
+		}
+	}
+
+	cfg.Licensing.UniqueMachine = "supersede"
+	second := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if resp := validateTestLicense(t, db, cfg, first.LicenseKey, "MID-1"); resp.Valid {
+		t.Fatalf("superseded license still valid: %+v", resp)
+	}
+	var action string
+	if err := db.QueryRow(`select action from audit_log where license_key=$1 order by created_at desc limit 1`, first.LicenseKey).Scan(&action); err != nil || action != AuditLicenseSupersede {
+		t.Fatalf("audit action = %q (%v)", action, err)
+	}
+
+	// the index holds even when the lookup is bypassed
+	_, err := db.Exec(`update licenses set machine_unique=true, revoked=false where license_key=$1`, first.LicenseKey)
+	if !isUniqueViolation(err) {
+		t.Fatalf("second claim on %s: %v", second.LicenseKey, err)
+	}
+}
+
+func TestReadReplicaSQLite(t *testing.T) {
+	primary, replica := testSQLiteDB(t), testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	reads := NewReadDB(primary, replica)
+
+	validate := func(key string) ValidateResponse {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: key, MachineID: "MID-1"})
+		rr := httptest.NewRecorder()
+		ValidateLicenseFrom(reads, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/validate", bytes.NewReader(b)))
+		var resp ValidateResponse
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &resp) != nil {
+			t.Fatalf("validate code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		return resp
+	}
+	list := func() int {
+		rr := httptest.NewRecorder()
+		ListLicensesFrom(reads, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses", nil))
+		var resp ListLicensesResponse
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &resp) != nil {
+			t.Fatalf("list code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		return len(resp.Licenses)
+	}
+
+	// the replica hasn't caught up: lists show its rows, while validation
+	// confirms the miss on the primary
+	lf := issueTestLicense(t, primary, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if n := list(); n != 0 {
+		t.Fatalf("replica list = %d licenses", n)
+	}
+	if resp := validate(lf.LicenseKey); !resp.Valid {
+		t.Fatalf("new license on a lagging replica: %+v", resp)
+	}
+	var seen int
+	if err := primary.QueryRow(`select count(*) from validation_events where license_key=$1`, lf.LicenseKey).Scan(&seen); err != nil || seen != 1 {
+		t.Fatalf("validation recorded %d times on the primary (%v)", seen, err)
+	}
+
+	// a failing replica hands reads to the primary
+	replica.Close()
+	if n := list(); n != 1 {
+		t.Fatalf("fallback list = %d licenses", n)
+	}
+	if resp := validate(lf.LicenseKey); !resp.Valid {
+		t.Fatalf("fallback validate: %+v", resp)
+	}
+}
+
+func TestRESTPathParamsSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Floating.SessionTTL = time.Minute
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), FloatingSeats: 1})
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/licenses/{license_key}/revoke", RevokeLicense(db, cfg))
+	mux.Handle("POST /api/v1/licenses/{license_key}/checkout", CheckoutLicense(db, cfg))
+	mux.Handle("DELETE /api/v1/sessions/{session_id}", CheckinLicense(db))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	// the path key wins over the body and the body may carry only the rest
+	rr := do(http.MethodPost, "/api/v1/licenses/"+lf.LicenseKey+"/checkout", `{"license_key":"other","machine_id":"MID-1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("checkout: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var co CheckoutResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &co)
+	if rr := do(http.MethodDelete, "/api/v1/sessions/"+co.SessionID, ""); rr.Code != http.StatusOK {
+		t.Fatalf("checkin: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// no body at all
+	if rr := do(http.MethodPost, "/api/v1/licenses/"+lf.LicenseKey+"/revoke", ""); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); !resp.Revoked {
+		t.Fatalf("expected revoked, got %+v", resp)
+	}
+}
+
+func TestFloatingCheckoutSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Floating.SessionTTL = time.Minute
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), FloatingSeats: 1})
+
+	checkout := func(machine string) (int, CheckoutResponse) {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: machine})
+		rr := httptest.NewRecorder()
+		CheckoutLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/checkout", bytes.NewReader(b)))
+		var resp CheckoutResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp
+	}
+
+	code, first := checkout("MID-1")
+	if code != http.StatusOK || first.SessionID == "" {
+		t.Fatalf("first checkout: code=%d resp=%+v", code, first)
+	}
+	if code, resp := checkout("MID-2"); code != http.StatusConflict {
+		t.Fatalf("expected pool exhausted, got code=%d resp=%+v", code, resp)
+	}
+
+	// a session that stops heartbeating is reclaimed
+	stale := time.Now().Add(-2 * time.Minute).UTC().Format(store.SQLiteTimeLayout)
+	if _, err := db.Exec(`update sessions set last_heartbeat_at=$1`, stale); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ReapStaleSessions(context.Background(), db, cfg); err != nil || n != 1 {
+		t.Fatalf("reap: n=%d err=%v", n, err)
+	}
+	code, second := checkout("MID-2")
+	if code != http.StatusOK {
+		t.Fatalf("checkout after reclaim: code=%d resp=%+v", code, second)
+	}
+
+	b, _ := json.Marshal(SessionRequest{SessionID: second.SessionID})
+	rr := httptest.NewRecorder()
+	SessionHeartbeat(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/session/heartbeat", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("session heartbeat code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	CheckinLicense(db).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/checkin", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("checkin code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if code, _ := checkout("MID-3"); code != http.StatusOK {
+		t.Fatalf("checkout after checkin: code=%d", code)
+	}
+}
+
+func TestValidateGracePeriodSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Licensing.GraceDays = 1
+
+	expired := time.Now().Add(-time.Hour)
+	three := 3
+	perLicense := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: expired, GraceDays: &three})
+	resp := validateTestLicense(t, db, cfg, perLicense.LicenseKey, "MID-1")
+	if !resp.Valid || !resp.Grace || resp.GraceDaysRemaining != 3 {
+		t.Fatalf("expected valid grace with 3 days left, got %+v", resp)
+	}
+
+	serverDefault := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", MachineID: "MID-2", ExpiresAt: expired.Add(-48 * time.Hour)})
+	resp = validateTestLicense(t, db, cfg, serverDefault.LicenseKey, "MID-2")
+	if resp.Valid || resp.Reason != "expired" {
+		t.Fatalf("expected expired past server grace, got %+v", resp)
+	}
+}
+
+func TestSuspendResumeSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	post := func(h http.Handler) {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey})
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("code=%d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+
+	post(SuspendLicense(db, cfg))
+	resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+	if resp.Valid || !resp.Suspended || resp.Revoked || resp.Reason != "suspended" {
+		t.Fatalf("expected suspended, got %+v", resp)
+	}
+	post(ResumeLicense(db, cfg))
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); !resp.Valid {
+		t.Fatalf("expected valid after resume, got %+v", resp)
+	}
+}
+
+func TestTransferLicenseSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Licensing.TransferCooldown = time.Hour
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "OLD", ExpiresAt: time.Now().Add(time.Hour)})
+
+	transfer := func(req TransferRequest) int {
+		b, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		TransferLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/transfer", bytes.NewReader(b)))
+		return rr.Code
+	}
+
+	if code := transfer(TransferRequest{LicenseKey: lf.LicenseKey, FromMachineID: "OLD", ToMachineID: "NEW"}); code != http.StatusOK {
+		t.Fatalf("transfer code=%d", code)
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "NEW"); !resp.Valid {
+		t.Fatalf("expected new machine valid, got %+v", resp)
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "OLD"); resp.Valid {
+		t.Fatalf("expected old machine invalid, got %+v", resp)
+	}
+	if code := transfer(TransferRequest{LicenseKey: lf.LicenseKey, ToMachineID: "NEWER"}); code != http.StatusTooManyRequests {
+		t.Fatalf("expected cooldown, got %d", code)
+	}
+	if code := transfer(TransferRequest{LicenseKey: lf.LicenseKey, ToMachineID: "NEWER", Force: true}); code != http.StatusOK {
+		t.Fatalf("forced transfer code=%d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	ListTransfers(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/transfers?license_key="+lf.LicenseKey, nil))
+	var hist ListTransfersResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &hist); err != nil {
+		t.Fatal(err)
+	}
+	if len(hist.Transfers) != 2 || hist.Transfers[0].ToMachineID != "NEWER" {
+		t.Fatalf("unexpected history %+v", hist.Transfers)
+	}
+}
+
+func TestUsageQuotaSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Usage.Enforcement = "degrade"
+
+	limit := int64(10)
+	lf := issueTestLicense(t, db, cfg, IssueRequest{
+		Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour),
+		Entitlements: entitlements.Set{"api_calls": {Type: entitlements.TypeQuota, Limit: &limit}},
+	})
+
+	report := func(amount int64) UsageResponse {
+		b, _ := json.Marshal(UsageRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-1", Metric: "api_calls", Amount: amount})
+		rr := httptest.NewRecorder()
+		ReportUsage(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/usage", bytes.NewReader(b)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("usage code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp UsageResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	if resp := report(6); resp.Total != 6 || resp.Exceeded {
+		t.Fatalf("first report %+v", resp)
+	}
+	if resp := report(6); resp.Total != 12 || !resp.Exceeded {
+		t.Fatalf("second report %+v", resp)
+	}
+
+	resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+	if !resp.Valid || len(resp.QuotaExceeded) != 1 || resp.QuotaExceeded[0] != "api_calls" {
+		t.Fatalf("degrade mode: %+v", resp)
+	}
+	cfg.Usage.Enforcement = "fail"
+	resp = validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+	if resp.Valid || resp.Reason != "quota exceeded" {
+		t.Fatalf("fail mode: %+v", resp)
+	}
+}
+
+func TestUsageActivationsSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), MaxActivations: 2})
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-2"})
+	rr := httptest.NewRecorder()
+	ActivateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/activate", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("activate code=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	report := func(machine string) (int, string) {
+		b, _ := json.Marshal(UsageRequest{LicenseKey: lf.LicenseKey, MachineID: machine, Metric: "api_calls", Amount: 1})
+		rr := httptest.NewRecorder()
+		ReportUsage(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/usage", bytes.NewReader(b)))
+		return rr.Code, strings.TrimSpace(rr.Body.String())
+	}
+
+	// any activated machine reports, not only the issuing one
+	if code, body := report("MID-2"); code != http.StatusOK {
+		t.Fatalf("activated machine: code=%d body=%s", code, body)
+	}
+	if _, err := db.Exec(`delete from activations where license_key=$1 and machine_id=$2`, lf.LicenseKey, "MID-1"); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := report("MID-1"); code != http.StatusForbidden || body != "machine mismatch" {
+		t.Fatalf("deactivated machine: code=%d body=%s", code, body)
+	}
+
+	for _, tc := range []struct{ set, reason string }{
+		{"suspended=true, revoked=false, archived_at=null", "suspended"},
+		{"suspended=false, revoked=true, archived_at=null", "revoked"},
+		{"suspended=false, revoked=false, archived_at='2020-01-01T00:00:00.000000000Z'", "archived"},
+	} {
+		if _, err := db.Exec(`update licenses set `+tc.set+` where license_key=$1`, lf.LicenseKey); err != nil {
+			t.Fatal(err)
+		}
+		if code, body := report("MID-2"); code != http.StatusForbidden || body != tc.reason {
+			t.Fatalf("%s: code=%d body=%s", tc.reason, code, body)
+		}
+	}
+}
+
+func TestCheckEntitlementSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Usage.Enforcement = "fail"
+
+	on, limit := true, int64(5)
+	lf := issueTestLicense(t, db, cfg, IssueRequest{
+		Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(30 * time.Minute),
+		Features: map[string]any{"export": false, "seats": 3, "legacy": "gold"},
+		Entitlements: entitlements.Set{
+			"sso":       {Type: entitlements.TypeFlag, Enabled: &on},
+			"api_calls": {Type: entitlements.TypeQuota, Limit: &limit},
+			"support":   {Type: entitlements.TypeTier, Tier: "premium"},
+		},
+	})
+
+	check := func(machine, feature string) EntitlementAssertion {
+		b, _ := json.Marshal(EntitlementRequest{MachineID: machine, Feature: feature})
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+		req.SetPathValue("license_key", lf.LicenseKey)
+		rr := httptest.NewRecorder()
+		CheckEntitlement(db, cfg).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: code=%d body=%s", feature, rr.Code, rr.Body.String())
+		}
+		var a EntitlementAssertion
+		_ = json.Unmarshal(rr.Body.Bytes(), &a)
+		return a
+	}
+
+	a := check("MID-1", "sso")
+	if !a.Enabled || a.KeyID == "" {
+		t.Fatalf("sso: %+v", a)
+	}
+	pub, err := crypto.ParsePublicKey(cfg.Signing.PublicKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := crypto.VerifyJSON(pub, a.payload(), a.Signature); err != nil || !ok {
+		t.Fatalf("signature does not verify: ok=%v err=%v", ok, err)
+	}
+	// the assertion never outlives the license
+	if !a.ValidUntil.Before(a.IssuedAt.Add(entitlementTTL)) {
+		t.Fatalf("valid_until %s should be capped at expiry", a.ValidUntil)
+	}
+
+	if a := check("MID-1", "api_calls"); !a.Enabled || a.Limit == nil || *a.Limit != 5 || a.Used == nil || *a.Used != 0 {
+		t.Fatalf("api_calls: %+v", a)
+	}
+	if _, err := db.Exec(`insert into usage_records (license_key, metric, period, amount) values ($1,$2,$3,$4)`,
+		lf.LicenseKey, "api_calls", cfg.UsagePeriod(time.Now()), 9); err != nil {
+		t.Fatal(err)
+	}
+	if a := check("MID-1", "api_calls"); a.Enabled || a.Reason != "quota exceeded" || *a.Used != 9 {
+		t.Fatalf("api_calls over quota: %+v", a)
+	}
+	if a := check("MID-1", "support"); !a.Enabled || a.Tier != "premium" {
+		t.Fatalf("support: %+v", a)
+	}
+	if a := check("MID-1", "seats"); !a.Enabled || a.Limit == nil || *a.Limit != 3 {
+		t.Fatalf("seats feature: %+v", a)
+	}
+	if a := check("MID-1", "export"); a.Enabled || a.Reason != "disabled" {
+		t.Fatalf("export feature: %+v", a)
+	}
+	if a := check("MID-1", "missing"); a.Enabled || a.Reason != "not granted" {
+		t.Fatalf("missing feature: %+v", a)
+	}
+	if a := check("MID-2", "sso"); a.Enabled || a.Reason != "machine mismatch" || a.Signature == "" {
+		t.Fatalf("unactivated machine: %+v", a)
+	}
+}
+
+func TestProductLicensesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	priv, pub, err := crypto.GeneratePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Signing.Products = map[string]config.KeyPair{"cad": {PrivateKeyPEM: priv, PublicKeyPEM: pub}}
+
+	cad := issueTestLicense(t, db, cfg, IssueRequest{ProductID: "cad", Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	other := issueTestLicense(t, db, cfg, IssueRequest{ProductID: "viewer", Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	if cad.PublicKey != pub || other.PublicKey != cfg.Signing.PublicKeyPEM {
+		t.Fatal("expected per-product key for cad and default key for viewer")
+	}
+
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: cad.LicenseKey, MachineID: "MID-1", ProductID: "viewer"})
+	rr := httptest.NewRecorder()
+	ValidateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/validate", bytes.NewReader(b)))
+	var vr ValidateResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &vr)
+	if vr.Valid || vr.Reason != "product mismatch" {
+		t.Fatalf("expected product mismatch, got %+v", vr)
+	}
+
+	rr = httptest.NewRecorder()
+	ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses?product_id=cad", nil))
+	var list ListLicensesResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Licenses) != 1 || list.Licenses[0].ProductID != "cad" {
+		t.Fatalf("expected only cad license, got %+v", list.Licenses)
+	}
+}
+
+func TestMetadataAndNotesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lf := issueTestLicense(t, db, cfg, IssueRequest{
+		Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour),
+		Metadata: map[string]any{"crm_order_id": "SO-1001"}, Notes: "initial",
+	})
+
+	notes := "renewal pending"
+	b, _ := json.Marshal(UpdateLicenseRequest{LicenseKey: lf.LicenseKey, Notes: &notes})
+	rr := httptest.NewRecorder()
+	UpdateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/update", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update code=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses", nil))
+	var list ListLicensesResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Licenses) != 1 {
+		t.Fatalf("expected 1 license, got %d", len(list.Licenses))
+	}
+	got := list.Licenses[0]
+	if got.Notes != notes || got.Metadata["crm_order_id"] != "SO-1001" {
+		t.Fatalf("unexpected metadata/notes: %+v", got)
+	}
+}
+
+func TestArchiveRestoreSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	list := func(query string) []LicenseSummary {
+		rr := httptest.NewRecorder()
+		ListLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses"+query, nil))
+		var resp ListLicensesResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.Licenses
+	}
+	post := func(h http.Handler) int {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey})
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+		return rr.Code
+	}
+
+	if code := post(ArchiveLicense(db, cfg)); code != http.StatusOK {
+		t.Fatalf("archive code=%d", code)
+	}
+	if got := list(""); len(got) != 0 {
+		t.Fatalf("archived license should be hidden, got %d", len(got))
+	}
+	if got := list("?include_archived=true"); len(got) != 1 || got[0].ArchivedAt == nil {
+		t.Fatalf("expected archived license with include_archived, got %+v", got)
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); resp.Valid || resp.Reason != "archived" {
+		t.Fatalf("expected archived reason, got %+v", resp)
+	}
+	if code := post(RestoreLicense(db, cfg)); code != http.StatusOK {
+		t.Fatalf("restore code=%d", code)
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); !resp.Valid {
+		t.Fatalf("expected valid after restore, got %+v", resp)
+	}
+}
+
+func TestIssueBatchSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	issue := func(req IssueBatchRequest) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		IssueBatch(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/issue-batch", bytes.NewReader(b)))
+		return rr
+	}
+
+	rr := issue(IssueBatchRequest{Count: 3, Template: &IssueRequest{Customer: "OEM", MachineID: "OEM-{n}", ExpiresAt: time.Now().Add(time.Hour)}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("batch code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp IssueBatchResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Licenses) != 3 || resp.Licenses[2].MachineID != "OEM-3" || resp.Licenses[0].Signature == "" {
+		t.Fatalf("unexpected batch response %+v", resp.Licenses)
+	}
+
+	// one invalid entry rejects the whole batch
+	rr = issue(IssueBatchRequest{Licenses: []IssueRequest{
+		{Customer: "A", MachineID: "M", ExpiresAt: time.Now().Add(time.Hour)},
+		{Customer: "B"},
+	}})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from licenses`).Scan(&n); err != nil || n != 3 {
+		t.Fatalf("expected 3 licenses stored, got %d (%v)", n, err)
+	}
+}
+
+func TestWebhooksSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	ctx := context.Background()
+
+	type hit struct {
+		event, sig string
+		body       []byte
+	}
+	hits := make(chan hit, 10)
+	var failing atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		hits <- hit{r.Header.Get("X-Raal-Event"), r.Header.Get("X-Raal-Signature"), b}
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	b, _ := json.Marshal(CreateWebhookRequest{URL: receiver.URL, Events: []string{EventLicenseIssued, EventLicenseExpired}})
+	rr := httptest.NewRecorder()
+	CreateWebhook(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var wh Webhook
+	_ = json.Unmarshal(rr.Body.Bytes(), &wh)
+	if wh.Secret == "" {
+		t.Fatal("expected generated secret")
+	}
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(-time.Minute)})
+	// revoked is not subscribed, so it must not be queued
+	RevokeLicense(db, cfg).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{"license_key":"`+lf.LicenseKey+`"}`)))
+
+	if n, err := DeliverWebhooks(ctx, db, cfg, receiver.Client()); err != nil || n != 1 {
+		t.Fatalf("expected 1 delivery, got %d (%v)", n, err)
+	}
+	got := <-hits
+	mac := hmac.New(sha256.New, []byte(wh.Secret))
+	mac.Write(got.body)
+	if got.event != EventLicenseIssued || got.sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("bad delivery event=%s sig=%s", got.event, got.sig)
+	}
+	if !strings.Contains(string(got.body), lf.LicenseKey) {
+		t.Fatalf("payload missing license key: %s", got.body)
+	}
+
+	// revoked licenses don't fire license.expired; an active expired one
+	// fires exactly once
+	lf2 := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", MachineID: "MID-2", ExpiresAt: time.Now().Add(-time.Minute)})
+	_, _ = DeliverWebhooks(ctx, db, cfg, receiver.Client())
+	<-hits
+	for i := 0; i < 2; i++ {
+		if err := ScanLicenseEvents(ctx, db, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failing.Store(true)
+	if n, _ := DeliverWebhooks(ctx, db, cfg, receiver.Client()); n != 1 {
+		t.Fatalf("expected 1 expiry delivery, got %d", n)
+	}
+	got = <-hits
+	if got.event != EventLicenseExpired || !strings.Contains(string(got.body), lf2.LicenseKey) {
+		t.Fatalf("unexpected delivery %s %s", got.event, got.body)
+	}
+	// the failed attempt backs off, so nothing is due yet
+	if n, _ := DeliverWebhooks(ctx, db, cfg, receiver.Client()); n != 0 {
+		t.Fatalf("expected backoff, got %d attempts", n)
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/webhooks/"+wh.ID+"/deliveries", nil)
+	req.SetPathValue("webhook_id", wh.ID)
+	ListWebhookDeliveries(db).ServeHTTP(rr, req)
+	var dl ListWebhookDeliveriesResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &dl)
+	if len(dl.Deliveries) != 3 {
+		t.Fatalf("expected 3 deliveries, got %+v", dl.Deliveries)
+	}
+	var pending int
+	for _, d := range dl.Deliveries {
+		if d.Status == "pending" {
+			pending++
+			if d.Attempts != 1 || d.ResponseCode == nil || *d.ResponseCode != http.StatusInternalServerError {
+				t.Fatalf("unexpected retry state %+v", d)
+			}
+		}
+	}
+	if pending != 1 {
+		t.Fatalf("expected 1 pending retry, got %d", pending)
+	}
+}
+
+func TestEventOutboxSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	ctx := context.Background()
+
+	b, _ := json.Marshal(CreateWebhookRequest{URL: "http://127.0.0.1:1", Events: []string{EventLicenseRevoked}})
+	rr := httptest.NewRecorder()
+	CreateWebhook(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create code=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// an event queued in a transaction that rolls back never happened
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queueEvent(ctx, tx, cfg, EventLicenseRevoked, map[string]any{"license_key": "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	// one committed but never dispatched, as after a crash, goes out later
+	if err := queueEvent(ctx, db, cfg, EventLicenseRevoked, map[string]any{"license_key": "k1"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{1, 0} {
+		if n, err := DispatchEvents(ctx, db, cfg); err != nil || n != want {
+			t.Fatalf("dispatch %d: got %d (%v), want %d", i, n, err, want)
+		}
+	}
+	var deliveries int
+	if err := db.QueryRow(`select count(*) from webhook_deliveries where event=$1 and payload like '%"k1"%'`, EventLicenseRevoked).Scan(&deliveries); err != nil || deliveries != 1 {
+		t.Fatalf("deliveries = %d (%v)", deliveries, err)
+	}
+}
+
+func TestEventStreamSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	srv := httptest.NewServer(EventStream())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "?types=license.issued,license.validation_failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content-type=%q", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	// read frames until a blank line; the first is the ": connected" comment
+	frame := func() map[string]string {
+		f := map[string]string{}
+		for lines.Scan() {
+			line := lines.Text()
+			if line == "" {
+				return f
+			}
+			k, v, _ := strings.Cut(line, ": ")
+			f[k] = v
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return nil
+	}
+	frame()
+
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	// revoked is filtered out by ?types=
+	RevokeLicense(db, cfg).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{"license_key":"`+lf.LicenseKey+`"}`)))
+	validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+
+	f := frame()
+	if f["event"] != EventLicenseIssued || !strings.Contains(f["data"], lf.LicenseKey) || f["id"] == "" {
+		t.Fatalf("unexpected issue frame %v", f)
+	}
+	f = frame()
+	var ev struct {
+		Type string         `json:"type"`
+		Data map[string]any `json:"data"`
+	}
+	_ = json.Unmarshal([]byte(f["data"]), &ev)
+	if f["event"] != EventLicenseValidationFailed || ev.Data["reason"] != "revoked" {
+		t.Fatalf("unexpected validation frame %v", f)
+	}
+}
+
+func TestExportLicensesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour),
+		Features: map[string]any{"tier": "pro", "limits": map[string]any{"seats": 5}}})
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "=cmd()", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour),
+		Features: map[string]any{"export": true}})
+
+	export := func(format string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ExportLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/export?format="+format, nil))
+		return rr
+	}
+
+	rr := export("csv")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment;") {
+		t.Fatalf("code=%d headers=%v", rr.Code, rr.Header())
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected header + 2 rows, got %d (%v)", len(records), err)
+	}
+	col := map[string]int{}
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	for _, name := range []string{"license_key", "features.tier", "features.limits.seats", "features.export"} {
+		if _, ok := col[name]; !ok {
+			t.Fatalf("missing column %s in %v", name, records[0])
+		}
+	}
+	byCustomer := map[string][]string{}
+	for _, rec := range records[1:] {
+		byCustomer[rec[col["customer"]]] = rec
+	}
+	acme := byCustomer["Acme"]
+	if acme == nil || acme[col["features.limits.seats"]] != "5" || acme[col["features.export"]] != "" {
+		t.Fatalf("bad flattened features %v", acme)
+	}
+	if byCustomer["'=cmd()"] == nil {
+		t.Fatalf("expected formula to be escaped, got %v", records[1:])
+	}
+
+	rr = export("json")
+	var rows []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &rows); err != nil || len(rows) != 2 {
+		t.Fatalf("bad json export (%v): %s", err, rr.Body.String())
+	}
+	for _, row := range rows {
+		if row["customer"] == "Acme" && row["features.tier"] != "pro" || row["customer"] == "=cmd()" && row["features.export"] != true {
+			t.Fatalf("unexpected json row %v", row)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/licenses/export", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	ExportLicenses(db, cfg).ServeHTTP(rr, req)
+	if lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n"); rr.Header().Get("Content-Type") != "application/x-ndjson" || len(lines) != 2 {
+		t.Fatalf("bad ndjson export (%s): %s", rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	if rr := export("xml"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown format, got %d", rr.Code)
+	}
+}
+
+func TestImportLicensesSQLite(t *testing.T) {
+	src := testSQLiteDB(t)
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	imp := func(req ImportRequest) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		ImportLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/import", bytes.NewReader(b)))
+		return rr
+	}
+
+	// an export from one server imports unchanged into another
+	lf := issueTestLicense(t, src, cfg, IssueRequest{Customer: "=Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour),
+		MaxActivations: 3, Features: map[string]any{"tier": "pro", "limits": map[string]any{"seats": 5}}})
+	rr := httptest.NewRecorder()
+	ExportLicenses(src, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/export", nil))
+	rr = imp(ImportRequest{CSV: rr.Body.String()})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("csv import code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	row := db.QueryRow(`select `+licenseSummaryColumns+` from licenses where license_key=$1`, lf.LicenseKey)
+	sum, err := scanLicenseSummary(row, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, _ := sum.Features["limits"].(map[string]any)
+	if sum.Customer != "=Acme" || sum.MaxActivations != 3 || sum.Features["tier"] != "pro" || limits["seats"] != float64(5) {
+		t.Fatalf("round trip mismatch %+v", sum)
+	}
+	if resp := validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1"); !resp.Valid {
+		t.Fatalf("imported license should validate: %+v", resp)
+	}
+
+	// JSON records keep legacy keys and can be re-signed
+	rr = imp(ImportRequest{Resign: true, Licenses: []ImportLicense{
+		{LicenseKey: "LEGACY-1", IssueRequest: IssueRequest{Customer: "Old", MachineID: "M-1", ExpiresAt: time.Now().Add(time.Hour)}},
+		{LicenseKey: "LEGACY-2", Revoked: true, IssueRequest: IssueRequest{Customer: "Old", MachineID: "M-2", ExpiresAt: time.Now().Add(time.Hour)}},
+	}})
+	var resp ImportResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.Imported != 2 || len(resp.Licenses) != 2 || resp.Licenses[0].LicenseKey != "LEGACY-1" || resp.Licenses[0].Signature == "" {
+		t.Fatalf("json import code=%d resp=%+v", rr.Code, resp)
+	}
+	if v := validateTestLicense(t, db, cfg, "LEGACY-2", "M-2"); v.Reason != "revoked" {
+		t.Fatalf("expected revoked import, got %+v", v)
+	}
+
+	// a taken key rolls back the whole import
+	rr = imp(ImportRequest{Licenses: []ImportLicense{
+		{LicenseKey: "NEW-1", IssueRequest: IssueRequest{Customer: "New", MachineID: "M", ExpiresAt: time.Now().Add(time.Hour)}},
+		{LicenseKey: "LEGACY-1", IssueRequest: IssueRequest{Customer: "New", MachineID: "M", ExpiresAt: time.Now().Add(time.Hour)}},
+	}})
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rr.Code)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from licenses`).Scan(&n); err != nil || n != 3 {
+		t.Fatalf("expected 3 licenses, got %d (%v)", n, err)
+	}
+
+	if rr := imp(ImportRequest{CSV: "license_key,customer,colour\nK,C,red\n"}); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "colour") {
+		t.Fatalf("expected unknown column error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestStatsSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	stats := func() StatsResponse {
+		rr := httptest.NewRecorder()
+		Stats(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp StatsResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+	if got := stats(); got.Total != 0 || got.Active != 0 {
+		t.Fatalf("expected zeroes on empty table, got %+v", got)
+	}
+
+	issue := func(expires time.Duration) string {
+		return issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID", ExpiresAt: time.Now().Add(expires)}).LicenseKey
+	}
+	issue(365 * 24 * time.Hour)
+	soon := issue(24 * time.Hour)
+	issue(-time.Hour)
+	revoked := issue(365 * 24 * time.Hour)
+	archived := issue(365 * 24 * time.Hour)
+	if _, err := db.Exec(`update licenses set revoked=true where license_key=$1`, revoked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`update licenses set archived_at=$1 where license_key=$2`, dbTime(cfg, time.Now()), archived); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	Heartbeat(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"license_key":"`+soon+`"}`)))
+
+	got := stats()
+	want := StatsResponse{Total: 4, Active: 2, Revoked: 1, Expired: 1, ExpiringSoon: 1, SeenLast24h: 1, Archived: 1}
+	got.GeneratedAt = time.Time{}
+	if got != want {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuditLogSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	a := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-A", ExpiresAt: time.Now().Add(time.Hour)})
+	b := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", MachineID: "MID-B", ExpiresAt: time.Now().Add(time.Hour)})
+	// revoke through the admin middleware so the actor is recorded
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses/revoke", strings.NewReader(`{"license_key":"`+a.LicenseKey+`"}`))
+	req.Header.Set("Authorization", "Bearer test-admin")
+	req.RemoteAddr = "203.0.113.7:51234"
+	rr := httptest.NewRecorder()
+	middleware.WithAdminKey(cfg, nil, RevokeLicense(db, cfg)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("revoke code=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	list := func(query string) ListAuditResponse {
+		rr := httptest.NewRecorder()
+		ListAudit(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/audit"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list%s: code=%d body=%s", query, rr.Code, rr.Body.String())
+		}
+		var resp ListAuditResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+
+	all := list("")
+	if len(all.Entries) != 3 || all.Entries[0].Action != AuditLicenseRevoke {
+		t.Fatalf("expected 3 entries, revoke first, got %+v", all.Entries)
+	}
+	actor := all.Entries[0].Actor
+	if !strings.HasPrefix(actor, "key:") || strings.Contains(actor, "test-admin") {
+		t.Fatalf("unexpected actor %q", actor)
+	}
+	if e := all.Entries[0]; e.RemoteIP != "203.0.113.7" || e.Changes["revoked"] != (FieldChange{Before: false, After: true}) || len(e.Changes) != 1 {
+		t.Fatalf("revoke entry: remote_ip=%q changes=%+v", e.RemoteIP, e.Changes)
+	}
+	if e := all.Entries[1]; e.Changes["customer"] != (FieldChange{After: "Beta"}) {
+		t.Fatalf("issue entry changes %+v", e.Changes)
+	}
+	if got := list("?actor=" + actor); len(got.Entries) != 1 || got.Entries[0].LicenseKey != a.LicenseKey {
+		t.Fatalf("actor filter: %+v", got.Entries)
+	}
+	if got := list("?action=license.issue&license_key=" + b.LicenseKey); len(got.Entries) != 1 || got.Entries[0].Details["customer"] != "Beta" {
+		t.Fatalf("action+license_key filter: %+v", got.Entries)
+	}
+	if got := list("?entity=license:" + a.LicenseKey); len(got.Entries) != 2 || got.Entries[0].Entity != "license:"+a.LicenseKey {
+		t.Fatalf("entity filter: %+v", got.Entries)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if got := list("?since=" + future); len(got.Entries) != 0 {
+		t.Fatalf("since filter: %+v", got.Entries)
+	}
+	if got := list("?until=" + future); len(got.Entries) != 3 {
+		t.Fatalf("until filter: %+v", got.Entries)
+	}
+
+	var seen []string
+	cursor := ""
+	for {
+		page := list("?limit=2&cursor=" + cursor)
+		for _, e := range page.Entries {
+			seen = append(seen, e.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 3 || seen[0] != all.Entries[0].ID || seen[2] != all.Entries[2].ID {
+		t.Fatalf("paging returned %v, want the ids of %+v", seen, all.Entries)
+	}
+
+	rr = httptest.NewRecorder()
+	ListAudit(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/audit?since=yesterday", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad since: code=%d", rr.Code)
+	}
+
+	// a deleted license's last state survives in the audit log
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/licenses/"+b.LicenseKey+"?force=true", nil)
+	req.SetPathValue("license_key", b.LicenseKey)
+	rr = httptest.NewRecorder()
+	DeleteLicense(db, cfg).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := list("?action=license.delete"); len(got.Entries) != 1 || got.Entries[0].Changes["customer"] != (FieldChange{Before: "Beta"}) {
+		t.Fatalf("delete entry %+v", got.Entries)
+	}
+}
+
+func TestAPIKeysSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lookup := AdminKeyLookup(db, cfg)
+
+	// call runs h behind the admin middleware with token
+	call := func(h http.Handler, method, target, token, body string, path ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if len(path) == 1 {
+			req.SetPathValue("key_id", path[0])
+		}
+		rr := httptest.NewRecorder()
+		middleware.WithAdminKey(cfg, lookup, h).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the bootstrap key from config creates the first managed key
+	rr := call(CreateAPIKey(db, cfg), http.MethodPost, "/api/v1/api-keys", "test-admin", `{"label":"ci"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var created APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	if !strings.HasPrefix(created.Key, apiKeyPrefix) || created.Prefix != created.Key[:apiKeyDisplayLen] {
+		t.Fatalf("unexpected key %+v", created)
+	}
+
+	list := func() []APIKey {
+		rr := call(ListAPIKeys(db), http.MethodGet, "/api/v1/api-keys", created.Key, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		if strings.Contains(rr.Body.String(), created.Key) {
+			t.Fatal("list must not return the plaintext key")
+		}
+		var resp ListAPIKeysResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.APIKeys
+	}
+	if keys := list(); len(keys) != 1 || keys[0].Label != "ci" || keys[0].LastUsedAt == nil {
+		t.Fatalf("expected ci key marked as used, got %+v", keys)
+	}
+
+	// actions taken with a managed key are audited under its id
+	if rr := call(UpdateAPIKey(db, cfg), http.MethodPatch, "/", created.Key, `{"label":"ci-deploy"}`, created.ID); rr.Code != http.StatusOK {
+		t.Fatalf("update code=%d", rr.Code)
+	}
+	var actor string
+	if err := db.QueryRow(`select actor from audit_log where action=$1`, AuditAPIKeyUpdate).Scan(&actor); err != nil || actor != "apikey:"+created.ID {
+		t.Fatalf("audit actor=%q err=%v", actor, err)
+	}
+
+	rr = call(RotateAPIKey(db, cfg), http.MethodPost, "/", created.Key, "", created.ID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rotate code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var rotated APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+	if rotated.ID != created.ID || rotated.Label != "ci-deploy" || rotated.Key == created.Key || rotated.RotatedAt == nil {
+		t.Fatalf("unexpected rotation %+v", rotated)
+	}
+	if rr := call(ListAPIKeys(db), http.MethodGet, "/", created.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("old secret after rotation: code=%d", rr.Code)
+	}
+
+	if rr := call(RevokeAPIKey(db, cfg), http.MethodPost, "/", "test-admin", "", created.ID); rr.Code != http.StatusOK {
+		t.Fatalf("revoke code=%d", rr.Code)
+	}
+	if rr := call(ListAPIKeys(db), http.MethodGet, "/", rotated.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: code=%d", rr.Code)
+	}
+	if rr := call(RotateAPIKey(db, cfg), http.MethodPost, "/", "test-admin", "", created.ID); rr.Code != http.StatusNotFound {
+		t.Fatalf("rotating a revoked key: code=%d", rr.Code)
+	}
+	if rr := call(RevokeAPIKey(db, cfg), http.MethodPost, "/", "test-admin", "", "not-a-uuid"); rr.Code != http.StatusNotFound {
+		t.Fatalf("bad key id: code=%d", rr.Code)
+	}
+}
+
+func TestAPIKeyRolesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lookup := AdminKeyLookup(db, cfg)
+
+	// call runs h behind the middleware for a route requiring role
+	call := func(h http.Handler, role, method, token, body string, path ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if len(path) == 1 {
+			req.SetPathValue("key_id", path[0])
+		}
+		rr := httptest.NewRecorder()
+		middleware.WithRole(cfg, lookup, role, h).ServeHTTP(rr, req)
+		return rr
+	}
+	create := func(body string) APIKey {
+		rr := call(CreateAPIKey(db, cfg), middleware.RoleAdmin, http.MethodPost, "test-admin", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("create %s: code=%d body=%s", body, rr.Code, rr.Body.String())
+		}
+		var k APIKey
+		_ = json.Unmarshal(rr.Body.Bytes(), &k)
+		return k
+	}
+	viewer := create(`{"label":"dashboard","role":"viewer"}`)
+	support := create(`{"label":"helpdesk","role":"support"}`)
+	if viewer.Role != middleware.RoleViewer || create(`{"label":"ops"}`).Role != middleware.RoleAdmin {
+		t.Fatalf("roles: viewer=%q", viewer.Role)
+	}
+	if rr := call(CreateAPIKey(db, cfg), middleware.RoleAdmin, http.MethodPost, "test-admin", `{"label":"x","role":"root"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown role: code=%d", rr.Code)
+	}
+
+	a := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	revoke, _ := json.Marshal(ValidateRequest{LicenseKey: a.LicenseKey})
+	cases := []struct {
+		token, role string
+		code        int
+	}{
+		{viewer.Key, middleware.RoleViewer, http.StatusOK},
+		{viewer.Key, middleware.RoleIssuer, http.StatusForbidden},
+		{viewer.Key, middleware.RoleSupport, http.StatusForbidden},
+		{support.Key, middleware.RoleViewer, http.StatusOK},
+		{support.Key, middleware.RoleSupport, http.StatusOK},
+		{support.Key, middleware.RoleIssuer, http.StatusForbidden},
+		{support.Key, middleware.RoleAdmin, http.StatusForbidden},
+		{"test-admin", middleware.RoleIssuer, http.StatusOK},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, c := range cases {
+		if rr := call(ok, c.role, http.MethodGet, c.token, ""); rr.Code != c.code {
+			t.Errorf("%s key on %s route: code=%d want %d", c.token, c.role, rr.Code, c.code)
+		}
+	}
+	if rr := call(RevokeLicense(db, cfg), middleware.RoleAdmin, http.MethodPost, support.Key, string(revoke)); rr.Code != http.StatusForbidden {
+		t.Fatalf("support revoked a license: code=%d", rr.Code)
+	}
+	if rr := call(ListAPIKeys(db), middleware.RoleAdmin, http.MethodGet, viewer.Key, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("viewer listed api keys: code=%d", rr.Code)
+	}
+
+	// promoting the viewer takes effect on its next request
+	if rr := call(UpdateAPIKey(db, cfg), middleware.RoleAdmin, http.MethodPatch, "test-admin", `{"role":"issuer"}`, viewer.ID); rr.Code != http.StatusOK {
+		t.Fatalf("update role: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := call(ok, middleware.RoleIssuer, http.MethodGet, viewer.Key, ""); rr.Code != http.StatusOK {
+		t.Fatalf("promoted key on issuer route: code=%d", rr.Code)
+	}
+	var label string
+	if err := db.QueryRow(`select label from api_keys where id=$1`, viewer.ID).Scan(&label); err != nil || label != "dashboard" {
+		t.Fatalf("role change touched the label: %q err=%v", label, err)
+	}
+}
+
+func TestScopedAPIKeysSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lookup := AdminKeyLookup(db, cfg)
+
+	// call runs h behind the middleware as server.Handler wires it
+	call := func(h http.Handler, access middleware.Access, method, token, body, licenseKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if licenseKey != "" {
+			req.SetPathValue("license_key", licenseKey)
+			h = LicenseInScope(db, h)
+		}
+		rr := httptest.NewRecorder()
+		middleware.WithAccess(cfg, lookup, access, h).ServeHTTP(rr, req)
+		return rr
+	}
+	manage := middleware.Access{Role: middleware.RoleAdmin, Scope: "apikeys:manage"}
+	issue := middleware.Access{Role: middleware.RoleIssuer, Scope: "licenses:issue", LicenseScoped: true}
+	read := middleware.Access{Role: middleware.RoleViewer, Scope: "licenses:read", LicenseScoped: true}
+
+	rr := call(CreateAPIKey(db, cfg), manage, http.MethodPost, "test-admin",
+		`{"label":"reseller","role":"issuer","scopes":["licenses:read","licenses:issue"],"product_ids":["pro"],"customers":["Acme"]}`, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var reseller APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &reseller)
+	if len(reseller.Scopes) != 2 || reseller.ProductIDs[0] != "pro" || reseller.Customers[0] != "Acme" {
+		t.Fatalf("unexpected key %+v", reseller)
+	}
+	if rr := call(CreateAPIKey(db, cfg), manage, http.MethodPost, "test-admin", `{"label":"x","scopes":["licenses:everything"]}`, ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown scope: code=%d", rr.Code)
+	}
+
+	// issuing stays within the key's products and customers
+	body := func(customer string) string {
+		b, _ := json.Marshal(IssueRequest{Customer: customer, ProductID: "pro", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+		return string(b)
+	}
+	rr = call(IssueLicense(db, cfg), issue, http.MethodPost, reseller.Key, body("Acme"), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("issue code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var own LicenseFile
+	_ = json.Unmarshal(rr.Body.Bytes(), &own)
+	if rr := call(IssueLicense(db, cfg), issue, http.MethodPost, reseller.Key, body("Beta"), ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("issue for another customer: code=%d", rr.Code)
+	}
+	batch := `{"licenses":[` + body("Acme") + `,` + body("Beta") + `]}`
+	if rr := call(IssueBatch(db, cfg), issue, http.MethodPost, reseller.Key, batch, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("batch with another customer: code=%d", rr.Code)
+	}
+	other := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", ProductID: "pro", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// other customers' licenses are invisible
+	listed := func() []LicenseSummary {
+		rr := call(ListLicenses(db, cfg), read, http.MethodGet, reseller.Key, "", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp ListLicensesResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.Licenses
+	}
+	if ls := listed(); len(ls) != 1 || ls[0].LicenseKey != own.LicenseKey {
+		t.Fatalf("list returned %+v", ls)
+	}
+	if rr := call(GetLicense(db, cfg), read, http.MethodGet, reseller.Key, "", own.LicenseKey); rr.Code != http.StatusOK {
+		t.Fatalf("get own license: code=%d", rr.Code)
+	}
+	if rr := call(GetLicense(db, cfg), read, http.MethodGet, reseller.Key, "", other.LicenseKey); rr.Code != http.StatusNotFound {
+		t.Fatalf("get another customer's license: code=%d", rr.Code)
+	}
+
+	// routes outside its scopes, or that cannot honor its restrictions, are refused
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, access := range []middleware.Access{
+		{Role: middleware.RoleViewer, Scope: "machines:read"},
+		{Role: middleware.RoleViewer, Scope: "licenses:read"}, // e.g. export
+		{Role: middleware.RoleSupport, Scope: "licenses:write", LicenseScoped: true},
+	} {
+		if rr := call(ok, access, http.MethodGet, reseller.Key, "", ""); rr.Code != http.StatusForbidden {
+			t.Errorf("%+v: code=%d want 403", access, rr.Code)
+		}
+	}
+	if rr := call(ok, read, http.MethodGet, "test-admin", "", other.LicenseKey); rr.Code != http.StatusOK {
+		t.Fatalf("bootstrap key: code=%d", rr.Code)
+	}
+
+	// lifting the customer restriction leaves the product one
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"customers":[]}`))
+	req.Header.Set("Authorization", "Bearer test-admin")
+	req.SetPathValue("key_id", reseller.ID)
+	rr = httptest.NewRecorder()
+	middleware.WithAccess(cfg, lookup, manage, UpdateAPIKey(db, cfg)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if ls := listed(); len(ls) != 2 {
+		t.Fatalf("after lifting customers, list returned %d licenses", len(ls))
+	}
+}
+
+func TestGraphQLSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	a := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour), MaxActivations: 2})
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour)})
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", MachineID: "MID-3", ExpiresAt: time.Now().Add(time.Hour)})
+	body, _ := json.Marshal(ValidateRequest{LicenseKey: a.LicenseKey, MachineID: "MID-1", Machine: &MachineInfo{Hostname: "build-01"}})
+	ActivateLicense(db, cfg).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	query := func(q string, vars map[string]any) (map[string]any, []any) {
+		body, _ := json.Marshal(map[string]any{"query": q, "variables": vars})
+		rr := httptest.NewRecorder()
+		GraphQL(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/graphql", bytes.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("graphql code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Data   map[string]any `json:"data"`
+			Errors []any          `json:"errors"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data, resp.Errors
+	}
+
+	data, errs := query(`query($c: String) {
+		customers(search: $c) { name license_count licenses(limit: 1) { customer } }
+		license(license_key: "`+a.LicenseKey+`") {
+			customer
+			activations { machine_id machine { hostname licenses { license_key } } }
+			audit_events { action license { customer } }
+		}
+		missing: license(license_key: "nope") { id }
+	}`, map[string]any{"c": "ACM"})
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	got, _ := json.Marshal(data)
+	want := `{"customers":[{"license_count":2,"licenses":[{"customer":"Acme"}],"name":"Acme"}],` +
+		`"license":{"activations":[{"machine":{"hostname":"build-01","licenses":[{"license_key":"` + a.LicenseKey + `"}]},"machine_id":"MID-1"}],` +
+		`"audit_events":[{"action":"license.issue","license":{"customer":"Acme"}}],"customer":"Acme"},"missing":null}`
+	if string(got) != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+
+	data, errs = query(`{ machines(limit: 0) { machine_id } licenses(customer: "Beta") { machine_id } }`, nil)
+	if len(errs) != 1 || data["machines"] != nil {
+		t.Fatalf("expected a limit error on machines, got data=%v errors=%v", data, errs)
+	}
+	if l, _ := data["licenses"].([]any); len(l) != 1 {
+		t.Fatalf("licenses should still resolve: %v", data)
+	}
+	if _, errs := query(`{ licenses { password } }`, nil); len(errs) != 1 {
+		t.Fatalf("expected unknown field error, got %v", errs)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: time.Hour}
+	for attempts, want := range cases {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("webhookBackoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// testSQLiteDB opens an in-memory SQLite database with the embedded schema applied.
+func testSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single connection keeps every query on the same in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.EnsureSQLiteSchema(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func issueTestLicense(t *testing.T, db *sql.DB, cfg *config.Config, ir IssueRequest) LicenseFile {
+	t.Helper()
+	b, _ := json.Marshal(ir)
+	rr := httptest.NewRecorder()
+	IssueLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/issue", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("issue code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var lf LicenseFile
+	if err := json.Unmarshal(rr.Body.Bytes(), &lf); err != nil {
+		t.Fatal(err)
+	}
+	return lf
+}
+
+func validateTestLicense(t *testing.T, db *sql.DB, cfg *config.Config, key, machine string) ValidateResponse {
+	t.Helper()
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: key, MachineID: machine})
+	rr := httptest.NewRecorder()
+	ValidateLicense(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/validate", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("validate code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp ValidateResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// minimal config with ephemeral keys for tests.
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	priv, pub, err := crypto.GeneratePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	hash, err := bcrypt.GenerateFromPassword([]byte("test-admin"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Server.AdminAPIKeyHashes = []string{string(hash)}
+	cfg.Server.Addr = ":0"
+	cfg.Signing.PrivateKeyPEM = priv
+	cfg.Signing.PublicKeyPEM = pub
+	return cfg
+}
+
+func TestReadySQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	ready := func() (int, Readiness) {
+		rr := httptest.NewRecorder()
+		Ready(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp Readiness
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Code, resp
+	}
+
+	if code, resp := ready(); code != http.StatusOK || !resp.OK || len(resp.Checks) != 3 {
+		t.Fatalf("expected ready, got %d %+v", code, resp)
+	}
+	if _, resp := ready(); resp.Database.Driver != "sqlite3" || resp.Database.LatencyMS < 0 || resp.Database.MigrationVersion == "" {
+		t.Fatalf("database status %+v", resp.Database)
+	}
+
+	// a forgotten migration fails only that check
+	if _, err := db.Exec(`DELETE FROM schema_migrations WHERE version='0018_validation_events'`); err != nil {
+		t.Fatal(err)
+	}
+	code, resp := ready()
+	if m := resp.Checks["migrations"]; code != http.StatusServiceUnavailable || m.OK || !strings.Contains(m.Error, "0018_validation_events") || !resp.Checks["database"].OK {
+		t.Fatalf("expected migration failure, got %d %+v", code, resp)
+	}
+
+	// a mismatched key pair fails
+	cfg = testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Signing.PublicKeyPEM = testConfig(t).Signing.PublicKeyPEM
+	if _, resp := ready(); resp.Checks["signing_key"].OK {
+		t.Fatalf("expected bad key, got %+v", resp)
+	}
+
+	db.Close()
+	if code, resp := ready(); code != http.StatusServiceUnavailable || resp.Checks["database"].OK {
+		t.Fatalf("expected database failure, got %d %+v", code, resp)
+	}
+}
+
+func TestInTxSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	insert := func(tx *sql.Tx, id string) error {
+		_, err := tx.Exec(`insert into webhooks (id, url, secret, events, active, created_at) values ($1, 'https://example.com', 's', '[]', true, $2)`, id, time.Now().UTC())
+		return err
+	}
+	count := func() int {
+		var n int
+		if err := db.QueryRow(`select count(*) from webhooks`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// a serialization failure reruns fn on a fresh transaction
+	runs := 0
+	rr := httptest.NewRecorder()
+	ok := inTx(rr, httptest.NewRequest(http.MethodPost, "/", nil), db, "test", func(tx *sql.Tx) error {
+		runs++
+		if err := insert(tx, "wh-1"); err != nil {
+			return err
+		}
+		if runs < 3 {
+			return fmt.Errorf("commit: %w", &pgconn.PgError{Code: "40001"})
+		}
+		return nil
+	})
+	if !ok || runs != 3 || count() != 1 {
+		t.Fatalf("ok=%v runs=%d rows=%d", ok, runs, count())
+	}
+
+	// a txAnswer rolls back and is sent as is
+	rr = httptest.NewRecorder()
+	ok = inTx(rr, httptest.NewRequest(http.MethodPost, "/", nil), db, "test", func(tx *sql.Tx) error {
+		if err := insert(tx, "wh-2"); err != nil {
+			return err
+		}
+		return txAnswer{http.StatusConflict, map[string]string{"reason": "taken"}}
+	})
+	if ok || rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), `"taken"`) || count() != 1 {
+		t.Fatalf("ok=%v code=%d body=%s rows=%d", ok, rr.Code, rr.Body.String(), count())
+	}
+
+	// other errors are a 500 and aren't rerun
+	runs = 0
+	rr = httptest.NewRecorder()
+	ok = inTx(rr, httptest.NewRequest(http.MethodPost, "/", nil), db, "test", func(*sql.Tx) error {
+		runs++
+		return sql.ErrConnDone
+	})
+	if ok || rr.Code != http.StatusInternalServerError || runs != 1 {
+		t.Fatalf("ok=%v code=%d runs=%d", ok, rr.Code, runs)
+	}
+}
+
+func TestSchemaDriftSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	status := func() SchemaStatus {
+		rr := httptest.NewRecorder()
+		MigrationStatus(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/migrations", nil))
+		var s SchemaStatus
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &s) != nil {
+			t.Fatalf("status code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		return s
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	call := func(method string) int {
+		rr := httptest.NewRecorder()
+		NewSchemaGuard(db, cfg).Wrap(ok).ServeHTTP(rr, httptest.NewRequest(method, "/api/v1/licenses", nil))
+		return rr.Code
+	}
+
+	if s := status(); !s.Tracked || s.Drift != "" || len(s.Pending) != 0 || s.Version == "" {
+		t.Fatalf("fresh database: %+v", s)
+	}
+	if code := call(http.MethodPost); code != http.StatusNoContent {
+		t.Fatalf("write without drift: %d", code)
+	}
+
+	// a newer binary's migration blocks writes but not reads
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version) VALUES ('9999_future')`); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s.Drift != DriftAhead || len(s.Unknown) != 1 || s.Unknown[0] != "9999_future" {
+		t.Fatalf("ahead: %+v", s)
+	}
+	if code := call(http.MethodPost); code != http.StatusServiceUnavailable {
+		t.Fatalf("write while ahead: %d", code)
+	}
+	if code := call(http.MethodGet); code != http.StatusNoContent {
+		t.Fatalf("read while ahead: %d", code)
+	}
+
+	if _, err := db.Exec(`DELETE FROM schema_migrations WHERE version IN ('9999_future', '0018_validation_events')`); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s.Drift != DriftBehind || len(s.Pending) != 1 {
+		t.Fatalf("behind: %+v", s)
+	}
+	if code := call(http.MethodDelete); code != http.StatusServiceUnavailable {
+		t.Fatalf("write while behind: %d", code)
+	}
+}
+
+func TestBackupSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	backup := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		CreateBackup(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/backups", nil))
+		return rr
+	}
+	if rr := backup(); rr.Code != http.StatusNotFound {
+		t.Fatalf("without backup_dir: code=%d", rr.Code)
+	}
+	cfg.DB.SQLite.BackupDir = filepath.Join(t.TempDir(), "backups")
+	rr := backup()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("backup code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var b Backup
+	_ = json.Unmarshal(rr.Body.Bytes(), &b)
+	if filepath.Dir(b.Path) != cfg.DB.SQLite.BackupDir || b.SizeBytes == 0 {
+		t.Fatalf("backup %+v", b)
+	}
+	copied, err := sql.Open("sqlite3", b.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	var n int
+	if err := copied.QueryRow(`select count(*) from licenses`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("backup holds %d licenses (%v)", n, err)
+	}
+}
+
+func TestOIDCSessionSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	idp := oidctest.NewServer(t, "raal")
+	cfg.OIDC.IssuerURL, cfg.OIDC.ClientID, cfg.OIDC.RedirectURL = idp.URL, "raal", "http://raal.test/auth/oidc/callback"
+	cfg.OIDC.AllowedDomains = []string{"example.com"}
+	cfg.OIDC.Roles = map[string]string{"ada@example.com": "support"}
+	p := oidc.New(oidc.Options{IssuerURL: idp.URL, ClientID: "raal", RedirectURL: cfg.OIDC.RedirectURL})
+	lookup := AdminKeyLookup(db, cfg)
+
+	// signIn runs login, the provider and the callback, returning the
+	// callback's response
+	signIn := func(tamper func(q url.Values)) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		OIDCLogin(cfg, p).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		if rr.Code != http.StatusFound {
+			t.Fatalf("login code=%d body=%s", rr.Code, rr.Body.String())
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		back, _ := url.Parse(resp.Header.Get("Location"))
+		q := back.Query()
+		if tamper != nil {
+			tamper(q)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+q.Encode(), nil)
+		for _, c := range rr.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		cb := httptest.NewRecorder()
+		OIDCCallback(db, cfg, p).ServeHTTP(cb, req)
+		return cb
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	call := func(h http.Handler, role, method string, cookies []*http.Cookie, csrf string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if csrf != "" {
+			req.Header.Set(middleware.CSRFHeader, csrf)
+		}
+		rr := httptest.NewRecorder()
+		middleware.WithRole(cfg, lookup, role, h).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := signIn(nil)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/static/admin.html" {
+		t.Fatalf("callback code=%d location=%q body=%s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+	var cookies []*http.Cookie
+	var csrf string
+	for _, c := range rr.Result().Cookies() {
+		switch c.Name {
+		case middleware.SessionCookie:
+			if !c.HttpOnly || !strings.HasPrefix(c.Value, sessionPrefix) {
+				t.Fatalf("session cookie %+v", c)
+			}
+			cookies = append(cookies, c)
+		case middleware.CSRFCookie:
+			csrf = c.Value
+			cookies = append(cookies, c)
+		}
+	}
+	if len(cookies) != 2 {
+		t.Fatalf("cookies %+v", rr.Result().Cookies())
+	}
+
+	// the session has ada's mapped role
+	rr = call(CurrentSession(), middleware.RoleViewer, http.MethodGet, cookies, "")
+	var sess Session
+	_ = json.Unmarshal(rr.Body.Bytes(), &sess)
+	if rr.Code != http.StatusOK || sess.Actor != "oidc:ada@example.com" {
+		t.Fatalf("session code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := call(ok, middleware.RoleAdmin, http.MethodGet, cookies, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("admin route as support: code=%d", rr.Code)
+	}
+	// writes need the CSRF token echoed
+	if rr := call(ok, middleware.RoleSupport, http.MethodPost, cookies, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("post without csrf: code=%d", rr.Code)
+	}
+	if rr := call(ok, middleware.RoleSupport, http.MethodPost, cookies, "wrong"); rr.Code != http.StatusForbidden {
+		t.Fatalf("post with wrong csrf: code=%d", rr.Code)
+	}
+	if rr := call(ok, middleware.RoleSupport, http.MethodPost, cookies, csrf); rr.Code != http.StatusNoContent {
+		t.Fatalf("post with csrf: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var logins int
+	if err := db.QueryRow(`select count(*) from audit_log where action=$1 and actor=$2`, AuditSessionLogin, "oidc:ada@example.com").Scan(&logins); err != nil || logins != 1 {
+		t.Fatalf("login audit count=%d err=%v", logins, err)
+	}
+
+	// a forged state, or an email outside allowed_domains, gets no session
+	if rr := signIn(func(q url.Values) { q.Set("state", "forged") }); rr.Code != http.StatusBadRequest {
+		t.Fatalf("forged state: code=%d", rr.Code)
+	}
+	idp.Email = "eve@elsewhere.example"
+	if rr := signIn(nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("other domain: code=%d", rr.Code)
+	}
+
+	// logout ends the session
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	Logout(db, cfg).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("logout code=%d", rr.Code)
+	}
+	if rr := call(CurrentSession(), middleware.RoleViewer, http.MethodGet, cookies, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("after logout: code=%d", rr.Code)
+	}
+}
+
+func TestClientCertSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// a CA issues agent certificates named after machines
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "agents"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true, IsCA: true,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+	agent := func(cn string, signer *ecdsa.PrivateKey, parent *x509.Certificate) *x509.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: cn},
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, parent, key.Public(), signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	cfg.MTLS.ClientCAPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+	cfg.MTLS.ForwardedCertHeader = "X-Client-Cert"
+
+	validate := func(machine string, cert *x509.Certificate, forwarded bool) int {
+		b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: machine})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses/validate", bytes.NewReader(b))
+		switch {
+		case cert != nil && forwarded:
+			req.Header.Set("X-Client-Cert", url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))))
+		case cert != nil:
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		}
+		rr := httptest.NewRecorder()
+		ValidateLicense(db, cfg).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	mid1 := agent("MID-1", caKey, ca)
+	if code := validate("MID-1", nil, false); code != http.StatusUnauthorized {
+		t.Fatalf("no certificate: code=%d", code)
+	}
+	if code := validate("MID-1", mid1, false); code != http.StatusOK {
+		t.Fatalf("machine certificate: code=%d", code)
+	}
+	if code := validate("MID-1", mid1, true); code != http.StatusOK {
+		t.Fatalf("forwarded certificate: code=%d", code)
+	}
+	// a stolen key is no use from another host, even with its own certificate
+	if code := validate("MID-1", agent("MID-2", caKey, ca), false); code != http.StatusForbidden {
+		t.Fatalf("another machine's certificate: code=%d", code)
+	}
+	selfKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if code := validate("MID-1", agent("MID-1", selfKey, caTmpl), false); code != http.StatusUnauthorized {
+		t.Fatalf("certificate from another CA: code=%d", code)
+	}
+
+	// bound to the customer, any of its machines may use an Acme certificate
+	cfg.MTLS.Bind = "customer"
+	if code := validate("MID-1", agent("Acme", caKey, ca), false); code != http.StatusOK {
+		t.Fatalf("customer certificate: code=%d", code)
+	}
+	if code := validate("MID-1", mid1, false); code != http.StatusForbidden {
+		t.Fatalf("machine certificate with customer binding: code=%d", code)
+	}
+
+	b, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-1"})
+	rr := httptest.NewRecorder()
+	Heartbeat(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/heartbeat", bytes.NewReader(b)))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("heartbeat without certificate: code=%d", rr.Code)
+	}
+}
+
+func TestAuthLockoutSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Server.AuthLockout.Threshold = 3
+	middleware.SetFailureStore(AuthFailureStore(db, cfg))
+	t.Cleanup(func() { middleware.SetFailureStore(nil) })
+
+	call := func(remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil)
+		req.RemoteAddr = remote + ":40000"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		middleware.WithRole(cfg, nil, middleware.RoleViewer, Stats(db, cfg)).ServeHTTP(rr, req)
+		return rr
+	}
+	for i := 0; i < 3; i++ {
+		if rr := call("198.51.100.9", "guess"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: code=%d", i, rr.Code)
+		}
+	}
+	// locked out, even with the right key, but only that client
+	rr := call("198.51.100.9", "test-admin")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("locked out: code=%d retry-after=%q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := call("198.51.100.10", "test-admin"); rr.Code != http.StatusOK {
+		t.Fatalf("other client: code=%d", rr.Code)
+	}
+
+	// the lockout is in the database, so a restarted (or another) server sees it
+	middleware.SetFailureStore(AuthFailureStore(db, cfg))
+	if rr := call("198.51.100.9", "test-admin"); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("after restart: code=%d", rr.Code)
+	}
+
+	if _, err := db.Exec(`update auth_failures set locked_until=$1`, dbTime(cfg, time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if rr := call("198.51.100.9", "test-admin"); rr.Code != http.StatusOK {
+		t.Fatalf("after the lockout: code=%d", rr.Code)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from auth_failures`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("failures left after a good login: %d err=%v", n, err)
+	}
+}
+
+func TestRequestSignatureSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lf := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// unsigned calls pass until the license gets a secret
+	validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses/"+lf.LicenseKey+"/request-secret", nil)
+	req.SetPathValue("license_key", lf.LicenseKey)
+	rr := httptest.NewRecorder()
+	SetRequestSecret(db, cfg).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("request-secret code=%d body=%s", rr.Code, rr.Body)
+	}
+	var rs RequestSecretResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &rs); err != nil || rs.RequestSecret == "" {
+		t.Fatalf("request-secret body=%s err=%v", rr.Body, err)
+	}
+
+	body, _ := json.Marshal(ValidateRequest{LicenseKey: lf.LicenseKey, MachineID: "MID-1"})
+	call := func(h http.Handler, ts time.Time, secret string, payload []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses/validate", bytes.NewReader(payload))
+		if secret != "" {
+			stamp := strconv.FormatInt(ts.Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(stamp + "."))
+			mac.Write(body)
+			req.Header.Set(RequestTimestampHeader, stamp)
+			req.Header.Set(RequestSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	now := time.Now()
+	if rr := call(ValidateLicense(db, cfg), now, rs.RequestSecret, body); rr.Code != http.StatusOK {
+		t.Fatalf("signed validate code=%d body=%s", rr.Code, rr.Body)
+	}
+	if rr := call(Heartbeat(db, cfg), now, rs.RequestSecret, body); rr.Code != http.StatusOK {
+		t.Fatalf("signed heartbeat code=%d body=%s", rr.Code, rr.Body)
+	}
+	for name, rr := range map[string]*httptest.ResponseRecorder{
+		"unsigned":       call(ValidateLicense(db, cfg), now, "", body),
+		"wrong secret":   call(ValidateLicense(db, cfg), now, "scraped", body),
+		"stale":          call(ValidateLicense(db, cfg), now.Add(-10*time.Minute), rs.RequestSecret, body),
+		"tampered body":  call(ValidateLicense(db, cfg), now, rs.RequestSecret, bytes.Replace(body, []byte("MID-1"), []byte("MID-2"), 1)),
+		"heartbeat bare": call(Heartbeat(db, cfg), now, "", body),
+	} {
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: code=%d body=%s", name, rr.Code, rr.Body)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/licenses/"+lf.LicenseKey+"/request-secret", nil)
+	req.SetPathValue("license_key", lf.LicenseKey)
+	rr = httptest.NewRecorder()
+	ClearRequestSecret(db, cfg).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("clear request-secret code=%d body=%s", rr.Code, rr.Body)
+	}
+	validateTestLicense(t, db, cfg, lf.LicenseKey, "MID-1")
+}
+
+func TestAdminLoginSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lookup := AdminKeyLookup(db, cfg)
+	login := middleware.WithAccess(cfg, lookup, middleware.Access{Role: middleware.RoleViewer, LicenseScoped: true}, Login(db, cfg))
+
+	// call runs h with token, an API key or session, as the bearer token
+	call := func(h http.Handler, method, token, body string, path ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if len(path) == 1 {
+			req.SetPathValue("key_id", path[0])
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	signIn := func(token string) LoginResponse {
+		t.Helper()
+		rr := call(login, http.MethodPost, token, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("login code=%d body=%s", rr.Code, rr.Body)
+		}
+		var resp LoginResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		var cookie bool
+		for _, c := range rr.Result().Cookies() {
+			cookie = cookie || (c.Name == middleware.SessionCookie && c.Value == resp.Token && c.HttpOnly)
+		}
+		if !strings.HasPrefix(resp.Token, sessionPrefix) || !cookie {
+			t.Fatalf("login response %+v cookies=%v", resp, rr.Result().Cookies())
+		}
+		return resp
+	}
+
+	// the bootstrap key's session is an admin session
+	boot := signIn("test-admin")
+	if boot.Role != middleware.RoleAdmin || !strings.HasPrefix(boot.Actor, "key:") {
+		t.Fatalf("bootstrap login %+v", boot)
+	}
+	if exp, err := time.Parse(time.RFC3339, boot.ExpiresAt); err != nil || time.Until(exp) > cfg.AdminSessionTTL() {
+		t.Fatalf("expires_at=%q err=%v", boot.ExpiresAt, err)
+	}
+	rr := call(middleware.WithAdminKey(cfg, lookup, CreateAPIKey(db, cfg)), http.MethodPost, boot.Token, `{"label":"panel","role":"viewer"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create key with session code=%d body=%s", rr.Code, rr.Body)
+	}
+	var key APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &key)
+
+	// a managed key's session keeps its role and actor, and can't renew itself
+	sess := signIn(key.Key)
+	if sess.Role != middleware.RoleViewer || sess.Actor != "apikey:"+key.ID {
+		t.Fatalf("managed key login %+v", sess)
+	}
+	stats := middleware.WithRole(cfg, lookup, middleware.RoleViewer, Stats(db, cfg))
+	if rr := call(stats, http.MethodGet, sess.Token, ""); rr.Code != http.StatusOK {
+		t.Fatalf("stats with session code=%d", rr.Code)
+	}
+	if rr := call(middleware.WithAdminKey(cfg, lookup, ListAPIKeys(db)), http.MethodGet, sess.Token, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("viewer session on admin route code=%d", rr.Code)
+	}
+	if rr := call(login, http.MethodPost, sess.Token, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("session renewing itself code=%d", rr.Code)
+	}
+
+	// revoking the key ends its sessions
+	if rr := call(middleware.WithAdminKey(cfg, lookup, RevokeAPIKey(db, cfg)), http.MethodPost, "test-admin", "", key.ID); rr.Code != http.StatusOK {
+		t.Fatalf("revoke code=%d", rr.Code)
+	}
+	if rr := call(stats, http.MethodGet, sess.Token, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("session of a revoked key code=%d", rr.Code)
+	}
+
+	var logins int
+	if err := db.QueryRow(`select count(*) from audit_log where action=$1`, AuditSessionLogin).Scan(&logins); err != nil || logins != 2 {
+		t.Fatalf("login audit entries=%d err=%v", logins, err)
+	}
+}
+
+func TestAPIKeyRotationOverlapSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lookup := AdminKeyLookup(db, cfg)
+	call := func(h http.Handler, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"label":"deploy"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.SetPathValue("key_id", strings.Split(strings.TrimPrefix(target, "/"), "?")[0])
+		rr := httptest.NewRecorder()
+		middleware.WithAdminKey(cfg, lookup, h).ServeHTTP(rr, req)
+		return rr
+	}
+	rr := call(CreateAPIKey(db, cfg), "/", "test-admin")
+	var old APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &old)
+
+	if rr := call(RotateAPIKey(db, cfg), "/"+old.ID+"?overlap=forever", "test-admin"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad overlap code=%d", rr.Code)
+	}
+	rr = call(RotateAPIKey(db, cfg), "/"+old.ID+"?overlap=1h", "test-admin")
+	var rotated APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+	if rr.Code != http.StatusOK || rotated.PreviousExpiresAt == nil || time.Until(*rotated.PreviousExpiresAt) > time.Hour {
+		t.Fatalf("rotate code=%d body=%s", rr.Code, rr.Body)
+	}
+	stats := func(token string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		middleware.WithRole(cfg, lookup, middleware.RoleViewer, Stats(db, cfg)).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	// both secrets work during the overlap
+	if old, cur := stats(old.Key), stats(rotated.Key); old != http.StatusOK || cur != http.StatusOK {
+		t.Fatalf("during overlap: old=%d new=%d", old, cur)
+	}
+	// and the old one stops by itself afterwards
+	if _, err := db.Exec(`update api_keys set previous_expires_at=$1`, dbTime(cfg, time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if old, cur := stats(old.Key), stats(rotated.Key); old != http.StatusUnauthorized || cur != http.StatusOK {
+		t.Fatalf("after overlap: old=%d new=%d", old, cur)
+	}
+
+	// rotating again without an overlap drops the previous secret at once
+	if _, err := db.Exec(`update api_keys set previous_expires_at=$1`, dbTime(cfg, time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	rr = call(RotateAPIKey(db, cfg), "/"+old.ID, "test-admin")
+	var again APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &again)
+	if again.PreviousExpiresAt != nil || stats(rotated.Key) != http.StatusUnauthorized || stats(old.Key) != http.StatusUnauthorized {
+		t.Fatalf("rotation without overlap: %+v", again)
+	}
+}
+
+func TestRequireLicenseKeySQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	a := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID-1", ExpiresAt: time.Now().Add(time.Hour)})
+	b := issueTestLicense(t, db, cfg, IssueRequest{Customer: "Beta", MachineID: "MID-2", ExpiresAt: time.Now().Add(time.Hour)})
+
+	h := RequireLicenseKey(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body is still there for the handler
+		var req ValidateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && r.ContentLength != 0 {
+			t.Errorf("body consumed: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	call := func(auth, pathKey, bodyKey string) int {
+		var body io.Reader = http.NoBody
+		if bodyKey != "" {
+			body = strings.NewReader(`{"license_key":"` + bodyKey + `","machine_id":"MID-1"}`)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses/heartbeat", body)
+		if pathKey != "" {
+			req.SetPathValue("license_key", pathKey)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "License "+auth)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	cases := []struct {
+		auth, pathKey, bodyKey string
+		code                   int
+	}{
+		{"", a.LicenseKey, "", http.StatusUnauthorized},
+		{"nope", a.LicenseKey, "", http.StatusUnauthorized},
+		{a.LicenseKey, a.LicenseKey, "", http.StatusNoContent},
+		{a.LicenseKey, b.LicenseKey, "", http.StatusForbidden},
+		{a.LicenseKey, "", a.LicenseKey, http.StatusNoContent},
+		{a.LicenseKey, "", b.LicenseKey, http.StatusForbidden},
+		{b.LicenseKey, "", "", http.StatusNoContent},
+	}
+	for i, c := range cases {
+		if got := call(c.auth, c.pathKey, c.bodyKey); got != c.code {
+			t.Errorf("case %d: code=%d want %d", i, got, c.code)
+		}
+	}
+}
+
+func TestAdminTOTPSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	lookup := AdminKeyLookup(db, cfg)
+	access := middleware.Access{Role: middleware.RoleViewer, LicenseScoped: true}
+
+	// call runs h behind admin auth with token, sending code if set
+	call := func(h http.Handler, method, token, code, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if code != "" {
+			req.Header.Set(TOTPHeader, code)
+		}
+		rr := httptest.NewRecorder()
+		middleware.WithAccess(cfg, lookup, access, h).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := call(EnrollTOTP(db, cfg), http.MethodPost, "test-admin", "", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("enroll with TOTP off: code=%d", rr.Code)
+	}
+	cfg.Server.TOTP.EncryptionKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	rr := call(EnrollTOTP(db, cfg), http.MethodPost, "test-admin", "", "")
+	var enr TOTPEnrollment
+	_ = json.Unmarshal(rr.Body.Bytes(), &enr)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(enr.URI, "otpauth://totp/raalisence:key:") {
+		t.Fatalf("enroll code=%d body=%s", rr.Code, rr.Body)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enr.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	_ = db.QueryRow(`select secret_enc from admin_totp`).Scan(&stored)
+	if stored == "" || strings.Contains(stored, enr.Secret) {
+		t.Fatalf("secret stored as %q", stored)
+	}
+	step := totp.Step(time.Now())
+
+	// not required until confirmed
+	guarded := RequireTOTP(db, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+	if rr := call(guarded, http.MethodPost, "test-admin", "", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("pending enrollment guarded: code=%d", rr.Code)
+	}
+	if rr := call(ConfirmTOTP(db, cfg), http.MethodPost, "test-admin", "", `{"code":"000000"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("confirm with a wrong code: code=%d", rr.Code)
+	}
+	if rr := call(ConfirmTOTP(db, cfg), http.MethodPost, "test-admin", "", `{"code":"`+totp.Code(secret, step-1)+`"}`); rr.Code != http.StatusOK {
+		t.Fatalf("confirm code=%d body=%s", rr.Code, rr.Body)
+	}
+	if rr := call(EnrollTOTP(db, cfg), http.MethodPost, "test-admin", "", ""); rr.Code != http.StatusConflict {
+		t.Fatalf("re-enroll: code=%d", rr.Code)
+	}
+
+	cases := []struct {
+		code string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"000000", http.StatusUnauthorized},
+		{totp.Code(secret, step), http.StatusNoContent},
+		{totp.Code(secret, step), http.StatusUnauthorized},   // replayed
+		{totp.Code(secret, step-1), http.StatusUnauthorized}, // older than the last one used
+		{totp.Code(secret, step+1), http.StatusNoContent},
+	}
+	for i, c := range cases {
+		if rr := call(guarded, http.MethodPost, "test-admin", c.code, ""); rr.Code != c.want {
+			t.Errorf("case %d: code=%d want %d", i, rr.Code, c.want)
+		}
+	}
+
+	// other identities are not affected
+	rr = call(CreateAPIKey(db, cfg), http.MethodPost, "test-admin", "", `{"label":"ops"}`)
+	var other APIKey
+	_ = json.Unmarshal(rr.Body.Bytes(), &other)
+	if rr := call(guarded, http.MethodPost, other.Key, "", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("unenrolled key: code=%d", rr.Code)
+	}
+	rr = call(GetTOTP(db, cfg), http.MethodGet, other.Key, "", "")
+	if !strings.Contains(rr.Body.String(), `"enrolled":false`) {
+		t.Fatalf("other key status %s", rr.Body)
+	}
+
+	// removing it takes a code too
+	disable := RequireTOTP(db, cfg, DisableTOTP(db, cfg))
+	if rr := call(disable, http.MethodDelete, "test-admin", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("disable without code: code=%d", rr.Code)
+	}
+	_, _ = db.Exec(`update admin_totp set last_step=0`) // every current code has been used
+	if rr := call(disable, http.MethodDelete, "test-admin", totp.Code(secret, step), ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("disable code=%d body=%s", rr.Code, rr.Body)
+	}
+	if rr := call(guarded, http.MethodPost, "test-admin", "", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("after disable: code=%d", rr.Code)
+	}
+}
+
+func TestLogLevelSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	t.Cleanup(func() { logging.Override(logging.Info, 0) })
+
+	call := func(h http.Handler, method, body string) (*httptest.ResponseRecorder, LogLevel) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, "/api/v1/log-level", strings.NewReader(body)))
+		var l LogLevel
+		_ = json.Unmarshal(rr.Body.Bytes(), &l)
+		return rr, l
+	}
+	if rr, l := call(GetLogLevel(), http.MethodGet, ""); rr.Code != http.StatusOK || l.Configured != "info" || l.Current != "info" || l.Until != nil {
+		t.Fatalf("get: code=%d %+v", rr.Code, l)
+	}
+	for _, bad := range []string{`{}`, `{"level":"loud"}`, `{"level":"debug","duration":"48h"}`, `{"level":"debug","duration":"soon"}`} {
+		if rr, _ := call(SetLogLevel(db, cfg), http.MethodPut, bad); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: code=%d", bad, rr.Code)
+		}
+	}
+	rr, l := call(SetLogLevel(db, cfg), http.MethodPut, `{"level":"debug","duration":"30m"}`)
+	if rr.Code != http.StatusOK || l.Current != "debug" || l.Configured != "info" || l.Until == nil || time.Until(*l.Until) > 30*time.Minute {
+		t.Fatalf("set: code=%d %+v", rr.Code, l)
+	}
+	if !logging.Enabled(logging.Debug) {
+		t.Fatal("debug not enabled by the override")
+	}
+	if rr, l := call(ResetLogLevel(db, cfg), http.MethodDelete, ""); rr.Code != http.StatusOK || l.Current != "info" || l.Until != nil {
+		t.Fatalf("reset: code=%d %+v", rr.Code, l)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from audit_log where action in ('log_level.set', 'log_level.reset')`).Scan(&n); err != nil || n != 2 {
+		t.Fatalf("audited %d (%v)", n, err)
+	}
+}
+
+func TestAuditRequestsSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.Server.RequestAudit.Enabled = true
+	cfg.Server.RequestAudit.MaxBody = 256
+	cfg.Server.RequestAudit.Redact = []string{"Notes"}
+	var got string
+	h := AuditRequests(db, cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+		http.Error(w, "nope", http.StatusUnprocessableEntity)
+	}))
+	send := func(method, target, body string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if got != body {
+			t.Fatalf("handler read %q, want %q", got, body)
+		}
+	}
+	details := func() (string, map[string]any) {
+		t.Helper()
+		var key, raw string
+		if err := db.QueryRow(`select license_key, details from audit_log where action=$1 order by created_at desc, rowid desc limit 1`, AuditAdminRequest).Scan(&key, &raw); err != nil {
+			t.Fatal(err)
+		}
+		var d map[string]any
+		_ = json.Unmarshal([]byte(raw), &d)
+		return key, d
+	}
+
+	send(http.MethodPost, "/api/v1/webhooks?dry_run=1", `{"url":"https://example.com/hook","secret":"s3cret","auth":{"password":"pw","user":"ops"},"notes":"private","license_key":"LK-1"}`)
+	key, d := details()
+	body, _ := d["body"].(map[string]any)
+	auth, _ := body["auth"].(map[string]any)
+	if key != "LK-1" || d["method"] != "POST" || d["path"] != "/api/v1/webhooks" || d["query"] != "dry_run=1" || d["status"] != float64(422) ||
+		body["secret"] != redacted || auth["password"] != redacted || auth["user"] != "ops" || body["notes"] != redacted || body["url"] != "https://example.com/hook" {
+		t.Fatalf("key=%q details=%v", key, d)
+	}
+
+	// too long, or not JSON: size only
+	long := `{"notes":"` + strings.Repeat("x", 300) + `"}`
+	send(http.MethodPost, "/api/v1/licenses/import", long)
+	if _, d := details(); d["body"] != nil || d["body_truncated"] != true || d["body_bytes"] != float64(257) {
+		t.Fatalf("details=%v", d)
+	}
+	send(http.MethodPost, "/api/v1/licenses/import", "key,customer\nLK-2,Acme\n")
+	if _, d := details(); d["body"] != nil || d["body_truncated"] != false || strings.Contains(fmt.Sprint(d), "Acme") {
+		t.Fatalf("details=%v", d)
+	}
+
+	// reads aren't recorded
+	var n int
+	db.QueryRow(`select count(*) from audit_log`).Scan(&n)
+	send(http.MethodGet, "/api/v1/licenses", "")
+	var after int
+	db.QueryRow(`select count(*) from audit_log`).Scan(&after)
+	if after != n {
+		t.Fatalf("GET recorded: %d -> %d", n, after)
+	}
+}
+
+func TestRefreshMetricsSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+
+	issue := func(expires time.Duration) string {
+		return issueTestLicense(t, db, cfg, IssueRequest{Customer: "Acme", MachineID: "MID", ExpiresAt: time.Now().Add(expires)}).LicenseKey
+	}
+	issue(365 * 24 * time.Hour)
+	issue(3 * 24 * time.Hour)
+	issue(20 * 24 * time.Hour)
+	issue(-time.Hour)
+	revoked := issue(2 * 24 * time.Hour)
+	archived := issue(2 * 24 * time.Hour)
+	if _, err := db.Exec(`update licenses set revoked=true where license_key=$1`, revoked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`update licenses set archived_at=$1 where license_key=$2`, dbTime(cfg, time.Now()), archived); err != nil {
+		t.Fatal(err)
+	}
+	for id, seen := range map[string]time.Duration{"MID-1": time.Hour, "MID-2": 2 * time.Hour, "MID-3": 48 * time.Hour} {
+		if _, err := db.Exec(`insert into machines(machine_id, first_seen_at, last_seen_at) values ($1, $2, $2)`, id, dbTime(cfg, time.Now().Add(-seen))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RefreshMetrics(context.Background(), db, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for g, want := range map[*metrics.Gauge]float64{metricActive: 3, metricExpiring7d: 1, metricExpiring30d: 2, metricRevoked: 1, metricMachines24h: 2} {
+		if g.Value() != want {
+			t.Fatalf("gauge = %v, want %v", g.Value(), want)
+		}
+	}
+	if time.Since(time.Unix(int64(metricRefreshed.Value()), 0)) > time.Minute {
+		t.Fatalf("refreshed at %v", metricRefreshed.Value())
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	events := make(chan errreport.Event, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e errreport.Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer sink.Close()
+	rep, err := errreport.New(errreport.Options{URL: sink.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rep.Close(context.Background())
+
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		internalError(w, "test.query", fmt.Errorf("connection reset"))
+	}
+	h := middleware.WithRequestID(ReportErrors(rep, "POST /api/v1/licenses", http.HandlerFunc(failing)))
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/licenses", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("code=%d", rr.Code)
+	}
+	select {
+	case e := <-events:
+		if e.Op != "test.query" || e.Error != "connection reset" || e.RequestID != "req-42" || e.Route != "POST /api/v1/licenses" ||
+			len(e.Stack) == 0 || !strings.Contains(e.Stack[0].Function, "TestReportErrors") {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error reported")
+	}
+}
+
+func TestStaleLicensesSQLite(t *testing.T) {
+	db := testSQLiteDB(t)
+	cfg := testConfig(t)
+	cfg.DB.Driver = "sqlite3"
+	cfg.Stale.After = 24 * time.Hour
+	ctx := context.Background()
+
+	seen := func(key string, ago time.Duration) {
+		if _, err := db.Exec(`update licenses set last_seen_at=$1 where license_key=$2`, dbTime(cfg, time.Now().Add(-ago)), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issue := func(customer string) string {
+		return issueTestLicense(t, db, cfg, IssueRequest{Customer: customer, MachineID: "MID-" + customer, ExpiresAt: time.Now().Add(365 * 24 * time.Hour)}).LicenseKey
+	}
+	oldest, older, fresh, revoked := issue("Acme"), issue("Beta"), issue("Gamma"), issue("Delta")
+	issue("Never") // never seen: not stale
+	seen(oldest, 72*time.Hour)
+	seen(older, 48*time.Hour)
+	seen(fresh, time.Hour)
+	seen(revoked, 72*time.Hour)
+	if _, err := db.Exec(`update licenses set revoked=true where license_key=$1`, revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	events := func() int {
+		var n int
+		db.QueryRow(`select count(*) from events where event=$1`, EventLicenseHeartbeatStale).Scan(&n)
+		return n
+	}
+	if n, err := ScanStaleLicenses(ctx, db, cfg); err != nil || n != 2 || metricStale.Value() != 2 || events() != 0 {
+		t.Fatalf("scan without webhook: n=%d gauge=%v events=%d (%v)", n, metricStale.Value(), events(), err)
+	}
+	// already seen as stale, so turning the webhook on fires nothing until
+	// a license goes stale again after a heartbeat
+	cfg.Stale.Webhook = true
+	seen(older, 30*time.Hour)
+	if _, err := db.Exec(`update licenses set stale_notified_at=$1 where license_key=$2`, dbTime(cfg, time.Now().Add(-40*time.Hour)), older); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ScanStaleLicenses(ctx, db, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := events(); n != 1 {
+		t.Fatalf("heartbeat_stale events = %d, want 1", n)
+	}
+
+	list := func(query string) ListStaleLicensesResponse {
+		rr := httptest.NewRecorder()
+		ListStaleLicenses(db, cfg).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/stale"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list%s: code=%d body=%s", query, rr.Code, rr.Body.String())
+		}
+		var resp ListStaleLicensesResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp
+	}
+	page := list("?limit=1")
+	if page.StaleAfter != "24h0m0s" || len(page.Licenses) != 1 || page.Licenses[0].LicenseKey != oldest || page.Licenses[0].MachineID != "MID-Acme" ||
+		!strings.HasPrefix(page.Licenses[0].SilentFor, "72h") || page.NextCursor == "" {
+		t.Fatalf("page 1: %+v", page)
+	}
+	page = list("?limit=1&cursor=" + page.NextCursor)
+	if len(page.Licenses) != 1 || page.Licenses[0].LicenseKey != older || page.NextCursor != "" {
+		t.Fatalf("page 2: %+v", page)
+	}
+	if page = list("?customer=Beta"); len(page.Licenses) != 1 || page.Licenses[0].LicenseKey != older {
+		t.Fatalf("customer filter: %+v", page)
+	}
 }
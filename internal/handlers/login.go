@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/oidc"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// adminSession is a row of admin_sessions.
+type adminSession struct {
+	subject, email, role string
+	// actor is set for sessions from an API key login; OIDC sessions get
+	// theirs from subject and email.
+	actor string
+	// apiKeyID is the managed key a login session stands in for.
+	apiKeyID string
+}
+
+// LoginResponse is a new admin panel session. The token is also set as the
+// session cookie; the panel needs neither once it has the cookie.
+type LoginResponse struct {
+	Token     string `json:"token"`
+	Actor     string `json:"actor"`
+	Role      string `json:"role"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// Login exchanges the admin API key in the Authorization header, or an OIDC
+// session, for a session of server.admin_session_ttl, so the admin panel
+// can drop the long-lived key. Sessions from a managed key keep its scope
+// and end when it is revoked.
+func Login(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// a login session renewing itself would never run out
+		renewal, err := isLoginSession(r, db)
+		if err != nil {
+			internalError(w, "login", err)
+			return
+		}
+		if renewal {
+			http.Error(w, "forbidden: sign in with an API key or SSO", http.StatusForbidden)
+			return
+		}
+		actor := middleware.GetAdminActor(r)
+		s := adminSession{subject: actor, role: middleware.GetAdminRole(r), actor: actor}
+		if id, ok := strings.CutPrefix(actor, apiKeyActorPrefix); ok {
+			s.apiKeyID = id
+		}
+		token, expires, err := startSession(w, r, db, cfg, s, cfg.AdminSessionTTL(), func(tx *sql.Tx, expires time.Time) error {
+			return auditTx(r, tx, cfg, AuditSessionLogin, "", map[string]any{"role": s.role, "expires_at": expires.Format(time.RFC3339)})
+		})
+		if err != nil {
+			internalError(w, "login", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, LoginResponse{Token: token, Actor: actor, Role: s.role, ExpiresAt: expires.Format(time.RFC3339)})
+	})
+}
+
+// isLoginSession reports whether r is authenticated by a session from an
+// earlier Login rather than by a key or OIDC.
+func isLoginSession(r *http.Request, db *sql.DB) (bool, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		c, err := r.Cookie(middleware.SessionCookie)
+		if err != nil {
+			return false, nil
+		}
+		token = c.Value
+	}
+	if !strings.HasPrefix(token, sessionPrefix) {
+		return false, nil
+	}
+	var actor string
+	err := db.QueryRowContext(r.Context(), `select actor from admin_sessions where token_hash=$1`, hashAPIKey(token)).Scan(&actor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return actor != "", err
+}
+
+// startSession stores s, good for ttl, with the audit entry that audit
+// writes in the same transaction, then sets the session and CSRF cookies.
+// It returns the session token and when it expires.
+func startSession(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, s adminSession, ttl time.Duration, audit func(tx *sql.Tx, expires time.Time) error) (string, time.Time, error) {
+	token, hash, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	csrf, err := oidc.RandomString(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	expires := now.Add(ttl)
+	if _, err := db.ExecContext(r.Context(), `delete from admin_sessions where expires_at <= $1`, dbTime(cfg, now)); err != nil {
+		logging.Errorf("admin session cleanup error err=%v", err)
+	}
+	err = store.RetryTx(r.Context(), db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(), `insert into admin_sessions (id, token_hash, subject, email, role, actor, api_key_id, created_at, expires_at) values ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+			uuid.NewString(), hash, s.subject, s.email, s.role, s.actor, s.apiKeyID, dbTime(cfg, now), dbTime(cfg, expires)); err != nil {
+			return err
+		}
+		return audit(tx, expires)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	maxAge := int(ttl.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name: middleware.SessionCookie, Value: token, Path: "/", MaxAge: maxAge,
+		HttpOnly: true, Secure: secureCookies(r, cfg), SameSite: http.SameSiteLaxMode,
+	})
+	// readable by the admin panel, which echoes it in X-CSRF-Token
+	http.SetCookie(w, &http.Cookie{
+		Name: middleware.CSRFCookie, Value: csrf, Path: "/", MaxAge: maxAge,
+		Secure: secureCookies(r, cfg), SameSite: http.SameSiteStrictMode,
+	})
+	return token, expires, nil
+}
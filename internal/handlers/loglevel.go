@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+// maxLogOverride caps how long a runtime log level override lasts, so one
+// forgotten during an incident doesn't flood the logs for good.
+const maxLogOverride = 24 * time.Hour
+
+// LogLevel is the server's log level: log.level, and the override in force
+// until Until, if any.
+type LogLevel struct {
+	Configured string     `json:"configured"`
+	Current    string     `json:"current"`
+	Until      *time.Time `json:"until,omitempty"`
+}
+
+// SetLogLevelRequest overrides the log level for Duration (default 15m, at
+// most 24h).
+type SetLogLevelRequest struct {
+	Level    string `json:"level"`
+	Duration string `json:"duration,omitempty"`
+}
+
+func logLevel() LogLevel {
+	configured, current, until := logging.Status()
+	l := LogLevel{Configured: configured.String(), Current: current.String()}
+	if !until.IsZero() {
+		u := until.UTC()
+		l.Until = &u
+	}
+	return l
+}
+
+// GetLogLevel reports the log level in force.
+func GetLogLevel() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, logLevel())
+	})
+}
+
+// SetLogLevel changes the log level of this process for a while, e.g. to
+// debug during an incident; it then falls back to log.level by itself.
+// Other replicas keep theirs.
+func SetLogLevel(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SetLogLevelRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		level, err := logging.ParseLevel(req.Level)
+		if err != nil || req.Level == "" {
+			http.Error(w, "level must be debug, info, warn or error", http.StatusBadRequest)
+			return
+		}
+		d := 15 * time.Minute
+		if req.Duration != "" {
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 || d > maxLogOverride {
+				http.Error(w, "duration must be a positive duration of at most 24h, e.g. 30m", http.StatusBadRequest)
+				return
+			}
+		}
+		logging.Override(level, d)
+		logging.Warnf("log level override level=%s for=%s", level, d)
+		recordAudit(r, db, cfg, AuditLogLevelSet, "", map[string]any{"level": level.String(), "duration": d.String()})
+		writeJSON(w, http.StatusOK, logLevel())
+	})
+}
+
+// ResetLogLevel ends a log level override early.
+func ResetLogLevel(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		logging.Override(logging.Info, 0)
+		recordAudit(r, db, cfg, AuditLogLevelReset, "", nil)
+		writeJSON(w, http.StatusOK, logLevel())
+	})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+const (
+	maxFingerprintComponents = 32
+	maxMachineFieldLen       = 256
+)
+
+// MachineInfo is what a client reports about its hardware on activate and
+// heartbeat. Fingerprint holds the components its machine_id was derived
+// from (e.g. "cpu", "board_serial", "mac"), so support can tell what changed
+// when a machine_id stops matching.
+type MachineInfo struct {
+	Hostname    string            `json:"hostname,omitempty"`
+	OS          string            `json:"os,omitempty"`
+	Fingerprint map[string]string `json:"fingerprint,omitempty"`
+}
+
+type Machine struct {
+	MachineID      string            `json:"machine_id"`
+	Hostname       string            `json:"hostname,omitempty"`
+	OS             string            `json:"os,omitempty"`
+	Fingerprint    map[string]string `json:"fingerprint"`
+	FirstSeenAt    time.Time         `json:"first_seen_at"`
+	LastSeenAt     time.Time         `json:"last_seen_at"`
+	LastLicenseKey string            `json:"last_license_key,omitempty"`
+}
+
+// MachineLicense is a license the machine is activated against.
+type MachineLicense struct {
+	LicenseKey  string    `json:"license_key"`
+	ProductID   string    `json:"product_id,omitempty"`
+	Customer    string    `json:"customer"`
+	Revoked     bool      `json:"revoked"`
+	ActivatedAt time.Time `json:"activated_at"`
+}
+
+type MachineDetail struct {
+	Machine
+	Licenses []MachineLicense `json:"licenses"`
+}
+
+type ListMachinesResponse struct {
+	Machines   []Machine `json:"machines"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// validate bounds what unauthenticated clients can store.
+func (m *MachineInfo) validate() error {
+	if m == nil {
+		return nil
+	}
+	if len(m.Hostname) > maxMachineFieldLen || len(m.OS) > maxMachineFieldLen {
+		return fmt.Errorf("machine hostname and os are limited to %d bytes", maxMachineFieldLen)
+	}
+	if len(m.Fingerprint) > maxFingerprintComponents {
+		return fmt.Errorf("machine fingerprint is limited to %d components", maxFingerprintComponents)
+	}
+	for k, v := range m.Fingerprint {
+		if k == "" || len(k) > maxMachineFieldLen || len(v) > maxMachineFieldLen {
+			return fmt.Errorf("machine fingerprint components need a name and are limited to %d bytes", maxMachineFieldLen)
+		}
+	}
+	return nil
+}
+
+// recordMachine upserts the machines row for machineID, marking it seen now
+// under licenseKey. Empty fields in info keep what was stored before, and a
+// missing fingerprint leaves the stored one alone.
+func recordMachine(ctx context.Context, db store.Querier, cfg *config.Config, machineID, licenseKey string, info *MachineInfo) error {
+	if info == nil {
+		info = &MachineInfo{}
+	}
+	return dbStore(db, cfg).RecordMachine(ctx, store.MachineReport{
+		MachineID: machineID, LicenseKey: licenseKey, Hostname: info.Hostname, OS: info.OS, Fingerprint: info.Fingerprint,
+	})
+}
+
+const machineColumns = `machine_id, hostname, os, fingerprint, first_seen_at, last_seen_at, last_license_key`
+
+func scanMachine(row rowScanner) (Machine, error) {
+	var m Machine
+	var fingerprint []byte
+	var first, last nullTime
+	if err := row.Scan(&m.MachineID, &m.Hostname, &m.OS, &fingerprint, &first, &last, &m.LastLicenseKey); err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(fingerprint, &m.Fingerprint); err != nil || m.Fingerprint == nil {
+		m.Fingerprint = map[string]string{}
+	}
+	m.FirstSeenAt, m.LastSeenAt = first.Time, last.Time
+	return m, nil
+}
+
+// ListMachines pages through known machines, most recently first seen
+// first. ?license_key= narrows to machines activated against that license;
+// ?hostname= matches a substring, case-insensitively.
+func ListMachines(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		var conds []string
+		var args []any
+		limit := defaultListLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxListLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if v := q.Get("cursor"); v != "" {
+			firstSeen, id, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, firstSeen, id)
+			conds = append(conds, keysetCond("first_seen_at", "machine_id", "<", len(args)-1))
+		}
+		if key := q.Get("license_key"); key != "" {
+			args = append(args, key)
+			conds = append(conds, fmt.Sprintf("exists (select 1 from activations a where a.machine_id=machines.machine_id and a.license_key=$%d)", len(args)))
+		}
+		if host := q.Get("hostname"); host != "" {
+			args = append(args, "%"+store.EscapeLike(host)+"%")
+			conds = append(conds, fmt.Sprintf(`hostname %s $%d escape '\'`, likeOp(cfg), len(args)))
+		}
+		query := `select ` + machineColumns + ` from machines`
+		if len(conds) > 0 {
+			query += " where " + strings.Join(conds, " and ")
+		}
+		query += fmt.Sprintf(" order by first_seen_at desc, machine_id desc limit %d", limit+1)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			internalError(w, "machines.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListMachinesResponse{Machines: []Machine{}}
+		for rows.Next() {
+			if len(resp.Machines) == limit {
+				last := resp.Machines[limit-1]
+				// the cursor has to compare equal to the stored column value
+				resp.NextCursor = encodeListCursor(cursorTime(cfg, last.FirstSeenAt), last.MachineID)
+				break
+			}
+			m, err := scanMachine(rows)
+			if err != nil {
+				internalError(w, "machines.list.scan", err)
+				return
+			}
+			resp.Machines = append(resp.Machines, m)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "machines.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// GetMachine returns the {machine_id} machine with the licenses it is
+// activated against, oldest activation first.
+func GetMachine(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.PathValue("machine_id")
+		if id == "" {
+			http.Error(w, "machine_id required", http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		m, err := scanMachine(db.QueryRowContext(ctx, `select `+machineColumns+` from machines where machine_id=$1`, id))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, "machines.get", err)
+			return
+		}
+		rows, err := db.QueryContext(ctx, `select a.license_key, l.product_id, l.customer, l.revoked, a.activated_at
+			from activations a join licenses l on l.license_key=a.license_key
+			where a.machine_id=$1 order by a.activated_at, a.license_key`, id)
+		if err != nil {
+			internalError(w, "machines.get.licenses", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := MachineDetail{Machine: m, Licenses: []MachineLicense{}}
+		for rows.Next() {
+			var ml MachineLicense
+			var at nullTime
+			if err := rows.Scan(&ml.LicenseKey, &ml.ProductID, &ml.Customer, &ml.Revoked, &at); err != nil {
+				internalError(w, "machines.get.scan", err)
+				return
+			}
+			ml.ActivatedAt = at.Time
+			resp.Licenses = append(resp.Licenses, ml)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "machines.get.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/metrics"
+)
+
+// Business gauges for capacity and renewal dashboards, set by
+// RefreshMetrics. Archived licenses are left out, as in Stats.
+var (
+	metricActive      = metrics.NewGauge("raalisence_licenses_active", "Licenses neither revoked, suspended nor expired.")
+	metricExpiring7d  = metrics.NewGauge("raalisence_licenses_expiring", "Active licenses expiring within the window.", "within", "7d")
+	metricExpiring30d = metrics.NewGauge("raalisence_licenses_expiring", "Active licenses expiring within the window.", "within", "30d")
+	metricRevoked     = metrics.NewGauge("raalisence_licenses_revoked", "Revoked licenses.")
+	metricMachines24h = metrics.NewGauge("raalisence_machines_seen_24h", "Machines seen on activate or heartbeat in the last 24 hours.")
+	metricRefreshed   = metrics.NewGauge("raalisence_metrics_refreshed_timestamp_seconds", "When the business gauges were last refreshed.")
+)
+
+// RefreshMetrics recomputes the business gauges: one pass over licenses,
+// like Stats, and a count of machines on their last_seen_at index.
+func RefreshMetrics(ctx context.Context, db *sql.DB, cfg *config.Config) error {
+	now := time.Now().UTC()
+	var active, expiring7d, expiring30d, revoked, machines int64
+	err := db.QueryRowContext(ctx, `select
+		coalesce(sum(case when revoked=false and suspended=false and expires_at >= $1 then 1 else 0 end), 0),
+		coalesce(sum(case when revoked=false and suspended=false and expires_at >= $1 and expires_at < $2 then 1 else 0 end), 0),
+		coalesce(sum(case when revoked=false and suspended=false and expires_at >= $1 and expires_at < $3 then 1 else 0 end), 0),
+		coalesce(sum(case when revoked=true then 1 else 0 end), 0)
+		from licenses where archived_at is null`,
+		dbTime(cfg, now), dbTime(cfg, now.Add(7*24*time.Hour)), dbTime(cfg, now.Add(30*24*time.Hour))).
+		Scan(&active, &expiring7d, &expiring30d, &revoked)
+	if err != nil {
+		return err
+	}
+	if err := db.QueryRowContext(ctx, `select count(*) from machines where last_seen_at >= $1`,
+		dbTime(cfg, now.Add(-24*time.Hour))).Scan(&machines); err != nil {
+		return err
+	}
+	metricActive.Set(float64(active))
+	metricExpiring7d.Set(float64(expiring7d))
+	metricExpiring30d.Set(float64(expiring30d))
+	metricRevoked.Set(float64(revoked))
+	metricMachines24h.Set(float64(machines))
+	metricRefreshed.Set(float64(now.Unix()))
+	return nil
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+// requireClientCert enforces mtls for a validate, heartbeat or entitlement
+// call on licenseKey from machineID. Without a certificate from the client
+// CA it answers 401; with one that names neither the machine nor (per
+// mtls.bind) the license's customer, 403. It always passes when mTLS is off.
+func requireClientCert(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, licenseKey, machineID string) bool {
+	if !cfg.MTLSEnabled() {
+		return true
+	}
+	cert, err := clientCert(r, cfg)
+	if err != nil {
+		logging.Warnf("client certificate rejected remote=%s err=%v", r.RemoteAddr, err)
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return false
+	}
+	bound := machineID
+	if cfg.MTLS.Bind == "customer" {
+		err := db.QueryRowContext(r.Context(), `select customer from licenses where license_key=$1`, licenseKey).Scan(&bound)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			internalError(w, "mtls.customer", err)
+			return false
+		}
+	}
+	if bound == "" || !slices.Contains(certNames(cert), bound) {
+		http.Error(w, "client certificate does not match the license", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// clientCert returns the client certificate from the TLS connection, or
+// from mtls.forwarded_cert_header, once it is verified against the client
+// CA.
+func clientCert(r *http.Request, cfg *config.Config) (*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		chain = r.TLS.PeerCertificates
+	} else if h := cfg.MTLS.ForwardedCertHeader; h != "" && r.Header.Get(h) != "" {
+		raw, err := url.QueryUnescape(r.Header.Get(h))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h, err)
+		}
+		block, _ := pem.Decode([]byte(raw))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s: no PEM certificate", h)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h, err)
+		}
+		chain = []*x509.Certificate{cert}
+	} else {
+		return nil, errors.New("no client certificate")
+	}
+	roots, err := cfg.ClientCAs()
+	if err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return chain[0], err
+}
+
+// certNames lists the names a client certificate may be bound by: its CN
+// and DNS and URI SANs.
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/offline"
+)
+
+type OfflineActivateRequest struct {
+	RequestCode string `json:"request_code"`
+}
+
+type OfflineActivateResponse struct {
+	ResponseCode string           `json:"response_code"`
+	Activation   offline.Response `json:"activation"`
+}
+
+// OfflineActivate activates the machine named in an offline request code,
+// applying the same seat rules as ActivateLicense, and returns a signed
+// response code for the admin to hand back to the air-gapped machine.
+func OfflineActivate(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body OfflineActivateRequest
+		if !decodeJSON(w, r, &body) {
+			return
+		}
+		req, err := offline.DecodeRequest(body.RequestCode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		var resp offline.Response
+		var responseCode string
+		if !inTx(w, r, db, "offline_activate", func(tx *sql.Tx) error {
+			ar, code, err := activateMachine(ctx, tx, cfg, req.LicenseKey, req.MachineID, nil)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return err
+			}
+			if code != http.StatusOK {
+				return txAnswer{code, ar}
+			}
+			st, err := loadLicenseState(ctx, tx, cfg, req.LicenseKey, false)
+			if err != nil {
+				return fmt.Errorf("lookup: %w", err)
+			}
+
+			resp = offline.Response{
+				ProductID:   st.ProductID,
+				LicenseKey:  req.LicenseKey,
+				MachineID:   req.MachineID,
+				Nonce:       req.Nonce,
+				ActivatedAt: time.Now().UTC(),
+				ExpiresAt:   st.ExpiresAt.UTC(),
+			}
+			priv, pubPEM, err := cfg.SigningKey(st.Tenant, st.ProductID)
+			if err != nil {
+				return fmt.Errorf("key: %w", err)
+			}
+			if err := resp.Sign(priv, pubPEM); err != nil {
+				return fmt.Errorf("sign: %w", err)
+			}
+			if responseCode, err = offline.EncodeResponse(resp); err != nil {
+				return fmt.Errorf("encode: %w", err)
+			}
+			if err := auditTx(r, tx, cfg, AuditLicenseOfflineActivate, req.LicenseKey, map[string]any{"machine_id": req.MachineID}); err != nil {
+				return fmt.Errorf("audit: %w", err)
+			}
+			return nil
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, OfflineActivateResponse{ResponseCode: responseCode, Activation: resp})
+	})
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/oidc"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+const (
+	// sessionPrefix marks OIDC session tokens, which AdminKeyLookup checks
+	// against admin_sessions rather than api_keys.
+	sessionPrefix = "raalsess_"
+	// oidcStateCookie carries state, nonce and PKCE verifier from
+	// OIDCLogin to OIDCCallback.
+	oidcStateCookie = "raal_oidc"
+	oidcStateTTL    = 10 * time.Minute
+	adminPanelPath  = "/static/admin.html"
+)
+
+// OIDCLogin starts an OIDC sign-in: it remembers a fresh state, nonce and
+// PKCE verifier in a short-lived cookie and redirects to the provider.
+func OIDCLogin(cfg *config.Config, p *oidc.Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var parts [3]string
+		for i := range parts {
+			s, err := oidc.RandomString(32)
+			if err != nil {
+				internalError(w, "oidc login", err)
+				return
+			}
+			parts[i] = s
+		}
+		state, nonce, verifier := parts[0], parts[1], parts[2]
+		authURL, err := p.AuthURL(r.Context(), state, nonce, verifier)
+		if err != nil {
+			logging.Errorf("oidc discovery error err=%v", err)
+			http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name: oidcStateCookie, Value: state + "." + nonce + "." + verifier,
+			Path: "/auth/oidc", MaxAge: int(oidcStateTTL.Seconds()),
+			HttpOnly: true, Secure: secureCookies(r, cfg), SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authURL, http.StatusFound)
+	})
+}
+
+// OIDCCallback finishes a sign-in: it checks the state, redeems the code,
+// maps the signed-in email to a role (see config.OIDCRole) and starts a
+// session, then sends the browser to the admin panel.
+func OIDCCallback(db *sql.DB, cfg *config.Config, p *oidc.Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, "sign-in failed: "+e, http.StatusUnauthorized)
+			return
+		}
+		c, err := r.Cookie(oidcStateCookie)
+		var state, nonce, verifier string
+		if err == nil {
+			parts := strings.Split(c.Value, ".")
+			if len(parts) == 3 {
+				state, nonce, verifier = parts[0], parts[1], parts[2]
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1})
+		if state == "" || q.Get("state") != state || q.Get("code") == "" {
+			http.Error(w, "sign-in expired or was started elsewhere; try again", http.StatusBadRequest)
+			return
+		}
+		claims, err := p.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+		if err != nil {
+			logging.Errorf("oidc exchange error err=%v", err)
+			http.Error(w, "sign-in failed", http.StatusUnauthorized)
+			return
+		}
+		verified := claims.EmailVerified != nil && *claims.EmailVerified
+		role := cfg.OIDCRole(claims.Email, verified)
+		if role == "" {
+			http.Error(w, "forbidden: "+claims.Email+" may not sign in", http.StatusForbidden)
+			return
+		}
+
+		session := adminSession{subject: claims.Subject, email: claims.Email, role: role}
+		_, _, err = startSession(w, r, db, cfg, session, cfg.OIDCSessionTTL(), func(tx *sql.Tx, _ time.Time) error {
+			return writeAudit(r.Context(), tx, cfg, sessionActor(claims.Subject, claims.Email), middleware.GetRequestID(r), AuditSessionLogin, "",
+				map[string]any{"subject": claims.Subject, "email": claims.Email, "role": role})
+		})
+		if err != nil {
+			internalError(w, "oidc session", err)
+			return
+		}
+		http.Redirect(w, r, adminPanelPath, http.StatusFound)
+	})
+}
+
+// Session describes the signed-in caller for the admin panel.
+type Session struct {
+	Actor string `json:"actor"`
+}
+
+// CurrentSession answers who the request is authenticated as; the admin
+// panel uses it to tell whether the session cookie is still good.
+func CurrentSession() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, Session{Actor: middleware.GetAdminActor(r)})
+	})
+}
+
+// Logout ends the session in the cookie, if any, and clears the cookies.
+func Logout(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c, err := r.Cookie(middleware.SessionCookie); err == nil && strings.HasPrefix(c.Value, sessionPrefix) {
+			err := endSession(r, db, cfg, hashAPIKey(c.Value))
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				internalError(w, "logout", err)
+				return
+			}
+		}
+		for _, name := range []string{middleware.SessionCookie, middleware.CSRFCookie} {
+			http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// endSession deletes the session with tokenHash and audits the logout in
+// the same transaction. It returns sql.ErrNoRows if there is no session.
+func endSession(r *http.Request, db *sql.DB, cfg *config.Config, tokenHash string) error {
+	return store.RetryTx(r.Context(), db, func(tx *sql.Tx) error {
+		a, err := dbStore(tx, cfg).DeleteAdminSession(r.Context(), tokenHash)
+		if err != nil {
+			return err
+		}
+		s := adminSession{subject: a.Subject, email: a.Email, actor: a.Actor}
+		return writeAudit(r.Context(), tx, cfg, s.sessionActor(), middleware.GetRequestID(r), AuditSessionLogout, "", nil)
+	})
+}
+
+// lookupSession is AdminKeyLookup for session tokens. OIDC sessions carry a
+// role but no scope; a session from an API key login takes the key's
+// current role and scope, and ends when the key is revoked.
+func lookupSession(ctx context.Context, db *sql.DB, cfg *config.Config, token string) (middleware.AdminKey, bool) {
+	var s adminSession
+	err := db.QueryRowContext(ctx, `select subject, email, role, actor, api_key_id from admin_sessions where token_hash=$1 and expires_at > $2`,
+		hashAPIKey(token), dbTime(cfg, time.Now())).Scan(&s.subject, &s.email, &s.role, &s.actor, &s.apiKeyID)
+	if err == nil && s.apiKeyID != "" {
+		var k APIKey
+		k, err = scanAPIKey(db.QueryRowContext(ctx, `select `+apiKeyColumns+` from api_keys where id=$1 and revoked_at is null`, s.apiKeyID))
+		if err == nil {
+			return apiKeyAdminKey(k), true
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logging.Errorf("admin session lookup error err=%v", err)
+		}
+		return middleware.AdminKey{}, false
+	}
+	return middleware.AdminKey{Actor: s.sessionActor(), Role: s.role}, true
+}
+
+// sessionActor is the audit actor of a session: that of the key it was
+// started with, or for OIDC sessions sessionActor of the signed-in person.
+func (s adminSession) sessionActor() string {
+	if s.actor != "" {
+		return s.actor
+	}
+	return sessionActor(s.subject, s.email)
+}
+
+// sessionActor is the audit actor of a signed-in person: "oidc:" plus their
+// email, or their subject when the provider shares no email.
+func sessionActor(subject, email string) string {
+	if email != "" {
+		return "oidc:" + email
+	}
+	return "oidc:" + subject
+}
+
+func newSessionToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = sessionPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashAPIKey(token), nil
+}
+
+// secureCookies marks cookies Secure when the server is reached over HTTPS,
+// as the request or the configured redirect URL tells.
+func secureCookies(r *http.Request, cfg *config.Config) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" || strings.HasPrefix(cfg.OIDC.RedirectURL, "https://")
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// ReissueLicense re-signs the license file for an existing key from the
+// current database state, e.g. after a signing key rotation or an update to
+// expiry/features. The license_key is unchanged; issued_at is the reissue time
+// and the license's recorded key_id becomes the key it was signed with now.
+// The new file is sent to webhooks subscribed to license.reissued.
+func ReissueLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest // re-use with license_key
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		lf, code, err := reissueLicense(withAudit(r, AuditLicenseReissue, nil), db, cfg, req.LicenseKey)
+		if code == http.StatusInternalServerError {
+			internalError(w, "reissue", err)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, http.StatusOK, lf)
+	})
+}
+
+// DownloadLicenseFile serves the re-signed license file as a download named
+// after the customer, ready to hand over as-is.
+func DownloadLicenseFile(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		lf, code, err := renderLicenseFile(r.Context(), db, cfg, key)
+		if code == http.StatusInternalServerError {
+			internalError(w, "license_file", err)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		b, err := json.MarshalIndent(lf, "", "  ")
+		if err != nil {
+			internalError(w, "license_file.marshal", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", licenseFileName(lf)))
+		w.Write(append(b, '\n'))
+	})
+}
+
+// reissueLicense re-signs key, records the key that signed it, with the
+// audit entry of ctx's store.Audit, its details given the key_id, and
+// announces the new file; codes are as for renderLicenseFile.
+func reissueLicense(ctx context.Context, db *sql.DB, cfg *config.Config, key string) (LicenseFile, int, error) {
+	lf, code, err := renderLicenseFile(ctx, db, cfg, key)
+	if err != nil {
+		return lf, code, err
+	}
+	a := store.AuditFrom(ctx)
+	details := map[string]any{"key_id": lf.KeyID}
+	for k, v := range a.Details {
+		details[k] = v
+	}
+	a.Details = details
+	ctx = store.WithAudit(ctx, a)
+	err = store.RetryTx(ctx, db, func(tx *sql.Tx) error {
+		if err := dbStore(tx, cfg).SetSigningKey(ctx, key, lf.KeyID); err != nil {
+			return fmt.Errorf("key_id: %w", err)
+		}
+		if err := queueEvent(ctx, tx, cfg, EventLicenseReissued, lf); err != nil {
+			return fmt.Errorf("event: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return LicenseFile{}, http.StatusInternalServerError, err
+	}
+	flushEvents(ctx, db, cfg)
+	return lf, http.StatusOK, nil
+}
+
+// renderLicenseFile signs the current state of key. Revoked and archived
+// licenses get 409; the returned code is 200 on success and 500 for errors
+// that must not be shown to the caller.
+func renderLicenseFile(ctx context.Context, db *sql.DB, cfg *config.Config, key string) (LicenseFile, int, error) {
+	st, err := loadLicenseState(ctx, db, cfg, key, false)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LicenseFile{}, http.StatusNotFound, errors.New("not found")
+		}
+		return LicenseFile{}, http.StatusInternalServerError, fmt.Errorf("lookup: %w", err)
+	}
+	if st.Revoked {
+		return LicenseFile{}, http.StatusConflict, errors.New("license revoked")
+	}
+	if st.Archived {
+		return LicenseFile{}, http.StatusConflict, errors.New("license archived")
+	}
+	lf, err := signLicenseFile(cfg, st.issueRequest(), key, time.Now().UTC())
+	if err != nil {
+		return LicenseFile{}, http.StatusInternalServerError, fmt.Errorf("sign: %w", err)
+	}
+	return lf, http.StatusOK, nil
+}
+
+// licenseFileName turns the customer name into a safe "<customer>.lic",
+// falling back to the license key when nothing usable is left.
+func licenseFileName(lf LicenseFile) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r == ' ':
+			return '_'
+		}
+		return -1
+	}, lf.Customer)
+	name = strings.Trim(name, "._")
+	if name == "" {
+		name = lf.LicenseKey
+	}
+	return name + ".lic"
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+// replicaRetryAfter is how long reads stay on the primary after the replica
+// fails one.
+const replicaRetryAfter = 30 * time.Second
+
+// errConfirmOnPrimary asks ReadDB to repeat a replica read on the primary
+// without counting it against the replica: the replica answered, but the
+// answer may only be lag (a license issued a moment ago, say).
+var errConfirmOnPrimary = errors.New("confirm on primary")
+
+// ReadDB sends reads that can tolerate replication lag to a read replica
+// (db.replica_dsn), keeping the primary for everything else. Without a
+// replica every read goes to the primary.
+type ReadDB struct {
+	Primary *sql.DB
+	replica *sql.DB
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NewReadDB returns the ReadDB of primary and replica, which may be nil.
+func NewReadDB(primary, replica *sql.DB) *ReadDB {
+	return &ReadDB{Primary: primary, replica: replica}
+}
+
+// read runs fn on the replica, then on the primary when there is no
+// replica, it failed within replicaRetryAfter, or fn fails on it (which
+// starts that wait) or asks for errConfirmOnPrimary.
+func (d *ReadDB) read(ctx context.Context, op string, fn func(*sql.DB) error) error {
+	if replica := d.usable(); replica != nil {
+		err := fn(replica)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !errors.Is(err, errConfirmOnPrimary) {
+			logging.Warnf("replica read error op=%s err=%v; reading from the primary for %s", op, err, replicaRetryAfter)
+			d.mu.Lock()
+			d.downUntil = time.Now().Add(replicaRetryAfter)
+			d.mu.Unlock()
+		}
+	}
+	return fn(d.Primary)
+}
+
+func (d *ReadDB) usable() *sql.DB {
+	if d.replica == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Now().Before(d.downUntil) {
+		return nil
+	}
+	return d.replica
+}
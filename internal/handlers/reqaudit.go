@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// AuditAdminRequest is the audit action of a request recorded by
+// AuditRequests.
+const AuditAdminRequest = "admin.request"
+
+// redacted replaces secret values in recorded request bodies.
+const redacted = "[redacted]"
+
+// secretFields are substrings of field names whose values AuditRequests
+// never stores.
+var secretFields = []string{"secret", "password", "passphrase", "token", "private", "otp", "api_key", "credential"}
+
+// AuditRequests records every admin write that reaches h (anything but GET
+// and HEAD) in the audit log as admin.request: the method, path, query,
+// response status and JSON body, with secrets redacted, so what was done
+// by hand can be reconstructed later. Bodies over
+// server.request_audit.max_body, or not JSON, are noted by size only. The
+// handler's own audit entries are written as before. Off, h is returned as
+// is.
+func AuditRequests(db *sql.DB, cfg *config.Config, h http.Handler) http.Handler {
+	if !cfg.Server.RequestAudit.Enabled {
+		return h
+	}
+	limit := cfg.RequestAuditMaxBody()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// keep the first limit+1 bytes and hand the handler the whole body,
+		// so it still meets any body limit error itself
+		captured, _ := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(captured), r.Body), r.Body}
+		sw := &auditWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		details := map[string]any{"method": r.Method, "path": r.URL.Path, "status": sw.status}
+		if r.URL.RawQuery != "" {
+			details["query"] = r.URL.RawQuery
+		}
+		licenseKey := r.PathValue("license_key")
+		switch body, ok := auditBody(captured, limit, cfg.Server.RequestAudit.Redact); {
+		case len(captured) == 0:
+		case ok:
+			details["body"] = body
+			if m, isObject := body.(map[string]any); isObject && licenseKey == "" {
+				licenseKey, _ = m["license_key"].(string)
+			}
+		default:
+			details["body_bytes"] = len(captured)
+			details["body_truncated"] = int64(len(captured)) > limit
+			if ct := r.Header.Get("Content-Type"); ct != "" {
+				details["content_type"] = ct
+			}
+		}
+		recordAudit(r, db, cfg, AuditAdminRequest, licenseKey, details)
+	})
+}
+
+// auditBody decodes a captured JSON body and redacts it. It reports false
+// for bodies that were cut short or aren't JSON, which can't be redacted.
+func auditBody(b []byte, max int64, extra []string) (any, bool) {
+	if int64(len(b)) > max {
+		return nil, false
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, false
+	}
+	return redact(v, extra), true
+}
+
+// redact replaces the values of secret-looking fields, at any depth.
+func redact(v any, extra []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if isSecretField(k, extra) {
+				v[k] = redacted
+			} else {
+				v[k] = redact(e, extra)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redact(e, extra)
+		}
+	}
+	return v
+}
+
+func isSecretField(name string, extra []string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	for _, s := range extra {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditWriter notes the response status for AuditRequests.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *auditWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
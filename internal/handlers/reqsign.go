@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+// Headers of a signed client request; the signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	RequestTimestampHeader = "X-Raal-Timestamp"
+	RequestSignatureHeader = "X-Raal-Signature"
+	// requestSignatureSkew is how far a signed request's timestamp may be
+	// from the server's clock before it counts as stale.
+	requestSignatureSkew = 5 * time.Minute
+)
+
+type RequestSecretResponse struct {
+	LicenseKey    string `json:"license_key"`
+	RequestSecret string `json:"request_secret"`
+}
+
+// SetRequestSecret issues a fresh request secret for a license, replacing
+// any earlier one. From then on validate, heartbeat and entitlement calls
+// for it must be signed. The secret is only shown in this response.
+func SetRequestSecret(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			internalError(w, "request_secret.generate", err)
+			return
+		}
+		secret := hex.EncodeToString(b)
+		if !setRequestSecret(w, r, db, cfg, key, secret) {
+			return
+		}
+		writeJSON(w, http.StatusOK, RequestSecretResponse{LicenseKey: key, RequestSecret: secret})
+	})
+}
+
+// ClearRequestSecret drops a license's request secret, so its calls no
+// longer need signing.
+func ClearRequestSecret(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		if !setRequestSecret(w, r, db, cfg, key, "") {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// setRequestSecret sets key's request secret, "" to clear it, answering
+// 404 for a missing license.
+func setRequestSecret(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, key, secret string) bool {
+	action := AuditLicenseRequestSecret
+	if secret == "" {
+		action = AuditLicenseRequestSecretClear
+	}
+	err := dbStore(db, cfg).SetRequestSecret(withAudit(r, action, nil), key, secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		internalError(w, "request_secret.update", err)
+		return false
+	}
+	return true
+}
+
+// bufferBody reads the request body (up to the route's limit, maxJSONBody
+// by default) and puts it back, so it can be decoded and then checked
+// against its signature.
+func bufferBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.BodyLimit(r, maxJSONBody)))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			logging.Warnf("request body too large path=%s remote=%s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, "bad request body", http.StatusBadRequest)
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// requireRequestSignature checks the signature of a call on licenseKey when
+// the license has a request secret, answering 401 if it is missing, wrong,
+// or its timestamp is more than requestSignatureSkew off. Licenses without
+// a secret always pass.
+func requireRequestSignature(w http.ResponseWriter, r *http.Request, db *sql.DB, licenseKey string, body []byte) bool {
+	var secret string
+	err := db.QueryRowContext(r.Context(), `select request_secret from licenses where license_key=$1`, licenseKey).Scan(&secret)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		internalError(w, "request_signature.lookup", err)
+		return false
+	}
+	if secret == "" {
+		return true
+	}
+	ts := r.Header.Get(RequestTimestampHeader)
+	sig, ok := strings.CutPrefix(r.Header.Get(RequestSignatureHeader), "sha256=")
+	if ts == "" || !ok {
+		http.Error(w, "request signature required", http.StatusUnauthorized)
+		return false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		http.Error(w, "bad "+RequestTimestampHeader, http.StatusUnauthorized)
+		return false
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > requestSignatureSkew || skew < -requestSignatureSkew {
+		http.Error(w, "stale request signature", http.StatusUnauthorized)
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signRequest(secret, ts, body)) {
+		logging.Warnf("bad request signature license_key=%s remote=%s", licenseKey, r.RemoteAddr)
+		http.Error(w, "bad request signature", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func signRequest(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
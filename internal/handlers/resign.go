@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// ResignFilter picks the licenses ResignAll re-signs; empty fields match
+// every license. Revoked and archived licenses are always skipped.
+type ResignFilter struct {
+	KeyID     string `json:"key_id,omitempty"` // licenses recorded as signed by this key, e.g. the one being retired
+	ProductID string `json:"product_id,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+// ResignAll reissues every active license matching f, in license_key
+// order, with the key the config now selects for it, as ReissueLicense
+// does for one: the new key_id is recorded, license.reissued goes to
+// webhooks and an audit entry is written for actor. Each new file is
+// passed to emit; an emit error stops the run. It returns how many
+// licenses were re-signed.
+func ResignAll(ctx context.Context, db *sql.DB, cfg *config.Config, f ResignFilter, actor, requestID string, emit func(LicenseFile) error) (int, error) {
+	query := `select license_key from licenses where revoked=$1 and archived_at is null`
+	args := []any{false}
+	for _, c := range []struct{ col, val string }{{"signing_key_id", f.KeyID}, {"product_id", f.ProductID}, {"tenant", f.Tenant}} {
+		if c.val != "" {
+			args = append(args, c.val)
+			query += fmt.Sprintf(" and %s=$%d", c.col, len(args))
+		}
+	}
+	// read the keys up front: SQLite can't update while a cursor is open
+	rows, err := db.QueryContext(ctx, query+` order by license_key`, args...)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		actx := store.WithAudit(ctx, store.Audit{
+			Actor: actor, RequestID: requestID,
+			Action: AuditLicenseReissue, Details: map[string]any{"bulk": true}, Diff: diffLicenses,
+		})
+		lf, code, err := reissueLicense(actx, db, cfg, key)
+		if err != nil {
+			if code != http.StatusInternalServerError {
+				continue // revoked, archived or deleted since the query
+			}
+			return n, fmt.Errorf("%s: %w", key, err)
+		}
+		n++
+		if err := emit(lf); err != nil {
+			return n, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return n, nil
+}
+
+// ResignLicenses re-signs every active license matching the ResignFilter
+// body (all of them for {}), for the day a signing key is retired, and
+// streams the new license files back as NDJSON.
+func ResignLicenses(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var f ResignFilter
+		if !decodeJSON(w, r, &f) {
+			return
+		}
+		actor := middleware.GetAdminActor(r)
+		if actor == "" {
+			actor = "unknown"
+		}
+		enc := json.NewEncoder(w)
+		started := false
+		n, err := ResignAll(r.Context(), db, cfg, f, actor, middleware.GetRequestID(r), func(lf LicenseFile) error {
+			if !started {
+				w.Header().Set("Content-Type", ndjsonContentType)
+				started = true
+			}
+			return enc.Encode(lf)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			if !started {
+				internalError(w, "resign", err)
+				return
+			}
+			// headers are out; the stream just ends early
+			logging.Errorf("handler error op=resign n=%d err=%v", n, err)
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", ndjsonContentType)
+		}
+	})
+}
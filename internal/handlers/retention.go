@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// Pruned counts the rows one PruneData run deleted, by retention setting.
+type Pruned struct {
+	Licenses    int64
+	Validations int64
+	Audit       int64 // audit_log and license_history rows
+	Events      int64 // outbox events and webhook deliveries
+}
+
+// Total is the number of rows deleted.
+func (p Pruned) Total() int64 { return p.Licenses + p.Validations + p.Audit + p.Events }
+
+// PruneData deletes what has outlived the retention settings: revoked or
+// expired licenses (with their dependent rows), validation events, audit and
+// history entries, and finished outbox events and webhook deliveries. Each
+// license is deleted in its own transaction and leaves a license.prune audit
+// entry, like DeleteLicense.
+func PruneData(ctx context.Context, db *sql.DB, cfg *config.Config) (Pruned, error) {
+	var p Pruned
+	now := time.Now()
+	r := cfg.Retention
+	var err error
+	if r.Licenses > 0 {
+		if p.Licenses, err = pruneLicenses(ctx, db, cfg, now.Add(-r.Licenses)); err != nil {
+			return p, err
+		}
+	}
+	steps := []struct {
+		age   time.Duration
+		count *int64
+		query string
+	}{
+		{r.Validations, &p.Validations, `delete from validation_events where created_at < $1`},
+		{r.Audit, &p.Audit, `delete from audit_log where created_at < $1`},
+		{r.Audit, &p.Audit, `delete from license_history where created_at < $1`},
+		{r.Events, &p.Events, `delete from events where dispatched_at is not null and dispatched_at < $1`},
+		{r.Events, &p.Events, `delete from webhook_deliveries where status <> 'pending' and created_at < $1`},
+	}
+	for _, s := range steps {
+		if s.age <= 0 {
+			continue
+		}
+		res, err := db.ExecContext(ctx, s.query, dbTime(cfg, now.Add(-s.age)))
+		if err != nil {
+			return p, fmt.Errorf("%s: %w", s.query, err)
+		}
+		n, _ := res.RowsAffected()
+		*s.count += n
+	}
+	return p, nil
+}
+
+// pruneLicenses deletes licenses revoked before cutoff, or whose grace
+// period ended before it.
+func pruneLicenses(ctx context.Context, db *sql.DB, cfg *config.Config, cutoff time.Time) (int64, error) {
+	// expires_at < cutoff finds every candidate; the grace period, which can
+	// be set per license, is checked below
+	rows, err := db.QueryContext(ctx, `select license_key from licenses where (revoked=true and updated_at < $1) or expires_at < $1`, dbTime(cfg, cutoff))
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, key := range keys {
+		pruned, err := pruneLicense(ctx, db, cfg, key, cutoff)
+		if err != nil {
+			return n, fmt.Errorf("license %s: %w", key, err)
+		}
+		if pruned {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func pruneLicense(ctx context.Context, db *sql.DB, cfg *config.Config, key string, cutoff time.Time) (bool, error) {
+	var pruned bool
+	err := store.RetryTx(ctx, db, func(tx *sql.Tx) error {
+		pruned = false
+		st, err := loadLicenseState(ctx, tx, cfg, key, true)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !st.Revoked && !st.graceEnd(cfg).Before(cutoff) {
+			return nil
+		}
+		actx := store.WithAudit(ctx, store.Audit{Actor: "retention", Action: AuditLicensePrune, Details: map[string]any{
+			"customer": st.Customer, "revoked": st.Revoked, "expires_at": st.ExpiresAt.UTC().Format(time.RFC3339),
+		}})
+		if err := dbStore(tx, cfg).Delete(actx, key); err != nil {
+			return err
+		}
+		pruned = true
+		return nil
+	})
+	return pruned, err
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// txAnswer is a response other than a 500 that a handler settles on inside
+// a transaction. Returned as an error, it makes inTx roll the transaction
+// back and send it.
+type txAnswer struct {
+	code int
+	body any // sent as JSON; a string is sent as text, like http.Error
+}
+
+func (a txAnswer) Error() string { return http.StatusText(a.code) }
+
+// inTx runs fn in a transaction of db with store.RetryTx, so a
+// serialization failure on CockroachDB reruns fn from the start; fn must
+// leave anything it sets outside the transaction as a rerun would want it.
+// inTx reports whether fn committed. If not, it has answered the request
+// through txFailed.
+func inTx(w http.ResponseWriter, r *http.Request, db *sql.DB, op string, fn func(tx *sql.Tx) error) bool {
+	if err := store.RetryTx(r.Context(), db, fn); err != nil {
+		txFailed(w, op, err)
+		return false
+	}
+	return true
+}
+
+// txFailed answers a request whose transaction failed with err: the
+// txAnswer it carries, or a 500 for op.
+func txFailed(w http.ResponseWriter, op string, err error) {
+	var a txAnswer
+	if !errors.As(err, &a) {
+		internalError(w, op, err)
+		return
+	}
+	if msg, ok := a.body.(string); ok {
+		http.Error(w, msg, a.code)
+		return
+	}
+	writeJSON(w, a.code, a.body)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// schemaCheckInterval is how long SchemaGuard trusts its last look at
+// schema_migrations.
+const schemaCheckInterval = 10 * time.Second
+
+// Schema drift between the database and the migrations built into the
+// binary.
+const (
+	DriftBehind = "behind" // migrations are pending
+	DriftAhead  = "ahead"  // a newer binary applied migrations this one lacks
+)
+
+var driftMessages = map[string]string{
+	DriftBehind: "database schema is older than this server expects; writes are refused until it is migrated",
+	DriftAhead:  "database schema is newer than this server; writes are refused until it is upgraded",
+}
+
+// SchemaStatus is the GET /api/v1/migrations response.
+type SchemaStatus struct {
+	Driver string `json:"driver"`
+	// Tracked is always true now every driver records its migrations in
+	// schema_migrations; it stays for clients that check it.
+	Tracked bool     `json:"tracked"`
+	Version string   `json:"version,omitempty"` // latest recorded migration
+	Pending []string `json:"pending"`
+	Unknown []string `json:"unknown"` // recorded, but not built into this binary
+	Drift   string   `json:"drift,omitempty"`
+}
+
+// schemaStatus compares the database's schema_migrations with the
+// migrations embedded for its driver.
+func schemaStatus(ctx context.Context, db *sql.DB, cfg *config.Config) (SchemaStatus, error) {
+	s := SchemaStatus{Driver: dialect(cfg).Driver(), Pending: []string{}, Unknown: []string{}}
+	pending, unknown, err := store.MigrationStatus(ctx, db, driverName(cfg))
+	if err != nil {
+		return s, err
+	}
+	s.Tracked = true
+	s.Version = store.MigrationVersion(ctx, db)
+	s.Pending = append(s.Pending, pending...)
+	s.Unknown = append(s.Unknown, unknown...)
+	switch {
+	case len(unknown) > 0:
+		s.Drift = DriftAhead
+	case len(pending) > 0:
+		s.Drift = DriftBehind
+	}
+	return s, nil
+}
+
+// MigrationStatus reports the schema version, pending migrations and any
+// drift from what this binary expects.
+func MigrationStatus(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, err := schemaStatus(r.Context(), db, cfg)
+		if err != nil {
+			internalError(w, "migrations.status", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	})
+}
+
+// SchemaGuard refuses writes while the database schema differs from the one
+// this binary was built for, as during a rolling upgrade where another
+// replica has already migrated, so an old binary can't write rows the new
+// schema doesn't expect (or the reverse).
+type SchemaGuard struct {
+	db  *sql.DB
+	cfg *config.Config
+
+	mu      sync.Mutex
+	checked time.Time
+	drift   string
+}
+
+func NewSchemaGuard(db *sql.DB, cfg *config.Config) *SchemaGuard {
+	return &SchemaGuard{db: db, cfg: cfg}
+}
+
+// Drift returns the current drift, "" when there is none, rechecking the
+// database at most every schemaCheckInterval. A failed check keeps the last
+// answer; the request will meet the database's own error.
+func (g *SchemaGuard) Drift(ctx context.Context) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if time.Since(g.checked) < schemaCheckInterval {
+		return g.drift
+	}
+	s, err := schemaStatus(ctx, g.db, g.cfg)
+	if err != nil {
+		logging.Warnf("schema check error: %v", err)
+		return g.drift
+	}
+	if s.Drift != g.drift {
+		logging.Warnf("schema drift=%q pending=%v unknown=%v", s.Drift, s.Pending, s.Unknown)
+	}
+	g.checked, g.drift = time.Now(), s.Drift
+	return g.drift
+}
+
+// Wrap answers requests other than GET, HEAD and OPTIONS with 503 while
+// there is drift.
+func (g *SchemaGuard) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if drift := g.Drift(r.Context()); drift != "" {
+				w.Header().Set("Retry-After", strconv.Itoa(int(schemaCheckInterval/time.Second)))
+				http.Error(w, driftMessages[drift], http.StatusServiceUnavailable)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
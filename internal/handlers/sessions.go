@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+type CheckoutResponse struct {
+	SessionID         string    `json:"session_id,omitempty"`
+	LeaseExpiresAt    time.Time `json:"lease_expires_at,omitempty"`
+	HeartbeatInterval int       `json:"heartbeat_interval_seconds,omitempty"`
+	InUse             int       `json:"in_use"`
+	FloatingSeats     int       `json:"floating_seats"`
+	Reason            string    `json:"reason,omitempty"`
+}
+
+type SessionRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+type SessionHeartbeatResponse struct {
+	OK             bool      `json:"ok"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+}
+
+func (req *SessionRequest) fromPath(r *http.Request) bool {
+	return setFromPath(r, "session_id", &req.SessionID)
+}
+
+// CheckoutLicense takes a seat from a floating license's pool. The seat is
+// held as long as the client keeps heartbeating within floating.session_ttl.
+func CheckoutLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.MachineID == "" {
+			http.Error(w, "license_key and machine_id required", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		ttl := cfg.SessionTTL()
+		now := time.Now().UTC()
+
+		sessionID := uuid.NewString()
+		var seats, inUse int
+		if !inTx(w, r, db, "checkout", func(tx *sql.Tx) error {
+			st, err := loadLicenseState(ctx, tx, cfg, req.LicenseKey, true)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return fmt.Errorf("lookup: %w", err)
+			}
+			seats = st.FloatingSeats
+			switch {
+			case seats <= 0:
+				return txAnswer{http.StatusBadRequest, CheckoutResponse{Reason: "not a floating license"}}
+			case st.Archived:
+				return txAnswer{http.StatusForbidden, CheckoutResponse{FloatingSeats: seats, Reason: "archived"}}
+			case st.Revoked:
+				return txAnswer{http.StatusForbidden, CheckoutResponse{FloatingSeats: seats, Reason: "revoked"}}
+			case st.Suspended:
+				return txAnswer{http.StatusForbidden, CheckoutResponse{FloatingSeats: seats, Reason: "suspended"}}
+			case now.After(st.graceEnd(cfg)):
+				return txAnswer{http.StatusForbidden, CheckoutResponse{FloatingSeats: seats, Reason: "expired"}}
+			}
+
+			// reclaim this license's stale seats before counting
+			if _, err := tx.ExecContext(ctx, `delete from sessions where license_key=$1 and last_heartbeat_at < $2`,
+				req.LicenseKey, dbTime(cfg, now.Add(-ttl))); err != nil {
+				return fmt.Errorf("reclaim: %w", err)
+			}
+			if err := tx.QueryRowContext(ctx, `select count(*) from sessions where license_key=$1`, req.LicenseKey).Scan(&inUse); err != nil {
+				return fmt.Errorf("count: %w", err)
+			}
+			if inUse >= seats {
+				return txAnswer{http.StatusConflict, CheckoutResponse{InUse: inUse, FloatingSeats: seats, Reason: "no seats available"}}
+			}
+			if _, err := tx.ExecContext(ctx, `insert into sessions (id, license_key, machine_id, checked_out_at, last_heartbeat_at) values ($1,$2,$3,$4,$4)`,
+				sessionID, req.LicenseKey, req.MachineID, dbTime(cfg, now)); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			return nil
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, CheckoutResponse{
+			SessionID:         sessionID,
+			LeaseExpiresAt:    now.Add(ttl),
+			HeartbeatInterval: int((ttl / 3).Seconds()),
+			InUse:             inUse + 1,
+			FloatingSeats:     seats,
+		})
+	})
+}
+
+// CheckinLicense returns a checked-out seat to the pool.
+func CheckinLicense(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SessionRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.SessionID == "" {
+			http.Error(w, "session_id required", http.StatusBadRequest)
+			return
+		}
+		res, err := db.ExecContext(r.Context(), `delete from sessions where id=$1`, req.SessionID)
+		if err != nil {
+			internalError(w, "checkin.delete", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
+
+// SessionHeartbeat extends a checked-out seat's lease. Sessions that have
+// already lapsed answer 410 so the client knows to check out again.
+func SessionHeartbeat(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SessionRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.SessionID == "" {
+			http.Error(w, "session_id required", http.StatusBadRequest)
+			return
+		}
+		ttl := cfg.SessionTTL()
+		now := time.Now().UTC()
+		res, err := db.ExecContext(r.Context(), `update sessions set last_heartbeat_at=$1 where id=$2 and last_heartbeat_at >= $3`,
+			dbTime(cfg, now), req.SessionID, dbTime(cfg, now.Add(-ttl)))
+		if err != nil {
+			internalError(w, "session.heartbeat", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "session expired or unknown", http.StatusGone)
+			return
+		}
+		writeJSON(w, http.StatusOK, SessionHeartbeatResponse{OK: true, LeaseExpiresAt: now.Add(ttl)})
+	})
+}
+
+// ReapStaleSessions deletes sessions whose last heartbeat is older than the
+// configured TTL, returning the number of seats reclaimed.
+func ReapStaleSessions(ctx context.Context, db *sql.DB, cfg *config.Config) (int64, error) {
+	cutoff := time.Now().Add(-cfg.SessionTTL())
+	res, err := db.ExecContext(ctx, `delete from sessions where last_heartbeat_at < $1`, dbTime(cfg, cutoff))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
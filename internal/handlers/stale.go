@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/metrics"
+)
+
+// staleCond selects the licenses whose last heartbeat is older than $1:
+// deployments that have stopped checking in. Licenses never seen, revoked
+// or archived don't count.
+const staleCond = `last_seen_at < $1 and revoked=false and archived_at is null`
+
+var metricStale = metrics.NewGauge("raalisence_licenses_stale", "Licenses without a heartbeat for longer than stale.after.")
+
+// StaleLicense is a license that stopped checking in.
+type StaleLicense struct {
+	LicenseKey string    `json:"license_key"`
+	Customer   string    `json:"customer"`
+	MachineID  string    `json:"machine_id"`
+	ProductID  string    `json:"product_id,omitempty"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// SilentFor is how long it has been since, rounded to the second.
+	SilentFor string `json:"silent_for"`
+}
+
+type ListStaleLicensesResponse struct {
+	StaleAfter string         `json:"stale_after"`
+	Licenses   []StaleLicense `json:"licenses"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// ScanStaleLicenses updates the stale license gauge and, with
+// stale.webhook, fires license.heartbeat_stale for each license that has
+// gone stale since its last heartbeat; stale_notified_at records that it
+// was seen either way. It returns how many licenses are stale.
+func ScanStaleLicenses(ctx context.Context, db *sql.DB, cfg *config.Config) (int, error) {
+	now := time.Now().UTC()
+	cutoff := now.Add(-cfg.StaleAfter())
+	var n int
+	if err := db.QueryRowContext(ctx, `select count(*) from licenses where `+staleCond, dbTime(cfg, cutoff)).Scan(&n); err != nil {
+		return 0, err
+	}
+	metricStale.Set(float64(n))
+	sc := licenseScan{
+		field: "last_seen_at", column: "stale_notified_at", arg: cutoff,
+		query: `select license_key, customer, last_seen_at from licenses
+			where ` + staleCond + ` and (stale_notified_at is null or stale_notified_at < last_seen_at)`,
+	}
+	if cfg.Stale.Webhook {
+		sc.event = EventLicenseHeartbeatStale
+	}
+	if err := scanLicenses(ctx, db, cfg, now, sc); err != nil {
+		return n, err
+	}
+	flushEvents(ctx, db, cfg)
+	return n, nil
+}
+
+// ListStaleLicenses pages through the stale licenses, longest silent
+// first. Filters: customer and product_id match exactly. Paging works like
+// ListLicenses (limit, cursor).
+func ListStaleLicenses(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, ok := listLimit(w, r)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		q := r.URL.Query()
+		args := []any{dbTime(cfg, now.Add(-cfg.StaleAfter()))}
+		conds := []string{staleCond}
+		if v := q.Get("cursor"); v != "" {
+			lastSeen, key, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, lastSeen, key)
+			conds = append(conds, keysetCond("last_seen_at", "license_key", ">", len(args)-1))
+		}
+		for _, col := range []string{"customer", "product_id"} {
+			if v := q.Get(col); v != "" {
+				args = append(args, v)
+				conds = append(conds, fmt.Sprintf("%s=$%d", col, len(args)))
+			}
+		}
+		query := `select license_key, customer, machine_id, product_id, last_seen_at from licenses where ` +
+			strings.Join(conds, " and ") + fmt.Sprintf(" order by last_seen_at, license_key limit %d", limit+1)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			internalError(w, "stale.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListStaleLicensesResponse{StaleAfter: cfg.StaleAfter().String(), Licenses: []StaleLicense{}}
+		for rows.Next() {
+			if len(resp.Licenses) == limit {
+				last := resp.Licenses[limit-1]
+				resp.NextCursor = encodeListCursor(cursorTime(cfg, last.LastSeenAt), last.LicenseKey)
+				break
+			}
+			var l StaleLicense
+			var seen nullTime
+			if err := rows.Scan(&l.LicenseKey, &l.Customer, &l.MachineID, &l.ProductID, &seen); err != nil {
+				internalError(w, "stale.list.scan", err)
+				return
+			}
+			l.LastSeenAt = seen.Time
+			l.SilentFor = now.Sub(seen.Time).Round(time.Second).String()
+			resp.Licenses = append(resp.Licenses, l)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "stale.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
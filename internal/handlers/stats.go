@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// StatsResponse summarises the license table. Archived licenses are only
+// counted in Archived; the other counts cover the rest. Active licenses
+// are neither revoked, suspended nor expired (grace periods aside).
+type StatsResponse struct {
+	Total        int       `json:"total"`
+	Active       int       `json:"active"`
+	Revoked      int       `json:"revoked"`
+	Suspended    int       `json:"suspended"`
+	Expired      int       `json:"expired"`
+	ExpiringSoon int       `json:"expiring_30d"`
+	SeenLast24h  int       `json:"heartbeats_24h"`
+	Archived     int       `json:"archived"`
+	GeneratedAt  time.Time `json:"generated_at"`
+}
+
+// Stats returns license totals for the admin dashboard, computed in a
+// single pass over the licenses table.
+func Stats(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		now := time.Now().UTC()
+		st, err := dbStore(db, cfg).Stats(r.Context(), now)
+		if err != nil {
+			internalError(w, "stats.query", err)
+			return
+		}
+		resp := StatsResponse{
+			Total: st.Total, Active: st.Active, Revoked: st.Revoked, Suspended: st.Suspended, Expired: st.Expired,
+			ExpiringSoon: st.ExpiringSoon, SeenLast24h: st.SeenRecently, Archived: st.Archived, GeneratedAt: now,
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
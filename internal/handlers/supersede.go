@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// machineTakenError is claimMachine refusing a license because the customer
+// already holds an active one for the machine.
+type machineTakenError struct {
+	LicenseKey string // the license holding the claim; empty if unknown
+}
+
+func (e machineTakenError) Error() string {
+	if e.LicenseKey == "" {
+		return "customer already has an active license for this machine"
+	}
+	return fmt.Sprintf("customer already has active license %s for this machine", e.LicenseKey)
+}
+
+// claimMachine applies licensing.unique_machine to licenseKey, just inserted
+// through tx for req: any other license of the customer for the machine that
+// isn't revoked or archived is revoked as superseded, or, with "reject" and
+// while it still validates, refused with machineTakenError. The new license
+// then holds the claim, which the idx_licenses_machine_unique index keeps
+// unique when issues race.
+func claimMachine(r *http.Request, tx *sql.Tx, cfg *config.Config, req IssueRequest, licenseKey string) error {
+	mode := cfg.Licensing.UniqueMachine
+	if mode == "" {
+		return nil
+	}
+	ctx := r.Context()
+	ls := dbStore(tx, cfg)
+	keys, err := ls.MachineHolders(ctx, req.Customer, req.MachineID, licenseKey)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		st, err := loadLicenseState(ctx, tx, cfg, key, false)
+		if err != nil {
+			return err
+		}
+		if mode == "reject" && !time.Now().After(st.graceEnd(cfg)) {
+			return machineTakenError{LicenseKey: key}
+		}
+		actx := withAudit(r, AuditLicenseSupersede, map[string]any{"superseded_by": licenseKey})
+		if err := ls.Revoke(actx, key); err != nil {
+			return err
+		}
+		if err := queueEvent(ctx, tx, cfg, EventLicenseRevoked, map[string]any{"license_key": key, "superseded_by": licenseKey}); err != nil {
+			return err
+		}
+	}
+	err = ls.ClaimMachine(ctx, licenseKey)
+	if isUniqueViolation(err) {
+		// a concurrent issue claimed the machine after the lookup
+		return machineTakenError{}
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation,
+// going by the messages of the pgx, go-sqlite3 and MySQL drivers.
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unique constraint") || strings.Contains(msg, "duplicate entry")
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+// SuspendLicense temporarily disables a license. Unlike revocation it is
+// expected to be undone with ResumeLicense.
+func SuspendLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return setSuspended(db, cfg, true, "suspend")
+}
+
+// ResumeLicense lifts a suspension. A revoked license stays revoked.
+func ResumeLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return setSuspended(db, cfg, false, "resume")
+}
+
+func setSuspended(db *sql.DB, cfg *config.Config, suspended bool, op string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ValidateRequest // re-use with license_key
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		if !inTx(w, r, db, op, func(tx *sql.Tx) error {
+			err := dbStore(tx, cfg).SetSuspended(withAudit(r, "license."+op, nil), req.LicenseKey, suspended)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			if suspended {
+				if err := queueEvent(r.Context(), tx, cfg, EventLicenseSuspended, map[string]any{"license_key": req.LicenseKey}); err != nil {
+					return fmt.Errorf("event: %w", err)
+				}
+			}
+			return nil
+		}) {
+			return
+		}
+		flushEvents(r.Context(), db, cfg)
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "suspended": suspended})
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+// timestampSignature has the configured time-stamping authority timestamp
+// the raw bytes of sig and returns the token as standard base64. It returns
+// "" when no TSA is configured, or when the TSA fails and signing.timestamp
+// is not required.
+func timestampSignature(cfg *config.Config, sig string) (string, error) {
+	url := cfg.Signing.Timestamp.URL
+	if url == "" {
+		return "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.TimestampTimeout())
+	defer cancel()
+	token, err := crypto.RequestTimestamp(ctx, &http.Client{Timeout: cfg.TimestampTimeout()}, url, raw)
+	if err != nil {
+		if cfg.Signing.Timestamp.Required {
+			return "", err
+		}
+		logging.Errorf("timestamp error url=%s err=%v", url, err)
+		return "", nil
+	}
+	return base64.StdEncoding.EncodeToString(token), nil
+}
+
+// VerifyTimestamp checks lf's RFC 3161 timestamp against its signature and
+// returns the time the TSA attests the license was signed, which a key
+// rotated or revoked later cannot change. With roots, the TSA's certificate
+// must chain to one of them.
+func (lf LicenseFile) VerifyTimestamp(roots *x509.CertPool) (time.Time, error) {
+	if lf.Timestamp == "" {
+		return time.Time{}, errors.New("license has no timestamp")
+	}
+	token, err := base64.StdEncoding.DecodeString(lf.Timestamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(lf.Signature)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return crypto.VerifyTimestamp(token, sig, roots)
+}
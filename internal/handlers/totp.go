@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/totp"
+)
+
+// TOTPHeader carries the current TOTP code on the routes that need one from
+// enrolled identities.
+const TOTPHeader = "X-Raal-TOTP"
+
+type TOTPStatus struct {
+	// Available is whether the server has TOTP turned on.
+	Available bool `json:"available"`
+	Enrolled  bool `json:"enrolled"`
+}
+
+// TOTPEnrollment is a pending second factor; the secret is only shown here.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"` // base32, for typing into an authenticator app
+	URI    string `json:"uri"`    // otpauth:// URI, for a QR code
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// GetTOTP tells whether the caller has a confirmed second factor.
+func GetTOTP(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := cfg.TOTPKey()
+		if err != nil {
+			internalError(w, "totp.key", err)
+			return
+		}
+		f, err := loadTOTP(r, db, middleware.GetAdminActor(r))
+		if err != nil {
+			internalError(w, "totp.lookup", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, TOTPStatus{Available: key != nil, Enrolled: f != nil && f.confirmed})
+	})
+}
+
+// EnrollTOTP starts TOTP enrollment for the caller with a fresh secret,
+// replacing any pending one. It only takes effect once ConfirmTOTP sees a
+// code from it; a confirmed factor must be removed with DisableTOTP first.
+func EnrollTOTP(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, ok := totpKey(w, cfg)
+		if !ok {
+			return
+		}
+		actor := middleware.GetAdminActor(r)
+		f, err := loadTOTP(r, db, actor)
+		if err != nil {
+			internalError(w, "totp.lookup", err)
+			return
+		}
+		if f != nil && f.confirmed {
+			http.Error(w, "conflict: TOTP already enrolled", http.StatusConflict)
+			return
+		}
+		secret, err := totp.NewSecret()
+		if err != nil {
+			internalError(w, "totp.generate", err)
+			return
+		}
+		enc, err := crypto.EncryptSecret(key, secret, actor)
+		if err != nil {
+			internalError(w, "totp.encrypt", err)
+			return
+		}
+		if err := dbStore(db, cfg).EnrollTOTP(r.Context(), actor, enc); err != nil {
+			internalError(w, "totp.insert", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, TOTPEnrollment{Secret: totp.Encode(secret), URI: totp.URI(cfg.TOTPIssuer(), actor, secret)})
+	})
+}
+
+// ConfirmTOTP finishes enrollment with a code from the caller's
+// authenticator app. From then on the caller needs a code for destructive
+// routes.
+func ConfirmTOTP(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, ok := totpKey(w, cfg)
+		if !ok {
+			return
+		}
+		var req TOTPConfirmRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		actor := middleware.GetAdminActor(r)
+		f, err := loadTOTP(r, db, actor)
+		if err != nil {
+			internalError(w, "totp.lookup", err)
+			return
+		}
+		if f == nil || f.confirmed {
+			http.Error(w, "no pending TOTP enrollment", http.StatusConflict)
+			return
+		}
+		if !useTOTPCode(w, r, db, cfg, key, f, req.Code) {
+			return
+		}
+		if !inTx(w, r, db, "totp.confirm", func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(r.Context(), `update admin_totp set confirmed_at=$1 where actor=$2`, dbTime(cfg, time.Now().UTC()), actor); err != nil {
+				return err
+			}
+			return auditTx(r, tx, cfg, AuditTOTPEnroll, "", nil)
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, TOTPStatus{Available: true, Enrolled: true})
+	})
+}
+
+// DisableTOTP removes the caller's second factor. The server wraps it in
+// RequireTOTP, so a confirmed factor takes a code to remove.
+func DisableTOTP(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !inTx(w, r, db, "totp.delete", func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(r.Context(), `delete from admin_totp where actor=$1`, middleware.GetAdminActor(r))
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			return auditTx(r, tx, cfg, AuditTOTPDisable, "", nil)
+		}) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// RequireTOTP makes callers with a confirmed second factor send a current
+// code in TOTPHeader, answering 401 when it is missing, wrong or already
+// used. Callers who haven't enrolled, and every caller while TOTP is off,
+// pass. It must run after admin auth, which sets the actor.
+func RequireTOTP(db *sql.DB, cfg *config.Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := cfg.TOTPKey()
+		if err != nil {
+			internalError(w, "totp.key", err)
+			return
+		}
+		if key == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f, err := loadTOTP(r, db, middleware.GetAdminActor(r))
+		if err != nil {
+			internalError(w, "totp.lookup", err)
+			return
+		}
+		if f == nil || !f.confirmed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		code := strings.TrimSpace(r.Header.Get(TOTPHeader))
+		if code == "" {
+			w.Header().Set("WWW-Authenticate", "TOTP")
+			http.Error(w, "unauthorized: TOTP code required in "+TOTPHeader, http.StatusUnauthorized)
+			return
+		}
+		if !useTOTPCode(w, r, db, cfg, key, f, code) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminTOTP is a row of admin_totp.
+type adminTOTP struct {
+	actor, secretEnc string
+	confirmed        bool
+	lastStep         int64
+}
+
+// loadTOTP returns actor's second factor, or nil if there is none.
+func loadTOTP(r *http.Request, db *sql.DB, actor string) (*adminTOTP, error) {
+	if actor == "" {
+		return nil, nil
+	}
+	f := adminTOTP{actor: actor}
+	var confirmedAt nullTime
+	err := db.QueryRowContext(r.Context(), `select secret_enc, confirmed_at, last_step from admin_totp where actor=$1`, actor).
+		Scan(&f.secretEnc, &confirmedAt, &f.lastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.confirmed = confirmedAt.Valid
+	return &f, nil
+}
+
+// useTOTPCode checks code against f and records its time step, so it can't
+// be used again, answering 401 if it is wrong or was used already.
+func useTOTPCode(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, key []byte, f *adminTOTP, code string) bool {
+	secret, err := crypto.DecryptSecret(key, f.secretEnc, f.actor)
+	if err != nil {
+		internalError(w, "totp.decrypt", err)
+		return false
+	}
+	step, ok := totp.Verify(secret, code, time.Now(), f.lastStep)
+	if ok {
+		// a concurrent request with the same code loses here
+		res, err := db.ExecContext(r.Context(), `update admin_totp set last_step=$1 where actor=$2 and last_step < $1`, step, f.actor)
+		if err != nil {
+			internalError(w, "totp.update", err)
+			return false
+		}
+		n, _ := res.RowsAffected()
+		ok = n == 1
+	}
+	if !ok {
+		logging.Warnf("bad TOTP code actor=%s remote=%s", f.actor, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "TOTP")
+		http.Error(w, "unauthorized: bad or reused TOTP code", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// totpKey returns the TOTP encryption key, answering 404 when TOTP is off.
+func totpKey(w http.ResponseWriter, cfg *config.Config) ([]byte, bool) {
+	key, err := cfg.TOTPKey()
+	if err != nil {
+		internalError(w, "totp.key", err)
+		return nil, false
+	}
+	if key == nil {
+		http.Error(w, "TOTP is not enabled on this server", http.StatusNotFound)
+		return nil, false
+	}
+	return key, true
+}
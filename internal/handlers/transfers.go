@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+)
+
+type TransferRequest struct {
+	LicenseKey    string `json:"license_key"`
+	FromMachineID string `json:"from_machine_id,omitempty"` // optional guard; must match the bound machine
+	ToMachineID   string `json:"to_machine_id"`
+	Reason        string `json:"reason,omitempty"`
+	Force         bool   `json:"force,omitempty"` // bypass licensing.transfer_cooldown
+}
+
+type Transfer struct {
+	ID            string    `json:"id"`
+	LicenseKey    string    `json:"license_key"`
+	FromMachineID string    `json:"from_machine_id"`
+	ToMachineID   string    `json:"to_machine_id"`
+	Reason        string    `json:"reason,omitempty"`
+	TransferredAt time.Time `json:"transferred_at"`
+}
+
+type ListTransfersResponse struct {
+	Transfers  []Transfer `json:"transfers"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+func (req *TransferRequest) fromPath(r *http.Request) bool {
+	return setFromPath(r, "license_key", &req.LicenseKey)
+}
+
+// TransferLicense rebinds a license from its current machine to another:
+// the old machine's activation and floating sessions are dropped, the new
+// machine is activated, and the move is recorded in license_transfers.
+func TransferLicense(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req TransferRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.ToMachineID == "" {
+			http.Error(w, "license_key and to_machine_id required", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		now := time.Now().UTC()
+		var st licenseState
+		if !inTx(w, r, db, "transfer", func(tx *sql.Tx) error {
+			var err error
+			st, err = loadLicenseState(ctx, tx, cfg, req.LicenseKey, true)
+			if errors.Is(err, sql.ErrNoRows) {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			if err != nil {
+				return fmt.Errorf("lookup: %w", err)
+			}
+			if st.Revoked {
+				return txAnswer{http.StatusConflict, "license revoked"}
+			}
+			if req.FromMachineID != "" && req.FromMachineID != st.MachineID {
+				return txAnswer{http.StatusConflict, "from_machine_id does not match bound machine"}
+			}
+			if st.MachineID == req.ToMachineID {
+				return txAnswer{http.StatusConflict, "license already bound to to_machine_id"}
+			}
+
+			if cooldown := cfg.Licensing.TransferCooldown; cooldown > 0 && !req.Force {
+				var last nullTime
+				err := tx.QueryRowContext(ctx, `select max(transferred_at) from license_transfers where license_key=$1`, req.LicenseKey).Scan(&last)
+				if err != nil {
+					return fmt.Errorf("cooldown: %w", err)
+				}
+				if last.Valid {
+					if wait := last.Time.Add(cooldown).Sub(now); wait > 0 {
+						w.Header().Set("Retry-After", strconv.FormatInt(int64(wait.Seconds())+1, 10))
+						return txAnswer{http.StatusTooManyRequests, "transfer cooldown in effect"}
+					}
+				}
+			}
+
+			actx := withAudit(r, AuditLicenseTransfer, map[string]any{"from_machine_id": st.MachineID, "to_machine_id": req.ToMachineID})
+			if err := dbStore(tx, cfg).Transfer(actx, req.LicenseKey, st.MachineID, req.ToMachineID, req.Reason); err != nil {
+				if isUniqueViolation(err) {
+					return txAnswer{http.StatusConflict, machineTakenError{}.Error()}
+				}
+				return fmt.Errorf("rebind: %w", err)
+			}
+			return nil
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "from_machine_id": st.MachineID, "to_machine_id": req.ToMachineID})
+	})
+}
+
+// ListTransfers returns the transfer history for ?license_key=, newest
+// first, paginated with ?limit= and ?cursor= like ListLicenses.
+func ListTransfers(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := licenseKeyParam(r)
+		if key == "" {
+			http.Error(w, "license_key required", http.StatusBadRequest)
+			return
+		}
+		limit, ok := listLimit(w, r)
+		if !ok {
+			return
+		}
+		query := `select id, license_key, from_machine_id, to_machine_id, reason, transferred_at from license_transfers where license_key=$1`
+		args := []any{key}
+		if v := r.URL.Query().Get("cursor"); v != "" {
+			at, id, err := decodeListCursor(v)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			args = append(args, at, id)
+			query += " and " + keysetCond("transferred_at", "id", "<", 2)
+		}
+		query += fmt.Sprintf(" order by transferred_at desc, id desc limit %d", limit+1)
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			internalError(w, "transfers.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListTransfersResponse{Transfers: []Transfer{}}
+		for rows.Next() {
+			if len(resp.Transfers) == limit {
+				last := resp.Transfers[limit-1]
+				resp.NextCursor = encodeListCursor(cursorTime(cfg, last.TransferredAt), last.ID)
+				break
+			}
+			var t Transfer
+			var at nullTime
+			if err := rows.Scan(&t.ID, &t.LicenseKey, &t.FromMachineID, &t.ToMachineID, &t.Reason, &at); err != nil {
+				internalError(w, "transfers.list.scan", err)
+				return
+			}
+			t.TransferredAt = at.Time
+			resp.Transfers = append(resp.Transfers, t)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "transfers.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/entitlements"
+)
+
+type UsageRequest struct {
+	LicenseKey string `json:"license_key"`
+	MachineID  string `json:"machine_id"`
+	Metric     string `json:"metric"` // e.g. api_calls, exports, cores
+	Amount     int64  `json:"amount"`
+}
+
+type UsageResponse struct {
+	Metric   string `json:"metric"`
+	Period   string `json:"period"`
+	Total    int64  `json:"total"`
+	Limit    *int64 `json:"limit,omitempty"`
+	Exceeded bool   `json:"exceeded"`
+}
+
+func (req *UsageRequest) fromPath(r *http.Request) bool {
+	return setFromPath(r, "license_key", &req.LicenseKey)
+}
+
+// ReportUsage adds metered consumption to the license's bucket for the
+// current period and reports the running total against any quota
+// entitlement of the same name. Only activated machines of a license that
+// isn't revoked, archived or suspended may report.
+func ReportUsage(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req UsageRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.LicenseKey == "" || req.MachineID == "" || req.Metric == "" {
+			http.Error(w, "license_key, machine_id and metric required", http.StatusBadRequest)
+			return
+		}
+		if req.Amount <= 0 {
+			http.Error(w, "amount must be positive", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		st, err := loadLicenseState(ctx, db, cfg, req.LicenseKey, false)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			internalError(w, "usage.lookup", err)
+			return
+		}
+		switch {
+		case st.Revoked:
+			http.Error(w, "revoked", http.StatusForbidden)
+			return
+		case st.Archived:
+			http.Error(w, "archived", http.StatusForbidden)
+			return
+		case st.Suspended:
+			http.Error(w, "suspended", http.StatusForbidden)
+			return
+		}
+		activated, err := isActivated(ctx, db, req.LicenseKey, req.MachineID)
+		if err != nil {
+			internalError(w, "usage.membership", err)
+			return
+		}
+		if !activated {
+			http.Error(w, "machine mismatch", http.StatusForbidden)
+			return
+		}
+
+		now := time.Now().UTC()
+		period := cfg.UsagePeriod(now)
+		total, err := dbStore(db, cfg).AddUsage(ctx, req.LicenseKey, req.Metric, period, req.Amount)
+		if err != nil {
+			internalError(w, "usage.upsert", err)
+			return
+		}
+
+		resp := UsageResponse{Metric: req.Metric, Period: period, Total: total}
+		if e, ok := st.Entitlements[req.Metric]; ok && e.Type == entitlements.TypeQuota && e.Limit != nil {
+			resp.Limit = e.Limit
+			resp.Exceeded = total > *e.Limit
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// exceededQuotas returns the names of quota entitlements whose usage in the
+// current period has passed their limit, sorted.
+func exceededQuotas(ctx context.Context, db *sql.DB, cfg *config.Config, licenseKey string, set entitlements.Set, now time.Time) ([]string, error) {
+	hasQuota := false
+	for _, e := range set {
+		if e.Type == entitlements.TypeQuota {
+			hasQuota = true
+			break
+		}
+	}
+	if !hasQuota {
+		return nil, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `select metric, amount from usage_records where license_key=$1 and period=$2`, licenseKey, cfg.UsagePeriod(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exceeded []string
+	for rows.Next() {
+		var metric string
+		var amount int64
+		if err := rows.Scan(&metric, &amount); err != nil {
+			return nil, err
+		}
+		if e, ok := set[metric]; ok && e.Type == entitlements.TypeQuota && e.Limit != nil && amount > *e.Limit {
+			exceeded = append(exceeded, metric)
+		}
+	}
+	sort.Strings(exceeded)
+	return exceeded, rows.Err()
+}
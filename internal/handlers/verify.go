@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/rpattn/raalisence/pkg/licensefile"
+)
+
+// licenseSchemaVersion is the layout of the signed license payload that
+// signLicenseFile writes. Bump it only together with pkg/licensefile, which
+// clients embed: they then reject new files cleanly instead of failing on a
+// signature they cannot reproduce.
+const licenseSchemaVersion = licensefile.SchemaVersion
+
+// LicenseTrust is what a verifier trusts; see licensefile.Trust.
+type LicenseTrust = licensefile.Trust
+
+// Verify checks lf's signature the way clients do, through pkg/licensefile,
+// so a file the server signs is always one the package accepts.
+func (lf LicenseFile) Verify(trust LicenseTrust) error {
+	b, err := json.Marshal(lf)
+	if err != nil {
+		return err
+	}
+	f, err := licensefile.Parse(b)
+	if err != nil {
+		return err
+	}
+	return f.Verify(trust)
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/store"
+)
+
+// Webhook event names.
+const (
+	EventLicenseIssued         = "license.issued"
+	EventLicenseRevoked        = "license.revoked"
+	EventLicenseSuspended      = "license.suspended"
+	EventLicenseExpired        = "license.expired"
+	EventLicenseHeartbeatStale = "license.heartbeat_stale"
+	EventLicenseReissued       = "license.reissued" // data is the new license file
+)
+
+var webhookEvents = map[string]bool{
+	EventLicenseIssued:         true,
+	EventLicenseRevoked:        true,
+	EventLicenseSuspended:      true,
+	EventLicenseExpired:        true,
+	EventLicenseHeartbeatStale: true,
+	EventLicenseReissued:       true,
+}
+
+const (
+	webhookBatchSize   = 100
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = time.Hour
+)
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"` // empty subscribes to every event
+	Secret string   `json:"secret,omitempty"` // generated when empty
+}
+
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret,omitempty"` // only returned on create
+	CreatedAt string   `json:"created_at"`
+}
+
+type ListWebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookDelivery struct {
+	ID            string  `json:"id"`
+	Event         string  `json:"event"`
+	Status        string  `json:"status"`
+	Attempts      int     `json:"attempts"`
+	NextAttemptAt string  `json:"next_attempt_at"`
+	LastError     string  `json:"last_error,omitempty"`
+	ResponseCode  *int    `json:"response_code,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+	DeliveredAt   *string `json:"delivered_at,omitempty"`
+}
+
+type ListWebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// webhookEnvelope is the JSON body POSTed to subscribers.
+type webhookEnvelope struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// CreateWebhook registers an endpoint for lifecycle events.
+func CreateWebhook(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req CreateWebhookRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+			return
+		}
+		if req.Events == nil {
+			req.Events = []string{}
+		}
+		for _, e := range req.Events {
+			if !webhookEvents[e] {
+				http.Error(w, fmt.Sprintf("unknown event %q", e), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Secret == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				internalError(w, "webhooks.create.secret", err)
+				return
+			}
+			req.Secret = hex.EncodeToString(b)
+		}
+		eventsJSON, err := json.Marshal(req.Events)
+		if err != nil {
+			internalError(w, "webhooks.create.marshal", err)
+			return
+		}
+		id := uuid.NewString()
+		if !inTx(w, r, db, "webhooks.create", func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(r.Context(), `insert into webhooks (id, url, secret, events, active, created_at) values ($1,$2,$3,$4,true,$5)`,
+				id, req.URL, req.Secret, string(eventsJSON), dbTime(cfg, time.Now())); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			return auditTx(r, tx, cfg, AuditWebhookCreate, "", map[string]any{"webhook_id": id, "url": req.URL, "events": req.Events})
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, Webhook{
+			ID:        id,
+			URL:       req.URL,
+			Events:    req.Events,
+			Active:    true,
+			Secret:    req.Secret,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		})
+	})
+}
+
+// ListWebhooks returns the registered endpoints (without secrets).
+func ListWebhooks(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rows, err := db.QueryContext(r.Context(), `select id, url, events, active, created_at from webhooks order by created_at`)
+		if err != nil {
+			internalError(w, "webhooks.list.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListWebhooksResponse{Webhooks: []Webhook{}}
+		for rows.Next() {
+			var wh Webhook
+			var events []byte
+			var created nullTime
+			if err := rows.Scan(&wh.ID, &wh.URL, &events, &wh.Active, &created); err != nil {
+				internalError(w, "webhooks.list.scan", err)
+				return
+			}
+			_ = json.Unmarshal(events, &wh.Events)
+			wh.CreatedAt = created.Time.Format(time.RFC3339Nano)
+			resp.Webhooks = append(resp.Webhooks, wh)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "webhooks.list.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// DeleteWebhook removes an endpoint and its delivery log.
+func DeleteWebhook(db *sql.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.PathValue("webhook_id")
+		ctx := r.Context()
+		if !inTx(w, r, db, "webhooks.delete", func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `delete from webhook_deliveries where webhook_id=$1`, id); err != nil {
+				return fmt.Errorf("deliveries: %w", err)
+			}
+			res, err := tx.ExecContext(ctx, `delete from webhooks where id=$1`, id)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return txAnswer{http.StatusNotFound, "not found"}
+			}
+			return auditTx(r, tx, cfg, AuditWebhookDelete, "", map[string]any{"webhook_id": id})
+		}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+}
+
+// ListWebhookDeliveries returns the most recent deliveries for one endpoint.
+func ListWebhookDeliveries(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rows, err := db.QueryContext(r.Context(), `select id, event, status, attempts, next_attempt_at, last_error, response_code, created_at, delivered_at
+			from webhook_deliveries where webhook_id=$1 order by created_at desc limit 200`, r.PathValue("webhook_id"))
+		if err != nil {
+			internalError(w, "webhooks.deliveries.query", err)
+			return
+		}
+		defer rows.Close()
+
+		resp := ListWebhookDeliveriesResponse{Deliveries: []WebhookDelivery{}}
+		for rows.Next() {
+			var d WebhookDelivery
+			var next, created, delivered nullTime
+			var code sql.NullInt64
+			if err := rows.Scan(&d.ID, &d.Event, &d.Status, &d.Attempts, &next, &d.LastError, &code, &created, &delivered); err != nil {
+				internalError(w, "webhooks.deliveries.scan", err)
+				return
+			}
+			d.NextAttemptAt = next.Time.Format(time.RFC3339Nano)
+			d.CreatedAt = created.Time.Format(time.RFC3339Nano)
+			if code.Valid {
+				c := int(code.Int64)
+				d.ResponseCode = &c
+			}
+			if delivered.Valid {
+				at := delivered.Time.Format(time.RFC3339Nano)
+				d.DeliveredAt = &at
+			}
+			resp.Deliveries = append(resp.Deliveries, d)
+		}
+		if err := rows.Err(); err != nil {
+			internalError(w, "webhooks.deliveries.rows", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// enqueueWebhook records a pending delivery of event, which occurred at at,
+// for every active endpoint subscribed to it. Delivery happens in
+// DeliverWebhooks.
+func enqueueWebhook(ctx context.Context, q store.Querier, cfg *config.Config, event string, data any, at time.Time) error {
+	rows, err := q.QueryContext(ctx, `select id, events from webhooks where active=true`)
+	if err != nil {
+		return err
+	}
+	var targets []string
+	for rows.Next() {
+		var id string
+		var eventsJSON []byte
+		if err := rows.Scan(&id, &eventsJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		var events []string
+		_ = json.Unmarshal(eventsJSON, &events)
+		if len(events) == 0 || contains(events, event) {
+			targets = append(targets, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, webhookID := range targets {
+		id := uuid.NewString()
+		payload, err := json.Marshal(webhookEnvelope{ID: id, Event: event, OccurredAt: at.UTC(), Data: data})
+		if err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, `insert into webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at) values ($1,$2,$3,$4,'pending',0,$5,$5)`,
+			id, webhookID, event, string(payload), dbTime(cfg, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventData is the license.issued payload: the public license fields, never
+// metadata or notes.
+func (req IssueRequest) eventData(licenseKey string) map[string]any {
+	return map[string]any{
+		"license_key":     licenseKey,
+		"product_id":      req.ProductID,
+		"customer":        req.Customer,
+		"machine_id":      req.MachineID,
+		"expires_at":      req.ExpiresAt.UTC(),
+		"max_activations": req.MaxActivations,
+		"floating_seats":  req.FloatingSeats,
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanLicenseEvents queues license.expired once a license passes
+// expires_at, tracked by expiry_notified_at so it fires once.
+// license.heartbeat_stale comes from ScanStaleLicenses.
+func ScanLicenseEvents(ctx context.Context, db *sql.DB, cfg *config.Config) error {
+	now := time.Now().UTC()
+	err := scanLicenses(ctx, db, cfg, now, licenseScan{
+		event: EventLicenseExpired, field: "expires_at", column: "expiry_notified_at", arg: now,
+		query: `select license_key, customer, expires_at from licenses
+			where expires_at < $1 and expiry_notified_at is null and revoked=false and archived_at is null`,
+	})
+	if err != nil {
+		return err
+	}
+	flushEvents(ctx, db, cfg)
+	return nil
+}
+
+// licenseScan finds licenses that have passed a point in time: query, given
+// arg, returns their key, customer and the time (field) they passed it.
+type licenseScan struct {
+	event, field, column string
+	query                string
+	arg                  time.Time
+}
+
+// scanLicenses marks each license sc finds in sc.column and queues
+// sc.event for it, unless sc.event is "".
+func scanLicenses(ctx context.Context, db *sql.DB, cfg *config.Config, now time.Time, sc licenseScan) error {
+	rows, err := db.QueryContext(ctx, sc.query, dbTime(cfg, sc.arg))
+	if err != nil {
+		return err
+	}
+	type hit struct {
+		key, customer string
+		at            nullTime
+	}
+	var hits []hit
+	for rows.Next() {
+		var h hit
+		if err := rows.Scan(&h.key, &h.customer, &h.at); err != nil {
+			rows.Close()
+			return err
+		}
+		hits = append(hits, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, h := range hits {
+		data := map[string]any{"license_key": h.key, "customer": h.customer, sc.field: h.at.Time}
+		if err := notifyLicense(ctx, db, cfg, sc.event, data, sc.column, h.key, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyLicense queues event, if any, and marks licenseKey notified in
+// column in one transaction, so the transition fires exactly once.
+func notifyLicense(ctx context.Context, db *sql.DB, cfg *config.Config, event string, data any, column, licenseKey string, now time.Time) error {
+	return store.RetryTx(ctx, db, func(tx *sql.Tx) error {
+		if event != "" {
+			if err := queueEvent(ctx, tx, cfg, event, data); err != nil {
+				return err
+			}
+		}
+		return dbStore(tx, cfg).MarkNotified(ctx, licenseKey, column, now)
+	})
+}
+
+// DeliverWebhooks POSTs due deliveries, signing each body with the
+// endpoint's secret (X-Raal-Signature: sha256=<hex hmac>). Failures are
+// retried with exponential backoff until webhooks.max_attempts, after which
+// the delivery is marked failed. It returns the number of attempts made.
+func DeliverWebhooks(ctx context.Context, db *sql.DB, cfg *config.Config, client *http.Client) (int, error) {
+	now := time.Now().UTC()
+	rows, err := db.QueryContext(ctx, `select d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		from webhook_deliveries d join webhooks w on w.id = d.webhook_id
+		where d.status='pending' and d.next_attempt_at <= $1 and w.active=true
+		order by d.next_attempt_at limit `+fmt.Sprint(webhookBatchSize), dbTime(cfg, now))
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		id, event, url, secret string
+		payload                []byte
+		attempts               int
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.event, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range batch {
+		code, sendErr := sendWebhook(ctx, client, d.url, d.secret, d.id, d.event, d.payload)
+		attempts := d.attempts + 1
+		var respCode any
+		if code > 0 {
+			respCode = code
+		}
+		if sendErr == nil {
+			_, err = db.ExecContext(ctx, `update webhook_deliveries set status='delivered', attempts=$1, response_code=$2, last_error='', delivered_at=$3 where id=$4`,
+				attempts, respCode, dbTime(cfg, time.Now()), d.id)
+		} else {
+			status := "pending"
+			if attempts >= cfg.WebhookMaxAttempts() {
+				status = "failed"
+			}
+			_, err = db.ExecContext(ctx, `update webhook_deliveries set status=$1, attempts=$2, response_code=$3, last_error=$4, next_attempt_at=$5 where id=$6`,
+				status, attempts, respCode, sendErr.Error(), dbTime(cfg, time.Now().Add(webhookBackoff(attempts))), d.id)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), nil
+}
+
+func sendWebhook(ctx context.Context, client *http.Client, target, secret, id, event string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Raal-Event", event)
+	req.Header.Set("X-Raal-Delivery", id)
+	req.Header.Set("X-Raal-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New(resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff is the wait before the next try after attempts failures.
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBackoffBase
+	for i := 1; i < attempts && d < webhookBackoffMax; i++ {
+		d *= 2
+	}
+	if d > webhookBackoffMax {
+		d = webhookBackoffMax
+	}
+	return d
+}
@@ -0,0 +1,348 @@
+// Package hsm signs with a key held in a hardware security module (or any
+// other PKCS#11 token, such as SoftHSM or a cloud HSM's client library). A
+// Signer is a crypto.Signer, so it signs licenses exactly like a PEM key; the
+// private key never leaves the token.
+//
+// ECDSA P-256 (CKM_ECDSA), RSA (CKM_RSA_PKCS_PSS, SHA-256, 32-byte salt) and
+// Ed25519 (CKM_EDDSA) keys are supported.
+package hsm
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 3.0 names missing from the pkcs11 package.
+const (
+	ckkECEdwards = 0x40
+	ckmEdDSA     = 0x1057
+)
+
+var oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+// Options locate the token and key. The token is found by TokenLabel, or
+// else by Slot; with neither, the first slot holding a token is used. The
+// key is found by KeyLabel and/or KeyID (hex CKA_ID) and must match exactly
+// one private key.
+type Options struct {
+	Module     string // path to the vendor's PKCS#11 library
+	TokenLabel string
+	Slot       *uint
+	PIN        string
+	KeyLabel   string
+	KeyID      string
+}
+
+// Signer signs with a private key on a PKCS#11 token. Sessions are not safe
+// for concurrent use, so signatures are made one at a time.
+type Signer struct {
+	opts Options
+	ctx  *pkcs11.Ctx
+	pub  gocrypto.PublicKey
+
+	mu      sync.Mutex
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+}
+
+// New loads the module, logs in to the token and finds the key pair.
+func New(opts Options) (*Signer, error) {
+	if opts.Module == "" {
+		return nil, errors.New("pkcs11: module path required")
+	}
+	if opts.KeyLabel == "" && opts.KeyID == "" {
+		return nil, errors.New("pkcs11: key_label or key_id required")
+	}
+	ctx := pkcs11.New(opts.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: cannot load module %s", opts.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11: initialize %s: %w", opts.Module, err)
+	}
+	s := &Signer{opts: opts, ctx: ctx}
+	if err := s.open(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Public returns the public key of the token's key pair.
+func (s *Signer) Public() gocrypto.PublicKey { return s.pub }
+
+// Sign signs like the standard library's keys do: digest is a SHA-256 hash
+// for ECDSA (returns an ASN.1 DER signature) and RSA (opts must be
+// *rsa.PSSOptions), and the whole message for Ed25519. If the session was
+// lost (token reset, HSM failover) it is reopened and the signature retried
+// once.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		if opts.HashFunc() != gocrypto.SHA256 {
+			return nil, fmt.Errorf("pkcs11: unsupported hash %v", opts.HashFunc())
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		pss, ok := opts.(*rsa.PSSOptions)
+		if !ok || pss.Hash != gocrypto.SHA256 || pss.SaltLength != rsa.PSSSaltLengthEqualsHash {
+			return nil, errors.New("pkcs11: RSA keys only sign PSS/SHA-256 with a hash-length salt")
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, 32))
+	case ed25519.PublicKey:
+		if opts.HashFunc() != 0 {
+			return nil, errors.New("pkcs11: Ed25519 signs the message, not a digest")
+		}
+		mech = pkcs11.NewMechanism(ckmEdDSA, nil)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sig, err := s.sign(mech, digest)
+	if sessionLost(err) {
+		_ = s.ctx.CloseSession(s.session)
+		if err = s.open(); err == nil {
+			sig, err = s.sign(mech, digest)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: sign: %w", err)
+	}
+	if _, ok := s.pub.(*ecdsa.PublicKey); ok {
+		return ecdsaDER(sig)
+	}
+	return sig, nil
+}
+
+func (s *Signer) sign(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mech}, s.key); err != nil {
+		return nil, err
+	}
+	return s.ctx.Sign(s.session, data)
+}
+
+// Close logs out and unloads the module.
+func (s *Signer) Close() {
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+	}
+	_ = s.ctx.Finalize()
+	s.ctx.Destroy()
+}
+
+func sessionLost(err error) bool {
+	var p11 pkcs11.Error
+	if !errors.As(err, &p11) {
+		return false
+	}
+	switch p11 {
+	case pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED, pkcs11.CKR_USER_NOT_LOGGED_IN,
+		pkcs11.CKR_DEVICE_REMOVED, pkcs11.CKR_TOKEN_NOT_PRESENT:
+		return true
+	}
+	return false
+}
+
+// open finds the slot, opens a session, logs in and looks the key pair up.
+func (s *Signer) open() error {
+	slot, err := s.findSlot()
+	if err != nil {
+		return err
+	}
+	session, err := s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("pkcs11: open session: %w", err)
+	}
+	s.session = session
+	if s.opts.PIN != "" {
+		if err := s.ctx.Login(session, pkcs11.CKU_USER, s.opts.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return fmt.Errorf("pkcs11: login: %w", err)
+		}
+	}
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY)}
+	if s.opts.KeyLabel != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.opts.KeyLabel))
+	}
+	if s.opts.KeyID != "" {
+		id, err := hex.DecodeString(s.opts.KeyID)
+		if err != nil {
+			return fmt.Errorf("pkcs11: key_id must be hex: %w", err)
+		}
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	key, err := s.findOne(template)
+	if err != nil {
+		return fmt.Errorf("pkcs11: private key: %w", err)
+	}
+	s.key = key
+	if s.pub == nil {
+		if s.pub, err = s.publicKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Signer) findSlot() (uint, error) {
+	if s.opts.TokenLabel == "" && s.opts.Slot != nil {
+		return *s.opts.Slot, nil
+	}
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: list slots: %w", err)
+	}
+	for _, slot := range slots {
+		if s.opts.TokenLabel == "" {
+			return slot, nil
+		}
+		info, err := s.ctx.GetTokenInfo(slot)
+		if err == nil && info.Label == s.opts.TokenLabel {
+			return slot, nil
+		}
+	}
+	if s.opts.TokenLabel != "" {
+		return 0, fmt.Errorf("pkcs11: no token labelled %q", s.opts.TokenLabel)
+	}
+	return 0, errors.New("pkcs11: no token present")
+}
+
+func (s *Signer) findOne(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	objs, _, err := s.ctx.FindObjects(s.session, 2)
+	_ = s.ctx.FindObjectsFinal(s.session)
+	switch {
+	case err != nil:
+		return 0, err
+	case len(objs) == 0:
+		return 0, errors.New("not found")
+	case len(objs) > 1:
+		return 0, errors.New("more than one key matches; set both key_label and key_id")
+	}
+	return objs[0], nil
+}
+
+// publicKey reads the public half of priv from the public key object with
+// the same CKA_ID (or, without one, the same label).
+func (s *Signer) publicKey(priv pkcs11.ObjectHandle) (gocrypto.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, priv, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: private key attributes: %w", err)
+	}
+	keyType, id, label := attrs[0].Value, attrs[1].Value, attrs[2].Value
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY)}
+	if len(id) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	} else {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+	pubObj, err := s.findOne(template)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: public key: %w", err)
+	}
+
+	switch ulong(keyType) {
+	case pkcs11.CKK_EC:
+		attrs, err := s.ctx.GetAttributeValue(s.session, pubObj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: public key attributes: %w", err)
+		}
+		return ecdsaPublicKey(attrs[0].Value, attrs[1].Value)
+	case ckkECEdwards:
+		attrs, err := s.ctx.GetAttributeValue(s.session, pubObj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: public key attributes: %w", err)
+		}
+		point := unwrapPoint(attrs[0].Value)
+		if len(point) != ed25519.PublicKeySize {
+			return nil, errors.New("pkcs11: Edwards key is not Ed25519")
+		}
+		return ed25519.PublicKey(point), nil
+	case pkcs11.CKK_RSA:
+		attrs, err := s.ctx.GetAttributeValue(s.session, pubObj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: public key attributes: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(new(big.Int).SetBytes(attrs[1].Value).Int64())}, nil
+	}
+	return nil, fmt.Errorf("pkcs11: unsupported key type %#x (want EC P-256, Ed25519 or RSA)", ulong(keyType))
+}
+
+// ulong decodes a CK_ULONG attribute, which is native-endian.
+func ulong(b []byte) uint {
+	switch len(b) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	}
+	return ^uint(0)
+}
+
+// ecdsaPublicKey builds a P-256 key from CKA_EC_PARAMS (the named curve OID)
+// and CKA_EC_POINT (the uncompressed point, usually DER OCTET STRING wrapped).
+func ecdsaPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil || !oid.Equal(oidP256) {
+		return nil, errors.New("pkcs11: EC key is not on P-256")
+	}
+	point = unwrapPoint(point)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("pkcs11: EC point: %w", err)
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(point[1:33]),
+		Y:     new(big.Int).SetBytes(point[33:]),
+	}, nil
+}
+
+// unwrapPoint strips the DER OCTET STRING PKCS#11 wraps points in; some
+// tokens return the bare point instead.
+func unwrapPoint(b []byte) []byte {
+	var inner []byte
+	if rest, err := asn1.Unmarshal(b, &inner); err == nil && len(rest) == 0 {
+		return inner
+	}
+	return b
+}
+
+// ecdsaDER converts PKCS#11's r||s ECDSA signature to ASN.1 DER.
+func ecdsaDER(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("pkcs11: malformed ECDSA signature of %d bytes", len(raw))
+	}
+	n := len(raw) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(raw[:n]), new(big.Int).SetBytes(raw[n:])})
+}
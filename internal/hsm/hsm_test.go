@@ -0,0 +1,93 @@
+package hsm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+func TestECDSAPublicKey(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecdhPub, _ := priv.PublicKey.ECDH()
+	point := ecdhPub.Bytes()
+	params, _ := asn1.Marshal(oidP256)
+	wrapped, _ := asn1.Marshal(point)
+
+	for name, p := range map[string][]byte{"wrapped": wrapped, "bare": point} {
+		pub, err := ecdsaPublicKey(params, p)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !pub.Equal(&priv.PublicKey) {
+			t.Fatalf("%s: wrong key", name)
+		}
+	}
+
+	p384, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+	if _, err := ecdsaPublicKey(p384, wrapped); err == nil {
+		t.Fatal("P-384 key accepted")
+	}
+}
+
+func TestECDSADER(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	h := sha256.Sum256([]byte("payload"))
+	r, s, _ := ecdsa.Sign(rand.Reader, priv, h[:])
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+
+	der, err := ecdsaDER(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&priv.PublicKey, h[:], der) {
+		t.Fatal("converted signature does not verify")
+	}
+	if _, err := ecdsaDER(raw[:63]); err == nil {
+		t.Fatal("odd-length signature accepted")
+	}
+}
+
+func TestULong(t *testing.T) {
+	b := make([]byte, 8)
+	binary.NativeEndian.PutUint64(b, 0x40)
+	if got := ulong(b); got != ckkECEdwards {
+		t.Fatalf("got %#x", got)
+	}
+	if got := ulong([]byte{1}); got != ^uint(0) {
+		t.Fatalf("short attribute decoded as %#x", got)
+	}
+}
+
+// TestSoftHSM signs on a real token when one is configured, e.g. SoftHSM:
+//
+//	softhsm2-util --init-token --free --label raal --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $MODULE --login --pin 1234 --keypairgen --key-type EC:prime256v1 --label lic
+//	RAAL_TEST_PKCS11_MODULE=$MODULE RAAL_TEST_PKCS11_PIN=1234 go test ./internal/hsm
+func TestSoftHSM(t *testing.T) {
+	module := os.Getenv("RAAL_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("RAAL_TEST_PKCS11_MODULE not set")
+	}
+	s, err := New(Options{Module: module, TokenLabel: "raal", PIN: os.Getenv("RAAL_TEST_PKCS11_PIN"), KeyLabel: "lic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	payload := map[string]any{"license_key": "k", "seats": 3}
+	sig, err := crypto.SignJSON(s, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := crypto.VerifyJSON(s.Public(), payload, sig); err != nil || !ok {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package logging filters the server's log lines by level and sends them to
+// stdout, stderr, a file or syslog. Lines keep the standard library's log
+// format, with a level= field in front of the message; log.Printf calls
+// that don't go through this package are logged as they are, whatever the
+// level.
+//
+// The level can be raised or lowered for a while at runtime (Override),
+// e.g. to debug during an incident, and falls back by itself.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Level is how severe a line is.
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn (or warning) or error; "" is info.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return Debug, nil
+	case "", "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	}
+	return Info, fmt.Errorf("unknown log level %q: want debug, info, warn or error", s)
+}
+
+// override is a level in force until a time.
+type override struct {
+	level Level
+	until time.Time
+}
+
+var (
+	base      atomic.Int32 // the configured Level
+	temporary atomic.Pointer[override]
+	sample    atomic.Int64 // keep 1 in sample validate request lines; <= 1 keeps all
+	sampled   atomic.Uint64
+)
+
+func init() { SetLevel(Info) }
+
+// SetLevel sets the configured level, which Override departs from.
+func SetLevel(l Level) { base.Store(int32(l)) }
+
+// Override uses l instead of the configured level for d, or cancels an
+// override when d <= 0. It returns when the override ends.
+func Override(l Level, d time.Duration) time.Time {
+	if d <= 0 {
+		temporary.Store(nil)
+		return time.Time{}
+	}
+	o := &override{level: l, until: time.Now().Add(d)}
+	temporary.Store(o)
+	return o.until
+}
+
+// Status reports the configured level, the level in force and, while an
+// override lasts, when it ends.
+func Status() (configured, current Level, until time.Time) {
+	configured = Level(base.Load())
+	if o := temporary.Load(); o != nil && time.Now().Before(o.until) {
+		return configured, o.level, o.until
+	}
+	return configured, configured, time.Time{}
+}
+
+// Enabled reports whether lines at l are logged.
+func Enabled(l Level) bool {
+	_, current, _ := Status()
+	return l >= current
+}
+
+func Debugf(format string, args ...any) { logf(Debug, format, args...) }
+func Infof(format string, args ...any)  { logf(Info, format, args...) }
+func Warnf(format string, args ...any)  { logf(Warn, format, args...) }
+func Errorf(format string, args ...any) { logf(Error, format, args...) }
+
+func logf(l Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	log.Output(3, "level="+l.String()+" "+fmt.Sprintf(format, args...))
+}
+
+// SetValidateSample keeps one in n of the request lines of successful
+// validations, which dominate the log of a busy server; n <= 1 keeps all.
+func SetValidateSample(n int) { sample.Store(int64(n)) }
+
+// KeepValidate reports whether to log the next validation request line.
+func KeepValidate() bool {
+	n := sample.Load()
+	if n <= 1 {
+		return true
+	}
+	return (sampled.Add(1)-1)%uint64(n) == 0
+}
+
+// Options configure Setup.
+type Options struct {
+	Level  string
+	Output string // stdout (the default), stderr, file or syslog
+	File   string // for Output file; appended to
+	// Syslog is the syslog server as network://addr, e.g. udp://host:514;
+	// empty is the local daemon. SyslogTag defaults to raalisence.
+	Syslog    string
+	SyslogTag string
+	// ValidateSample keeps one in ValidateSample validation request lines.
+	ValidateSample int
+}
+
+// Setup points the standard logger at o's output and sets the level and
+// sampling. The returned Closer closes a file or syslog connection.
+func Setup(o Options) (io.Closer, error) {
+	l, err := ParseLevel(o.Level)
+	if err != nil {
+		return nil, err
+	}
+	var w io.Writer
+	var c io.Closer = nopCloser{}
+	flags := log.LstdFlags
+	switch strings.ToLower(o.Output) {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	case "file":
+		if o.File == "" {
+			return nil, fmt.Errorf("log.output file needs log.file")
+		}
+		f, err := os.OpenFile(o.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("log.file: %w", err)
+		}
+		w, c = f, f
+	case "syslog":
+		sw, err := dialSyslog(o.Syslog, o.SyslogTag)
+		if err != nil {
+			return nil, fmt.Errorf("log.syslog: %w", err)
+		}
+		// syslog stamps lines itself
+		w, c, flags = sw, sw, 0
+	default:
+		return nil, fmt.Errorf("log.output: want stdout, stderr, file or syslog, got %q", o.Output)
+	}
+	log.SetOutput(w)
+	log.SetFlags(flags)
+	SetLevel(l)
+	SetValidateSample(o.ValidateSample)
+	return c, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
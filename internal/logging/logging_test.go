@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		SetLevel(Info)
+		Override(Info, 0)
+	})
+
+	for in, want := range map[string]Level{"": Info, "DEBUG": Debug, "warning": Warn, "error": Error} {
+		if l, err := ParseLevel(in); err != nil || l != want {
+			t.Fatalf("ParseLevel(%q) = %v, %v", in, l, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("ParseLevel accepted loud")
+	}
+
+	SetLevel(Warn)
+	Infof("hidden")
+	Warnf("shown %d", 1)
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "level=warn shown 1") {
+		t.Fatalf("log %q", out)
+	}
+
+	until := Override(Debug, 50*time.Millisecond)
+	if configured, current, u := Status(); configured != Warn || current != Debug || !u.Equal(until) {
+		t.Fatalf("status %v %v %v", configured, current, u)
+	}
+	Debugf("debugging")
+	if !strings.Contains(buf.String(), "level=debug debugging") {
+		t.Fatalf("log %q", buf.String())
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, current, u := Status(); current != Warn || !u.IsZero() {
+		t.Fatalf("override outlived itself: %v %v", current, u)
+	}
+	Override(Error, time.Hour)
+	Override(Error, 0)
+	if Enabled(Info) || !Enabled(Warn) {
+		t.Fatal("cancelled override still in force")
+	}
+}
+
+func TestKeepValidate(t *testing.T) {
+	t.Cleanup(func() { SetValidateSample(0) })
+	SetValidateSample(4)
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if KeepValidate() {
+			kept++
+		}
+	}
+	if kept != 25 {
+		t.Fatalf("kept %d of 100 at 1 in 4", kept)
+	}
+	SetValidateSample(1)
+	if !KeepValidate() || !KeepValidate() {
+		t.Fatal("sample 1 dropped a line")
+	}
+}
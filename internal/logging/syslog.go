@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// syslogWriter sends each line at the syslog priority of its level= field.
+type syslogWriter struct{ w *syslog.Writer }
+
+func dialSyslog(addr, tag string) (*syslogWriter, error) {
+	if tag == "" {
+		tag = "raalisence"
+	}
+	var network, raddr string
+	if addr != "" {
+		var ok bool
+		network, raddr, ok = strings.Cut(addr, "://")
+		if !ok {
+			return nil, fmt.Errorf("want network://address, got %q", addr)
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) Write(b []byte) (int, error) {
+	line := string(bytes.TrimRight(b, "\n"))
+	var err error
+	switch {
+	case strings.HasPrefix(line, "level=debug "):
+		err = s.w.Debug(line)
+	case strings.HasPrefix(line, "level=warn "):
+		err = s.w.Warning(line)
+	case strings.HasPrefix(line, "level=error "):
+		err = s.w.Err(line)
+	default:
+		err = s.w.Info(line)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *syslogWriter) Close() error { return s.w.Close() }
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+)
+
+func dialSyslog(addr, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not available on this platform")
+}
@@ -0,0 +1,128 @@
+// Package metrics keeps the server's gauges and serves them in the
+// Prometheus text exposition format. It is deliberately small: gauges set
+// by background jobs, no histograms, no client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Gauge is one series: a metric name and fixed label pairs.
+type Gauge struct {
+	name, labels string
+	mu           sync.Mutex
+	value        float64
+}
+
+// Set sets the gauge.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Value returns the gauge's value.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// Registry holds gauges by metric name.
+type Registry struct {
+	mu     sync.Mutex
+	help   map[string]string
+	gauges map[string][]*Gauge
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{help: map[string]string{}, gauges: map[string][]*Gauge{}}
+}
+
+// Default is the registry the server's gauges live in.
+var Default = NewRegistry()
+
+// NewGauge registers a gauge in Default; see Registry.Gauge.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.Gauge(name, help, labels...)
+}
+
+// Gauge returns the series of name with the label pairs (key, value, ...),
+// registering it the first time. Series of one name share help.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	if len(labels)%2 != 0 {
+		panic("metrics: labels must be key, value pairs")
+	}
+	var pairs []string
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	ls := strings.Join(pairs, ",")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.gauges[name] {
+		if g.labels == ls {
+			return g
+		}
+	}
+	g := &Gauge{name: name, labels: ls}
+	r.help[name] = help
+	r.gauges[name] = append(r.gauges[name], g)
+	return g
+}
+
+// WriteTo writes every gauge in the Prometheus text format, by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.gauges))
+	for name := range r.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, r.help[name], name)
+		for _, g := range r.gauges[name] {
+			b.WriteString(name)
+			if g.labels != "" {
+				b.WriteString("{" + g.labels + "}")
+			}
+			b.WriteString(" " + formatValue(g.Value()) + "\n")
+		}
+	}
+	r.mu.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves r to Prometheus.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
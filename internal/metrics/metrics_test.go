@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("raal_b", "B things.").Set(2.5)
+	r.Gauge("raal_a", "A things.", "within", "7d").Set(3)
+	r.Gauge("raal_a", "A things.", "within", "30d").Set(10)
+	r.Gauge("raal_a", "A things.", "within", "7d").Set(4) // same series
+
+	rr := httptest.NewRecorder()
+	Handler(r).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# HELP raal_a A things.
+# TYPE raal_a gauge
+raal_a{within="7d"} 4
+raal_a{within="30d"} 10
+# HELP raal_b B things.
+# TYPE raal_b gauge
+raal_b 2.5
+`
+	if rr.Code != http.StatusOK || rr.Body.String() != want {
+		t.Fatalf("code=%d body=\n%s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,104 +1,200 @@
 package middleware
 
 import (
-	"log"
-	"net"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/rpattn/raalisence/internal/config"
 )
 
+// Roles of admin API keys; see RoleAllows for what each may do. Bootstrap keys
+// from config are always admin.
 const (
-	adminFailureWindow    = 10 * time.Minute
-	adminFailureThreshold = 5
+	RoleAdmin   = "admin"   // everything, including revoke/delete and key management
+	RoleIssuer  = "issuer"  // issue, renew and re-sign licenses
+	RoleSupport = "support" // suspend/resume, transfers and freeing seats
+	RoleViewer  = "viewer"  // read-only
 )
 
-type failureState struct {
-	count   int
-	last    time.Time
-	alerted bool
+// ValidRole reports whether role is one of the roles above.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleIssuer, RoleSupport, RoleViewer:
+		return true
+	}
+	return false
 }
 
-type failureTracker struct {
-	mu    sync.Mutex
-	state map[string]*failureState
+// RoleAllows reports whether a key with role have may call a route that
+// requires need. Issuer and support are side by side: each gets the viewer
+// routes plus its own; admin gets everything.
+func RoleAllows(have, need string) bool {
+	if !ValidRole(have) {
+		return false
+	}
+	return have == RoleAdmin || have == need || need == RoleViewer
 }
 
-func newFailureTracker() *failureTracker {
-	return &failureTracker{state: make(map[string]*failureState)}
+// AdminKey is an authenticated API key: its audit actor, role and scope.
+type AdminKey struct {
+	Actor string
+	Role  string
+	KeyScope
 }
 
-func (t *failureTracker) recordFailure(key string) (count int, shouldAlert bool) {
-	now := time.Now()
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// AdminKeyLookup checks a token against the database-managed admin keys.
+type AdminKeyLookup func(ctx context.Context, token string) (AdminKey, bool)
 
-	st := t.state[key]
-	if st == nil || now.Sub(st.last) > adminFailureWindow {
-		st = &failureState{}
-		t.state[key] = st
-	}
-	st.count++
-	st.last = now
-
-	if st.count >= adminFailureThreshold && !st.alerted {
-		st.alerted = true
-		return st.count, true
-	}
-	return st.count, false
+// Access is what a route requires of an API key.
+type Access struct {
+	Role  string
+	Scope string // "" when any key scope will do
+	// LicenseScoped routes honor the product and customer restrictions of
+	// a KeyScope themselves; restricted keys get a 403 on every other route.
+	LicenseScoped bool
 }
 
-func (t *failureTracker) reset(key string) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	delete(t.state, key)
+// WithAdminKey requires header: Authorization: Bearer <admin_api_key>
+// The token may be a database-managed key (checked with lookup, which may be
+// nil) or one of the bootstrap keys from config, and must have the admin
+// role. Without the header, an OIDC session cookie is looked up the same way.
+func WithAdminKey(cfg *config.Config, lookup AdminKeyLookup, next http.Handler) http.Handler {
+	return WithRole(cfg, lookup, RoleAdmin, next)
 }
 
-var adminFailures = newFailureTracker()
+// WithRole is WithAdminKey for routes that need only role: keys without it
+// get a 403.
+func WithRole(cfg *config.Config, lookup AdminKeyLookup, role string, next http.Handler) http.Handler {
+	return WithAccess(cfg, lookup, Access{Role: role}, next)
+}
 
-// WithAdminKey requires header: Authorization: Bearer <admin_api_key>
-func WithAdminKey(cfg *config.Config, next http.Handler) http.Handler {
+// WithAccess is WithAdminKey for routes that need access: keys without its
+// role or scope get a 403. The key's scope is available to the route from
+// GetKeyScope.
+func WithAccess(cfg *config.Config, lookup AdminKeyLookup, access Access, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := adminFailureKey(r)
-		ah := r.Header.Get("Authorization")
-		const pfx = "Bearer "
-		if !strings.HasPrefix(ah, pfx) {
-			count, alert := adminFailures.recordFailure(key)
-			if alert {
-				log.Printf("ALERT admin_auth_failure remote=%s count=%d window=%v", key, count, adminFailureWindow)
-			}
+		key := ClientIP(r)
+		if lockedOut(w, r, cfg, key) {
+			return
+		}
+		token, fromCookie := adminToken(r)
+		if token == "" {
+			authFailed(r, cfg, key)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		token := ah[len(pfx):]
-		if !cfg.AdminKeyOK(token) {
-			count, alert := adminFailures.recordFailure(key)
-			if alert {
-				log.Printf("ALERT admin_auth_failure remote=%s count=%d window=%v", key, count, adminFailureWindow)
-			}
+		k, ok := adminAuth(r.Context(), cfg, lookup, token)
+		if !ok {
+			authFailed(r, cfg, key)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if fromCookie && !csrfOK(r) {
+			http.Error(w, "forbidden: missing or wrong "+CSRFHeader+" header", http.StatusForbidden)
+			return
+		}
 
-		adminFailures.reset(key)
-		next.ServeHTTP(w, r)
+		authSucceeded(r, key)
+		switch {
+		case !RoleAllows(k.Role, access.Role):
+			http.Error(w, "forbidden: requires the "+access.Role+" role", http.StatusForbidden)
+			return
+		case access.Scope != "" && !k.Allows(access.Scope):
+			http.Error(w, "forbidden: requires the "+access.Scope+" scope", http.StatusForbidden)
+			return
+		case k.Restricted() && !access.LicenseScoped:
+			http.Error(w, "forbidden: not available to keys restricted to products or customers", http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), adminActorKey, k.Actor)
+		ctx = context.WithValue(ctx, adminRoleKey, k.Role)
+		ctx = context.WithValue(ctx, keyScopeKey, k.KeyScope)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func adminFailureKey(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		if i := strings.IndexByte(xff, ','); i >= 0 {
-			return strings.TrimSpace(xff[:i])
+// Cookies set by a sign-in, through OIDC or POST /api/v1/auth/login.
+// SessionCookie holds the session token and stands in for the bearer key
+// when there is no Authorization header; CSRFCookie is readable by the admin
+// panel, which echoes it in CSRFHeader on every request that changes
+// something.
+const (
+	SessionCookie = "raal_session"
+	CSRFCookie    = "raal_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// adminToken returns the bearer token, or else the session cookie's.
+func adminToken(r *http.Request) (token string, fromCookie bool) {
+	ah := r.Header.Get("Authorization")
+	const pfx = "Bearer "
+	if strings.HasPrefix(ah, pfx) {
+		return ah[len(pfx):], false
+	}
+	if ah != "" {
+		return "", false
+	}
+	if c, err := r.Cookie(SessionCookie); err == nil && c.Value != "" {
+		return c.Value, true
+	}
+	return "", false
+}
+
+// csrfOK reports whether a cookie-authenticated request is safe to serve:
+// reads always are, anything else must repeat the CSRF cookie in the header.
+func csrfOK(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	c, err := r.Cookie(CSRFCookie)
+	h := r.Header.Get(CSRFHeader)
+	return err == nil && c.Value != "" && subtle.ConstantTimeCompare([]byte(c.Value), []byte(h)) == 1
+}
+
+const (
+	adminActorKey ctxKey = "admin-actor"
+	adminRoleKey  ctxKey = "admin-role"
+)
+
+// adminAuth returns the key for token, trying database keys before the
+// (bcrypt-hashed, so slower) bootstrap keys.
+func adminAuth(ctx context.Context, cfg *config.Config, lookup AdminKeyLookup, token string) (AdminKey, bool) {
+	if lookup != nil {
+		if k, ok := lookup(ctx, token); ok {
+			return k, true
 		}
-		return strings.TrimSpace(xff)
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	if cfg.AdminKeyOK(token) {
+		return AdminKey{Actor: adminActor(token), Role: RoleAdmin}, true
 	}
-	return host
+	return AdminKey{}, false
+}
+
+// adminActor identifies a bootstrap admin key without revealing it: "key:"
+// plus the first 12 hex chars of its sha256.
+func adminActor(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "key:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// GetAdminActor returns the admin identity set by WithAdminKey, or "" for
+// unauthenticated requests.
+func GetAdminActor(r *http.Request) string {
+	if v, ok := r.Context().Value(adminActorKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// GetAdminRole returns the role of the key that authenticated r, or "" for
+// unauthenticated requests.
+func GetAdminRole(r *http.Request) string {
+	v, _ := r.Context().Value(adminRoleKey).(string)
+	return v
 }
@@ -0,0 +1,26 @@
+// internal/middleware/bodylimit.go
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+const bodyLimitKey ctxKey = "body-limit"
+
+// WithBodyLimit sets the most request body, in bytes, next's handlers will
+// read; see BodyLimit.
+func WithBodyLimit(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey, limit)))
+	})
+}
+
+// BodyLimit returns the body limit set by WithBodyLimit, or fallback when
+// the route has none.
+func BodyLimit(r *http.Request, fallback int64) int64 {
+	if v, ok := r.Context().Value(bodyLimitKey).(int64); ok && v > 0 {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,74 @@
+// internal/middleware/clientip.go
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPKey ctxKey = "client-ip"
+
+// WithClientIP works out each request's client IP for ClientIP. It is the
+// connection's address unless that is one of proxies; then X-Forwarded-For
+// is read from the right, past any more of proxies, to the first hop they
+// didn't add. A client can't spoof its address by sending the header itself.
+func WithClientIP(proxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, proxies)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+	})
+}
+
+// ClientIP returns the client IP WithClientIP found, else the RemoteAddr
+// host.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	ip := remoteHost(r)
+	if !trusted(ip, proxies) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// a garbled hop ends what can be believed
+			return ip
+		}
+		ip = hop
+		if !trusted(ip, proxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func trusted(ip string, proxies []netip.Prefix) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range proxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/logging"
+)
+
+// Failures within adminFailureWindow of each other raise an alert once
+// there are adminFailureThreshold of them; server.auth_lockout, when on,
+// sets the window instead.
+const (
+	adminFailureWindow    = 10 * time.Minute
+	adminFailureThreshold = 5
+)
+
+// FailureStore keeps count of failed admin logins per client, and the
+// lockouts they lead to. The default lives in memory; a store in the
+// database (see handlers.AuthFailureStore) keeps lockouts across restarts
+// and shares them between replicas.
+type FailureStore interface {
+	// RecordFailure counts a failure for key at now and returns how many
+	// there have been, a gap longer than window starting the count over.
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	// Lock shuts key out until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil returns the end of key's lockout; the zero time if there
+	// is none.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reset forgets key's failures after a successful login.
+	Reset(ctx context.Context, key string) error
+}
+
+var (
+	adminFailuresMu sync.RWMutex
+	adminFailures   FailureStore = newFailureTracker()
+)
+
+// SetFailureStore replaces the store of admin auth failures; nil restores
+// the in-memory default.
+func SetFailureStore(s FailureStore) {
+	if s == nil {
+		s = newFailureTracker()
+	}
+	adminFailuresMu.Lock()
+	adminFailures = s
+	adminFailuresMu.Unlock()
+}
+
+func failureStore() FailureStore {
+	adminFailuresMu.RLock()
+	defer adminFailuresMu.RUnlock()
+	return adminFailures
+}
+
+// lockedOut answers 429 if key is locked out. Store errors let the request
+// through: a database hiccup shouldn't lock every admin out.
+func lockedOut(w http.ResponseWriter, r *http.Request, cfg *config.Config, key string) bool {
+	if cfg.Server.AuthLockout.Threshold <= 0 {
+		return false
+	}
+	until, err := failureStore().LockedUntil(r.Context(), key)
+	if err != nil {
+		logging.Errorf("auth lockout lookup error remote=%s err=%v", key, err)
+		return false
+	}
+	wait := time.Until(until)
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(wait.Seconds())+1, 10))
+	http.Error(w, "too many failed logins; try again later", http.StatusTooManyRequests)
+	return true
+}
+
+// authFailed records a failed admin login from key, alerting once it looks
+// like an attack and locking key out at the configured threshold.
+func authFailed(r *http.Request, cfg *config.Config, key string) {
+	window := adminFailureWindow
+	if cfg.Server.AuthLockout.Threshold > 0 {
+		window = cfg.AuthLockoutWindow()
+	}
+	now := time.Now()
+	store := failureStore()
+	count, err := store.RecordFailure(r.Context(), key, now, window)
+	if err != nil {
+		logging.Errorf("auth failure record error remote=%s err=%v", key, err)
+		return
+	}
+	if count == adminFailureThreshold {
+		logging.Warnf("ALERT admin_auth_failure remote=%s count=%d window=%v", key, count, window)
+	}
+	if t := cfg.Server.AuthLockout.Threshold; t > 0 && count >= t {
+		until := now.Add(cfg.AuthLockoutDuration())
+		if err := store.Lock(r.Context(), key, until); err != nil {
+			logging.Errorf("auth lockout error remote=%s err=%v", key, err)
+			return
+		}
+		logging.Warnf("ALERT admin_auth_lockout remote=%s count=%d until=%s", key, count, until.Format(time.RFC3339))
+	}
+}
+
+func authSucceeded(r *http.Request, key string) {
+	if err := failureStore().Reset(r.Context(), key); err != nil {
+		logging.Errorf("auth failure reset error remote=%s err=%v", key, err)
+	}
+}
+
+type failureState struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// failureTracker is the in-memory FailureStore.
+type failureTracker struct {
+	mu    sync.Mutex
+	state map[string]*failureState
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{state: make(map[string]*failureState)}
+}
+
+func (t *failureTracker) RecordFailure(_ context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := t.state[key]
+	if st == nil || now.Sub(st.last) > window {
+		st = &failureState{lockedUntil: t.lockedUntil(key)}
+		t.state[key] = st
+	}
+	st.count++
+	st.last = now
+	return st.count, nil
+}
+
+func (t *failureTracker) Lock(_ context.Context, key string, until time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st := t.state[key]; st != nil {
+		st.lockedUntil = until
+	}
+	return nil
+}
+
+func (t *failureTracker) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lockedUntil(key), nil
+}
+
+func (t *failureTracker) lockedUntil(key string) time.Time {
+	if st := t.state[key]; st != nil {
+		return st.lockedUntil
+	}
+	return time.Time{}
+}
+
+func (t *failureTracker) Reset(_ context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.state, key)
+	return nil
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/rpattn/raalisence/internal/logging"
 )
 
 // statusWriter captures the status code and bytes written.
@@ -27,6 +29,10 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer (Flush,
+// deadlines) for streaming responses.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -34,10 +40,13 @@ func Logging(next http.Handler) http.Handler {
 
 		next.ServeHTTP(sw, r)
 
+		if strings.HasSuffix(r.URL.Path, "/validate") && sw.status < 400 && !logging.KeepValidate() {
+			return // sampled out (log.validate_sample)
+		}
 		// Timestamp in UTC, RFC3339Nano for precision.
 		ts := start.UTC().Format(time.RFC3339Nano)
 		reqID := GetRequestID(r)
-		log.Printf(
+		logging.Infof(
 			"ts=%s req_id=%s method=%s path=%s status=%d bytes=%d dur=%s remote=%s",
 			ts, reqID, r.Method, r.URL.Path, sw.status, sw.bytes, time.Since(start), r.RemoteAddr,
 		)
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -71,6 +70,55 @@ func (l *limiter) allow(key string) (ok bool, remaining int, retryAfter time.Dur
 	return false, int(b.tokens), retryAfter
 }
 
+// setRate changes l's rate and burst; buckets keep their tokens, up to
+// the new burst.
+func (l *limiter) setRate(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rps, l.burst = rps, float64(burst)
+	for _, b := range l.buckets {
+		b.tokens = mathMin(l.burst, b.tokens)
+	}
+}
+
+// size returns how many client buckets l holds.
+func (l *limiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// rateLimiters are the limiters of the last WithRateLimit, by group, for
+// RateLimitBuckets and SetRateLimits.
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   map[string]*limiter
+)
+
+// RateLimitBuckets reports how many clients each rate limit group is
+// tracking (fast, admin and default), for the debug endpoints.
+func RateLimitBuckets() map[string]int {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	n := make(map[string]int, len(rateLimiters))
+	for group, l := range rateLimiters {
+		n[group] = l.size()
+	}
+	return n
+}
+
+// SetRateLimits applies cfg's rate limits to the limiters of the last
+// WithRateLimit, after a config reload. Clients keep their buckets.
+func SetRateLimits(cfg *config.Config) {
+	limits := cfg.RateLimits()
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	for group, l := range rateLimiters {
+		g := limits[group]
+		l.setRate(g.RPS, g.Burst)
+	}
+}
+
 func mathMin(a, b float64) float64 {
 	if a < b {
 		return a
@@ -81,20 +129,30 @@ func mathMin(a, b float64) float64 {
 // WithRateLimit applies a simple token bucket rate limit per client.
 // Keying strategy:
 // - Admin endpoints (/issue, /revoke) are keyed by admin token (so two admins behind the same IP aren't unfairly throttled).
-// - Other endpoints keyed by client IP (first X-Forwarded-For hop if present, else RemoteAddr).
-func WithRateLimit(cfg *config.Config, next http.Handler) http.Handler {
-	// Defaults (tweak as you like or expose in config)
-	fast := newLimiter(5, 10, 10*time.Minute) // validate/heartbeat
-	admin := newLimiter(1, 3, 10*time.Minute) // issue/revoke
-	deflt := newLimiter(2, 5, 10*time.Minute) // everything else
+// - Other endpoints keyed by client IP (see ClientIP).
+func WithRateLimit(cfg *config.Config, lookup AdminKeyLookup, next http.Handler) http.Handler {
+	limits := cfg.RateLimits()
+	group := func(name string) *limiter {
+		g := limits[name]
+		return newLimiter(g.RPS, g.Burst, 10*time.Minute)
+	}
+	fast := group("fast")     // validate/heartbeat/activate/floating sessions
+	admin := group("admin")   // issue/revoke
+	deflt := group("default") // everything else
+	rateLimitersMu.Lock()
+	rateLimiters = map[string]*limiter{"fast": fast, "admin": admin, "default": deflt}
+	rateLimitersMu.Unlock()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var l *limiter
-		key := rateKey(cfg, r)
-		switch r.URL.Path {
-		case "/api/v1/licenses/validate", "/api/v1/licenses/heartbeat":
+		key := rateKey(cfg, lookup, r)
+		switch rateRoute(r) {
+		case "/api/v1/licenses/validate", "/api/v1/licenses/heartbeat", "/api/v1/licenses/activate",
+			"/api/v1/licenses/checkout", "/api/v1/licenses/checkin", "/api/v1/licenses/session/heartbeat",
+			"/api/v1/licenses/usage", "/api/v1/licenses/entitlement":
 			l = fast
-		case "/api/v1/licenses/issue", "/api/v1/licenses/revoke":
+		case "/api/v1/licenses/issue", "/api/v1/licenses/issue-batch", "/api/v1/licenses/reissue", "/api/v1/licenses/revoke", "/api/v1/licenses/suspend", "/api/v1/licenses/resume",
+			"/api/v1/licenses/deactivate", "/api/v1/licenses/offline-activate", "/api/v1/licenses/import":
 			l = admin
 		default:
 			l = deflt
@@ -116,28 +174,44 @@ func WithRateLimit(cfg *config.Config, next http.Handler) http.Handler {
 	})
 }
 
-func rateKey(cfg *config.Config, r *http.Request) string {
-	if tok := bearerToken(r.Header.Get("Authorization")); tok != "" && cfg.AdminKeyOK(tok) {
-		return "admin:" + tok
+// rateRoute maps the RESTful routes (under /api/v1 or /api/v2) onto the
+// legacy action paths so every spelling of an endpoint shares a limiter group.
+func rateRoute(r *http.Request) string {
+	const licenses = "/api/v1/licenses"
+	p := r.URL.Path
+	if rest, ok := strings.CutPrefix(p, "/api/v2/"); ok {
+		p = "/api/v1/" + rest
 	}
-	if ip := clientIP(r); ip != "" {
-		return "ip:" + ip
+	switch {
+	case p == licenses && r.Method == http.MethodPost:
+		return licenses + "/issue"
+	case p == licenses+"/batch":
+		return licenses + "/issue-batch"
+	case strings.HasPrefix(p, "/api/v1/sessions/"):
+		if strings.HasSuffix(p, "/heartbeat") {
+			return licenses + "/session/heartbeat"
+		}
+		return licenses + "/checkin"
+	case strings.HasPrefix(p, licenses+"/"):
+		// /api/v1/licenses/{license_key}/{action}
+		parts := strings.Split(strings.TrimPrefix(p, licenses+"/"), "/")
+		if len(parts) == 2 && parts[0] != "session" {
+			return licenses + "/" + parts[1]
+		}
 	}
-	return "ip:unknown"
+	return p
 }
 
-func clientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		if i := strings.IndexByte(xff, ','); i >= 0 {
-			return strings.TrimSpace(xff[:i])
+func rateKey(cfg *config.Config, lookup AdminKeyLookup, r *http.Request) string {
+	if tok := bearerToken(r.Header.Get("Authorization")); tok != "" {
+		if _, ok := adminAuth(r.Context(), cfg, lookup, tok); ok {
+			return "admin:" + tok
 		}
-		return strings.TrimSpace(xff)
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	if ip := ClientIP(r); ip != "" {
+		return "ip:" + ip
 	}
-	return host
+	return "ip:unknown"
 }
 
 func bearerToken(h string) string {
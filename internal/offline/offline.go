@@ -0,0 +1,138 @@
+// Package offline implements the activation code exchange for air-gapped
+// machines. The client encodes a Request as a code the user carries to an
+// admin; the server activates the machine and answers with a signed
+// Response code the client imports and verifies without network access.
+//
+// Codes are unpadded base64url-encoded JSON so they survive copy/paste.
+package offline
+
+import (
+	gocrypto "crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// Request is generated on the offline machine. Nonce is random and echoed in
+// the Response so a client only accepts the answer to its own request.
+type Request struct {
+	LicenseKey string    `json:"license_key"`
+	MachineID  string    `json:"machine_id"`
+	Nonce      string    `json:"nonce"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Response is the signed activation returned to the offline machine.
+type Response struct {
+	ProductID   string    `json:"product_id,omitempty"`
+	LicenseKey  string    `json:"license_key"`
+	MachineID   string    `json:"machine_id"`
+	Nonce       string    `json:"nonce"`
+	ActivatedAt time.Time `json:"activated_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Signature   string    `json:"signature"`
+	PublicKey   string    `json:"public_key"`
+}
+
+func (r Request) validate() error {
+	if r.LicenseKey == "" || r.MachineID == "" || r.Nonce == "" {
+		return errors.New("license_key, machine_id and nonce required")
+	}
+	return nil
+}
+
+// Payload returns the fields covered by the signature.
+func (r Response) Payload() map[string]any {
+	p := map[string]any{
+		"license_key":  r.LicenseKey,
+		"machine_id":   r.MachineID,
+		"nonce":        r.Nonce,
+		"activated_at": r.ActivatedAt.UTC().Format(time.RFC3339Nano),
+		"expires_at":   r.ExpiresAt.UTC().Format(time.RFC3339Nano),
+	}
+	if r.ProductID != "" {
+		p["product_id"] = r.ProductID
+	}
+	return p
+}
+
+// Sign fills in Signature and PublicKey.
+func (r *Response) Sign(priv gocrypto.Signer, pubPEM string) error {
+	sig, err := crypto.SignJSON(priv, r.Payload())
+	if err != nil {
+		return err
+	}
+	r.Signature = sig
+	r.PublicKey = pubPEM
+	return nil
+}
+
+// Verify checks the signature with pub (the client's pinned key, not the one
+// embedded in the response) and that the response answers req.
+func (r Response) Verify(pub gocrypto.PublicKey, req Request) error {
+	ok, err := crypto.VerifyJSON(pub, r.Payload(), r.Signature)
+	if err != nil {
+		return fmt.Errorf("verify signature: %w", err)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	if r.LicenseKey != req.LicenseKey || r.MachineID != req.MachineID || r.Nonce != req.Nonce {
+		return errors.New("response does not match request")
+	}
+	return nil
+}
+
+// EncodeRequest returns the code for req.
+func EncodeRequest(req Request) (string, error) {
+	if err := req.validate(); err != nil {
+		return "", err
+	}
+	return encode(req)
+}
+
+// DecodeRequest parses a request code.
+func DecodeRequest(code string) (Request, error) {
+	var req Request
+	if err := decode(code, &req); err != nil {
+		return Request{}, fmt.Errorf("decode request code: %w", err)
+	}
+	return req, req.validate()
+}
+
+// EncodeResponse returns the code for resp.
+func EncodeResponse(resp Response) (string, error) {
+	return encode(resp)
+}
+
+// DecodeResponse parses a response code.
+func DecodeResponse(code string) (Response, error) {
+	var resp Response
+	if err := decode(code, &resp); err != nil {
+		return Response{}, fmt.Errorf("decode response code: %w", err)
+	}
+	return resp, nil
+}
+
+func encode(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decode(code string, v any) error {
+	// tolerate line wrapping from email clients and terminals
+	code = strings.Join(strings.Fields(code), "")
+	b, err := base64.RawURLEncoding.DecodeString(code)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,58 @@
+package offline
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestResponseVerify(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &priv.PublicKey
+
+	req := Request{LicenseKey: "LK", MachineID: "AIR-1", Nonce: "n1", CreatedAt: time.Now()}
+	code, err := EncodeRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wrapped codes still decode
+	got, err := DecodeRequest(code[:10] + "\n  " + code[10:])
+	if err != nil || got.Nonce != "n1" {
+		t.Fatalf("decode request: %+v %v", got, err)
+	}
+
+	resp := Response{LicenseKey: "LK", MachineID: "AIR-1", Nonce: "n1", ActivatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := resp.Sign(priv, ""); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := EncodeResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := DecodeResponse(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := back.Verify(pub, req); err != nil {
+		t.Fatalf("expected valid response: %v", err)
+	}
+
+	tampered := back
+	tampered.ExpiresAt = tampered.ExpiresAt.Add(24 * time.Hour)
+	if err := tampered.Verify(pub, req); err == nil {
+		t.Fatal("tampered response should not verify")
+	}
+	other := req
+	other.Nonce = "n2"
+	if err := back.Verify(pub, other); err == nil {
+		t.Fatal("response for another request should not verify")
+	}
+	if _, err := EncodeRequest(Request{LicenseKey: "LK"}); err == nil {
+		t.Fatal("expected error for incomplete request")
+	}
+}
@@ -0,0 +1,443 @@
+// Package oidc signs people in with OpenID Connect: the authorization code
+// flow with PKCE, and verification of the ID token it returns against the
+// provider's published keys. Provider metadata is discovered on first use,
+// so the server starts even while the identity provider is unreachable.
+//
+// Only the HTTP API is used; no OIDC client library is needed.
+package oidc
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxResponse = 1 << 20
+	// clockSkew is how far exp and iat may be off from the local clock.
+	clockSkew = 2 * time.Minute
+	// keysMinAge stops an unknown kid from refetching the JWKS on every
+	// login.
+	keysMinAge = time.Minute
+)
+
+// Options configure a Provider.
+type Options struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string   // sent with HTTP basic auth; empty for public clients
+	RedirectURL  string   // the callback registered with the provider
+	Scopes       []string // default openid, email and profile
+	HTTPClient   *http.Client
+}
+
+// Provider is one OpenID Connect identity provider.
+type Provider struct {
+	opts   Options
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]gocrypto.PublicKey
+	keysFetched time.Time
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims the server uses.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	Name          string   `json:"name"`
+	Nonce         string   `json:"nonce"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Audience      audience `json:"aud"`
+	AuthorizedFor string   `json:"azp"`
+}
+
+// audience is the aud claim, a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// New returns a Provider for opts. Nothing is fetched until it is used.
+func New(opts Options) *Provider {
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(opts.Scopes, "openid") {
+		opts.Scopes = append([]string{"openid"}, opts.Scopes...)
+	}
+	opts.IssuerURL = strings.TrimSuffix(opts.IssuerURL, "/")
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{opts: opts, client: client}
+}
+
+// RandomString returns n random bytes, base64url-encoded, for states, nonces
+// and PKCE verifiers.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthURL returns the provider URL that starts a sign-in. state and nonce
+// must be checked on the way back; verifier is the PKCE code verifier (S256)
+// to pass to Exchange.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization_endpoint: %w", err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.opts.ClientID)
+	q.Set("redirect_uri", p.opts.RedirectURL)
+	q.Set("scope", strings.Join(p.opts.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades an authorization code for the provider's tokens and
+// returns the verified ID token claims; nonce must be the one passed to
+// AuthURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	m, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.opts.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.opts.ClientSecret == "" {
+		form.Set("client_id", p.opts.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.opts.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.opts.ClientID), url.QueryEscape(p.opts.ClientSecret))
+	}
+	var tok struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	status, err := p.do(req, &tok)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || tok.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint: HTTP %d %s %s", status, tok.Error, tok.Description)
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return p.Verify(ctx, tok.IDToken, nonce)
+}
+
+// Verify checks an ID token's signature against the provider's keys and its
+// iss, aud, exp and nonce claims.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	m, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: id_token signature: %w", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case c.Issuer != m.Issuer:
+		return nil, fmt.Errorf("oidc: id_token issuer %q, want %q", c.Issuer, m.Issuer)
+	case !slices.Contains(c.Audience, p.opts.ClientID):
+		return nil, errors.New("oidc: id_token is for another client")
+	case len(c.Audience) > 1 && c.AuthorizedFor != "" && c.AuthorizedFor != p.opts.ClientID:
+		return nil, errors.New("oidc: id_token azp is another client")
+	case c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return nil, errors.New("oidc: id_token expired")
+	case c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, errors.New("oidc: id_token issued in the future")
+	case c.Nonce != nonce:
+		return nil, errors.New("oidc: id_token nonce does not match")
+	case c.Subject == "":
+		return nil, errors.New("oidc: id_token has no subject")
+	}
+	return &c, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("oidc: id_token: %w", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("oidc: id_token: %w", err)
+	}
+	return nil
+}
+
+func verifySignature(alg string, key gocrypto.PublicKey, signed, sig []byte) error {
+	h := sha256.Sum256(signed)
+	ok := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256":
+			ok = rsa.VerifyPKCS1v15(k, gocrypto.SHA256, h[:], sig) == nil
+		case "PS256":
+			ok = rsa.VerifyPSS(k, gocrypto.SHA256, h[:], sig, nil) == nil
+		default:
+			return fmt.Errorf("oidc: unsupported alg %q for an RSA key", alg)
+		}
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || k.Curve != elliptic.P256() {
+			return fmt.Errorf("oidc: unsupported alg %q for an EC key", alg)
+		}
+		if len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			ok = ecdsa.Verify(k, h[:], r, s)
+		}
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("oidc: unsupported alg %q for an Ed25519 key", alg)
+		}
+		ok = ed25519.Verify(k, signed, sig)
+	default:
+		return fmt.Errorf("oidc: unsupported key type %T", key)
+	}
+	if !ok {
+		return errors.New("oidc: bad id_token signature")
+	}
+	return nil
+}
+
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.opts.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var m metadata
+	status, err := p.do(req, &m)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery: HTTP %d", status)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.opts.IssuerURL {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", m.Issuer, p.opts.IssuerURL)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+// key returns the provider key kid, refetching the JWKS when kid is new
+// (the provider rotated its keys) but at most once per keysMinAge.
+func (p *Provider) key(ctx context.Context, kid string) (gocrypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < keysMinAge {
+		return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := p.do(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: jwks: HTTP %d", status)
+	}
+	keys := map[string]gocrypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+	if k, ok := p.lookupKey(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+}
+
+// lookupKey finds kid; a token without one may use the provider's only key.
+func (p *Provider) lookupKey(kid string) (gocrypto.PublicKey, bool) {
+	if k, ok := p.keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (gocrypto.PublicKey, error) {
+	b := func(s string) ([]byte, error) { return base64.RawURLEncoding.DecodeString(s) }
+	switch k.Kty {
+	case "RSA":
+		n, err := b(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := b(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// do sends req and decodes a JSON response into v, returning the status.
+func (p *Provider) do(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("oidc: %s: %w", req.URL.Path, err)
+	}
+	return resp.StatusCode, nil
+}
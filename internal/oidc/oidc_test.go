@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/oidc/oidctest"
+)
+
+func TestSignIn(t *testing.T) {
+	idp := oidctest.NewServer(t, "raal")
+	p := New(Options{IssuerURL: idp.URL, ClientID: "raal", ClientSecret: "s3cret", RedirectURL: "http://app/auth/oidc/callback"})
+	ctx := context.Background()
+
+	// signIn runs the browser leg and returns the code
+	signIn := func(nonce, verifier string) string {
+		t.Helper()
+		authURL, err := p.AuthURL(ctx, "state-1", nonce, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		back, _ := url.Parse(resp.Header.Get("Location"))
+		if back.Query().Get("state") != "state-1" {
+			t.Fatalf("redirected to %s", back)
+		}
+		return back.Query().Get("code")
+	}
+
+	claims, err := p.Exchange(ctx, signIn("nonce-1", "verifier-1"), "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "ada@example.com" || claims.Subject == "" {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	if _, err := p.Exchange(ctx, signIn("nonce-2", "verifier-2"), "another-verifier", "nonce-2"); err == nil {
+		t.Fatal("exchanged a code with the wrong PKCE verifier")
+	}
+	if _, err := p.Exchange(ctx, signIn("nonce-3", "verifier-3"), "verifier-3", "replayed-nonce"); err == nil {
+		t.Fatal("accepted an id_token for another nonce")
+	}
+
+	now := time.Now()
+	valid := map[string]any{"iss": idp.URL, "aud": []string{"raal"}, "sub": "u1", "nonce": "n", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	if _, err := p.Verify(ctx, idp.Sign(valid), "n"); err != nil {
+		t.Fatal(err)
+	}
+	for name, change := range map[string]map[string]any{
+		"issuer":   {"iss": "https://evil.example"},
+		"audience": {"aud": "someone-else"},
+		"expired":  {"exp": now.Add(-time.Hour).Unix()},
+	} {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		for k, v := range change {
+			claims[k] = v
+		}
+		if _, err := p.Verify(ctx, idp.Sign(claims), "n"); err == nil {
+			t.Errorf("%s: verified", name)
+		}
+	}
+	tok, forged := idp.Sign(valid), idp.Sign(map[string]any{"sub": "mallory"})
+	sig := tok[strings.LastIndexByte(tok, '.'):]
+	if _, err := p.Verify(ctx, forged[:strings.LastIndexByte(forged, '.')]+sig, "n"); err == nil {
+		t.Fatal("verified a token with a swapped payload")
+	}
+}
@@ -0,0 +1,123 @@
+// Package oidctest runs a minimal OpenID Connect provider for tests: it
+// approves every authorization request for the configured user and signs
+// ID tokens with a fresh ES256 key.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a test identity provider. Set Email (and EmailVerified) before
+// a sign-in to choose who it signs in.
+type Server struct {
+	*httptest.Server
+	ClientID      string
+	Email         string
+	EmailVerified bool
+
+	key   *ecdsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+type grant struct {
+	nonce, challenge, email string
+	verified                bool
+}
+
+// NewServer starts a provider for clientID; it is closed when t ends.
+func NewServer(t *testing.T, clientID string) *Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{ClientID: clientID, Email: "ada@example.com", EmailVerified: true, key: key, codes: map[string]grant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": "test", "use": "sig", "alg": "ES256",
+		"x": b64(s.key.X.FillBytes(make([]byte, 32))), "y": b64(s.key.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+// authorize approves at once, redirecting back with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	code := base64.RawURLEncoding.EncodeToString([]byte(q.Get("state") + q.Get("nonce")))
+	s.mu.Lock()
+	s.codes[code] = grant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), email: s.Email, verified: s.EmailVerified}
+	s.mu.Unlock()
+	back, _ := url.Parse(q.Get("redirect_uri"))
+	back.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	tok := s.Sign(map[string]any{
+		"iss": s.URL, "aud": s.ClientID, "sub": "user-" + g.email, "email": g.email, "email_verified": g.verified,
+		"nonce": g.nonce, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	})
+	json.NewEncoder(w).Encode(map[string]string{"id_token": tok, "token_type": "Bearer"})
+}
+
+// Sign returns claims as an ES256 JWT signed with the provider's key.
+func (s *Server) Sign(claims map[string]any) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	h, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "test", "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	sum := sha256.Sum256([]byte(signed))
+	r, sg, err := ecdsa.Sign(rand.Reader, s.key, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), sg.FillBytes(make([]byte, 32))...)
+	return signed + "." + b64(sig)
+}
@@ -0,0 +1,336 @@
+// Package openapi builds an OpenAPI 3 document from the Go request/response
+// types of the HTTP handlers and validates JSON bodies against the schemas it
+// derives, so the served spec and the server's 400s never drift apart.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Route describes one operation. Request and Response are zero values of the
+// body types (nil when there is no JSON body).
+type Route struct {
+	Method   string
+	Path     string // mux pattern syntax, e.g. /api/v1/licenses/{license_key}
+	Summary  string
+	Admin    bool
+	Role     string // key role an Admin route requires, served as x-required-role
+	Scope    string // key scope an Admin route requires, served as x-required-scope
+	Query    []string
+	Request  any
+	Response any
+}
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]map[string]any `json:"securitySchemes"`
+}
+
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	OperationID string                `json:"operationId"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Role        string                `json:"x-required-role,omitempty"`
+	Scope       string                `json:"x-required-scope,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema the generator emits.
+type Schema struct {
+	Ref        string             `json:"$ref,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Nullable   bool               `json:"nullable,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is false for structs and a *Schema for maps.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+}
+
+// Spec is a generated document plus what is needed to validate against it.
+type Spec struct {
+	Doc    *Document
+	bodies map[string]*Schema // "METHOD path" -> request body schema
+}
+
+const refPrefix = "#/components/schemas/"
+
+// Build generates the document for routes.
+func Build(title, version string, routes []Route) *Spec {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]map[string]any{
+				"adminKey": {"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	spec := &Spec{Doc: doc, bodies: map[string]*Schema{}}
+	for _, rt := range routes {
+		op := &Operation{
+			Summary:     rt.Summary,
+			OperationID: operationID(rt.Method, rt.Path),
+			Responses: map[string]Response{
+				"default": {Description: "error (text/plain)"},
+			},
+		}
+		for _, name := range pathParams(rt.Path) {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+		for _, name := range rt.Query {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "query", Schema: &Schema{Type: "string"}})
+		}
+		if rt.Request != nil {
+			s := schemaOf(doc.Components.Schemas, reflect.TypeOf(rt.Request))
+			op.RequestBody = &RequestBody{Content: map[string]MediaType{"application/json": {Schema: s}}}
+			spec.bodies[rt.Method+" "+rt.Path] = s
+		}
+		ok := Response{Description: "OK"}
+		if rt.Response != nil {
+			ok.Content = map[string]MediaType{"application/json": {Schema: schemaOf(doc.Components.Schemas, reflect.TypeOf(rt.Response))}}
+		}
+		op.Responses["200"] = ok
+		if rt.Admin {
+			op.Security = []map[string][]string{{"adminKey": {}}}
+			op.Role, op.Scope = rt.Role, rt.Scope
+		}
+		if doc.Paths[rt.Path] == nil {
+			doc.Paths[rt.Path] = map[string]*Operation{}
+		}
+		doc.Paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return spec
+}
+
+// BodySchema returns the request body schema for a mux pattern such as
+// "POST /api/v1/licenses", if the route takes a body.
+func (s *Spec) BodySchema(pattern string) (*Schema, bool) {
+	b, ok := s.bodies[pattern]
+	return b, ok
+}
+
+// Validate checks a JSON body against schema. An empty body is accepted;
+// handlers decide whether they need one.
+func (s *Spec) Validate(schema *Schema, body []byte) error {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Errorf("body: invalid json")
+	}
+	return s.validate(schema, v, "body")
+}
+
+func (s *Spec) validate(schema *Schema, v any, at string) error {
+	if schema.Ref != "" {
+		schema = s.Doc.Components.Schemas[strings.TrimPrefix(schema.Ref, refPrefix)]
+	}
+	if v == nil || schema.Type == "" {
+		// encoding/json accepts null for any field
+		return nil
+	}
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object", at)
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := schema.Properties[k]; ok {
+				if err := s.validate(prop, obj[k], at+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema.AdditionalProperties.(type) {
+			case *Schema:
+				if err := s.validate(extra, obj[k], at+"."+k); err != nil {
+					return err
+				}
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unknown field %q", at, k)
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array", at)
+		}
+		for i, item := range arr {
+			if err := s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string", at)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: expected RFC3339 date-time", at)
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected integer", at)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected number", at)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", at)
+		}
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema for t, registering named structs as components.
+func schemaOf(components map[string]*Schema, t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+	var s *Schema
+	switch {
+	case t == timeType:
+		s = &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := components[t.Name()]; !ok {
+			components[t.Name()] = &Schema{} // placeholder breaks recursion
+			components[t.Name()] = structSchema(components, t)
+		}
+		// siblings of $ref are ignored in 3.0, so nullable is not set here
+		return &Schema{Ref: refPrefix + t.Name()}
+	case t.Kind() == reflect.Struct:
+		s = structSchema(components, t)
+	case t.Kind() == reflect.Map:
+		s = &Schema{Type: "object", AdditionalProperties: schemaOf(components, t.Elem())}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		s = &Schema{Type: "array", Items: schemaOf(components, t.Elem())}
+	case t.Kind() == reflect.String:
+		s = &Schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		s = &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s = &Schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s = &Schema{Type: "number"}
+	default:
+		s = &Schema{} // any
+	}
+	s.Nullable = nullable
+	return s
+}
+
+func structSchema(components map[string]*Schema, t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range structSchema(components, ft).Properties {
+					s.Properties[k] = v
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaOf(components, f.Type)
+	}
+	return s
+}
+
+func pathParams(path string) []string {
+	var out []string
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			out = append(out, strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}"))
+		}
+	}
+	return out
+}
+
+// operationID turns "POST /api/v1/licenses/{license_key}/revoke" into
+// "postLicensesLicenseKeyRevoke".
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(strings.TrimPrefix(path, "/api/v1"), "/") {
+		seg = strings.Trim(seg, "{}")
+		for _, word := range strings.FieldsFunc(seg, func(r rune) bool { return r == '_' || r == '-' }) {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
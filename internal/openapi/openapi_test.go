@@ -0,0 +1,63 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count *int   `json:"count,omitempty"`
+}
+
+type body struct {
+	At    time.Time         `json:"at"`
+	Items []item            `json:"items"`
+	Tags  map[string]string `json:"tags"`
+	Any   map[string]any    `json:"any"`
+	skip  string
+}
+
+func TestBuildAndValidate(t *testing.T) {
+	spec := Build("test", "v1", []Route{
+		{Method: "POST", Path: "/api/v1/things/{thing_id}", Admin: true, Request: body{}, Response: item{}},
+	})
+
+	op := spec.Doc.Paths["/api/v1/things/{thing_id}"]["post"]
+	if op == nil || op.OperationID != "postThingsThingId" || len(op.Parameters) != 1 || len(op.Security) != 1 {
+		t.Fatalf("unexpected operation %+v", op)
+	}
+	if _, ok := spec.Doc.Components.Schemas["item"]; !ok {
+		t.Fatalf("expected item component, got %v", spec.Doc.Components.Schemas)
+	}
+	if _, ok := spec.Doc.Components.Schemas["body"].Properties["skip"]; ok {
+		t.Fatal("unexported field leaked into schema")
+	}
+
+	schema, ok := spec.BodySchema("POST /api/v1/things/{thing_id}")
+	if !ok {
+		t.Fatal("expected body schema")
+	}
+	valid := `{"at":"2025-01-01T00:00:00Z","items":[{"name":"a","count":2},{"name":"b","count":null}],"tags":{"k":"v"},"any":{"x":[1,"y"]}}`
+	if err := spec.Validate(schema, []byte(valid)); err != nil {
+		t.Fatalf("expected valid body: %v", err)
+	}
+	if err := spec.Validate(schema, nil); err != nil {
+		t.Fatalf("empty body should pass: %v", err)
+	}
+
+	cases := map[string]string{
+		`{"at":"yesterday"}`:                   "body.at",
+		`{"items":[{"name":"a","count":1.5}]}`: "body.items[0].count",
+		`{"tags":{"k":1}}`:                     "body.tags.k",
+		`{"extra":true}`:                       `unknown field "extra"`,
+		`[1]`:                                  "expected object",
+	}
+	for in, want := range cases {
+		err := spec.Validate(schema, []byte(in))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", in, want, err)
+		}
+	}
+}
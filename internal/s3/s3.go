@@ -0,0 +1,195 @@
+// Package s3 uploads objects to Amazon S3 or any S3-compatible store (MinIO,
+// Cloudflare R2, Backblaze B2, ...), addressing buckets path-style and
+// signing requests with AWS Signature Version 4.
+//
+// Only the HTTP API is used; no AWS SDK is needed.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options configure a Client. Empty credentials fall back to
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type Options struct {
+	Endpoint        string // default https://s3.<region>.amazonaws.com
+	Region          string // default us-east-1
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	HTTPClient *http.Client
+}
+
+// Client puts objects into one bucket.
+type Client struct {
+	endpoint *url.URL
+	region   string
+	bucket   string
+	keyID    string
+	secret   string
+	token    string
+	client   *http.Client
+	now      func() time.Time
+}
+
+// New returns the Client for o.
+func New(o Options) (*Client, error) {
+	if o.Bucket == "" {
+		return nil, errors.New("s3: bucket required")
+	}
+	if o.Region == "" {
+		o.Region = "us-east-1"
+	}
+	if o.Endpoint == "" {
+		o.Endpoint = "https://s3." + o.Region + ".amazonaws.com"
+	}
+	if o.AccessKeyID == "" && o.SecretAccessKey == "" {
+		o.AccessKeyID, o.SecretAccessKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if o.SessionToken == "" {
+			o.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+	}
+	if o.AccessKeyID == "" || o.SecretAccessKey == "" {
+		return nil, errors.New("s3: access key id and secret access key required")
+	}
+	u, err := url.Parse(strings.TrimRight(o.Endpoint, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("s3: bad endpoint %q", o.Endpoint)
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return &Client{
+		endpoint: u, region: o.Region, bucket: o.Bucket,
+		keyID: o.AccessKeyID, secret: o.SecretAccessKey, token: o.SessionToken,
+		client: o.HTTPClient, now: time.Now,
+	}, nil
+}
+
+// Put uploads size bytes of body as the object key, in a single PUT (so up
+// to 5 GiB). body is read twice: once to hash it for the signature, then
+// to send it.
+func (c *Client) Put(ctx context.Context, key string, body io.ReadSeeker, size int64) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	u := *c.endpoint
+	u.Path = u.Path + "/" + c.bucket + "/" + key
+	u.RawPath = c.endpoint.EscapedPath() + "/" + escape(c.bucket) + "/" + escape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody // rather than a chunked upload of unknown length
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+	c.sign(req, hex.EncodeToString(h.Sum(nil)), c.now())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("s3: put %s: %s: %s", key, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// sign adds the x-amz-date, x-amz-content-sha256 and Authorization headers
+// of Signature Version 4 to req, signing every header it has set and Host.
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	k := hmacSHA256([]byte("AWS4"+c.secret), now.Format("20060102"))
+	k = hmacSHA256(k, c.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.keyID+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	parts := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes s as SigV4 wants: everything but unreserved
+// characters, keeping the slashes of object keys.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' || strings.IndexByte("-_.~/", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"database/sql"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rpattn/raalisence/internal/middleware"
+)
+
+var (
+	debugDB      atomic.Pointer[sql.DB]
+	publishDebug sync.Once
+)
+
+// debugHandler serves net/http/pprof under /debug/pprof/ and expvar at
+// /debug/vars, where besides cmdline and memstats it publishes the
+// database pool's stats (db_pool), the clients each rate limit group
+// tracks (rate_limit_buckets) and the goroutine count.
+func debugHandler(db *sql.DB) http.Handler {
+	debugDB.Store(db)
+	publishDebug.Do(func() {
+		// expvar names are process-wide, so these read the latest server's
+		expvar.Publish("db_pool", expvar.Func(func() any {
+			if db := debugDB.Load(); db != nil {
+				return db.Stats()
+			}
+			return nil
+		}))
+		expvar.Publish("rate_limit_buckets", expvar.Func(func() any { return middleware.RateLimitBuckets() }))
+		expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Drain starts a graceful shutdown: from now on every new request, except
+// the liveness probes (/livez, /healthz), is answered 503 with Retry-After
+// and its connection closed, so clients and load balancers go elsewhere,
+// while requests already running, e.g. an issuance mid-transaction, carry
+// on. /readyz fails with the rest.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+func (s *Server) drain(next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(math.Ceil(s.cfg.ShutdownTimeout().Seconds())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() && r.URL.Path != "/livez" && r.URL.Path != "/healthz" {
+			w.Header().Set("Retry-After", retryAfter)
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/openapi"
+)
+
+// authPolicy returns apiRoutes with server.auth_policy applied, and the
+// patterns that take the license key as their credential instead.
+func authPolicy(cfg *config.Config) ([]openapi.Route, map[string]bool, error) {
+	routes := make([]openapi.Route, len(apiRoutes))
+	index := map[string]int{}
+	for i, rt := range apiRoutes {
+		routes[i] = rt
+		index[rt.Method+" "+rt.Path] = i
+	}
+	licenseAuth := map[string]bool{}
+	for _, p := range cfg.Server.AuthPolicy {
+		i, ok := index[p.Route]
+		if !ok {
+			return nil, nil, fmt.Errorf("server.auth_policy: no route %q", p.Route)
+		}
+		rt := &routes[i]
+		switch p.Auth {
+		case config.RouteAuthAdmin:
+			rt.Admin = true
+			if !middleware.ValidRole(rt.Role) {
+				rt.Role = middleware.RoleViewer // routes made admin default to any key
+			}
+		case config.RouteAuthLicense:
+			rt.Admin, rt.Role, rt.Scope = false, "", ""
+			licenseAuth[p.Route] = true
+		case config.RouteAuthNone:
+			rt.Admin, rt.Role, rt.Scope = false, "", ""
+		}
+		if p.Role != "" {
+			if !rt.Admin || !middleware.ValidRole(p.Role) {
+				return nil, nil, fmt.Errorf("server.auth_policy: %s: role %q needs an admin route and one of admin, issuer, support or viewer", p.Route, p.Role)
+			}
+			rt.Role = p.Role
+		}
+		if p.Scope != "" {
+			if !rt.Admin || !middleware.ValidScope(p.Scope) {
+				return nil, nil, fmt.Errorf("server.auth_policy: %s: scope %q needs an admin route and a known scope", p.Route, p.Scope)
+			}
+			rt.Scope = p.Scope
+		}
+	}
+	return routes, licenseAuth, nil
+}
+
+// CheckAuthPolicy reports server.auth_policy entries that name an unknown
+// route, role or scope, which Handler would otherwise panic on.
+func CheckAuthPolicy(cfg *config.Config) error {
+	_, _, err := authPolicy(cfg)
+	return err
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/rpattn/raalisence/internal/graphql"
+	"github.com/rpattn/raalisence/internal/handlers"
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/openapi"
+)
+
+// apiRoutes documents every RESTful route registered in Handler; the served
+// OpenAPI spec and request validation are generated from it. Admin marks the
+// routes that need an API key and Role the key role they require.
+var apiRoutes = []openapi.Route{
+	{Method: "GET", Path: "/api/v1/licenses", Summary: "List licenses", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read",
+		Query:    []string{"limit", "cursor", "stream", "customer", "machine_id", "revoked", "product_id", "tenant", "key_id", "expires_before", "expires_after", "include_archived"},
+		Response: handlers.ListLicensesResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses", Summary: "Issue a license", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:issue", Query: []string{"format"}, Request: handlers.IssueRequest{}, Response: handlers.LicenseFile{}},
+	{Method: "GET", Path: "/api/v1/licenses/export", Summary: "Export all licenses as CSV, JSON or NDJSON", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read", Query: []string{"format", "stream"}},
+	{Method: "POST", Path: "/api/v1/licenses/batch", Summary: "Issue licenses in one transaction", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:issue", Request: handlers.IssueBatchRequest{}, Response: handlers.IssueBatchResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/import", Summary: "Import licenses from another system", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:issue", Request: handlers.ImportRequest{}, Response: handlers.ImportResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/resign", Summary: "Re-sign all matching active licenses (NDJSON of license files)", Admin: true, Role: middleware.RoleAdmin, Scope: "licenses:issue", Request: handlers.ResignFilter{}},
+	{Method: "POST", Path: "/api/v1/licenses/offline-activate", Summary: "Answer an offline activation request code", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:issue", Request: handlers.OfflineActivateRequest{}, Response: handlers.OfflineActivateResponse{}},
+	{Method: "GET", Path: "/api/v1/licenses/stale", Summary: "List licenses that stopped sending heartbeats", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read",
+		Query: []string{"limit", "cursor", "customer", "product_id"}, Response: handlers.ListStaleLicensesResponse{}},
+	{Method: "GET", Path: "/api/v1/licenses/{license_key}", Summary: "Get a license", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read", Response: handlers.LicenseDetail{}},
+	{Method: "PATCH", Path: "/api/v1/licenses/{license_key}", Summary: "Update a license", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:write", Request: handlers.UpdateLicenseRequest{}},
+	{Method: "DELETE", Path: "/api/v1/licenses/{license_key}", Summary: "Permanently delete a license", Admin: true, Role: middleware.RoleAdmin, Scope: "licenses:revoke", Query: []string{"force"}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/revoke", Summary: "Revoke a license", Admin: true, Role: middleware.RoleAdmin, Scope: "licenses:revoke", Request: handlers.ValidateRequest{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/suspend", Summary: "Suspend a license", Admin: true, Role: middleware.RoleSupport, Scope: "licenses:write", Request: handlers.ValidateRequest{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/resume", Summary: "Resume a suspended license", Admin: true, Role: middleware.RoleSupport, Scope: "licenses:write", Request: handlers.ValidateRequest{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/archive", Summary: "Archive a license", Admin: true, Role: middleware.RoleAdmin, Scope: "licenses:revoke", Request: handlers.ValidateRequest{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/restore", Summary: "Restore an archived license", Admin: true, Role: middleware.RoleAdmin, Scope: "licenses:revoke", Request: handlers.ValidateRequest{}},
+	{Method: "GET", Path: "/api/v1/licenses/{license_key}/file", Summary: "Download the signed license file", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read"},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/reissue", Summary: "Re-sign the license file", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:issue", Request: handlers.ValidateRequest{}, Response: handlers.LicenseFile{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/transfer", Summary: "Move a license to another machine", Admin: true, Role: middleware.RoleSupport, Scope: "licenses:write", Request: handlers.TransferRequest{}},
+	{Method: "GET", Path: "/api/v1/licenses/{license_key}/transfers", Summary: "List transfers", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read", Query: []string{"limit", "cursor"}, Response: handlers.ListTransfersResponse{}},
+	{Method: "GET", Path: "/api/v1/licenses/{license_key}/activations", Summary: "List activated machines", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read", Query: []string{"limit", "cursor"}, Response: handlers.ListActivationsResponse{}},
+	{Method: "GET", Path: "/api/v1/licenses/{license_key}/activity", Summary: "Validation activity", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read", Query: []string{"days", "limit"}, Response: handlers.LicenseActivity{}},
+	{Method: "GET", Path: "/api/v1/licenses/{license_key}/history", Summary: "License change history", Admin: true, Role: middleware.RoleViewer, Scope: "licenses:read", Query: []string{"limit", "cursor"}, Response: handlers.LicenseHistoryResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/deactivate", Summary: "Free a machine's seat", Admin: true, Role: middleware.RoleSupport, Scope: "licenses:write", Request: handlers.ValidateRequest{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/request-secret", Summary: "Require signed client requests", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:write", Response: handlers.RequestSecretResponse{}},
+	{Method: "DELETE", Path: "/api/v1/licenses/{license_key}/request-secret", Summary: "Stop requiring signed client requests", Admin: true, Role: middleware.RoleIssuer, Scope: "licenses:write"},
+
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/challenge", Summary: "Get a single-use validation nonce", Request: handlers.ValidateRequest{}, Response: handlers.ChallengeResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/validate", Summary: "Validate a license", Request: handlers.ValidateRequest{}, Response: handlers.ValidateResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/heartbeat", Summary: "Record a heartbeat", Request: handlers.ValidateRequest{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/activate", Summary: "Activate a machine", Request: handlers.ValidateRequest{}, Response: handlers.ActivateResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/usage", Summary: "Report metered usage", Request: handlers.UsageRequest{}, Response: handlers.UsageResponse{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/entitlement", Summary: "Check one feature (signed assertion)", Request: handlers.EntitlementRequest{}, Response: handlers.EntitlementAssertion{}},
+	{Method: "POST", Path: "/api/v1/licenses/{license_key}/checkout", Summary: "Check out a floating seat", Request: handlers.ValidateRequest{}, Response: handlers.CheckoutResponse{}},
+	{Method: "POST", Path: "/api/v1/sessions/{session_id}/heartbeat", Summary: "Extend a floating seat lease", Request: handlers.SessionRequest{}, Response: handlers.SessionHeartbeatResponse{}},
+	{Method: "DELETE", Path: "/api/v1/sessions/{session_id}", Summary: "Check a floating seat back in", Request: handlers.SessionRequest{}},
+
+	{Method: "GET", Path: "/api/v1/machines", Summary: "List machines seen on activate/heartbeat", Admin: true, Role: middleware.RoleViewer, Scope: "machines:read",
+		Query: []string{"limit", "cursor", "license_key", "hostname"}, Response: handlers.ListMachinesResponse{}},
+	{Method: "GET", Path: "/api/v1/machines/{machine_id}", Summary: "Get a machine and its licenses", Admin: true, Role: middleware.RoleViewer, Scope: "machines:read", Response: handlers.MachineDetail{}},
+
+	{Method: "GET", Path: "/api/v1/stats", Summary: "License totals for the dashboard", Admin: true, Role: middleware.RoleViewer, Scope: "stats:read", Response: handlers.StatsResponse{}},
+	{Method: "GET", Path: "/api/v1/audit", Summary: "Query the admin audit log", Admin: true, Role: middleware.RoleViewer, Scope: "audit:read",
+		Query:    []string{"actor", "action", "entity", "license_key", "since", "until", "limit", "cursor"},
+		Response: handlers.ListAuditResponse{}},
+	{Method: "POST", Path: "/api/v1/graphql", Summary: "Read-only GraphQL query over licenses, customers, machines and audit events", Admin: true, Role: middleware.RoleViewer, Scope: "graphql:read",
+		Request: graphql.Request{}, Response: graphql.Response{}},
+	{Method: "GET", Path: "/api/v1/events/stream", Summary: "Stream license events (text/event-stream)", Admin: true, Role: middleware.RoleViewer, Scope: "events:read", Query: []string{"types"}},
+
+	{Method: "GET", Path: "/api/v1/api-keys", Summary: "List admin API keys", Admin: true, Role: middleware.RoleAdmin, Scope: "apikeys:manage", Response: handlers.ListAPIKeysResponse{}},
+	{Method: "POST", Path: "/api/v1/api-keys", Summary: "Create an admin API key", Admin: true, Role: middleware.RoleAdmin, Scope: "apikeys:manage", Request: handlers.CreateAPIKeyRequest{}, Response: handlers.APIKey{}},
+	{Method: "PATCH", Path: "/api/v1/api-keys/{key_id}", Summary: "Relabel an admin API key", Admin: true, Role: middleware.RoleAdmin, Scope: "apikeys:manage", Request: handlers.UpdateAPIKeyRequest{}},
+	{Method: "POST", Path: "/api/v1/api-keys/{key_id}/rotate", Summary: "Replace an admin API key's secret", Admin: true, Role: middleware.RoleAdmin, Scope: "apikeys:manage", Query: []string{"overlap"}, Response: handlers.APIKey{}},
+	{Method: "POST", Path: "/api/v1/api-keys/{key_id}/revoke", Summary: "Revoke an admin API key", Admin: true, Role: middleware.RoleAdmin, Scope: "apikeys:manage"},
+
+	{Method: "GET", Path: "/api/v1/webhooks", Summary: "List webhook endpoints", Admin: true, Role: middleware.RoleAdmin, Scope: "webhooks:manage", Response: handlers.ListWebhooksResponse{}},
+	{Method: "POST", Path: "/api/v1/webhooks", Summary: "Register a webhook endpoint", Admin: true, Role: middleware.RoleAdmin, Scope: "webhooks:manage", Request: handlers.CreateWebhookRequest{}, Response: handlers.Webhook{}},
+	{Method: "DELETE", Path: "/api/v1/webhooks/{webhook_id}", Summary: "Remove a webhook endpoint", Admin: true, Role: middleware.RoleAdmin, Scope: "webhooks:manage"},
+	{Method: "GET", Path: "/api/v1/webhooks/{webhook_id}/deliveries", Summary: "List recent deliveries", Admin: true, Role: middleware.RoleAdmin, Scope: "webhooks:manage", Response: handlers.ListWebhookDeliveriesResponse{}},
+
+	{Method: "GET", Path: "/api/v1/migrations", Summary: "Schema version, pending migrations and drift from this binary", Admin: true, Role: middleware.RoleViewer, Scope: "system:read", Response: handlers.SchemaStatus{}},
+	{Method: "GET", Path: "/api/v1/metrics", Summary: "Business gauges in the Prometheus text format", Admin: true, Role: middleware.RoleViewer, Scope: "system:read"},
+	{Method: "GET", Path: "/api/v1/log-level", Summary: "Log level in force", Admin: true, Role: middleware.RoleViewer, Scope: "system:read", Response: handlers.LogLevel{}},
+	{Method: "PUT", Path: "/api/v1/log-level", Summary: "Override the log level for a while", Admin: true, Role: middleware.RoleAdmin, Scope: "system:write", Request: handlers.SetLogLevelRequest{}, Response: handlers.LogLevel{}},
+	{Method: "DELETE", Path: "/api/v1/log-level", Summary: "End a log level override", Admin: true, Role: middleware.RoleAdmin, Scope: "system:write", Response: handlers.LogLevel{}},
+	{Method: "POST", Path: "/api/v1/backups", Summary: "Back up the SQLite database to db.sqlite.backup_dir", Admin: true, Role: middleware.RoleAdmin, Scope: "backups:manage", Response: handlers.Backup{}},
+}
+
+// serveSpec serves the generated OpenAPI document.
+func serveSpec(spec *openapi.Spec) http.Handler {
+	b, err := json.MarshalIndent(spec.Doc, "", "  ")
+	if err != nil {
+		panic(err) // generated from static types; cannot fail at runtime
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+// validateBody rejects bodies that don't match schema with a 400 naming the
+// offending field, then hands the buffered body on to h.
+func validateBody(spec *openapi.Spec, schema *openapi.Schema, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, middleware.BodyLimit(r, maxValidatedBody)))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := spec.Validate(schema, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// maxValidatedBody matches the largest per-handler limit (batch issuance),
+// for routes without a limit of their own; handlers still enforce their
+// own, smaller caps.
+const maxValidatedBody = 4 << 20
@@ -1,34 +1,307 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/db/sqlite"
+	"github.com/rpattn/raalisence/internal/errreport"
 	"github.com/rpattn/raalisence/internal/handlers"
+	"github.com/rpattn/raalisence/internal/logging"
+	"github.com/rpattn/raalisence/internal/metrics"
 	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/oidc"
+	"github.com/rpattn/raalisence/internal/openapi"
+	"github.com/rpattn/raalisence/internal/s3"
 )
 
 type Server struct {
-	db  *sql.DB
-	cfg *config.Config
+	db     *sql.DB
+	reads  *handlers.ReadDB
+	cfg    *config.Config
+	errors *errreport.Reporter
+
+	draining atomic.Bool // see Drain
 }
 
-func New(db *sql.DB, cfg *config.Config) *Server { return &Server{db: db, cfg: cfg} }
+func New(db *sql.DB, cfg *config.Config) *Server {
+	return &Server{db: db, reads: handlers.NewReadDB(db, nil), cfg: cfg}
+}
+
+// WithErrorReporter sends the routes' internal errors to rep.
+func (s *Server) WithErrorReporter(rep *errreport.Reporter) *Server {
+	s.errors = rep
+	return s
+}
+
+// WithReplica sends the reads that can tolerate replication lag to replica.
+func (s *Server) WithReplica(replica *sql.DB) *Server {
+	s.reads = handlers.NewReadDB(s.db, replica)
+	return s
+}
 
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
-	// health
+	// health: /livez restarts the process, /readyz takes it out of rotation
+	mux.Handle("/livez", handlers.Health())
 	mux.Handle("/healthz", handlers.Health())
+	mux.Handle("/readyz", handlers.Ready(s.db, s.cfg))
+
+	keys := handlers.AdminKeyLookup(s.db, s.cfg)
+	if s.cfg.Server.AuthLockout.Threshold > 0 {
+		// lockouts must hold across restarts and replicas
+		middleware.SetFailureStore(handlers.AuthFailureStore(s.db, s.cfg))
+	}
+
+	// RESTful routes must be listed in apiRoutes, which decides admin auth
+	// and the key role required, and feeds the OpenAPI spec and optional body
+	// validation. Each is served under /api/v1 and, with JSON error
+	// envelopes, /api/v2.
+	for _, rt := range apiRoutes {
+		if rt.Admin && (!middleware.ValidRole(rt.Role) || !middleware.ValidScope(rt.Scope)) {
+			panic("admin route without a valid role and scope: " + rt.Method + " " + rt.Path)
+		}
+	}
+	// server.auth_policy may change who can call each route
+	effective, licenseAuth, err := authPolicy(s.cfg)
+	if err != nil {
+		panic(err) // main checks it first with CheckAuthPolicy
+	}
+	spec := openapi.Build("raalisence", "v1", effective)
+	routes := map[string]openapi.Route{}
+	for _, rt := range effective {
+		routes[rt.Method+" "+rt.Path] = rt
+	}
+	// Besides the {license_key} routes, which go through LicenseInScope,
+	// these honor the product and customer restrictions of scoped keys.
+	licenseScoped := map[string]bool{
+		"GET /api/v1/licenses":        true,
+		"POST /api/v1/licenses":       true,
+		"POST /api/v1/licenses/batch": true,
+	}
+	// Destructive routes take a TOTP code from identities that enrolled one.
+	totpGuarded := map[string]bool{
+		"DELETE /api/v1/licenses/{license_key}":      true,
+		"POST /api/v1/licenses/{license_key}/revoke": true,
+		"POST /api/v1/api-keys":                      true,
+		"PATCH /api/v1/api-keys/{key_id}":            true,
+		"POST /api/v1/api-keys/{key_id}/rotate":      true,
+		"POST /api/v1/api-keys/{key_id}/revoke":      true,
+	}
+	// auth wraps h in the key check of the apiRoutes entry for pattern, as
+	// server.auth_policy has it.
+	auth := func(pattern string, h http.Handler) http.Handler {
+		rt, ok := routes[pattern]
+		if !ok {
+			panic("route missing from apiRoutes: " + pattern)
+		}
+		if rt.Admin {
+			access := middleware.Access{Role: rt.Role, Scope: rt.Scope, LicenseScoped: licenseScoped[pattern]}
+			if strings.Contains(rt.Path, "{license_key}") {
+				h, access.LicenseScoped = handlers.LicenseInScope(s.db, h), true
+			}
+			if totpGuarded[pattern] {
+				h = handlers.RequireTOTP(s.db, s.cfg, h)
+			}
+			h = middleware.WithAccess(s.cfg, keys, access, h)
+		}
+		if licenseAuth[pattern] {
+			h = handlers.RequireLicenseKey(s.db, h)
+		}
+		return h
+	}
+	// Request bodies are capped per route group (server.body_limits): these
+	// take large payloads, and the routes for licensed clients, the ones
+	// without an API key, get the client limit.
+	bodyGroups := map[string]string{
+		"POST /api/v1/licenses":        config.BodyLimitIssue,
+		"POST /api/v1/licenses/batch":  config.BodyLimitIssue,
+		"POST /api/v1/licenses/import": config.BodyLimitImport,
+	}
+	limit := func(pattern string, h http.Handler) http.Handler {
+		group, ok := bodyGroups[pattern]
+		if !ok {
+			group = config.BodyLimitDefault
+			if !routes[pattern].Admin {
+				group = config.BodyLimitClient
+			}
+		}
+		return middleware.WithBodyLimit(s.cfg.BodyLimit(group), h)
+	}
+	// Admin writes wait out schema drift (a rolling upgrade, say); licensed
+	// clients only touch columns every schema version has, and keep working.
+	guard := handlers.NewSchemaGuard(s.db, s.cfg)
+	handle := func(pattern string, h http.Handler) {
+		h = handlers.ReportErrors(s.errors, pattern, h)
+		if schema, ok := spec.BodySchema(pattern); ok && s.cfg.Server.ValidateRequests {
+			h = validateBody(spec, schema, h)
+		}
+		if routes[pattern].Admin {
+			h = handlers.AuditRequests(s.db, s.cfg, guard.Wrap(h))
+		}
+		h = limit(pattern, auth(pattern, h))
+		mux.Handle(pattern, s.v1(h, true))
+		mux.Handle(v2Pattern(pattern), h)
+	}
+	mux.Handle("GET /openapi.json", serveSpec(spec))
+	mux.Handle("GET /api/v2/openapi.json", serveSpec(openapi.Build("raalisence", "v2", v2Routes(effective))))
+	mux.Handle("GET /.well-known/jwks.json", handlers.JWKS(s.cfg))
+
+	// OIDC sign-in for the admin panel; the session cookie then works on
+	// the admin routes in place of a bearer key
+	if s.cfg.OIDCEnabled() {
+		p := oidc.New(oidc.Options{
+			IssuerURL:    s.cfg.OIDC.IssuerURL,
+			ClientID:     s.cfg.OIDC.ClientID,
+			ClientSecret: s.cfg.OIDC.ClientSecret,
+			RedirectURL:  s.cfg.OIDC.RedirectURL,
+			Scopes:       s.cfg.OIDC.Scopes,
+		})
+		mux.Handle("GET /auth/oidc/login", handlers.OIDCLogin(s.cfg, p))
+		mux.Handle("GET /auth/oidc/callback", handlers.OIDCCallback(s.db, s.cfg, p))
+	}
+	// any admin key (restricted ones too, whose sessions keep the
+	// restriction) or SSO session can be swapped for a short-lived session
+	login := middleware.Access{Role: middleware.RoleViewer, LicenseScoped: true}
+	loginHandler := middleware.WithAccess(s.cfg, keys, login, handlers.ReportErrors(s.errors, "POST /api/v1/auth/login", handlers.Login(s.db, s.cfg)))
+	mux.Handle("POST /api/v1/auth/login", s.v1(loginHandler, true))
+	mux.Handle(v2Pattern("POST /api/v1/auth/login"), loginHandler)
+	// TOTP second factors, for the same callers as login
+	totpRoutes := []struct {
+		pattern string
+		h       http.Handler
+	}{
+		{"GET /api/v1/auth/totp", handlers.GetTOTP(s.db, s.cfg)},
+		{"POST /api/v1/auth/totp", handlers.EnrollTOTP(s.db, s.cfg)},
+		{"POST /api/v1/auth/totp/confirm", handlers.ConfirmTOTP(s.db, s.cfg)},
+		{"DELETE /api/v1/auth/totp", handlers.RequireTOTP(s.db, s.cfg, handlers.DisableTOTP(s.db, s.cfg))},
+	}
+	for _, t := range totpRoutes {
+		h := middleware.WithAccess(s.cfg, keys, login, middleware.WithBodyLimit(s.cfg.BodyLimit(config.BodyLimitDefault), handlers.ReportErrors(s.errors, t.pattern, t.h)))
+		mux.Handle(t.pattern, s.v1(h, true))
+		mux.Handle(v2Pattern(t.pattern), h)
+	}
+	mux.Handle("GET /auth/session", middleware.WithRole(s.cfg, keys, middleware.RoleViewer, handlers.CurrentSession()))
+	mux.Handle("POST /auth/logout", handlers.Logout(s.db, s.cfg))
 
-	// license handlers
-	mux.Handle("/api/v1/licenses", middleware.WithAdminKey(s.cfg, handlers.ListLicenses(s.db, s.cfg)))
-	mux.Handle("/api/v1/licenses/issue", middleware.WithAdminKey(s.cfg, handlers.IssueLicense(s.db, s.cfg)))
-	mux.Handle("/api/v1/licenses/revoke", middleware.WithAdminKey(s.cfg, handlers.RevokeLicense(s.db)))
-	mux.Handle("/api/v1/licenses/update", middleware.WithAdminKey(s.cfg, handlers.UpdateLicense(s.db, s.cfg)))
-	mux.Handle("/api/v1/licenses/validate", handlers.ValidateLicense(s.db, s.cfg))
-	mux.Handle("/api/v1/licenses/heartbeat", handlers.Heartbeat(s.db))
+	// licenses: admin
+	handle("GET /api/v1/licenses", handlers.ListLicensesFrom(s.reads, s.cfg))
+	handle("POST /api/v1/licenses", handlers.IssueLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/batch", handlers.IssueBatch(s.db, s.cfg))
+	handle("GET /api/v1/licenses/export", handlers.ExportLicenses(s.db, s.cfg))
+	handle("POST /api/v1/licenses/import", handlers.ImportLicenses(s.db, s.cfg))
+	handle("POST /api/v1/licenses/resign", handlers.ResignLicenses(s.db, s.cfg))
+	handle("POST /api/v1/licenses/offline-activate", handlers.OfflineActivate(s.db, s.cfg))
+	handle("GET /api/v1/licenses/stale", handlers.ListStaleLicenses(s.db, s.cfg))
+	handle("GET /api/v1/licenses/{license_key}", handlers.GetLicense(s.db, s.cfg))
+	handle("PATCH /api/v1/licenses/{license_key}", handlers.UpdateLicense(s.db, s.cfg))
+	handle("DELETE /api/v1/licenses/{license_key}", handlers.DeleteLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/revoke", handlers.RevokeLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/suspend", handlers.SuspendLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/resume", handlers.ResumeLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/archive", handlers.ArchiveLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/restore", handlers.RestoreLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/reissue", handlers.ReissueLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/request-secret", handlers.SetRequestSecret(s.db, s.cfg))
+	handle("DELETE /api/v1/licenses/{license_key}/request-secret", handlers.ClearRequestSecret(s.db, s.cfg))
+	handle("GET /api/v1/licenses/{license_key}/file", handlers.DownloadLicenseFile(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/transfer", handlers.TransferLicense(s.db, s.cfg))
+	handle("GET /api/v1/licenses/{license_key}/transfers", handlers.ListTransfers(s.db, s.cfg))
+	handle("GET /api/v1/licenses/{license_key}/activations", handlers.ListActivations(s.db, s.cfg))
+	handle("GET /api/v1/licenses/{license_key}/activity", handlers.GetLicenseActivity(s.db, s.cfg))
+	handle("GET /api/v1/licenses/{license_key}/history", handlers.GetLicenseHistory(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/deactivate", handlers.DeactivateMachine(s.db, s.cfg))
+
+	// licenses: clients
+	handle("POST /api/v1/licenses/{license_key}/challenge", handlers.IssueChallenge(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/validate", handlers.ValidateLicenseFrom(s.reads, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/heartbeat", handlers.Heartbeat(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/activate", handlers.ActivateLicense(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/usage", handlers.ReportUsage(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/entitlement", handlers.CheckEntitlement(s.db, s.cfg))
+	handle("POST /api/v1/licenses/{license_key}/checkout", handlers.CheckoutLicense(s.db, s.cfg))
+	handle("POST /api/v1/sessions/{session_id}/heartbeat", handlers.SessionHeartbeat(s.db, s.cfg))
+	handle("DELETE /api/v1/sessions/{session_id}", handlers.CheckinLicense(s.db))
+
+	// machines: admin
+	handle("GET /api/v1/machines", handlers.ListMachines(s.db, s.cfg))
+	handle("GET /api/v1/machines/{machine_id}", handlers.GetMachine(s.db))
+
+	// dashboard: admin
+	handle("GET /api/v1/stats", handlers.Stats(s.db, s.cfg))
+	handle("GET /api/v1/audit", handlers.ListAudit(s.db, s.cfg))
+	handle("POST /api/v1/graphql", handlers.GraphQL(s.db, s.cfg))
+	handle("GET /api/v1/events/stream", handlers.EventStream())
+
+	// admin API keys: admin
+	handle("GET /api/v1/api-keys", handlers.ListAPIKeys(s.db))
+	handle("POST /api/v1/api-keys", handlers.CreateAPIKey(s.db, s.cfg))
+	handle("PATCH /api/v1/api-keys/{key_id}", handlers.UpdateAPIKey(s.db, s.cfg))
+	handle("POST /api/v1/api-keys/{key_id}/rotate", handlers.RotateAPIKey(s.db, s.cfg))
+	handle("POST /api/v1/api-keys/{key_id}/revoke", handlers.RevokeAPIKey(s.db, s.cfg))
+
+	// webhooks: admin
+	handle("GET /api/v1/webhooks", handlers.ListWebhooks(s.db))
+	handle("POST /api/v1/webhooks", handlers.CreateWebhook(s.db, s.cfg))
+	handle("DELETE /api/v1/webhooks/{webhook_id}", handlers.DeleteWebhook(s.db, s.cfg))
+	handle("GET /api/v1/webhooks/{webhook_id}/deliveries", handlers.ListWebhookDeliveries(s.db))
+
+	// backups: admin
+	handle("POST /api/v1/backups", handlers.CreateBackup(s.db, s.cfg))
+	handle("GET /api/v1/migrations", handlers.MigrationStatus(s.db, s.cfg))
+	handle("GET /api/v1/metrics", metrics.Handler(metrics.Default))
+	handle("GET /api/v1/log-level", handlers.GetLogLevel())
+	handle("PUT /api/v1/log-level", handlers.SetLogLevel(s.db, s.cfg))
+	handle("DELETE /api/v1/log-level", handlers.ResetLogLevel(s.db, s.cfg))
+
+	// legacy action-in-path routes, kept as deprecated aliases of the above
+	// and authorized and guarded like their successors
+	legacy := []struct {
+		pattern, successor string
+		h                  http.Handler
+	}{
+		{"POST /api/v1/licenses/issue", "POST /api/v1/licenses", handlers.IssueLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/issue-batch", "POST /api/v1/licenses/batch", handlers.IssueBatch(s.db, s.cfg)},
+		{"POST /api/v1/licenses/update", "PATCH /api/v1/licenses/{license_key}", handlers.UpdateLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/revoke", "POST /api/v1/licenses/{license_key}/revoke", handlers.RevokeLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/suspend", "POST /api/v1/licenses/{license_key}/suspend", handlers.SuspendLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/resume", "POST /api/v1/licenses/{license_key}/resume", handlers.ResumeLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/archive", "POST /api/v1/licenses/{license_key}/archive", handlers.ArchiveLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/restore", "POST /api/v1/licenses/{license_key}/restore", handlers.RestoreLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/reissue", "POST /api/v1/licenses/{license_key}/reissue", handlers.ReissueLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/transfer", "POST /api/v1/licenses/{license_key}/transfer", handlers.TransferLicense(s.db, s.cfg)},
+		{"GET /api/v1/licenses/transfers", "GET /api/v1/licenses/{license_key}/transfers", handlers.ListTransfers(s.db, s.cfg)},
+		{"GET /api/v1/licenses/activations", "GET /api/v1/licenses/{license_key}/activations", handlers.ListActivations(s.db, s.cfg)},
+		{"POST /api/v1/licenses/deactivate", "POST /api/v1/licenses/{license_key}/deactivate", handlers.DeactivateMachine(s.db, s.cfg)},
+		{"POST /api/v1/licenses/validate", "POST /api/v1/licenses/{license_key}/validate", handlers.ValidateLicenseFrom(s.reads, s.cfg)},
+		{"POST /api/v1/licenses/heartbeat", "POST /api/v1/licenses/{license_key}/heartbeat", handlers.Heartbeat(s.db, s.cfg)},
+		{"POST /api/v1/licenses/activate", "POST /api/v1/licenses/{license_key}/activate", handlers.ActivateLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/usage", "POST /api/v1/licenses/{license_key}/usage", handlers.ReportUsage(s.db, s.cfg)},
+		{"POST /api/v1/licenses/checkout", "POST /api/v1/licenses/{license_key}/checkout", handlers.CheckoutLicense(s.db, s.cfg)},
+		{"POST /api/v1/licenses/checkin", "DELETE /api/v1/sessions/{session_id}", handlers.CheckinLicense(s.db)},
+		{"POST /api/v1/licenses/session/heartbeat", "POST /api/v1/sessions/{session_id}/heartbeat", handlers.SessionHeartbeat(s.db, s.cfg)},
+	}
+	for _, l := range legacy {
+		h := handlers.ReportErrors(s.errors, l.pattern, l.h)
+		if routes[l.successor].Admin {
+			h = handlers.AuditRequests(s.db, s.cfg, guard.Wrap(h))
+		}
+		mux.Handle(l.pattern, s.v1(deprecated(l.successor, limit(l.successor, auth(l.successor, h))), false))
+	}
+
+	// profiles and runtime stats for admins, when turned on
+	if s.cfg.Server.DebugEndpoints {
+		debug := middleware.Access{Role: middleware.RoleAdmin, Scope: "system:read"}
+		mux.Handle("/debug/", middleware.WithAccess(s.cfg, keys, debug, debugHandler(s.db)))
+	}
 
 	// static admin panel
 	fs := http.FileServer(http.Dir("static"))
@@ -37,8 +310,231 @@ func (s *Server) Handler() http.Handler {
 		http.Redirect(w, r, "/static/admin.html", http.StatusFound)
 	})
 
-	h := middleware.WithRequestID(middleware.WithRateLimit(s.cfg, mux))
+	// Load has already refused malformed proxies
+	proxies, _ := s.cfg.TrustedProxies()
+	h := middleware.WithRequestID(middleware.WithClientIP(proxies, errorEnvelope(s.drain(middleware.WithRateLimit(s.cfg, keys, mux)))))
 
 	// logging
 	return middleware.Logging(h)
 }
+
+// ReapSessions periodically reclaims floating seats whose heartbeats have
+// stopped. It blocks until ctx is cancelled.
+func (s *Server) ReapSessions(ctx context.Context) {
+	t := time.NewTicker(s.cfg.SessionTTL() / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := handlers.ReapStaleSessions(ctx, s.db, s.cfg)
+			if err != nil {
+				logging.Errorf("session reaper error: %v", err)
+				continue
+			}
+			if n > 0 {
+				logging.Infof("session reaper reclaimed=%d", n)
+			}
+		}
+	}
+}
+
+// RunWebhooks periodically queues time-based license events, dispatches
+// outbox events a request didn't get to (the process stopped between its
+// commit and the dispatch, say) and delivers pending webhooks. It blocks
+// until ctx is cancelled.
+func (s *Server) RunWebhooks(ctx context.Context) {
+	client := &http.Client{Timeout: s.cfg.WebhookTimeout()}
+	t := time.NewTicker(s.cfg.WebhookPollInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := handlers.ScanLicenseEvents(ctx, s.db, s.cfg); err != nil {
+				logging.Errorf("webhook scan error: %v", err)
+			}
+			if _, err := handlers.DispatchEvents(ctx, s.db, s.cfg); err != nil {
+				logging.Errorf("event dispatch error: %v", err)
+			}
+			n, err := handlers.DeliverWebhooks(ctx, s.db, s.cfg, client)
+			if err != nil {
+				logging.Errorf("webhook delivery error: %v", err)
+				continue
+			}
+			if n > 0 {
+				logging.Debugf("webhook deliveries attempted=%d", n)
+			}
+		}
+	}
+}
+
+// RunRetention periodically deletes data older than the retention
+// settings. It returns at once when retention.interval is 0 and otherwise
+// blocks until ctx is cancelled.
+func (s *Server) RunRetention(ctx context.Context) {
+	if s.cfg.Retention.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(s.cfg.Retention.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p, err := handlers.PruneData(ctx, s.db, s.cfg)
+			if err != nil {
+				logging.Errorf("retention error: %v", err)
+			}
+			if p.Total() > 0 {
+				logging.Infof("retention pruned licenses=%d validations=%d audit=%d events=%d", p.Licenses, p.Validations, p.Audit, p.Events)
+			}
+		}
+	}
+}
+
+// RunStaleScan flags licenses that stopped sending heartbeats every
+// stale.interval; see handlers.ScanStaleLicenses. It blocks until ctx is
+// cancelled.
+func (s *Server) RunStaleScan(ctx context.Context) {
+	if s.cfg.Stale.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(s.cfg.Stale.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := handlers.ScanStaleLicenses(ctx, s.db, s.cfg); err != nil {
+				logging.Errorf("stale scan error: %v", err)
+			}
+		}
+	}
+}
+
+// RunMetrics refreshes the business gauges every metrics.interval, and
+// once at start. It blocks until ctx is cancelled.
+func (s *Server) RunMetrics(ctx context.Context) {
+	if s.cfg.Metrics.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(s.cfg.Metrics.Interval)
+	defer t.Stop()
+	for {
+		if err := handlers.RefreshMetrics(ctx, s.db, s.cfg); err != nil && ctx.Err() == nil {
+			logging.Errorf("metrics refresh error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunCheckpoints checkpoints a SQLite database's WAL every
+// db.sqlite.checkpoint.interval, between the configured hooks. It blocks
+// until ctx is cancelled.
+func (s *Server) RunCheckpoints(ctx context.Context) {
+	c := s.cfg.DB.SQLite.Checkpoint
+	if c.Interval <= 0 || s.cfg.DB.Driver != "sqlite3" {
+		return
+	}
+	opts := sqlite.CheckpointOptions{Mode: c.Mode, PreHook: c.PreHook, PostHook: c.PostHook, Path: s.cfg.DB.Path}
+	t := time.NewTicker(c.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			res, err := sqlite.Checkpoint(ctx, s.db, opts)
+			if err != nil {
+				logging.Errorf("checkpoint error: %v", err)
+				continue
+			}
+			if res.Busy {
+				logging.Warnf("checkpoint busy mode=%s log=%d checkpointed=%d", c.Mode, res.Log, res.Checkpointed)
+			}
+		}
+	}
+}
+
+// RunSnapshots copies a SQLite database to db.sqlite.snapshots.dir and S3
+// every db.sqlite.snapshots.interval. It blocks until ctx is cancelled.
+func (s *Server) RunSnapshots(ctx context.Context) {
+	c := s.cfg.DB.SQLite.Snapshots
+	if c.Interval <= 0 || s.cfg.DB.Driver != "sqlite3" {
+		return
+	}
+	opts := sqlite.SnapshotOptions{Dir: c.Dir, Keep: c.Keep}
+	if c.S3.Bucket != "" {
+		client, err := s3.New(s3.Options{
+			Endpoint: c.S3.Endpoint, Region: c.S3.Region, Bucket: c.S3.Bucket,
+			AccessKeyID: c.S3.AccessKeyID, SecretAccessKey: c.S3.SecretAccessKey,
+		})
+		if err != nil {
+			logging.Errorf("snapshots disabled: %v", err)
+			return
+		}
+		opts.Upload = func(ctx context.Context, name, path string) error {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			return client.Put(ctx, c.S3.Prefix+name, f, fi.Size())
+		}
+	}
+	t := time.NewTicker(c.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			name, size, err := sqlite.Snapshot(ctx, s.db, opts)
+			if err != nil {
+				logging.Errorf("snapshot error: %v", err)
+				continue
+			}
+			logging.Infof("snapshot %s (%d bytes)", name, size)
+		}
+	}
+}
+
+// Reload applies next, a config fresh from config.Load, to the running
+// server: the signing keys, admin API keys and rate limits change at once
+// (config.Config.Reload), with no connection dropped. A config that fails
+// config.Config.Lint is refused and the current one kept. It returns what
+// changed.
+func (s *Server) Reload(next *config.Config) ([]string, error) {
+	if errs := next.Lint(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	changed, err := s.cfg.Reload(next)
+	if err != nil {
+		return nil, err
+	}
+	middleware.SetRateLimits(s.cfg)
+	return changed, nil
+}
+
+// deprecated marks responses from a legacy route so clients can find the
+// RESTful replacement.
+func deprecated(successor string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("X-Successor-Route", successor)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rpattn/raalisence/internal/config"
+	"github.com/rpattn/raalisence/internal/crypto"
+	migrate "github.com/rpattn/raalisence/internal/db/migrations_sqlite"
+)
+
+// Handler panics on conflicting mux patterns, so building it is the test.
+func TestRoutes(t *testing.T) {
+	h := New(nil, &config.Config{}).Handler()
+
+	cases := []struct {
+		method, path string
+		code         int
+		deprecated   bool
+	}{
+		{http.MethodGet, "/api/v1/licenses/abc", http.StatusUnauthorized, false},
+		{http.MethodPost, "/api/v1/licenses/abc/revoke", http.StatusUnauthorized, false},
+		{http.MethodPost, "/api/v1/licenses/revoke", http.StatusUnauthorized, true},
+		{http.MethodGet, "/api/v1/licenses/validate", http.StatusUnauthorized, false}, // GET falls through to {license_key}
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(c.method, c.path, nil))
+		if rr.Code != c.code {
+			t.Errorf("%s %s: code=%d want %d", c.method, c.path, rr.Code, c.code)
+		}
+		if got := rr.Header().Get("Deprecation") == "true"; got != c.deprecated {
+			t.Errorf("%s %s: deprecated=%v want %v", c.method, c.path, got, c.deprecated)
+		}
+	}
+}
+
+// Legacy aliases of admin routes refuse writes during schema drift, as
+// their successors do.
+func TestLegacySchemaGuard(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.EnsureSQLiteSchema(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version) VALUES ('9999_future')`); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.DB.Driver = "sqlite3"
+	cfg.Server.AdminAPIKey = "admin"
+	h := New(db, cfg).Handler()
+
+	for _, path := range []string{"/api/v1/licenses/revoke", "/api/v1/licenses/abc/revoke"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"license_key":"abc"}`))
+		req.Header.Set("Authorization", "Bearer admin")
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: code=%d body=%s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestOpenAPIAndValidation(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.ValidateRequests = true
+	h := New(nil, cfg).Handler()
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("openapi.json: code=%d err=%v", rr.Code, err)
+	}
+	if len(doc.Paths) == 0 || doc.Paths["/api/v1/licenses/{license_key}/validate"]["post"] == nil {
+		t.Fatalf("validate route missing from spec: %v", doc.Paths)
+	}
+	if op, _ := doc.Paths["/api/v1/licenses/{license_key}/suspend"]["post"].(map[string]any); op["x-required-role"] != "support" {
+		t.Fatalf("suspend route role: %v", op)
+	}
+
+	// rejected before reaching the handler (which would need a db)
+	for _, body := range []string{`{"machine_id":5}`, `{"machine":"x"}`} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/licenses/abc/validate", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: code=%d want 400", body, rr.Code)
+		}
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.API.V1Deprecated = true
+	cfg.API.V1Sunset = "2027-01-31"
+	h := New(nil, cfg).Handler()
+
+	// v2 errors are JSON envelopes
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/licenses/abc", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	h.ServeHTTP(rr, req)
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusUnauthorized {
+		t.Fatalf("v2: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if body.Error.Code != "unauthorized" || body.Error.Message != "unauthorized" || body.Error.RequestID != "req-1" {
+		t.Fatalf("unexpected envelope %+v", body.Error)
+	}
+	if rr.Header().Get("Deprecation") != "" {
+		t.Fatalf("v2 marked deprecated")
+	}
+
+	// v1 keeps plain errors and announces its sunset
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/licenses/abc", nil))
+	if rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("v1: code=%d content-type=%s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr.Header().Get("Deprecation") != "true" || rr.Header().Get("Sunset") != "Sun, 31 Jan 2027 00:00:00 GMT" {
+		t.Fatalf("v1 headers: %v", rr.Header())
+	}
+	if got := rr.Header().Get("Link"); got != `</api/v2/licenses/abc>; rel="successor-version"` {
+		t.Fatalf("v1 link: %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v2/openapi.json", nil))
+	var doc struct {
+		Paths map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil || doc.Paths["/api/v2/licenses/{license_key}"] == nil {
+		t.Fatalf("v2 spec: code=%d err=%v", rr.Code, err)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg := &config.Config{}
+	if tc, err := TLSConfig(cfg); tc != nil || err != nil {
+		t.Fatalf("without server.tls: %v %v", tc, err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:   time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign, BasicConstraintsValid: true, IsCA: true,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	dir := t.TempDir()
+	cfg.Server.TLS.CertFile = filepath.Join(dir, "cert.pem")
+	cfg.Server.TLS.KeyFile = filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(cfg.Server.TLS.CertFile, certPEM, 0o600)
+	_ = os.WriteFile(cfg.Server.TLS.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	// with mtls on, client certificates are asked for but optional
+	cfg.MTLS.ClientCAPEM = string(certPEM)
+	tc, err := TLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.MinVersion != tls.VersionTLS12 || tc.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Fatalf("min version %x, client auth %v", tc.MinVersion, tc.ClientAuth)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, len(r.TLS.PeerCertificates))
+	}))
+	srv.TLS = tc
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	get := func(c *tls.Config) (string, error) {
+		c.RootCAs = roots
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: c}}).Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+	if got, err := get(&tls.Config{}); err != nil || got != "0" {
+		t.Fatalf("without a client certificate: %q %v", got, err)
+	}
+	clientCert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	if got, err := get(&tls.Config{Certificates: []tls.Certificate{clientCert}}); err != nil || got != "1" {
+		t.Fatalf("with a client certificate: %q %v", got, err)
+	}
+	if _, err := get(&tls.Config{MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Fatal("TLS 1.1 accepted")
+	}
+
+	cfg.Server.TLS.ClientCAFile = cfg.Server.TLS.CertFile
+	if tc, err := TLSConfig(cfg); err != nil || tc.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("with client_ca_file: %v %v", tc, err)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.BodyLimits.Client = 512
+	h := New(nil, cfg).Handler()
+
+	body := `{"license_key":"abc","machine_id":"` + strings.Repeat("m", 1024) + `"}`
+	for _, path := range []string{"/api/v1/licenses/abc/validate", "/api/v1/licenses/validate", "/api/v2/licenses/abc/heartbeat"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: code=%d want 413", path, rr.Code)
+		}
+	}
+	if got := cfg.BodyLimit(config.BodyLimitImport); got != 4<<20 {
+		t.Errorf("default import limit %d", got)
+	}
+}
+
+func TestAuthPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.AuthPolicy = []config.RoutePolicy{
+		{Route: "GET /api/v1/licenses", Role: "admin"},
+		{Route: "POST /api/v1/licenses/{license_key}/heartbeat", Auth: config.RouteAuthLicense},
+		{Route: "DELETE /api/v1/licenses/{license_key}", Auth: config.RouteAuthNone},
+	}
+	routes, licenseAuth, err := authPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range routes {
+		switch rt.Method + " " + rt.Path {
+		case "GET /api/v1/licenses":
+			if !rt.Admin || rt.Role != "admin" || rt.Scope != "licenses:read" {
+				t.Errorf("list licenses: %+v", rt)
+			}
+		case "DELETE /api/v1/licenses/{license_key}":
+			if rt.Admin || rt.Role != "" {
+				t.Errorf("delete license: %+v", rt)
+			}
+		}
+	}
+	if !licenseAuth["POST /api/v1/licenses/{license_key}/heartbeat"] || len(licenseAuth) != 1 {
+		t.Fatalf("licenseAuth=%v", licenseAuth)
+	}
+
+	// no Authorization: License header, turned away before the db
+	h := New(nil, cfg).Handler()
+	for _, path := range []string{"/api/v1/licenses/abc/heartbeat", "/api/v2/licenses/abc/heartbeat", "/api/v1/licenses/heartbeat"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"license_key":"abc"}`)))
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "License" {
+			t.Errorf("%s: code=%d want 401", path, rr.Code)
+		}
+	}
+
+	cfg.Server.AuthPolicy = []config.RoutePolicy{{Route: "GET /api/v1/nope", Auth: config.RouteAuthNone}}
+	if err := CheckAuthPolicy(cfg); err == nil {
+		t.Fatal("unknown route accepted")
+	}
+	cfg.Server.AuthPolicy = []config.RoutePolicy{{Route: "GET /api/v1/licenses", Scope: "licenses:everything"}}
+	if err := CheckAuthPolicy(cfg); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.AdminAPIKey = "test-admin"
+	get := func(h http.Handler, path, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get(New(nil, cfg).Handler(), "/debug/vars", "test-admin"); rr.Code != http.StatusFound {
+		t.Fatalf("off: code=%d", rr.Code)
+	}
+
+	cfg.Server.DebugEndpoints = true
+	h := New(nil, cfg).Handler()
+	if rr := get(h, "/debug/pprof/", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("no key: code=%d", rr.Code)
+	}
+	if rr := get(h, "/debug/pprof/goroutine?debug=1", "test-admin"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "goroutine profile") {
+		t.Fatalf("goroutine profile: code=%d", rr.Code)
+	}
+	rr := get(h, "/debug/vars", "test-admin")
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &vars); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("vars: code=%d err=%v", rr.Code, err)
+	}
+	var buckets map[string]int
+	_ = json.Unmarshal(vars["rate_limit_buckets"], &buckets)
+	if buckets["default"] == 0 || vars["memstats"] == nil || vars["goroutines"] == nil {
+		t.Fatalf("vars: %s", rr.Body.String())
+	}
+}
+
+func TestReload(t *testing.T) {
+	keyPair := func() (string, string) {
+		priv, pub, err := crypto.GeneratePEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return priv, pub
+	}
+	cfg := &config.Config{}
+	cfg.Server.Addr = ":0"
+	cfg.Server.AdminAPIKey = "old-admin"
+	cfg.Server.DebugEndpoints = true
+	cfg.Signing.PrivateKeyPEM, cfg.Signing.PublicKeyPEM = keyPair()
+	s := New(nil, cfg)
+	h := s.Handler()
+	get := func(path, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.7:1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	jwks := get("/.well-known/jwks.json", "").Body.String()
+
+	newConfig := func() *config.Config {
+		next := &config.Config{}
+		next.Server.Addr = ":0"
+		next.Server.AdminAPIKey = "new-admin"
+		return next
+	}
+	next := newConfig()
+	next.Signing.PrivateKeyPEM, next.Signing.PublicKeyPEM = cfg.Signing.PrivateKeyPEM, "not a key"
+	if _, err := s.Reload(next); err == nil {
+		t.Fatal("reload with a broken key pair: no error")
+	}
+	if rr := get("/debug/vars", "old-admin"); rr.Code != http.StatusOK {
+		t.Fatalf("refused reload changed the admin key: code=%d", rr.Code)
+	}
+
+	next = newConfig()
+	next.Signing.PrivateKeyPEM, next.Signing.PublicKeyPEM = keyPair()
+	next.RateLimit.Default = config.RateLimitGroup{RPS: 0.001, Burst: 1}
+	changed, err := s.Reload(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(changed, ","); got != "signing keys,admin API keys,rate limits" {
+		t.Fatalf("changed: %s", got)
+	}
+	if got := get("/.well-known/jwks.json", ""); got.Code != http.StatusOK || got.Body.String() == jwks {
+		t.Fatalf("jwks not reloaded: code=%d", got.Code)
+	}
+	if rr := get("/debug/vars", "old-admin"); rr.Code != http.StatusUnauthorized && rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("old admin key: code=%d", rr.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rr := get("/openapi.json", ""); rr.Code == http.StatusTooManyRequests {
+			return
+		}
+	}
+	t.Fatal("reloaded rate limit not applied")
+}
+
+// X-Forwarded-For only names the client when a trusted proxy sent it.
+func TestTrustedProxies(t *testing.T) {
+	get := func(h http.Handler, remote, xff string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Forwarded-For", xff)
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	cfg := &config.Config{}
+	cfg.RateLimit.Default = config.RateLimitGroup{RPS: 0.001, Burst: 1}
+	h := New(nil, cfg).Handler()
+	if code := get(h, "198.51.100.7", "203.0.113.1"); code != http.StatusOK {
+		t.Fatalf("first request: code=%d", code)
+	}
+	if code := get(h, "198.51.100.7", "203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed X-Forwarded-For escaped the limit: code=%d", code)
+	}
+
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "198.51.100.7"}
+	h = New(nil, cfg).Handler()
+	for _, xff := range []string{"203.0.113.1", "192.0.2.9, 203.0.113.2, 10.1.2.3"} {
+		if code := get(h, "198.51.100.7", xff); code != http.StatusOK {
+			t.Fatalf("%s via a trusted proxy: code=%d", xff, code)
+		}
+	}
+	if code := get(h, "198.51.100.7", "192.0.2.10, 203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("hop left of the client was believed: code=%d", code)
+	}
+
+	cfg.Server.TrustedProxies = []string{"not-an-ip"}
+	if _, err := cfg.TrustedProxies(); err == nil {
+		t.Fatal("malformed trusted proxy accepted")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.Shutdown.Timeout = 2500 * time.Millisecond
+	s := New(nil, cfg)
+	h := s.Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+	if rr := get("/openapi.json"); rr.Code != http.StatusOK {
+		t.Fatalf("before drain: code=%d", rr.Code)
+	}
+
+	s.Drain()
+	for _, path := range []string{"/openapi.json", "/api/v1/licenses/abc", "/readyz"} {
+		rr := get(path)
+		if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "3" || rr.Header().Get("Connection") != "close" {
+			t.Errorf("%s: code=%d retry-after=%q", path, rr.Code, rr.Header().Get("Retry-After"))
+		}
+	}
+	var env struct{ Error APIError }
+	if rr := get("/api/v2/licenses/abc"); rr.Code != http.StatusServiceUnavailable || json.Unmarshal(rr.Body.Bytes(), &env) != nil || env.Error.Code != "service_unavailable" {
+		t.Errorf("v2: code=%d body=%s", rr.Code, rr.Body.String())
+	}
+	for _, path := range []string{"/livez", "/healthz"} {
+		if rr := get(path); rr.Code != http.StatusOK {
+			t.Errorf("%s while draining: code=%d", path, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rpattn/raalisence/internal/middleware"
+	"github.com/rpattn/raalisence/internal/openapi"
+)
+
+const (
+	v1Prefix = "/api/v1/"
+	v2Prefix = "/api/v2/"
+)
+
+// v2Pattern returns the /api/v2 spelling of a RESTful route pattern.
+func v2Pattern(pattern string) string {
+	return strings.Replace(pattern, v1Prefix, v2Prefix, 1)
+}
+
+// v2Routes mirrors apiRoutes under /api/v2 for that version's spec.
+func v2Routes(routes []openapi.Route) []openapi.Route {
+	out := make([]openapi.Route, len(routes))
+	for i, rt := range routes {
+		rt.Path = v2Pattern(rt.Path)
+		out[i] = rt
+	}
+	return out
+}
+
+// v1 announces the deprecation of /api/v1 once api.v1_deprecated is set:
+// Deprecation, Sunset when a date is configured, and (with successor) a Link
+// to the same path under /api/v2.
+func (s *Server) v1(h http.Handler, successor bool) http.Handler {
+	if !s.cfg.API.V1Deprecated {
+		return h
+	}
+	sunset, _ := s.cfg.V1Sunset() // checked by config.Load
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if !sunset.IsZero() {
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		if successor {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, v2Prefix+strings.TrimPrefix(r.URL.Path, v1Prefix)))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// APIError is the body of every /api/v2 error response, wrapped as
+// {"error": {...}}. Code is the status text in snake_case (e.g.
+// "not_found"); Details carries a handler's JSON error body, if it had one.
+type APIError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"request_id,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// errorEnvelope rewrites error responses under /api/v2 into APIError
+// envelopes. Handlers keep writing plain http.Error text (or their own JSON),
+// so both versions share them; successful responses pass through untouched.
+func errorEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, v2Prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.buf != nil {
+			ew.finish(r)
+		}
+	})
+}
+
+// envelopeWriter holds back the body of an error response so finish can
+// re-encode it.
+type envelopeWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+	buf         *bytes.Buffer // set once an error status is written
+}
+
+func (w *envelopeWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code >= 400 {
+		w.status, w.buf = code, &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *envelopeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// streaming responses.
+func (w *envelopeWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *envelopeWriter) finish(r *http.Request) {
+	body := bytes.TrimSpace(w.buf.Bytes())
+	e := APIError{
+		Code:      strings.ReplaceAll(strings.ToLower(http.StatusText(w.status)), " ", "_"),
+		Message:   string(body),
+		RequestID: middleware.GetRequestID(r),
+	}
+	h := w.Header()
+	if strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		e.Message = http.StatusText(w.status)
+		if json.Unmarshal(body, &e.Details) == nil {
+			if reason, ok := e.Details["reason"].(string); ok && reason != "" {
+				e.Message = reason
+			}
+		}
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	_ = json.NewEncoder(w.ResponseWriter).Encode(map[string]APIError{"error": e})
+}
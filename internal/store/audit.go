@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit says who makes the changes made through a context, for the
+// audit_log rows LicenseStore writes in the same transaction as them.
+type Audit struct {
+	Actor     string // "unknown" when empty
+	RequestID string
+	RemoteIP  string
+	// Action names the change, e.g. license.import for an Insert; each
+	// mutation has a default.
+	Action  string
+	Details map[string]any
+	// Diff returns the field changes between the license before and after
+	// the change, either nil when there is no license. Without it no
+	// changes are recorded.
+	Diff func(before, after *License) map[string]any
+}
+
+type auditKey struct{}
+
+// WithAudit returns ctx carrying a for the changes made with it.
+func WithAudit(ctx context.Context, a Audit) context.Context {
+	return context.WithValue(ctx, auditKey{}, a)
+}
+
+// AuditFrom returns the Audit ctx carries, if any.
+func AuditFrom(ctx context.Context) Audit {
+	a, _ := ctx.Value(auditKey{}).(Audit)
+	return a
+}
+
+// AuditEntry is one audit_log row.
+type AuditEntry struct {
+	Actor      string
+	Action     string
+	Entity     string // what was changed, e.g. license:<key> or webhook:<id>
+	LicenseKey string
+	Details    map[string]any
+	Changes    any // {"field":{"before":..,"after":..}}
+	RequestID  string
+	RemoteIP   string
+}
+
+// LicenseEntity is the audit_log entity of a license.
+func LicenseEntity(licenseKey string) string { return "license:" + licenseKey }
+
+// WriteAudit adds e to audit_log through q, so a transaction can commit
+// it with the change it describes.
+func WriteAudit(ctx context.Context, q Querier, d Dialect, e AuditEntry) error {
+	if e.Actor == "" {
+		e.Actor = "unknown"
+	}
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	if e.Changes == nil {
+		e.Changes = map[string]any{}
+	}
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `insert into audit_log (id, actor, action, entity, license_key, details, request_id, remote_ip, changes, created_at) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		uuid.NewString(), e.Actor, e.Action, e.Entity, e.LicenseKey, string(details), e.RequestID, e.RemoteIP, string(changes), d.Time(time.Now()))
+	return err
+}
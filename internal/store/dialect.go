@@ -0,0 +1,135 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Dialect is how a driver stores times and JSON, and spells the operators
+// that differ, for the queries handlers still write themselves.
+type Dialect interface {
+	// Driver is the db.driver the dialect is for.
+	Driver() string
+	// Time converts t into a query argument for a timestamp column.
+	Time(t time.Time) any
+	// CursorTime formats t for a list cursor, which has to compare equal
+	// to the stored column value.
+	CursorTime(t time.Time) string
+	// JSONCast is appended to the placeholder for a JSON column.
+	JSONCast() string
+	// Like is the case-insensitive LIKE operator.
+	Like() string
+}
+
+// SQLiteTimeLayout is RFC3339 with fixed-width nanoseconds so TEXT values
+// compare correctly with < and > in SQL.
+const SQLiteTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// mysqlTimeLayout is a DATETIME(6) literal, which MySQL compares exactly;
+// it doesn't take RFC3339's "T" and "Z" everywhere.
+const mysqlTimeLayout = "2006-01-02 15:04:05.999999"
+
+// DialectFor returns the Dialect of driver: sqlite3, mysql, cockroach, or
+// anything else for Postgres.
+func DialectFor(driver string) Dialect {
+	switch driver {
+	case "sqlite3":
+		return sqliteDialect{}
+	case "mysql":
+		return mysqlDialect{}
+	case "cockroach":
+		return cockroachDialect{}
+	}
+	return postgresDialect{}
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Driver() string                { return "pgx" }
+func (postgresDialect) Time(t time.Time) any          { return t.UTC() }
+func (postgresDialect) CursorTime(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+func (postgresDialect) JSONCast() string              { return "::jsonb" }
+func (postgresDialect) Like() string                  { return "ilike" }
+
+// cockroachDialect is CockroachDB's, which reads Postgres's SQL through the
+// same pgx driver but runs every transaction serializable; see RetryTx.
+type cockroachDialect struct{ postgresDialect }
+
+func (cockroachDialect) Driver() string { return "cockroach" }
+
+// sqliteDialect stores times and JSON as TEXT.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Driver() string                { return "sqlite3" }
+func (sqliteDialect) Time(t time.Time) any          { return t.UTC().Format(SQLiteTimeLayout) }
+func (sqliteDialect) CursorTime(t time.Time) string { return t.UTC().Format(SQLiteTimeLayout) }
+func (sqliteDialect) JSONCast() string              { return "" }
+
+// Like is already case-insensitive for ASCII in SQLite.
+func (sqliteDialect) Like() string { return "like" }
+
+// mysqlDialect covers MySQL and MariaDB, whose connections are set up by
+// package mysql to read the Postgres-style queries.
+type mysqlDialect struct{}
+
+func (mysqlDialect) Driver() string                { return "mysql" }
+func (mysqlDialect) Time(t time.Time) any          { return t.UTC() }
+func (mysqlDialect) CursorTime(t time.Time) string { return t.UTC().Format(mysqlTimeLayout) }
+func (mysqlDialect) JSONCast() string              { return "" }
+
+// Like overrides the tables' utf8mb4_bin collation.
+func (mysqlDialect) Like() string { return "collate utf8mb4_general_ci like" }
+
+// ParseTime parses a timestamp stored as TEXT: RFC3339Nano, falling back to
+// RFC3339 and SQLite's datetime('now') layout.
+func ParseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t, nil
+	}
+	if t, err2 := time.Parse(time.RFC3339, s); err2 == nil {
+		return t, nil
+	}
+	if t, err2 := time.Parse("2006-01-02 15:04:05", s); err2 == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, err
+}
+
+// NullTime scans a timestamp column from any driver: Postgres and MySQL
+// yield time.Time, SQLite yields TEXT in one of the layouts ParseTime
+// accepts.
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (n *NullTime) Scan(v any) error {
+	switch x := v.(type) {
+	case nil:
+		n.Time, n.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		n.Time, n.Valid = x.UTC(), true
+		return nil
+	case string:
+		t, err := ParseTime(x)
+		if err != nil {
+			return err
+		}
+		n.Time, n.Valid = t.UTC(), true
+		return nil
+	case []byte:
+		return n.Scan(string(x))
+	default:
+		return fmt.Errorf("NullTime: unsupported type %T", v)
+	}
+}
+
+// Ptr returns nil for NULL, for optional fields.
+func (n NullTime) Ptr() *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Time
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	pgmigrate "github.com/rpattn/raalisence/internal/db/migrations"
+	mysqlmigrate "github.com/rpattn/raalisence/internal/db/migrations_mysql"
+	migrate "github.com/rpattn/raalisence/internal/db/migrations_sqlite"
+)
+
+// MigrationStatus compares driver's schema_migrations in db with the
+// migrations built into the binary for it: pending are built in but not
+// applied, oldest first; unknown are applied but not built in, so applied
+// by a newer binary.
+func MigrationStatus(ctx context.Context, db *sql.DB, driver string) (pending, unknown []string, err error) {
+	switch driver {
+	case "sqlite3":
+		return migrate.StatusSQLite(ctx, db)
+	case "mysql":
+		return mysqlmigrate.StatusMySQL(ctx, db)
+	}
+	return pgmigrate.StatusPostgres(ctx, db)
+}
+
+// MigrationVersion returns the latest migration recorded in
+// schema_migrations, or "" when there is none or it can't be read.
+func MigrationVersion(ctx context.Context, q Querier) string {
+	var v sql.NullString
+	if err := q.QueryRowContext(ctx, `select max(version) from schema_migrations`).Scan(&v); err != nil {
+		return ""
+	}
+	return v.String
+}
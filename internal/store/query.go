@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EscapeLike escapes LIKE wildcards so user input matches literally, with
+// escape '\'.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// where accumulates conditions with numbered placeholders.
+type where struct {
+	conds []string
+	args  []any
+}
+
+// add appends cond, with each %d replaced by the placeholder number of v.
+func (w *where) add(cond string, v any) {
+	w.args = append(w.args, v)
+	w.conds = append(w.conds, strings.ReplaceAll(cond, "%d", fmt.Sprint(len(w.args))))
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(w.conds, " and ")
+}
+
+func (s *sqlStore) Find(ctx context.Context, f LicenseFilter) ([]License, error) {
+	var w where
+	if f.Customer != "" {
+		w.add("customer=$%d", f.Customer)
+	}
+	if f.CustomerLike != "" {
+		w.add(`customer `+s.Like()+` $%d escape '\'`, "%"+EscapeLike(f.CustomerLike)+"%")
+	}
+	if f.ProductID != "" {
+		w.add("product_id=$%d", f.ProductID)
+	}
+	if f.MachineID != "" {
+		w.add("(machine_id=$%d or exists (select 1 from activations a where a.license_key=licenses.license_key and a.machine_id=$%d))", f.MachineID)
+	}
+	if f.ActivatedOn != "" {
+		w.add("exists (select 1 from activations a where a.license_key=licenses.license_key and a.machine_id=$%d)", f.ActivatedOn)
+	}
+	if f.Revoked != nil {
+		w.add("revoked=$%d", *f.Revoked)
+	}
+	if !f.IncludeArchived {
+		w.conds = append(w.conds, "archived_at is null")
+	}
+	query := `select ` + LicenseColumns + ` from licenses` + w.String() + ` order by created_at desc, id desc`
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" limit %d", f.Limit)
+	}
+	rows, err := s.q.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []License{}
+	for rows.Next() {
+		l, err := ScanLicense(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) Each(ctx context.Context, fn func(License) error) error {
+	rows, err := s.q.QueryContext(ctx, `select `+LicenseColumns+` from licenses order by created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l, err := ScanLicense(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Stats(ctx context.Context, now time.Time) (Stats, error) {
+	const query = `select
+		coalesce(sum(case when archived_at is null then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is null and revoked=false and suspended=false and expires_at >= $1 then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is null and revoked=true then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is null and revoked=false and suspended=true then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is null and revoked=false and expires_at < $1 then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is null and revoked=false and expires_at >= $1 and expires_at < $2 then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is null and last_seen_at >= $3 then 1 else 0 end), 0),
+		coalesce(sum(case when archived_at is not null then 1 else 0 end), 0)
+		from licenses`
+	var st Stats
+	err := s.q.QueryRowContext(ctx, query, s.Time(now), s.Time(now.Add(StatsExpiringWithin)), s.Time(now.Add(-StatsSeenWithin))).
+		Scan(&st.Total, &st.Active, &st.Revoked, &st.Suspended, &st.Expired, &st.ExpiringSoon, &st.SeenRecently, &st.Archived)
+	return st, err
+}
+
+func (s *sqlStore) Customers(ctx context.Context, search string, limit int) ([]Customer, error) {
+	var w where
+	if search != "" {
+		w.add(`customer `+s.Like()+` $%d escape '\'`, "%"+EscapeLike(search)+"%")
+	}
+	rows, err := s.q.QueryContext(ctx, `select customer, count(*) from licenses`+w.String()+
+		fmt.Sprintf(" group by customer order by customer limit %d", limit), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Customer{}
+	for rows.Next() {
+		var c Customer
+		if err := rows.Scan(&c.Name, &c.LicenseCount); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) CustomerLicenses(ctx context.Context, customer string) (int, error) {
+	var n int
+	err := s.q.QueryRowContext(ctx, `select count(*) from licenses where customer=$1`, customer).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TxRetries is how many times RetryTx reruns a transaction that lost a
+// serialization conflict before giving up.
+const TxRetries = 5
+
+// IsSerializationFailure reports whether err, or one it wraps, is SQLSTATE
+// 40001: the transaction conflicted with another and has to be rerun from
+// the start. CockroachDB raises it under contention on every isolation
+// level; Postgres only under SERIALIZABLE or REPEATABLE READ.
+func IsSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
+// RetryTx runs fn in a transaction of db and commits it, rerunning both on
+// a fresh transaction while they fail with a serialization failure, at most
+// TxRetries more times. fn must not have effects outside the transaction
+// that a rerun would repeat.
+func RetryTx(ctx context.Context, db interface {
+	BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+}, fn func(*sql.Tx) error) error {
+	for i := 0; ; i++ {
+		err := runTx(ctx, db, fn)
+		if !IsSerializationFailure(err) || i == TxRetries {
+			return err
+		}
+		if err := RetryBackoff(ctx, i); err != nil {
+			return err
+		}
+	}
+}
+
+func runTx(ctx context.Context, db interface {
+	BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+}, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RetryBackoff waits before rerun attempt+1 of a transaction: a jittered
+// 10ms doubling each time, so the transactions that conflicted don't
+// collide again in lockstep. It returns early with ctx's error.
+func RetryBackoff(ctx context.Context, attempt int) error {
+	d := 10 * time.Millisecond << attempt
+	t := time.NewTimer(d/2 + time.Duration(rand.Int63n(int64(d))))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,370 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sqlStore is the LicenseStore of the SQL drivers; its Dialect covers what
+// differs between them.
+type sqlStore struct {
+	q Querier
+	Dialect
+}
+
+func (s *sqlStore) Get(ctx context.Context, licenseKey string, forUpdate bool) (License, error) {
+	query := `select ` + LicenseColumns + ` from licenses where license_key=$1`
+	if forUpdate && s.Driver() != "sqlite3" {
+		query += " for update"
+	}
+	return ScanLicense(s.q.QueryRowContext(ctx, query, licenseKey))
+}
+
+func (s *sqlStore) Insert(ctx context.Context, l License) error {
+	return s.audited(ctx, "license.issue", l.LicenseKey, false, func(s *sqlStore) error { return s.insert(ctx, l) })
+}
+
+func (s *sqlStore) insert(ctx context.Context, l License) error {
+	const insert = `insert into licenses (id, license_key, customer, machine_id, features, expires_at, revoked, last_seen_at, max_activations, floating_seats, grace_days, entitlements, product_id, metadata, notes, features_recipient_key, require_nonce, tenant, signing_key_id, created_at, updated_at)
+		values ($1,$2,$3,$4,$5,$6,false,null,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$18)`
+	features, err := json.Marshal(l.Features)
+	if err != nil {
+		return err
+	}
+	ents, err := json.Marshal(l.Entitlements)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(l.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.q.ExecContext(ctx, insert, l.ID, l.LicenseKey, l.Customer, l.MachineID, string(features), s.Time(l.ExpiresAt),
+		l.MaxActivations, l.FloatingSeats, l.GraceDays, string(ents), l.ProductID, string(meta), l.Notes, l.FeaturesRecipientKey, l.RequireNonce, l.Tenant, l.SigningKeyID, s.Time(time.Now()))
+	return err
+}
+
+func (s *sqlStore) Update(ctx context.Context, licenseKey string, u LicenseUpdate) error {
+	return s.audited(ctx, "license.update", licenseKey, true, func(s *sqlStore) error { return s.update(ctx, licenseKey, u) })
+}
+
+func (s *sqlStore) update(ctx context.Context, licenseKey string, u LicenseUpdate) error {
+	var sets []string
+	var args []any
+	set := func(column string, v any) {
+		args = append(args, v)
+		sets = append(sets, fmt.Sprintf("%s=$%d", column, len(args)))
+	}
+	setJSON := func(column string, v any) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", column, err)
+		}
+		set(column, string(b))
+		sets[len(sets)-1] += s.JSONCast()
+		return nil
+	}
+	if u.ExpiresAt != nil {
+		set("expires_at", s.Time(*u.ExpiresAt))
+		sets = append(sets, "expiry_notified_at=null")
+	}
+	if u.Features != nil {
+		if err := setJSON("features", u.Features); err != nil {
+			return err
+		}
+	}
+	if u.MaxActivations != nil {
+		set("max_activations", *u.MaxActivations)
+	}
+	if u.FloatingSeats != nil {
+		set("floating_seats", *u.FloatingSeats)
+	}
+	if u.GraceDays != nil {
+		set("grace_days", *u.GraceDays)
+	}
+	if u.Entitlements != nil {
+		if err := setJSON("entitlements", u.Entitlements); err != nil {
+			return err
+		}
+	}
+	if u.Metadata != nil {
+		if err := setJSON("metadata", u.Metadata); err != nil {
+			return err
+		}
+	}
+	if u.Notes != nil {
+		set("notes", *u.Notes)
+	}
+	if u.RequireNonce != nil {
+		set("require_nonce", *u.RequireNonce)
+	}
+	set("updated_at", s.Time(time.Now()))
+	args = append(args, licenseKey)
+	return s.exec(ctx, fmt.Sprintf("update licenses set %s where license_key=$%d", strings.Join(sets, ", "), len(args)), args...)
+}
+
+func (s *sqlStore) Revoke(ctx context.Context, licenseKey string) error {
+	return s.audited(ctx, "license.revoke", licenseKey, true, func(s *sqlStore) error {
+		return s.exec(ctx, `update licenses set revoked=true, updated_at=$1 where license_key=$2`, s.Time(time.Now()), licenseKey)
+	})
+}
+
+func (s *sqlStore) Touch(ctx context.Context, licenseKey string, t time.Time) error {
+	return s.exec(ctx, `update licenses set last_seen_at=$1, updated_at=$2 where license_key=$3`, s.Time(t), s.Time(time.Now()), licenseKey)
+}
+
+func (s *sqlStore) SetSuspended(ctx context.Context, licenseKey string, suspended bool) error {
+	action := "license.resume"
+	if suspended {
+		action = "license.suspend"
+	}
+	return s.audited(ctx, action, licenseKey, true, func(s *sqlStore) error {
+		return s.exec(ctx, `update licenses set suspended=$1, updated_at=$2 where license_key=$3`, suspended, s.Time(time.Now()), licenseKey)
+	})
+}
+
+func (s *sqlStore) Archive(ctx context.Context, licenseKey string) error {
+	return s.audited(ctx, "license.archive", licenseKey, true, func(s *sqlStore) error {
+		if err := s.exec(ctx, `update licenses set archived_at=$1, updated_at=$1 where license_key=$2 and archived_at is null`, s.Time(time.Now()), licenseKey); err != nil {
+			return err
+		}
+		// archived licenses hold no floating seats
+		_, err := s.q.ExecContext(ctx, `delete from sessions where license_key=$1`, licenseKey)
+		return err
+	})
+}
+
+func (s *sqlStore) Restore(ctx context.Context, licenseKey string) error {
+	return s.audited(ctx, "license.restore", licenseKey, true, func(s *sqlStore) error {
+		return s.exec(ctx, `update licenses set archived_at=null, updated_at=$1 where license_key=$2 and archived_at is not null`, s.Time(time.Now()), licenseKey)
+	})
+}
+
+func (s *sqlStore) Transfer(ctx context.Context, licenseKey, from, to, reason string) error {
+	return s.audited(ctx, "license.transfer", licenseKey, true, func(s *sqlStore) error {
+		now := s.Time(time.Now())
+		for _, step := range []struct {
+			query string
+			args  []any
+		}{
+			{`delete from activations where license_key=$1 and machine_id=$2`, []any{licenseKey, from}},
+			{`delete from sessions where license_key=$1 and machine_id=$2`, []any{licenseKey, from}},
+			{`update licenses set machine_id=$1, updated_at=$2 where license_key=$3`, []any{to, now, licenseKey}},
+			{`insert into license_transfers (id, license_key, from_machine_id, to_machine_id, reason, transferred_at) values ($1,$2,$3,$4,$5,$6)`,
+				[]any{uuid.NewString(), licenseKey, from, to, reason, now}},
+		} {
+			if _, err := s.q.ExecContext(ctx, step.query, step.args...); err != nil {
+				return err
+			}
+		}
+		var n int
+		if err := s.q.QueryRowContext(ctx, `select count(*) from activations where license_key=$1 and machine_id=$2`, licenseKey, to).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		_, err := s.q.ExecContext(ctx, `insert into activations (id, license_key, machine_id, activated_at) values ($1,$2,$3,$4)`,
+			uuid.NewString(), licenseKey, to, now)
+		return err
+	})
+}
+
+func (s *sqlStore) SetSigningKey(ctx context.Context, licenseKey, keyID string) error {
+	return s.audited(ctx, "license.reissue", licenseKey, true, func(s *sqlStore) error {
+		return s.exec(ctx, `update licenses set signing_key_id=$1 where license_key=$2`, keyID, licenseKey)
+	})
+}
+
+func (s *sqlStore) SetRequestSecret(ctx context.Context, licenseKey, secret string) error {
+	action := "license.request_secret"
+	if secret == "" {
+		action = "license.request_secret_clear"
+	}
+	return s.audited(ctx, action, licenseKey, true, func(s *sqlStore) error {
+		return s.exec(ctx, `update licenses set request_secret=$1, updated_at=$2 where license_key=$3`, secret, s.Time(time.Now()), licenseKey)
+	})
+}
+
+// licenseTables are the tables referencing licenses by license_key, which
+// Delete clears first; SQLite doesn't enforce the foreign keys.
+var licenseTables = []string{"sessions", "activations", "license_transfers", "usage_records", "validation_events", "validation_nonces", "license_history", "idempotency_keys"}
+
+func (s *sqlStore) Delete(ctx context.Context, licenseKey string) error {
+	return s.audited(ctx, "license.delete", licenseKey, true, func(s *sqlStore) error {
+		for _, table := range licenseTables {
+			if _, err := s.q.ExecContext(ctx, `delete from `+table+` where license_key=$1`, licenseKey); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		}
+		// machines outlive their licenses, but not the reference
+		if _, err := s.q.ExecContext(ctx, `update machines set last_license_key='' where last_license_key=$1`, licenseKey); err != nil {
+			return fmt.Errorf("machines: %w", err)
+		}
+		return s.exec(ctx, `delete from licenses where license_key=$1`, licenseKey)
+	})
+}
+
+func (s *sqlStore) MachineHolders(ctx context.Context, customer, machineID, exceptKey string) ([]string, error) {
+	query := `select license_key from licenses where customer=$1 and machine_id=$2 and license_key <> $3 and revoked=false and archived_at is null`
+	if s.Driver() != "sqlite3" {
+		query += " for update"
+	}
+	rows, err := s.q.QueryContext(ctx, query, customer, machineID, exceptKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlStore) ClaimMachine(ctx context.Context, licenseKey string) error {
+	return s.exec(ctx, `update licenses set machine_unique=true where license_key=$1`, licenseKey)
+}
+
+func (s *sqlStore) MarkNotified(ctx context.Context, licenseKey, column string, t time.Time) error {
+	switch column {
+	case "expiry_notified_at", "stale_notified_at":
+	default:
+		return fmt.Errorf("MarkNotified: unknown column %q", column)
+	}
+	_, err := s.q.ExecContext(ctx, `update licenses set `+column+`=$1 where license_key=$2`, s.Time(t), licenseKey)
+	return err
+}
+
+// audited makes change, a change to the license licenseKey, in one
+// transaction with its audit_log row, described by the Audit ctx carries
+// (action unless that names its own), and with a license_history row when
+// the Audit's Diff finds fields changed, unless change deleted it. Given
+// existing, the license is read and locked first, and sql.ErrNoRows
+// reported if there is none.
+// Working through a transaction already, it is the caller's to commit (and
+// to rerun on a serialization failure); otherwise RetryTx does both.
+func (s *sqlStore) audited(ctx context.Context, action, licenseKey string, existing bool, change func(*sqlStore) error) error {
+	if db, ok := s.q.(interface {
+		BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+	}); ok {
+		return RetryTx(ctx, db, func(tx *sql.Tx) error {
+			return (&sqlStore{q: tx, Dialect: s.Dialect}).audited(ctx, action, licenseKey, existing, change)
+		})
+	}
+
+	a := AuditFrom(ctx)
+	if a.Action != "" {
+		action = a.Action
+	}
+	var before *License
+	if existing {
+		l, err := s.Get(ctx, licenseKey, true)
+		if err != nil {
+			return err
+		}
+		before = &l
+	}
+	if err := change(s); err != nil {
+		return err
+	}
+	var after *License
+	switch l, err := s.Get(ctx, licenseKey, false); {
+	case err == nil:
+		after = &l
+	case !errors.Is(err, sql.ErrNoRows) || before == nil:
+		return err
+	}
+	var changes map[string]any
+	if a.Diff != nil {
+		changes = a.Diff(before, after)
+	}
+	// a deleted license's history went with it; the audit entry keeps its
+	// last state
+	if len(changes) > 0 && after != nil {
+		b, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		actor := a.Actor
+		if actor == "" {
+			actor = "unknown"
+		}
+		if _, err := s.q.ExecContext(ctx, `insert into license_history (id, license_key, action, actor, changes, request_id, created_at) values ($1,$2,$3,$4,$5,$6,$7)`,
+			uuid.NewString(), licenseKey, action, actor, string(b), a.RequestID, s.Time(time.Now())); err != nil {
+			return fmt.Errorf("license_history: %w", err)
+		}
+	}
+	e := AuditEntry{
+		Actor: a.Actor, Action: action, Entity: LicenseEntity(licenseKey), LicenseKey: licenseKey,
+		Details: a.Details, RequestID: a.RequestID, RemoteIP: a.RemoteIP,
+	}
+	if changes != nil {
+		e.Changes = changes
+	}
+	if err := WriteAudit(ctx, s.q, s.Dialect, e); err != nil {
+		return fmt.Errorf("audit_log: %w", err)
+	}
+	return nil
+}
+
+// exec runs an update of one license, reporting sql.ErrNoRows when there
+// is no such license.
+func (s *sqlStore) exec(ctx context.Context, query string, args ...any) error {
+	res, err := s.q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ScanLicense reads LicenseColumns, then any extra columns into extra,
+// from a query of the caller's. It takes the rows of every driver.
+func ScanLicense(sc Scanner, extra ...any) (License, error) {
+	var l License
+	var features, ents, meta []byte
+	var expires, lastSeen, archivedAt NullTime
+	var graceDays sql.NullInt64
+	dest := append([]any{&l.ID, &l.LicenseKey, &l.ProductID, &l.Customer, &l.MachineID, &features, &expires, &l.Revoked, &l.Suspended, &lastSeen,
+		&l.MaxActivations, &l.FloatingSeats, &graceDays, &ents, &meta, &l.Notes, &archivedAt, &l.Tenant, &l.SigningKeyID, &l.FeaturesRecipientKey, &l.RequireNonce}, extra...)
+	if err := sc.Scan(dest...); err != nil {
+		return l, err
+	}
+	l.ExpiresAt = expires.Time
+	l.LastSeenAt = lastSeen.Ptr()
+	l.ArchivedAt = archivedAt.Ptr()
+	if graceDays.Valid {
+		g := int(graceDays.Int64)
+		l.GraceDays = &g
+	}
+	if len(features) > 0 {
+		if err := json.Unmarshal(features, &l.Features); err != nil {
+			return l, fmt.Errorf("bad features: %w", err)
+		}
+	}
+	if len(ents) > 0 {
+		if err := json.Unmarshal(ents, &l.Entitlements); err != nil {
+			return l, fmt.Errorf("bad entitlements: %w", err)
+		}
+	}
+	if len(meta) > 0 {
+		if err := json.Unmarshal(meta, &l.Metadata); err != nil {
+			return l, fmt.Errorf("bad metadata: %w", err)
+		}
+	}
+	return l, nil
+}
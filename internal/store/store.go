@@ -0,0 +1,213 @@
+// Package store keeps the licenses table, and the statements on other
+// tables that differ between drivers, behind an interface. Handlers deal in
+// Go values; each driver's implementation decides how times and JSON are
+// stored and spells the statements that differ.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/entitlements"
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Scanner is a *sql.Row or *sql.Rows.
+type Scanner interface {
+	Scan(dest ...any) error
+}
+
+// License is a row of licenses.
+type License struct {
+	ID         string
+	LicenseKey string
+	ProductID  string
+	Tenant     string
+	Customer   string
+	MachineID  string
+	ExpiresAt  time.Time
+	Revoked    bool
+	Suspended  bool
+	LastSeenAt *time.Time
+	ArchivedAt *time.Time
+
+	MaxActivations int
+	FloatingSeats  int
+	GraceDays      *int // nil falls back to licensing.grace_days
+
+	Features     map[string]any
+	Entitlements entitlements.Set
+	Metadata     map[string]any
+	Notes        string
+
+	FeaturesRecipientKey string
+	RequireNonce         bool
+	SigningKeyID         string // fingerprint of the key it was last signed with
+}
+
+// LicenseUpdate changes the fields of a license that are set.
+type LicenseUpdate struct {
+	ExpiresAt      *time.Time // also clears expiry_notified_at
+	Features       map[string]any
+	MaxActivations *int
+	FloatingSeats  *int
+	GraceDays      *int
+	Entitlements   entitlements.Set
+	Metadata       map[string]any
+	Notes          *string
+	RequireNonce   *bool
+}
+
+// Empty reports whether u changes nothing.
+func (u LicenseUpdate) Empty() bool {
+	return u.ExpiresAt == nil && u.Features == nil && u.MaxActivations == nil && u.FloatingSeats == nil && u.GraceDays == nil &&
+		u.Entitlements == nil && u.Metadata == nil && u.Notes == nil && u.RequireNonce == nil
+}
+
+// LicenseStore reads and writes licenses. Missing licenses are reported as
+// sql.ErrNoRows. The admin changes (all but Touch, MachineHolders and
+// ClaimMachine) write an audit_log row in the same transaction as the
+// change, from the Audit of their context (see WithAudit); heartbeats are
+// not audited.
+type LicenseStore interface {
+	Dialect
+
+	// Get returns the license with licenseKey. forUpdate locks the row
+	// where the driver can (SQLite serialises writers already) and must
+	// only be used inside a transaction.
+	Get(ctx context.Context, licenseKey string, forUpdate bool) (License, error)
+	// Insert stores a new license, setting created_at and updated_at.
+	Insert(ctx context.Context, l License) error
+	Update(ctx context.Context, licenseKey string, u LicenseUpdate) error
+	Revoke(ctx context.Context, licenseKey string) error
+	// Touch records a heartbeat at t.
+	Touch(ctx context.Context, licenseKey string, t time.Time) error
+	SetSuspended(ctx context.Context, licenseKey string, suspended bool) error
+	// Archive hides a license and frees its floating seats; Restore brings
+	// it back. Either reports sql.ErrNoRows when the license is missing or
+	// already in that state.
+	Archive(ctx context.Context, licenseKey string) error
+	Restore(ctx context.Context, licenseKey string) error
+	// Transfer rebinds a license from machine from to machine to: from's
+	// activation and seats go, to is activated and the move is recorded in
+	// license_transfers.
+	Transfer(ctx context.Context, licenseKey, from, to, reason string) error
+	// SetSigningKey records the fingerprint of the key a license was last
+	// signed with.
+	SetSigningKey(ctx context.Context, licenseKey, keyID string) error
+	// SetRequestSecret sets the secret a license's client requests are
+	// signed with; "" clears it.
+	SetRequestSecret(ctx context.Context, licenseKey, secret string) error
+	// Delete removes a license and every row referencing it.
+	Delete(ctx context.Context, licenseKey string) error
+
+	// MachineHolders locks and returns the customer's licenses for
+	// machineID other than exceptKey that are neither revoked nor archived.
+	MachineHolders(ctx context.Context, customer, machineID, exceptKey string) ([]string, error)
+	// ClaimMachine makes licenseKey the customer's license for its machine,
+	// which idx_licenses_machine_unique keeps to one.
+	ClaimMachine(ctx context.Context, licenseKey string) error
+	// MarkNotified sets column, expiry_notified_at or stale_notified_at,
+	// so the event it tracks fires once.
+	MarkNotified(ctx context.Context, licenseKey, column string, t time.Time) error
+
+	// Find returns the licenses matching f, newest first.
+	Find(ctx context.Context, f LicenseFilter) ([]License, error)
+	// Each calls fn for every license, oldest first, stopping at its first
+	// error.
+	Each(ctx context.Context, fn func(License) error) error
+	// Stats counts licenses by state as of now.
+	Stats(ctx context.Context, now time.Time) (Stats, error)
+	// Customers lists customer names alphabetically with their license
+	// counts; search matches a substring, case-insensitively.
+	Customers(ctx context.Context, search string, limit int) ([]Customer, error)
+	// CustomerLicenses counts the licenses of customer.
+	CustomerLicenses(ctx context.Context, customer string) (int, error)
+}
+
+// Store is LicenseStore and the statements on other tables that differ
+// between drivers.
+type Store interface {
+	LicenseStore
+
+	// RecordMachine upserts m's machines row, marking it seen now.
+	RecordMachine(ctx context.Context, m MachineReport) error
+	// AddUsage adds amount to a license's metric for period and returns
+	// the new total.
+	AddUsage(ctx context.Context, licenseKey, metric, period string, amount int64) (int64, error)
+	// EnrollTOTP stores actor's encrypted TOTP secret, unconfirmed,
+	// replacing any earlier enrollment.
+	EnrollTOTP(ctx context.Context, actor, secretEnc string) error
+	// DeleteAdminSession deletes the session with tokenHash and returns it,
+	// or sql.ErrNoRows.
+	DeleteAdminSession(ctx context.Context, tokenHash string) (AdminSession, error)
+	// RecordAuthFailure counts a failed admin login from client, starting
+	// over when the last one is older than window, and returns the count.
+	// Clients that stopped failing and aren't locked out are forgotten.
+	RecordAuthFailure(ctx context.Context, client string, now time.Time, window time.Duration) (int, error)
+}
+
+// LicenseFilter selects licenses for Find. Empty fields match every
+// license.
+type LicenseFilter struct {
+	Customer        string // exact
+	CustomerLike    string // case-insensitive substring
+	ProductID       string
+	MachineID       string // bound to or activated on the machine
+	ActivatedOn     string // activated on the machine
+	Revoked         *bool
+	IncludeArchived bool
+	Limit           int
+}
+
+// Stats are license counts by state. Archived licenses are only counted
+// in Archived.
+type Stats struct {
+	Total, Active, Revoked, Suspended, Expired int
+	ExpiringSoon                               int // within StatsExpiringWithin
+	SeenRecently                               int // within StatsSeenWithin
+	Archived                                   int
+}
+
+// The windows of Stats.ExpiringSoon and Stats.SeenRecently.
+const (
+	StatsExpiringWithin = 30 * 24 * time.Hour
+	StatsSeenWithin     = 24 * time.Hour
+)
+
+// Customer is a customer name and its license count.
+type Customer struct {
+	Name         string
+	LicenseCount int
+}
+
+// MachineReport is what a client reported about its machine. Empty fields
+// keep what was stored before, and a nil Fingerprint the stored one.
+type MachineReport struct {
+	MachineID   string
+	LicenseKey  string // the license it was last seen under
+	Hostname    string
+	OS          string
+	Fingerprint map[string]string
+}
+
+// AdminSession is who an admin_sessions row was for.
+type AdminSession struct {
+	Subject, Email, Actor string
+}
+
+// LicenseColumns are the columns ScanLicense expects, in order.
+const LicenseColumns = `id, license_key, product_id, customer, machine_id, features, expires_at, revoked, suspended, last_seen_at, max_activations, floating_seats, grace_days, entitlements, metadata, notes, archived_at, tenant, signing_key_id, features_recipient_key, require_nonce`
+
+// New returns the Store for driver (sqlite3, mysql, or anything else for
+// Postgres) working through q.
+func New(q Querier, driver string) Store {
+	return &sqlStore{q: q, Dialect: DialectFor(driver)}
+}
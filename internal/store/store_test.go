@@ -0,0 +1,309 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/mattn/go-sqlite3"
+
+	migrate "github.com/rpattn/raalisence/internal/db/migrations_sqlite"
+	"github.com/rpattn/raalisence/internal/entitlements"
+)
+
+func testSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := migrate.EnsureSQLiteSchema(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestLicenseStoreSQLite(t *testing.T) {
+	ctx := context.Background()
+	s := New(testSQLiteDB(t), "sqlite3")
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+	grace := 3
+	err := s.Insert(ctx, License{
+		ID: "id-1", LicenseKey: "key-1", Customer: "acme", MachineID: "m1", ExpiresAt: expires,
+		MaxActivations: 2, GraceDays: &grace,
+		Features:     map[string]any{"pro": true},
+		Entitlements: entitlements.Set{},
+		Metadata:     map[string]any{"crm": "42"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := s.Get(ctx, "key-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.ExpiresAt.Equal(expires) || l.MaxActivations != 2 || l.GraceDays == nil || *l.GraceDays != 3 ||
+		l.Features["pro"] != true || l.Metadata["crm"] != "42" || l.LastSeenAt != nil || l.Revoked {
+		t.Fatalf("get = %+v", l)
+	}
+
+	later := expires.AddDate(1, 0, 0)
+	notes := "renewed"
+	if err := s.Update(ctx, "key-1", LicenseUpdate{ExpiresAt: &later, Notes: &notes, Features: map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Touch(ctx, "key-1", expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	l, err = s.Get(ctx, "key-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.ExpiresAt.Equal(later) || l.Notes != "renewed" || len(l.Features) != 0 || l.LastSeenAt == nil || !l.LastSeenAt.Equal(expires) || !l.Revoked {
+		t.Fatalf("after update = %+v", l)
+	}
+
+	if _, err := s.Get(ctx, "missing", false); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("get missing err = %v", err)
+	}
+	for name, err := range map[string]error{
+		"update": s.Update(ctx, "missing", LicenseUpdate{Notes: &notes}),
+		"revoke": s.Revoke(ctx, "missing"),
+		"touch":  s.Touch(ctx, "missing", expires),
+	} {
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s missing err = %v", name, err)
+		}
+	}
+}
+
+func TestSQLiteAudit(t *testing.T) {
+	ctx := context.Background()
+	db := testSQLiteDB(t)
+	s := New(db, "sqlite3")
+	diff := func(before, after *License) map[string]any {
+		if before == nil || before.Revoked == after.Revoked {
+			return nil
+		}
+		return map[string]any{"revoked": after.Revoked}
+	}
+	if err := s.Insert(ctx, License{ID: "id-1", LicenseKey: "key-1", ExpiresAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	actx := WithAudit(ctx, Audit{Actor: "key:abc", RequestID: "req-1", Details: map[string]any{"why": "fraud"}, Diff: diff})
+	if err := s.Revoke(actx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a change rolled back takes its audit entry with it
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := "x"
+	if err := New(tx, "sqlite3").Update(WithAudit(ctx, Audit{Action: "license.import"}), "key-1", LicenseUpdate{Notes: &notes}); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if err := s.Revoke(actx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("revoke missing err = %v", err)
+	}
+
+	rows, err := db.Query(`SELECT actor, action, entity, license_key, details, request_id, changes FROM audit_log ORDER BY created_at`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var actor, action, entity, key, details, requestID, changes string
+		if err := rows.Scan(&actor, &action, &entity, &key, &details, &requestID, &changes); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Join([]string{actor, action, entity, key, details, requestID, changes}, " "))
+	}
+	want := []string{
+		`unknown license.issue license:key-1 key-1 {}  {}`,
+		`key:abc license.revoke license:key-1 key-1 {"why":"fraud"} req-1 {"revoked":true}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("audit_log =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var history int
+	if err := db.QueryRow(`SELECT count(*) FROM license_history WHERE license_key='key-1' AND action='license.revoke'`).Scan(&history); err != nil || history != 1 {
+		t.Fatalf("license_history rows = %d, err = %v", history, err)
+	}
+}
+
+func TestLicenseLifecycleSQLite(t *testing.T) {
+	ctx := context.Background()
+	db := testSQLiteDB(t)
+	s := New(db, "sqlite3")
+	if err := s.Insert(ctx, License{ID: "id-1", LicenseKey: "key-1", Customer: "acme", MachineID: "m1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"suspend", s.SetSuspended(ctx, "key-1", true), nil},
+		{"resume", s.SetSuspended(ctx, "key-1", false), nil},
+		{"archive", s.Archive(ctx, "key-1"), nil},
+		{"archive again", s.Archive(ctx, "key-1"), sql.ErrNoRows},
+		{"restore", s.Restore(ctx, "key-1"), nil},
+		{"restore again", s.Restore(ctx, "key-1"), sql.ErrNoRows},
+		{"transfer", s.Transfer(ctx, "key-1", "m1", "m2", "new laptop"), nil},
+		{"request secret", s.SetRequestSecret(ctx, "key-1", "s3cret"), nil},
+		{"signing key", s.SetSigningKey(ctx, "key-1", "kid-1"), nil},
+		{"suspend missing", s.SetSuspended(ctx, "missing", true), sql.ErrNoRows},
+	} {
+		if !errors.Is(step.err, step.want) || (step.want == nil && step.err != nil) {
+			t.Fatalf("%s: err = %v, want %v", step.name, step.err, step.want)
+		}
+	}
+	l, err := s.Get(ctx, "key-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.MachineID != "m2" || l.Suspended || l.ArchivedAt != nil || l.SigningKeyID != "kid-1" {
+		t.Fatalf("license = %+v", l)
+	}
+	var activated int
+	if err := db.QueryRow(`SELECT count(*) FROM activations WHERE license_key='key-1' AND machine_id='m2'`).Scan(&activated); err != nil || activated != 1 {
+		t.Fatalf("m2 activations = %d, err = %v", activated, err)
+	}
+
+	if err := s.Delete(WithAudit(ctx, Audit{Diff: func(before, after *License) map[string]any {
+		if after != nil {
+			t.Errorf("after delete = %+v", after)
+		}
+		return map[string]any{"customer": before.Customer}
+	}}), "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"licenses", "activations", "license_transfers", "license_history"} {
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM ` + table + ` WHERE license_key='key-1'`).Scan(&n); err != nil || n != 0 {
+			t.Fatalf("%s rows = %d, err = %v", table, n, err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT action FROM audit_log WHERE license_key='key-1' ORDER BY created_at`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var actions []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, a)
+	}
+	want := "license.issue license.suspend license.resume license.archive license.restore license.transfer license.request_secret license.reissue license.delete"
+	if got := strings.Join(actions, " "); got != want {
+		t.Fatalf("audit actions = %s\nwant %s", got, want)
+	}
+}
+
+func TestLicenseUpdateEmpty(t *testing.T) {
+	if !(LicenseUpdate{}).Empty() {
+		t.Fatal("zero update not empty")
+	}
+	n := ""
+	if (LicenseUpdate{Notes: &n}).Empty() {
+		t.Fatal("update of notes is empty")
+	}
+}
+
+func TestSQLiteCanonicalTimes(t *testing.T) {
+	ctx := context.Background()
+	db := testSQLiteDB(t)
+	// rows as servers before 0032 left them
+	if _, err := db.Exec(`INSERT INTO licenses (id, license_key, customer, machine_id, expires_at, last_seen_at, created_at, updated_at)
+		VALUES ('id-1', 'key-1', 'acme', 'm1', '2030-01-02T05:04:05+02:00', '2025-06-07T08:09:10.5Z', '2025-01-01 00:00:00', '2025-01-01 00:00:00')`); err != nil {
+		t.Fatal(err)
+	}
+	body, err := os.ReadFile("../db/migrations_sqlite/0032_canonical_times.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(body)); err != nil {
+		t.Fatal(err)
+	}
+	var expires, lastSeen, created string
+	if err := db.QueryRow(`SELECT expires_at, last_seen_at, created_at FROM licenses`).Scan(&expires, &lastSeen, &created); err != nil {
+		t.Fatal(err)
+	}
+	if expires != "2030-01-02T03:04:05.000000000Z" || lastSeen != "2025-06-07T08:09:10.500000000Z" || created != "2025-01-01T00:00:00.000000000Z" {
+		t.Fatalf("expires=%s last_seen=%s created=%s", expires, lastSeen, created)
+	}
+
+	// the trigger keeps updated_at in the same layout
+	if _, err := db.Exec(`UPDATE licenses SET notes='x'`); err != nil {
+		t.Fatal(err)
+	}
+	var updated string
+	if err := db.QueryRow(`SELECT updated_at FROM licenses`).Scan(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(SQLiteTimeLayout, updated); err != nil || len(updated) != len("2006-01-02T15:04:05.000000000Z") {
+		t.Fatalf("updated_at = %q", updated)
+	}
+
+	l, err := New(db, "sqlite3").Get(ctx, "key-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.ExpiresAt.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("expires = %v", l.ExpiresAt)
+	}
+}
+
+func TestRetryTx(t *testing.T) {
+	ctx := context.Background()
+	db := testSQLiteDB(t)
+	conflict := fmt.Errorf("insert: %w", &pgconn.PgError{Code: "40001"})
+	runs := 0
+	err := RetryTx(ctx, db, func(tx *sql.Tx) error {
+		runs++
+		if _, err := tx.Exec(`INSERT INTO licenses (id, license_key, customer, machine_id, expires_at) VALUES ('id-1', 'key-1', 'acme', 'm1', '2030-01-01')`); err != nil {
+			return err
+		}
+		if runs < 3 {
+			return conflict
+		}
+		return nil
+	})
+	if err != nil || runs != 3 {
+		t.Fatalf("err = %v after %d runs", err, runs)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM licenses`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("licenses = %d, err = %v; rolled back attempts left rows", n, err)
+	}
+
+	runs = 0
+	if err := RetryTx(ctx, db, func(*sql.Tx) error { runs++; return conflict }); !IsSerializationFailure(err) || runs != TxRetries+1 {
+		t.Fatalf("err = %v after %d runs", err, runs)
+	}
+	runs = 0
+	if err := RetryTx(ctx, db, func(*sql.Tx) error { runs++; return sql.ErrNoRows }); err != sql.ErrNoRows || runs != 1 {
+		t.Fatalf("err = %v after %d runs", err, runs)
+	}
+}
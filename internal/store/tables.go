@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// The statements on tables other than licenses that differ between
+// drivers, mostly upserts: MySQL has no ON CONFLICT or RETURNING, and
+// names the row that would have been inserted values(col) rather than
+// excluded.col.
+
+func (s *sqlStore) RecordMachine(ctx context.Context, m MachineReport) error {
+	fingerprint := "{}"
+	if m.Fingerprint != nil {
+		b, err := json.Marshal(m.Fingerprint)
+		if err != nil {
+			return err
+		}
+		fingerprint = string(b)
+	}
+	upsert := `on conflict (machine_id) do update set
+			hostname = case when excluded.hostname <> '' then excluded.hostname else machines.hostname end,
+			os = case when excluded.os <> '' then excluded.os else machines.os end,
+			fingerprint = case when $7 then excluded.fingerprint else machines.fingerprint end,
+			last_seen_at = excluded.last_seen_at,
+			last_license_key = excluded.last_license_key`
+	if s.Driver() == "mysql" {
+		upsert = `on duplicate key update
+			hostname = case when values(hostname) <> '' then values(hostname) else hostname end,
+			os = case when values(os) <> '' then values(os) else os end,
+			fingerprint = case when $7 then values(fingerprint) else fingerprint end,
+			last_seen_at = values(last_seen_at),
+			last_license_key = values(last_license_key)`
+	}
+	_, err := s.q.ExecContext(ctx, `insert into machines (machine_id, hostname, os, fingerprint, first_seen_at, last_seen_at, last_license_key)
+		values ($1,$2,$3,$4,$5,$5,$6) `+upsert,
+		m.MachineID, m.Hostname, m.OS, fingerprint, s.Time(time.Now()), m.LicenseKey, m.Fingerprint != nil)
+	return err
+}
+
+func (s *sqlStore) AddUsage(ctx context.Context, licenseKey, metric, period string, amount int64) (int64, error) {
+	upsert := `on conflict (license_key, metric, period) do update set amount = usage_records.amount + excluded.amount, updated_at = excluded.updated_at`
+	if s.Driver() == "mysql" {
+		upsert = `on duplicate key update amount = amount + values(amount), updated_at = values(updated_at)`
+	}
+	if _, err := s.q.ExecContext(ctx, `insert into usage_records (license_key, metric, period, amount, updated_at) values ($1,$2,$3,$4,$5) `+upsert,
+		licenseKey, metric, period, amount, s.Time(time.Now())); err != nil {
+		return 0, err
+	}
+	var total int64
+	err := s.q.QueryRowContext(ctx, `select amount from usage_records where license_key=$1 and metric=$2 and period=$3`,
+		licenseKey, metric, period).Scan(&total)
+	return total, err
+}
+
+func (s *sqlStore) EnrollTOTP(ctx context.Context, actor, secretEnc string) error {
+	upsert := `on conflict (actor) do update set secret_enc=excluded.secret_enc, confirmed_at=null, last_step=0, created_at=excluded.created_at`
+	if s.Driver() == "mysql" {
+		upsert = `on duplicate key update secret_enc=values(secret_enc), confirmed_at=null, last_step=0, created_at=values(created_at)`
+	}
+	_, err := s.q.ExecContext(ctx, `insert into admin_totp (actor, secret_enc, confirmed_at, last_step, created_at) values ($1, $2, null, 0, $3) `+upsert,
+		actor, secretEnc, s.Time(time.Now()))
+	return err
+}
+
+func (s *sqlStore) DeleteAdminSession(ctx context.Context, tokenHash string) (AdminSession, error) {
+	var a AdminSession
+	if s.Driver() == "mysql" {
+		// no RETURNING; a concurrent delete may return the session twice
+		err := s.q.QueryRowContext(ctx, `select subject, email, actor from admin_sessions where token_hash=$1`, tokenHash).Scan(&a.Subject, &a.Email, &a.Actor)
+		if err != nil {
+			return a, err
+		}
+		_, err = s.q.ExecContext(ctx, `delete from admin_sessions where token_hash=$1`, tokenHash)
+		return a, err
+	}
+	err := s.q.QueryRowContext(ctx, `delete from admin_sessions where token_hash=$1 returning subject, email, actor`, tokenHash).Scan(&a.Subject, &a.Email, &a.Actor)
+	return a, err
+}
+
+func (s *sqlStore) RecordAuthFailure(ctx context.Context, client string, now time.Time, window time.Duration) (int, error) {
+	if _, err := s.q.ExecContext(ctx, `delete from auth_failures where last_failure_at < $1 and (locked_until is null or locked_until < $2)`,
+		s.Time(now.Add(-window)), s.Time(now)); err != nil {
+		return 0, err
+	}
+	var n int
+	if s.Driver() == "mysql" {
+		// failures is set first, so it still sees the old last_failure_at
+		if _, err := s.q.ExecContext(ctx, `insert into auth_failures (client, failures, last_failure_at) values ($1, 1, $2)
+			on duplicate key update
+				failures = case when last_failure_at < $3 then 1 else failures + 1 end,
+				last_failure_at = values(last_failure_at)`,
+			client, s.Time(now), s.Time(now.Add(-window))); err != nil {
+			return 0, err
+		}
+		err := s.q.QueryRowContext(ctx, `select failures from auth_failures where client=$1`, client).Scan(&n)
+		return n, err
+	}
+	err := s.q.QueryRowContext(ctx, `insert into auth_failures (client, failures, last_failure_at) values ($1, 1, $2)
+		on conflict (client) do update set
+			failures = case when auth_failures.last_failure_at < $3 then 1 else auth_failures.failures + 1 end,
+			last_failure_at = excluded.last_failure_at
+		returning failures`,
+		client, s.Time(now), s.Time(now.Add(-window))).Scan(&n)
+	return n, err
+}
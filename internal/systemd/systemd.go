@@ -0,0 +1,25 @@
+// Package systemd lets the server run as a systemd service: it takes over
+// the listening sockets socket activation passes in (sd_listen_fds) and
+// reports its state to the service manager (sd_notify). Neither needs
+// libsystemd, and outside systemd both do nothing.
+package systemd
+
+import "net"
+
+// listenFDsStart is the first descriptor systemd passes (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation, in
+// the order of the socket unit, or none when the process wasn't started
+// that way. The LISTEN_* variables are cleared so children don't take the
+// sockets for their own.
+func Listeners() ([]net.Listener, error) {
+	return listeners(listenFDsStart)
+}
+
+// Notify sends state, e.g. "READY=1" or "STOPPING=1", to the service
+// manager when it asked for notifications (Type=notify); otherwise it does
+// nothing.
+func Notify(state string) error {
+	return notify(state)
+}
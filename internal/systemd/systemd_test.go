@@ -0,0 +1,77 @@
+//go:build !windows && !plan9
+
+package systemd
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListeners(t *testing.T) {
+	if lns, err := listeners(listenFDsStart); err != nil || lns != nil {
+		t.Fatalf("not socket activated: %v %v", lns, err)
+	}
+
+	// pass a listener's descriptor as systemd would
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "http")
+	if lns, err := listeners(int(f.Fd())); err != nil || lns != nil {
+		t.Fatalf("another process's sockets: %v %v", lns, err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	lns, err := listeners(int(f.Fd()))
+	if err != nil || len(lns) != 1 {
+		t.Fatalf("listeners: %v %v", lns, err)
+	}
+	defer lns[0].Close()
+	if lns[0].Addr().String() != ln.Addr().String() {
+		t.Fatalf("addr %s, want %s", lns[0].Addr(), ln.Addr())
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("LISTEN_FDS not cleared")
+	}
+	go http.Serve(lns[0], http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := Notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err := Notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Fatalf("got %q %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package totp implements RFC 6238 time-based one-time passwords as
+// authenticator apps use them: HMAC-SHA1, 30-second steps, 6 digits.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Period is the length of a time step.
+	Period = 30 * time.Second
+	// Digits is the length of a code.
+	Digits = 6
+	// skew is how many steps before or after now a code may be from, for
+	// clock drift and codes typed just as they roll over.
+	skew = 1
+)
+
+// NewSecret returns a random 160-bit secret.
+func NewSecret() ([]byte, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Encode returns secret in the unpadded base32 authenticator apps take.
+func Encode(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// URI is the otpauth:// URI for enrolling secret in an authenticator app,
+// usually shown as a QR code.
+func URI(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", Encode(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 { return t.Unix() / int64(Period.Seconds()) }
+
+// Code returns the code for secret at time step step.
+func Code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1_000_000)
+}
+
+// Verify reports whether code is valid for secret at now, and for which
+// time step. Steps up to after are refused, so a code that was used once
+// can't be used again.
+func Verify(secret []byte, code string, now time.Time, after int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	cur := Step(now)
+	for s := cur - skew; s <= cur+skew; s++ {
+		if s <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(Code(secret, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,52 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1, cut to 6 digits.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		if got := Code(secret, Step(time.Unix(c.unix, 0))); got != c.code {
+			t.Errorf("T=%d: %s want %s", c.unix, got, c.code)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	prev := Code(secret, Step(now)-1)
+	step, ok := Verify(secret, prev, now, 0)
+	if !ok || step != Step(now)-1 {
+		t.Fatalf("previous step refused: %d %v", step, ok)
+	}
+	if _, ok := Verify(secret, prev, now, step); ok {
+		t.Fatal("code accepted twice")
+	}
+	if _, ok := Verify(secret, Code(secret, Step(now)-3), now, 0); ok {
+		t.Fatal("stale code accepted")
+	}
+	if _, ok := Verify(secret, "12345", now, 0); ok {
+		t.Fatal("short code accepted")
+	}
+	uri := URI("raalisence", "key:abc", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/raalisence:key:abc?") || !strings.Contains(uri, "secret="+Encode(secret)) {
+		t.Fatalf("uri %s", uri)
+	}
+}
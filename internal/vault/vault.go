@@ -0,0 +1,333 @@
+// Package vault signs with a key held in HashiCorp Vault's transit secrets
+// engine, so the private key never leaves Vault. A Signer is a crypto.Signer
+// for the transit key's public half, pinned to the key version that was
+// current when it was created, so a key rotated in Vault doesn't start
+// producing signatures the published public key can't verify.
+//
+// Only the HTTP API is used; no Vault client library is needed.
+package vault
+
+import (
+	"bytes"
+	"context"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth methods.
+const (
+	AuthToken      = "token"
+	AuthAppRole    = "approle"
+	AuthKubernetes = "kubernetes"
+)
+
+// defaultJWTPath is where Kubernetes mounts the pod's service account token.
+const defaultJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Options configure a Signer. Empty Address, Token and Namespace fall back to
+// VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE.
+type Options struct {
+	Address   string
+	Namespace string
+	Mount     string // transit mount path, default "transit"
+	Key       string // transit key name
+	Timeout   time.Duration
+
+	AuthMethod string // AuthToken (default), AuthAppRole or AuthKubernetes
+	AuthMount  string // defaults to the method name
+	Token      string
+	RoleID     string // approle
+	SecretID   string // approle
+	Role       string // kubernetes
+	JWTPath    string // kubernetes, default defaultJWTPath
+
+	HTTPClient *http.Client
+}
+
+// Signer signs through a transit key.
+type Signer struct {
+	opts    Options
+	client  *http.Client
+	pub     gocrypto.PublicKey
+	version int
+
+	mu    sync.Mutex
+	token string
+}
+
+// Error is a non-2xx response from Vault.
+type Error struct {
+	Status int
+	Errors []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault: HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("vault: HTTP %d: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// New logs in, fetches the transit key's latest public key and returns a
+// Signer for it.
+func New(ctx context.Context, opts Options) (*Signer, error) {
+	if opts.Address == "" {
+		opts.Address = os.Getenv("VAULT_ADDR")
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if opts.Mount == "" {
+		opts.Mount = "transit"
+	}
+	if opts.AuthMethod == "" {
+		opts.AuthMethod = AuthToken
+	}
+	if opts.AuthMount == "" {
+		opts.AuthMount = opts.AuthMethod
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	switch {
+	case opts.Address == "":
+		return nil, errors.New("vault: address required (or set VAULT_ADDR)")
+	case opts.Key == "":
+		return nil, errors.New("vault: transit key name required")
+	}
+	switch opts.AuthMethod {
+	case AuthToken:
+		if opts.Token == "" {
+			opts.Token = os.Getenv("VAULT_TOKEN")
+		}
+		if opts.Token == "" {
+			return nil, errors.New("vault: token required (or set VAULT_TOKEN)")
+		}
+	case AuthAppRole:
+		if opts.RoleID == "" || opts.SecretID == "" {
+			return nil, errors.New("vault: approle auth needs role_id and secret_id")
+		}
+	case AuthKubernetes:
+		if opts.Role == "" {
+			return nil, errors.New("vault: kubernetes auth needs role")
+		}
+		if opts.JWTPath == "" {
+			opts.JWTPath = defaultJWTPath
+		}
+	default:
+		return nil, fmt.Errorf("vault: unknown auth method %q (want token, approle or kubernetes)", opts.AuthMethod)
+	}
+	s := &Signer{opts: opts, client: opts.HTTPClient, token: opts.Token}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: opts.Timeout}
+	}
+	if err := s.loadPublicKey(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Public returns the public key of the pinned key version.
+func (s *Signer) Public() gocrypto.PublicKey { return s.pub }
+
+// Version is the transit key version s signs with.
+func (s *Signer) Version() int { return s.version }
+
+// Sign signs like the standard library's keys do: digest is a SHA-256 hash
+// for ECDSA (ASN.1 DER signature) and RSA (opts must be *rsa.PSSOptions), and
+// the whole message for Ed25519.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	req := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"key_version": s.version,
+	}
+	path := "sign/" + url.PathEscape(s.opts.Key)
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		if opts.HashFunc() != gocrypto.SHA256 {
+			return nil, fmt.Errorf("vault: unsupported hash %v", opts.HashFunc())
+		}
+		path += "/sha2-256"
+		req["prehashed"], req["marshaling_algorithm"] = true, "asn1"
+	case *rsa.PublicKey:
+		pss, ok := opts.(*rsa.PSSOptions)
+		if !ok || pss.Hash != gocrypto.SHA256 || pss.SaltLength != rsa.PSSSaltLengthEqualsHash {
+			return nil, errors.New("vault: RSA keys only sign PSS/SHA-256 with a hash-length salt")
+		}
+		path += "/sha2-256"
+		req["prehashed"], req["signature_algorithm"], req["salt_length"] = true, "pss", "hash"
+	case ed25519.PublicKey:
+		if opts.HashFunc() != 0 {
+			return nil, errors.New("vault: Ed25519 signs the message, not a digest")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err := s.call(ctx, http.MethodPost, s.opts.Mount+"/"+path, req, &resp); err != nil {
+		return nil, err
+	}
+	// vault:v<version>:<base64 signature>
+	parts := strings.SplitN(resp.Data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("vault: unexpected signature format %q", resp.Data.Signature)
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+func (s *Signer) loadPublicKey(ctx context.Context) error {
+	var resp struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := s.call(ctx, http.MethodGet, s.opts.Mount+"/keys/"+url.PathEscape(s.opts.Key), nil, &resp); err != nil {
+		return err
+	}
+	d := resp.Data
+	key, ok := d.Keys[strconv.Itoa(d.LatestVersion)]
+	if !ok || key.PublicKey == "" {
+		return fmt.Errorf("vault: transit key %q has no public key (type %q is not an asymmetric signing key)", s.opts.Key, d.Type)
+	}
+
+	switch d.Type {
+	case "ed25519":
+		raw, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return fmt.Errorf("vault: malformed ed25519 public key")
+		}
+		s.pub = ed25519.PublicKey(raw)
+	case "ecdsa-p256", "rsa-2048", "rsa-3072", "rsa-4096":
+		block, _ := pem.Decode([]byte(key.PublicKey))
+		if block == nil {
+			return fmt.Errorf("vault: malformed %s public key", d.Type)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("vault: %s public key: %w", d.Type, err)
+		}
+		s.pub = pub
+	default:
+		return fmt.Errorf("vault: transit key type %q is not supported (want ecdsa-p256, ed25519 or rsa-*)", d.Type)
+	}
+	s.version = d.LatestVersion
+	return nil
+}
+
+// call sends one API request, logging in first if there is no token yet and
+// once more if Vault rejects the current one (an expired login).
+func (s *Signer) call(ctx context.Context, method, path string, body, out any) error {
+	token, err := s.currentToken(ctx, false)
+	if err != nil {
+		return err
+	}
+	err = s.do(ctx, method, path, token, body, out)
+	var vErr *Error
+	if errors.As(err, &vErr) && vErr.Status == http.StatusForbidden && s.opts.AuthMethod != AuthToken {
+		if token, err = s.currentToken(ctx, true); err != nil {
+			return err
+		}
+		err = s.do(ctx, method, path, token, body, out)
+	}
+	return err
+}
+
+func (s *Signer) currentToken(ctx context.Context, renew bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && !renew {
+		return s.token, nil
+	}
+	var login map[string]any
+	switch s.opts.AuthMethod {
+	case AuthAppRole:
+		login = map[string]any{"role_id": s.opts.RoleID, "secret_id": s.opts.SecretID}
+	case AuthKubernetes:
+		jwt, err := os.ReadFile(s.opts.JWTPath)
+		if err != nil {
+			return "", fmt.Errorf("vault: kubernetes service account token: %w", err)
+		}
+		login = map[string]any{"role": s.opts.Role, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		return s.token, nil
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := s.do(ctx, http.MethodPost, "auth/"+s.opts.AuthMount+"/login", "", login, &resp); err != nil {
+		return "", fmt.Errorf("vault: %s login: %w", s.opts.AuthMethod, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault: %s login returned no token", s.opts.AuthMethod)
+	}
+	s.token = resp.Auth.ClientToken
+	return s.token, nil
+}
+
+func (s *Signer) do(ctx context.Context, method, path, token string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.opts.Address, "/")+"/v1/"+strings.Trim(path, "/"), rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if s.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.opts.Namespace)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		vErr := &Error{Status: res.StatusCode}
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&e) == nil {
+			vErr.Errors = e.Errors
+		}
+		return vErr
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("vault: decode response: %w", err)
+	}
+	return nil
+}
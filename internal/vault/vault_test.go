@@ -0,0 +1,155 @@
+package vault
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// fakeTransit serves the transit endpoints New and Sign use, signing with a
+// local key the way Vault does.
+type fakeTransit struct {
+	t       *testing.T
+	typ     string
+	key     gocrypto.Signer
+	token   string // accepted X-Vault-Token
+	logins  int
+	lastReq map[string]any
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.logins++
+		f.token = "s.login" + string(rune('0'+f.logins))
+		_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": f.token}})
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/lic":
+		pub := f.key.Public()
+		var pubStr string
+		if ed, ok := pub.(ed25519.PublicKey); ok {
+			pubStr = base64.StdEncoding.EncodeToString(ed)
+		} else {
+			der, _ := x509.MarshalPKIXPublicKey(pub)
+			pubStr = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"type": f.typ, "latest_version": 2,
+			"keys": map[string]any{"2": map[string]any{"public_key": pubStr}},
+		}})
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/transit/sign/lic"):
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.lastReq = req
+		input, _ := base64.StdEncoding.DecodeString(req["input"].(string))
+		var opts gocrypto.SignerOpts = gocrypto.SHA256
+		switch f.typ {
+		case "ed25519":
+			opts = gocrypto.Hash(0)
+		case "rsa-3072":
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: gocrypto.SHA256}
+		}
+		sig, err := f.key.Sign(rand.Reader, input, opts)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"signature": "vault:v2:" + base64.StdEncoding.EncodeToString(sig),
+		}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSignerVerifies(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 3072)
+	for typ, key := range map[string]gocrypto.Signer{"ecdsa-p256": ecKey, "ed25519": edKey, "rsa-3072": rsaKey} {
+		f := &fakeTransit{t: t, typ: typ, key: key, token: "s.root"}
+		srv := httptest.NewServer(f)
+		s, err := New(context.Background(), Options{Address: srv.URL, Key: "lic", Token: "s.root"})
+		if err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		if s.Version() != 2 {
+			t.Fatalf("%s: version %d", typ, s.Version())
+		}
+		payload := map[string]any{"license_key": "k", "customer": "A&B"}
+		sig, err := crypto.SignJSON(s, payload)
+		if err != nil {
+			t.Fatalf("%s: sign: %v", typ, err)
+		}
+		if ok, err := crypto.VerifyJSON(key.Public(), payload, sig); err != nil || !ok {
+			t.Fatalf("%s: ok=%v err=%v", typ, ok, err)
+		}
+		if f.lastReq["key_version"] != float64(2) {
+			t.Fatalf("%s: sign request not pinned to the key version: %v", typ, f.lastReq)
+		}
+		srv.Close()
+	}
+}
+
+func TestAppRoleRelogin(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	f := &fakeTransit{t: t, typ: "ecdsa-p256", key: key}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	s, err := New(context.Background(), Options{Address: srv.URL, Key: "lic", AuthMethod: AuthAppRole, RoleID: "role", SecretID: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.token = "s.revoked" // the login expired
+	if _, err := crypto.SignJSON(s, map[string]any{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if f.logins != 2 {
+		t.Fatalf("logins = %d, want 2", f.logins)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	f := &fakeTransit{t: t, token: "s.root"}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	for _, c := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{Address: srv.URL, Token: "s.root"}, "key name required"},
+		{Options{Address: srv.URL, Key: "lic", Token: "s.wrong"}, "HTTP 403: permission denied"},
+		{Options{Address: srv.URL, Key: "lic", AuthMethod: "ldap"}, "unknown auth method"},
+		{Options{Address: srv.URL, Key: "other", Token: "s.root"}, "HTTP 404"},
+	} {
+		if _, err := New(context.Background(), c.opts); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%+v: got %v, want %q", c.opts, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Package licensefile verifies raalisence license files offline, for
+// products that embed the check instead of calling the server:
+//
+//	f, err := licensefile.Verify(licenseJSON, pinnedPublicKeyPEM)
+//	if err == nil {
+//		err = f.Check(machineID, time.Now())
+//	}
+//
+// It rebuilds the signed payload exactly as the server signs it (RFC 8785
+// canonical JSON) and has no server dependencies.
+package licensefile
+
+import (
+	gocrypto "crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// SchemaVersion is the newest signed payload layout this package checks.
+const SchemaVersion = 1
+
+var (
+	ErrBadSignature = errors.New("licensefile: bad signature")
+	ErrExpired      = errors.New("licensefile: license expired")
+	ErrWrongMachine = errors.New("licensefile: license is for another machine")
+)
+
+// File is a license file as served by the issue, reissue and download
+// endpoints.
+type File struct {
+	SchemaVersion     int                    `json:"schema_version,omitempty"`
+	ProductID         string                 `json:"product_id,omitempty"`
+	Tenant            string                 `json:"tenant,omitempty"`
+	Customer          string                 `json:"customer"`
+	MachineID         string                 `json:"machine_id"`
+	LicenseKey        string                 `json:"license_key"`
+	ExpiresAt         time.Time              `json:"expires_at"`
+	Features          map[string]any         `json:"features"`
+	EncryptedFeatures json.RawMessage        `json:"encrypted_features,omitempty"`
+	MaxActivations    int                    `json:"max_activations"`
+	FloatingSeats     int                    `json:"floating_seats,omitempty"`
+	GraceDays         *int                   `json:"grace_days,omitempty"`
+	Entitlements      map[string]Entitlement `json:"entitlements,omitempty"`
+	IssuedAt          time.Time              `json:"issued_at"`
+	Signature         string                 `json:"signature"`
+	Alg               string                 `json:"alg"`
+	PublicKey         string                 `json:"public_key_pem"`
+	KeyID             string                 `json:"key_id,omitempty"`
+	CertChain         []string               `json:"cert_chain,omitempty"`
+	Timestamp         string                 `json:"timestamp,omitempty"`
+}
+
+// Entitlement is one typed entitlement grant.
+type Entitlement struct {
+	Type    string `json:"type"`
+	Enabled *bool  `json:"enabled,omitempty"`
+	Limit   *int64 `json:"limit,omitempty"`
+	Tier    string `json:"tier,omitempty"`
+}
+
+// Trust is what a verifier trusts. Keys pins public keys by kid (key_id,
+// see KeyID and /.well-known/jwks.json); Roots pins the CAs a cert_chain
+// must lead to. With neither set the file's own public_key_pem is trusted,
+// which only proves the file is intact, not who signed it.
+type Trust struct {
+	Keys  map[string]gocrypto.PublicKey
+	Roots *x509.CertPool
+}
+
+// Parse decodes a license file without verifying it.
+func Parse(licenseJSON []byte) (*File, error) {
+	var f File
+	if err := json.Unmarshal(licenseJSON, &f); err != nil {
+		return nil, fmt.Errorf("licensefile: %w", err)
+	}
+	return &f, nil
+}
+
+// Verify parses licenseJSON and checks that it was signed by the key in
+// publicKeyPEM; the public key embedded in the file is ignored.
+func Verify(licenseJSON []byte, publicKeyPEM string) (*File, error) {
+	pub, err := crypto.ParsePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("licensefile: %w", err)
+	}
+	f, err := Parse(licenseJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.verifyWith(pub); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// KeyID returns the kid the server gives publicKeyPEM: the hex SHA-256 of
+// its DER encoding.
+func KeyID(publicKeyPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return "", errors.New("licensefile: no PEM data")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify checks f's signature, dispatching on its envelope: schema_version
+// picks the signed payload, key_id the pinned key (else a cert_chain to a
+// pinned root, valid at issued_at), and alg must name the algorithm of the
+// key used, so a file cannot be made to verify under another algorithm.
+func (f *File) Verify(trust Trust) error {
+	pub, err := f.verifyKey(trust)
+	if err != nil {
+		return err
+	}
+	return f.verifyWith(pub)
+}
+
+func (f *File) verifyKey(trust Trust) (gocrypto.PublicKey, error) {
+	if trust.Keys != nil {
+		if pub, ok := trust.Keys[f.KeyID]; ok {
+			return pub, nil
+		}
+		if trust.Roots == nil {
+			return nil, fmt.Errorf("licensefile: unknown key_id %q", f.KeyID)
+		}
+	}
+	if trust.Roots != nil {
+		if len(f.CertChain) == 0 {
+			return nil, errors.New("licensefile: license has no certificate chain")
+		}
+		pub, err := crypto.VerifyCertChain(f.CertChain, trust.Roots, f.IssuedAt)
+		if err != nil {
+			return nil, fmt.Errorf("licensefile: %w", err)
+		}
+		return pub, nil
+	}
+	pub, err := crypto.ParsePublicKey(f.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("licensefile: %w", err)
+	}
+	return pub, nil
+}
+
+func (f *File) verifyWith(pub gocrypto.PublicKey) error {
+	payload, err := f.Payload()
+	if err != nil {
+		return err
+	}
+	alg, err := crypto.Algorithm(pub)
+	if err != nil {
+		return fmt.Errorf("licensefile: %w", err)
+	}
+	switch want := f.Alg; want {
+	case crypto.AlgES256, crypto.AlgEdDSA, crypto.AlgPS256, "":
+		if want == "" {
+			want = crypto.AlgES256 // files from before alg was added
+		}
+		if alg != want {
+			return fmt.Errorf("licensefile: alg is %s but the key is %s", want, alg)
+		}
+	default:
+		return fmt.Errorf("licensefile: unsupported alg %q", f.Alg)
+	}
+	ok, err := crypto.VerifyJSON(pub, payload, f.Signature)
+	if err != nil {
+		return fmt.Errorf("licensefile: %w", err)
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Payload returns the fields f's schema_version says are signed, as they
+// are canonicalized for the signature.
+func (f *File) Payload() (map[string]any, error) {
+	switch f.SchemaVersion {
+	case 0, 1: // files from before schema_version was added are version 1
+	default:
+		return nil, fmt.Errorf("licensefile: unsupported schema_version %d", f.SchemaVersion)
+	}
+	ents := f.Entitlements
+	if ents == nil {
+		ents = map[string]Entitlement{} // signed as {}, omitted from the file
+	}
+	p := map[string]any{
+		"customer":        f.Customer,
+		"machine_id":      f.MachineID,
+		"license_key":     f.LicenseKey,
+		"expires_at":      f.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		"issued_at":       f.IssuedAt.UTC().Format(time.RFC3339Nano),
+		"features":        f.Features,
+		"max_activations": f.MaxActivations,
+		"floating_seats":  f.FloatingSeats,
+		"entitlements":    ents,
+	}
+	if f.ProductID != "" {
+		p["product_id"] = f.ProductID
+	}
+	if f.Tenant != "" {
+		p["tenant"] = f.Tenant
+	}
+	if f.GraceDays != nil {
+		p["grace_days"] = *f.GraceDays
+	}
+	if len(f.EncryptedFeatures) > 0 {
+		delete(p, "features")
+		p["encrypted_features"] = f.EncryptedFeatures
+	}
+	return p, nil
+}
+
+// Check reports whether a verified f is usable on machineID at now: it
+// must be for that machine (unless machineID is empty) and not past
+// expires_at plus its grace_days. Use InGrace to warn during the grace
+// period.
+func (f *File) Check(machineID string, now time.Time) error {
+	if machineID != "" && f.MachineID != machineID {
+		return ErrWrongMachine
+	}
+	if now.After(f.graceEnd()) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// InGrace reports whether now is past expires_at but within grace_days.
+func (f *File) InGrace(now time.Time) bool {
+	return now.After(f.ExpiresAt) && !now.After(f.graceEnd())
+}
+
+// graceEnd matches the server: grace days are 24 hours each. The server's
+// licensing.grace_days default is not in the file, so only a license's own
+// grace_days count here.
+func (f *File) graceEnd() time.Time {
+	if f.GraceDays == nil || *f.GraceDays <= 0 {
+		return f.ExpiresAt
+	}
+	return f.ExpiresAt.Add(time.Duration(*f.GraceDays) * 24 * time.Hour)
+}
@@ -0,0 +1,89 @@
+package licensefile
+
+import (
+	gocrypto "crypto"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rpattn/raalisence/internal/crypto"
+)
+
+// signed returns f as JSON, signed with a fresh key, and that key's PEM.
+func signed(t *testing.T, f File) ([]byte, string) {
+	t.Helper()
+	privPEM, pubPEM, err := crypto.GenerateKeyPEM(crypto.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := crypto.ParsePrivateKey(privPEM)
+	f.SchemaVersion, f.Alg, f.PublicKey = SchemaVersion, crypto.AlgEdDSA, pubPEM
+	f.KeyID, _ = KeyID(pubPEM)
+	p, err := f.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Signature, err = crypto.SignJSON(priv, p); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(f)
+	return b, pubPEM
+}
+
+func TestVerify(t *testing.T) {
+	grace := 2
+	limit := int64(5)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	b, pubPEM := signed(t, File{
+		Customer: "Acme", MachineID: "MID-1", LicenseKey: "K-1",
+		ExpiresAt: expires, IssuedAt: expires.AddDate(-1, 0, 0),
+		Features:     map[string]any{"tier": "pro", "seats": 3},
+		GraceDays:    &grace,
+		Entitlements: map[string]Entitlement{"exports": {Type: "quota", Limit: &limit}},
+	})
+
+	f, err := Verify(b, pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Customer != "Acme" || f.Entitlements["exports"].Limit == nil {
+		t.Fatalf("parsed %+v", f)
+	}
+	if err := f.Verify(Trust{}); err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := crypto.ParsePublicKey(pubPEM)
+	if err := f.Verify(Trust{Keys: map[string]gocrypto.PublicKey{f.KeyID: pub}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherPEM, _ := crypto.GenerateKeyPEM(crypto.AlgEdDSA)
+	if _, err := Verify(b, otherPEM); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("verified with another key: %v", err)
+	}
+	tampered := *f
+	tampered.Features = map[string]any{"tier": "enterprise", "seats": 3}
+	if err := tampered.Verify(Trust{}); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered features: %v", err)
+	}
+	future := *f
+	future.SchemaVersion = SchemaVersion + 1
+	if err := future.Verify(Trust{}); err == nil {
+		t.Fatal("verified an unknown schema_version")
+	}
+
+	if err := f.Check("MID-1", expires.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Check("MID-2", expires.Add(-time.Hour)); !errors.Is(err, ErrWrongMachine) {
+		t.Fatalf("other machine: %v", err)
+	}
+	inGrace := expires.Add(24 * time.Hour)
+	if err := f.Check("MID-1", inGrace); err != nil || !f.InGrace(inGrace) {
+		t.Fatalf("in grace: %v", err)
+	}
+	if err := f.Check("MID-1", expires.Add(49*time.Hour)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("after grace: %v", err)
+	}
+}
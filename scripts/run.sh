@@ -24,7 +24,7 @@ else
 fi
 if [[ -z "${RAAL_SERVER_ADMIN_API_KEY_HASHES}" && -n "${RAAL_SERVER_ADMIN_API_KEY}" ]]; then
   echo "Deriving bcrypt hash for configured RAAL_SERVER_ADMIN_API_KEY…"
-  RAAL_SERVER_ADMIN_API_KEY_HASHES="$(go run ./scripts/hash-admin-key.go "${RAAL_SERVER_ADMIN_API_KEY}")"
+  RAAL_SERVER_ADMIN_API_KEY_HASHES="$(go run ./cmd/raalisence hash-key "${RAAL_SERVER_ADMIN_API_KEY}")"
 fi
 echo "Admin key hashes configured: $([[ -n "${RAAL_SERVER_ADMIN_API_KEY_HASHES}" ]] && echo yes || echo no)"
 